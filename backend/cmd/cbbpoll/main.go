@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -18,74 +19,154 @@ import (
 	_ "github.com/r-cbb/cbbpoll/docs"
 	"github.com/r-cbb/cbbpoll/internal/app"
 	"github.com/r-cbb/cbbpoll/internal/auth"
+	"github.com/r-cbb/cbbpoll/internal/config"
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/db/migrate"
+	"github.com/r-cbb/cbbpoll/internal/db/postgres"
 	"github.com/r-cbb/cbbpoll/internal/db/sqlite"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
 	"github.com/r-cbb/cbbpoll/internal/server"
+	"github.com/r-cbb/cbbpoll/internal/version"
 )
 
 func main() {
 	log.SetOutput(os.Stdout)
-	log.Println("Initializing server...")
+
+	// Settings come from the file named by CONFIG_FILE, if any, and the environment
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	err = cfg.Validate()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg.Database.URL, os.Args[2:]))
+	}
+
+	v := version.Get()
+	log.Printf("Initializing server %s (commit %s, built %s)...", v.Version, v.Commit, v.BuildTime)
 
 	srv := server.NewServer()
-	var err error
 
 	// Setup Database connection
-	db, err := sqlite.NewClient("/data/cbbpoll.db")
+	db, err := openDatabase(cfg.Database.URL)
 	if err != nil {
 		log.Fatal(err.Error())
 		panic(err.Error())
 	}
-	log.Println("\tSqlite Client initialized")
 
-	// Setup service layer
-	srv.App = app.NewPollService(db)
-	srv.App.Admins = append(srv.App.Admins, "Concision", "einsteins_haircut")
+	// Bring the schema up to date, refusing to start if it's newer than we know
+	migrator, err := db.Migrator()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		log.Fatalf("error migrating database: %s", err.Error())
+	}
+	for _, m := range applied {
+		log.Printf("\tApplied migration %d_%s", m.Version, m.Name)
+	}
 
-	// Setup JWT Auth
-	setupAuth(srv)
+	// Readiness checks for /readyz, along with the server's own check of JWT keys
+	srv.AddReadinessCheck("database", db.Ping)
+	srv.AddReadinessCheck("migrations", func(ctx context.Context) error {
+		pending, err := migrator.Pending()
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d migrations pending", len(pending))
+		}
+		return migrator.Check()
+	})
 
-	// Setup HTTP Server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8000"
-		log.Printf("\tDefaulting to port %s", port)
-	} else {
-		log.Printf("\tUsing port %s from environment variable", port)
+	// Setup service layer
+	srv.App = app.NewPollService(metrics.InstrumentDB(db))
+	srv.App.Admins = append(srv.App.Admins, cfg.Admins...)
+	srv.App.RefreshTokenTTL = cfg.Auth.RefreshTokenTTL
+	srv.App.Eligibility = app.EligibilityRules{
+		MinAccountAge:  cfg.Eligibility.MinAccountAge,
+		MinKarma:       cfg.Eligibility.MinKarma,
+		AllowSuspended: cfg.Eligibility.AllowSuspended,
+		FlairSubreddit: cfg.Eligibility.FlairSubreddit,
+	}
+	if len(cfg.Admins) == 0 {
+		log.Println("\tNo admins configured")
 	}
 
 	// Setup reddit client
-	// TODO read from config
-	srv.RedditClient = server.NewRedditClient("https://oauth.reddit.com/api/v1")
+	srv.RedditClient = server.NewRedditClient(cfg.Reddit.BaseURL)
+	if cfg.Reddit.OAuthEnabled() {
+		srv.RedditOAuth = server.NewRedditOAuth(server.OAuthConfig{
+			ClientID:     cfg.Reddit.ClientID,
+			ClientSecret: cfg.Reddit.ClientSecret,
+			RedirectURL:  cfg.Reddit.RedirectURL,
+			AuthURL:      cfg.Reddit.AuthURL,
+			TokenURL:     cfg.Reddit.TokenURL,
+			Scopes:       cfg.Reddit.Scopes,
+		})
+		srv.FrontendURL = cfg.Reddit.FrontendURL
+		srv.LoginKey = []byte(cfg.Reddit.LoginKey)
+	}
+
+	// Setup posting poll results to reddit
+	if bot := cfg.Reddit.Bot; bot.Enabled() {
+		redditBot := server.NewRedditBot(server.BotConfig{
+			ClientID:     bot.ClientID,
+			ClientSecret: bot.ClientSecret,
+			Username:     bot.Username,
+			Password:     bot.Password,
+			Subreddit:    bot.Subreddit,
+			TokenURL:     cfg.Reddit.TokenURL,
+			BaseURL:      cfg.Reddit.BaseURL,
+		})
+		srv.App.Poster = redditBot
+		srv.App.PublishWindow = bot.PublishWindow
+		log.Printf("\tPosting results to r/%s as u/%s", bot.Subreddit, bot.Username)
+
+		if bot.AutoPublish {
+			go publishClosedPolls(srv.App, publishInterval)
+		}
+
+		if bot.SyncFlair {
+			srv.App.Flair = redditBot
+			go syncFlair(srv.App, flairSyncInterval)
+			log.Printf("\tSyncing flair in r/%s", bot.Subreddit)
+		}
+	}
+
+	// Setup JWT Auth, after reddit so its login routes are included
+	setupAuth(srv, cfg.Auth)
 
 	// Enable CORS for Swagger-UI
 	c := cors.New(cors.Options{
 		Debug:          false,
-		AllowedHeaders: []string{"*"},
-		AllowedOrigins: []string{"*"},
+		AllowedHeaders: cfg.CORS.AllowedHeaders,
+		AllowedOrigins: cfg.CORS.AllowedOrigins,
 		AllowedMethods: []string{},
-		MaxAge:         1000,
+		MaxAge:         cfg.CORS.MaxAge,
 	})
 
-	// TODO: config
-	host := os.Getenv("SERVER_HOST")
-	if host == "" {
-		host = "http://localhost:8000"
-	}
-	srv.SetHost(host)
-
-	var useSSL bool
-	httpsEnabled := os.Getenv("HTTPS_ENABLED")
-	if httpsEnabled == "1" || strings.ToLower(httpsEnabled) == "true" {
-		useSSL = true
-	}
+	srv.SetHost(cfg.Server.Host)
 
 	var handler http.Handler
 	handler = c.Handler(srv)
 
-	if useSSL {
-		dataDir := "/data/ssl_cache"
+	// Metrics get a listener of their own, kept off the public API
+	var metricsDone chan bool
+	if cfg.Server.MetricsPort != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsDone = serverListen(makeHTTPServer(metricsMux, cfg.Server.MetricsPort, cfg.Server), false, cfg.Server.ShutdownTimeout)
+	}
+
+	if cfg.Server.HTTPS.Enabled {
+		allowedHost := cfg.Server.HTTPS.Host
 		hostPolicy := func(ctx context.Context, host string) error {
-			allowedHost := "api.cbbpoll.com"
 			if host == allowedHost {
 				return nil
 			}
@@ -95,26 +176,30 @@ func main() {
 		m := &autocert.Manager{
 			Prompt:     autocert.AcceptTOS,
 			HostPolicy: hostPolicy,
-			Cache:      autocert.DirCache(dataDir),
+			Cache:      autocert.DirCache(cfg.Server.HTTPS.CacheDir),
 		}
-		httpsSrv := makeHTTPServer(handler, "443")
+		httpsSrv := makeHTTPServer(handler, "443", cfg.Server)
 		httpsSrv.TLSConfig = &tls.Config{GetCertificate: m.GetCertificate}
 
-		d1 := serverListen(httpsSrv, true)
+		d1 := serverListen(httpsSrv, true, cfg.Server.ShutdownTimeout)
 
-		httpSrv := makeHTTPServer(m.HTTPHandler(nil), "80")
-		d2 := serverListen(httpSrv, false)
+		httpSrv := makeHTTPServer(m.HTTPHandler(nil), "80", cfg.Server)
+		d2 := serverListen(httpSrv, false, cfg.Server.ShutdownTimeout)
 		<-d1
 		<-d2
 		log.Println("Done")
 
 	} else {
-		httpSrv := makeHTTPServer(handler, port)
-		done := serverListen(httpSrv, false)
+		httpSrv := makeHTTPServer(handler, cfg.Server.Port, cfg.Server)
+		done := serverListen(httpSrv, false, cfg.Server.ShutdownTimeout)
 		<-done
 		log.Println("Done")
 	}
 
+	if metricsDone != nil {
+		<-metricsDone
+	}
+
 	err = db.Close()
 	if err != nil {
 		log.Printf("Error closing db: %s", err.Error())
@@ -123,7 +208,58 @@ func main() {
 	os.Exit(0)
 }
 
-func serverListen(s *http.Server, tls bool) chan bool {
+type migratableDB interface {
+	db.DBClient
+	Migrator() (*migrate.Migrator, error)
+	Ping(ctx context.Context) error
+}
+
+// openDatabase picks a DBClient implementation based on the form of dsn.
+// postgres:// and postgresql:// URLs connect to postgres; anything else is
+// treated as the path to a sqlite database file.
+func openDatabase(dsn string) (migratableDB, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		client, err := postgres.NewClient(dsn)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("\tPostgres Client initialized")
+		return client, nil
+	}
+
+	client, err := sqlite.NewClient(dsn)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("\tSqlite Client initialized")
+	return client, nil
+}
+
+// How often to check for polls that have closed and need their results posted
+const publishInterval = 5 * time.Minute
+
+func publishClosedPolls(ps *app.PollService, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := ps.PublishClosedPolls()
+		if err != nil {
+			log.Printf("error publishing poll results: %s", err.Error())
+		}
+	}
+}
+
+// How often to set the flair of users whose primary team has changed
+const flairSyncInterval = 10 * time.Minute
+
+func syncFlair(ps *app.PollService, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := ps.SyncFlair()
+		if err != nil {
+			log.Printf("error syncing flair: %s", err.Error())
+		}
+	}
+}
+
+func serverListen(s *http.Server, tls bool, shutdownTimeout time.Duration) chan bool {
 	done := make(chan bool, 1)
 	signalled := make(chan os.Signal, 1)
 	signal.Notify(signalled, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGSTOP)
@@ -138,7 +274,7 @@ func serverListen(s *http.Server, tls bool) chan bool {
 	go func() {
 		<-signalled
 		log.Println("Server stopping")
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
 		_ = s.Shutdown(ctx)
 		log.Println("Server stopped")
@@ -148,18 +284,18 @@ func serverListen(s *http.Server, tls bool) chan bool {
 	return done
 }
 
-func makeHTTPServer(handler http.Handler, port string) *http.Server {
+func makeHTTPServer(handler http.Handler, port string, cfg config.Server) *http.Server {
 	return &http.Server{
 		Handler:      handler,
 		Addr:         fmt.Sprintf(":%s", port),
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		WriteTimeout: cfg.WriteTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
 }
 
-func setupAuth(server *server.Server) {
-	keyFile, err := os.Open("/data/jwtRS256.key")
+func setupAuth(server *server.Server, cfg config.Auth) {
+	keyFile, err := os.Open(cfg.PrivateKeyFile)
 	if err != nil {
 		log.Fatalf("error opening secret key file: %s", err.Error())
 	}
@@ -169,7 +305,7 @@ func setupAuth(server *server.Server) {
 		}
 	}()
 
-	pubKeyFile, err := os.Open("/data/jwtRS256.key.pub")
+	pubKeyFile, err := os.Open(cfg.PublicKeyFile)
 	if err != nil {
 		log.Fatalf("error opening public key file: %s", err.Error())
 	}
@@ -179,11 +315,30 @@ func setupAuth(server *server.Server) {
 		}
 	}()
 
-	server.AuthClient, err = auth.InitJwtAuth(keyFile, pubKeyFile)
+	var verificationKeys []io.Reader
+	for _, name := range cfg.VerificationKeyFiles {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatalf("error opening verification key file: %s", err.Error())
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Printf("error closing file: %s", err.Error())
+			}
+		}()
+		verificationKeys = append(verificationKeys, f)
+	}
+
+	jwtClient, err := auth.InitJwtAuth(keyFile, pubKeyFile, verificationKeys...)
 	if err != nil {
 		log.Printf("error initializing JWT authentication: %s", err.Error())
-	} else {
-		server.AuthRoutes()
-		log.Println("\tJWT Auth initialized")
+		return
 	}
+
+	jwtClient.Issuer = cfg.Issuer
+	jwtClient.TTL = cfg.AccessTokenTTL
+	jwtClient.Revocations = server.App
+	server.AuthClient = jwtClient
+	server.AuthRoutes()
+	log.Println("\tJWT Auth initialized")
 }
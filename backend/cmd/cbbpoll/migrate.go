@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+const migrateUsage = `usage: cbbpoll migrate <command>
+
+Commands:
+  up      apply all pending migrations
+  down    roll back the most recently applied migration
+  status  list migrations and whether they've been applied, without changing
+          the database
+
+The database is selected by database.url in CONFIG_FILE or by DATABASE_URL,
+as when running the server.`
+
+// runMigrate implements the "cbbpoll migrate" subcommand, returning the exit code.
+func runMigrate(dsn string, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := openDatabase(dsn)
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("Error closing db: %s", err.Error())
+		}
+	}()
+
+	m, err := db.Migrator()
+	if err != nil {
+		log.Println(err.Error())
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		for _, mig := range applied {
+			log.Printf("Applied migration %d_%s", mig.Version, mig.Name)
+		}
+		if err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+		if len(applied) == 0 {
+			log.Println("Database is up to date")
+		}
+	case "down":
+		mig, err := m.Down()
+		if err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+		log.Printf("Rolled back migration %d_%s", mig.Version, mig.Name)
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+		unversioned, err := m.Unversioned()
+		if err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+		if unversioned {
+			fmt.Println("Database is unversioned; no migrations have been recorded")
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		_ = w.Flush()
+		if err := m.Check(); err != nil {
+			log.Println(err.Error())
+			return 1
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
@@ -37,6 +37,14 @@ func main() {
 	defer db.Close()
 	log.Println("Sqlite Client initialized")
 
+	m, err := db.Migrator()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if _, err = m.Up(); err != nil {
+		log.Fatal(err.Error())
+	}
+
 	// Setup service layer
 	a := app.NewPollService(db)
 
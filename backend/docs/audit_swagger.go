@@ -0,0 +1,52 @@
+package docs
+
+import (
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// swagger:route GET /v1/audit audit list-audit
+// List privileged changes made by admins and moderators, newest first.
+//
+// Requires the audit:view permission.  Entries are never removed, but a deleted user's name is replaced throughout the log by the anonymous name they were deleted under.
+// security:
+//   api_key: []
+// responses:
+//   200: auditResponse
+//   400: badRequestError
+//   401: unauthorizedError
+//   403: forbiddenError
+//   500: unexpectedError
+
+// swagger:parameters list-audit
+type listAuditParameters struct {
+	// Only changes made by this user
+	// in: query
+	Actor string `json:"actor"`
+	// Only this kind of change, such as user.update
+	// in: query
+	Action string `json:"action"`
+	// Only changes to this kind of target: team, user, poll or ballot
+	// in: query
+	TargetType string `json:"target_type"`
+	// Only changes to this target
+	// in: query
+	TargetID string `json:"target_id"`
+	// Only changes made in this request
+	// in: query
+	RequestID string `json:"request_id"`
+	// Only changes made at or after this time
+	// in: query
+	Since time.Time `json:"since"`
+	// Only changes made before this time
+	// in: query
+	Until time.Time `json:"until"`
+}
+
+// Audit log entries.
+// swagger:response auditResponse
+type auditResponse struct {
+	// in: body
+	Body []models.AuditEntry
+}
@@ -13,3 +13,30 @@ type pingResponseWrapper struct {
 	// in:body
 	Body models.VersionInfo
 }
+
+// swagger:route GET /v1/version meta version
+// Version and build information for the running server.
+// responses:
+//   200: pingResponse
+
+// swagger:route GET /healthz meta healthz
+// Liveness check.  Succeeds whenever the process is serving requests.
+// responses:
+//   200: healthResponse
+
+// swagger:route GET /readyz meta readyz
+// Readiness check.  Fails with 503 unless the database is reachable, migrations are up to date and JWT keys are loaded.
+// responses:
+//   200: healthResponse
+//   503: healthResponse
+
+// Status of the server and each of its readiness checks.
+// swagger:response healthResponse
+type healthResponseWrapper struct {
+	// in:body
+	Body struct {
+		// example: ok
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks,omitempty"`
+	}
+}
@@ -0,0 +1,48 @@
+package docs
+
+import "github.com/r-cbb/cbbpoll/internal/models"
+
+// swagger:route GET /v1/roles roles list-roles
+// List every role and the permissions it grants.
+// responses:
+//   200: rolesResponse
+//   500: unexpectedError
+
+// List of roles.
+// swagger:response rolesResponse
+type rolesResponse struct {
+	// in: body
+	Body []models.RoleInfo
+}
+
+// swagger:route PUT /v1/users/{userId}/roles roles set-roles
+// Replace the roles granted to a user.  Requires the roles:manage permission.
+// security:
+//   api_key: []
+// responses:
+//   200: userResponse
+//   400: badRequestError
+//   401: unauthorizedError
+//   403: forbiddenError
+//   404: notFoundError
+//   500: unexpectedError
+
+// swagger:parameters set-roles
+type setRolesParameters struct {
+	// in: path
+	// required: true
+	UserID string `json:"userId"`
+	// in: body
+	Body struct {
+		// example: ["poll_manager","auditor"]
+		Roles []models.Role `json:"roles"`
+	}
+}
+
+// User doesn't have permission.
+// swagger:response forbiddenError
+type forbiddenError struct{}
+
+// Not found.
+// swagger:response notFoundError
+type notFoundError struct{}
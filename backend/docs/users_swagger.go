@@ -34,3 +34,91 @@ type userResponse struct {
 //     description: User not found.
 //   "5xx":
 //     description: Unexpected error.
+
+// swagger:route GET /v1/users/{userId}/bias users user-bias
+// How much a voter has favoured their primary team in closed polls, poll by poll and season by season.
+// responses:
+//   200: userBiasResponse
+//   404: notFoundError
+//   500: unexpectedError
+
+// swagger:parameters user-bias
+type userBiasParameters struct {
+	// in: path
+	// required: true
+	UserID string `json:"userId"`
+}
+
+// How a voter ranked their primary team compared to the consensus.
+// swagger:response userBiasResponse
+type userBiasResponse struct {
+	// in: body
+	Body models.UserBias
+}
+
+// swagger:route GET /v1/users/{userId}/ballots users user-ballots
+// Every ballot a user has cast, with stats summarising how they vote.
+//
+// Ballots for polls that are still open are only included for the voter themselves and users who can view ballots.
+// responses:
+//   200: votingHistoryResponse
+//   404: notFoundError
+//   500: unexpectedError
+
+// swagger:parameters user-ballots
+type votingHistoryParameters struct {
+	// in: path
+	// required: true
+	UserID string `json:"userId"`
+}
+
+// A user's ballots and voting stats.
+// swagger:response votingHistoryResponse
+type votingHistoryResponse struct {
+	// in: body
+	Body models.VotingHistory
+}
+
+// swagger:route DELETE /v1/users/{userId} users delete-user
+// Delete a user, keeping their ballots in closed polls anonymously.
+//
+// Users can delete themselves; deleting anyone else requires the users:manage permission.  Ballots in polls that are still open are deleted, and reasons given for votes are removed.
+// security:
+//   api_key: []
+// responses:
+//   204: userDeleted
+//   401: unauthorizedError
+//   403: forbiddenError
+//   404: notFoundError
+//   500: unexpectedError
+
+// swagger:route GET /v1/users/{userId}/export users export-user
+// Everything stored about a user, as a JSON file to download.
+//
+// Users can export their own data; exporting anyone else's requires the users:manage permission.
+// security:
+//   api_key: []
+// responses:
+//   200: userExportResponse
+//   401: unauthorizedError
+//   403: forbiddenError
+//   404: notFoundError
+//   500: unexpectedError
+
+// swagger:parameters delete-user export-user
+type userPrivacyParameters struct {
+	// in: path
+	// required: true
+	UserID string `json:"userId"`
+}
+
+// The user was deleted.
+// swagger:response userDeleted
+type userDeleted struct{}
+
+// Everything stored about a user.
+// swagger:response userExportResponse
+type userExportResponse struct {
+	// in: body
+	Body models.UserExport
+}
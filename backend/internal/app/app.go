@@ -2,12 +2,18 @@ package app
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
-	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
 
 	"github.com/r-cbb/cbbpoll/internal/db"
 	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
 	"github.com/r-cbb/cbbpoll/internal/models"
+	"github.com/r-cbb/cbbpoll/internal/render"
 )
 
 var numRanks = 25
@@ -15,27 +21,64 @@ var numRanks = 25
 type PollService struct {
 	Db     db.DBClient
 	Admins []string
+	// Who can submit ballots, checked each time a user logs in
+	Eligibility EligibilityRules
+	// Where poll results are posted.  Publishing is disabled if this is nil.
+	Poster Poster
+	// How long after closing PublishClosedPolls still publishes a poll.  Defaults to DefaultPublishWindow.
+	PublishWindow time.Duration
+	// Sets users' subreddit flair from their primary team.  SyncFlair is disabled if this is nil.
+	Flair FlairSetter
+	// How long refresh tokens can be used for.  Defaults to DefaultRefreshTokenTTL.
+	RefreshTokenTTL time.Duration
+	log             *logrus.Entry
+	requestID       string
+	perms           *permissionCache
+	publishing      *sync.Mutex
 }
 
 func NewPollService(Db db.DBClient) *PollService {
-	ps := PollService{Db: Db}
+	ps := PollService{Db: Db, perms: newPermissionCache(permissionCacheTTL), publishing: &sync.Mutex{}}
+	return &ps
+}
+
+// WithLogger returns a copy of the service that logs to l, typically a logger
+// tagged with the details of the request being served.
+func (ps PollService) WithLogger(l *logrus.Entry) *PollService {
+	ps.log = l
+	return &ps
+}
+
+// WithRequestID returns a copy of the service that notes id as the request
+// privileged changes were made in when recording them in the audit log.
+func (ps PollService) WithRequestID(id string) *PollService {
+	ps.requestID = id
 	return &ps
 }
 
+func (ps PollService) logger() *logrus.Entry {
+	if ps.log == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	return ps.log
+}
+
 func (ps PollService) AddTeam(user models.UserToken, newTeam models.Team) (createdTeam models.Team, err error) {
 	const op errors.Op = "app.AddTeam"
-	if !user.LoggedIn() {
-		return models.Team{}, errors.E(op, "user is not logged in", errors.KindUnauthenticated)
+	if err := ps.authorize(user, models.PermManageTeams); err != nil {
+		return models.Team{}, errors.E(op, err, "user can't add teams")
 	}
 
-	if !user.IsAdmin {
-		return models.Team{}, errors.E(op, "only admins can add teams", errors.KindUnauthorized)
+	// The team's ID, and so the rest of the entry, is filled in by the db
+	dbClient, err := ps.audited(user, models.AuditAddTeam, models.AuditTargetTeam, "", nil, nil)
+	if err != nil {
+		return models.Team{}, errors.E(op, err)
 	}
 
-	createdTeam, err = ps.Db.AddTeam(newTeam)
+	createdTeam, err = dbClient.AddTeam(newTeam)
 	if errors.Kind(err) == errors.KindConcurrencyProblem {
 		// Retry once
-		createdTeam, err = ps.Db.AddTeam(newTeam)
+		createdTeam, err = dbClient.AddTeam(newTeam)
 	}
 
 	if err != nil {
@@ -76,6 +119,7 @@ func (ps PollService) NewUser(nickname string) (models.User, error) {
 	for _, admin := range ps.Admins {
 		if nickname == admin {
 			newUser.IsAdmin = true
+			newUser.Roles = []models.Role{models.RoleAdmin}
 		}
 	}
 
@@ -89,30 +133,59 @@ func (ps PollService) NewUser(nickname string) (models.User, error) {
 
 func (ps PollService) AddUser(user models.UserToken, newUser models.User) (createdUser models.User, err error) {
 	const op errors.Op = "app.AddUser"
-	if !user.LoggedIn() {
-		return models.User{}, errors.E(op, errors.KindUnauthenticated)
+	if err := ps.authorize(user, models.PermManageUsers); err != nil {
+		return models.User{}, errors.E(op, err, "user can't add users")
+	}
+
+	if newUser.IsAdmin || len(newUser.Roles) > 0 {
+		if err := ps.authorize(user, models.PermManageRoles); err != nil {
+			return models.User{}, errors.E(op, err, "user can't grant roles")
+		}
+	}
+
+	if err := validateRoles(newUser.Roles); err != nil {
+		return models.User{}, errors.E(op, err)
+	}
+
+	if err := ps.checkPrimaryTeam(newUser); err != nil {
+		return models.User{}, errors.E(op, err)
 	}
+	newUser.Team = nil
 
-	if !user.IsAdmin {
-		return models.User{}, errors.E(op, errors.KindUnauthorized)
+	dbClient, err := ps.audited(user, models.AuditAddUser, models.AuditTargetUser, newUser.Nickname, nil, newUser.WithNormalizedRoles())
+	if err != nil {
+		return models.User{}, errors.E(op, err)
 	}
 
-	createdUser, err = ps.Db.AddUser(newUser)
+	createdUser, err = dbClient.AddUser(newUser)
 	if err != nil {
 		return models.User{}, errors.E(op, err, "error adding user to db")
 	}
 
-	return createdUser, nil
+	users := []models.User{createdUser}
+	if err := ps.withTeams(users); err != nil {
+		return models.User{}, errors.E(op, err)
+	}
+
+	return users[0], nil
 }
 
-func (ps PollService) GetUser(name string) (models.User, error) {
+// GetUser returns the user called name.  Why they can't vote is only included
+// for the user themselves and those who can manage users.
+func (ps PollService) GetUser(user models.UserToken, name string) (models.User, error) {
 	const op errors.Op = "app.GetUser"
-	user, err := ps.Db.GetUser(name)
+	u, err := ps.Db.GetUser(name)
 	if err != nil {
 		return models.User{}, errors.E(op, err, "error retrieving user from db")
 	}
 
-	return user, nil
+	users := []models.User{u}
+	if err := ps.withTeams(users); err != nil {
+		return models.User{}, errors.E(op, err)
+	}
+	hideEligibility(user, users)
+
+	return users[0], nil
 }
 
 func (ps PollService) GetUsers(user models.UserToken, opts Options) ([]models.User, error) {
@@ -123,6 +196,11 @@ func (ps PollService) GetUsers(user models.UserToken, opts Options) ([]models.Us
 		return nil, errors.E(err, op, "error retrieving users from db")
 	}
 
+	if err := ps.withTeams(users); err != nil {
+		return nil, errors.E(op, err)
+	}
+	hideEligibility(user, users)
+
 	return users, nil
 }
 
@@ -132,50 +210,215 @@ func (ps PollService) UpdateUser(user models.UserToken, name string, updatedUser
 		return models.User{}, errors.E(op, errors.KindUnauthenticated)
 	}
 
-	if user.Nickname != name && !user.IsAdmin {
-		return models.User{}, errors.E(op, errors.KindUnauthorized)
-	}
-
 	if updatedUser.Nickname != name {
 		return models.User{}, errors.E(op, errors.KindBadRequest, "can't change a user's nickname")
 	}
 
 	existingUser, err := ps.Db.GetUser(name)
 	if err != nil {
-		return models.User{}, errors.E(op, "error retrieving user to update from db")
+		return models.User{}, errors.E(op, err, "error retrieving user to update from db")
+	}
+
+	// Voter moderators can approve or remove other voters, but changing
+	// anything else about another user, or approving yourself, takes an admin
+	if user.Nickname != name {
+		perm := models.PermManageVoters
+		if existingUser.PrimaryTeam != updatedUser.PrimaryTeam || existingUser.IsAdmin != updatedUser.IsAdmin {
+			perm = models.PermManageUsers
+		}
+		if err := ps.authorize(user, perm); err != nil {
+			return models.User{}, errors.E(op, err, "user can't edit other users")
+		}
+	} else if existingUser.IsVoter != updatedUser.IsVoter {
+		if err := ps.authorize(user, models.PermManageUsers); err != nil {
+			return models.User{}, errors.E(op, err, "user can't alter their own voter status")
+		}
 	}
 
-	if existingUser.IsVoter != updatedUser.IsVoter && !user.IsAdmin {
-		return models.User{}, errors.E(op, errors.KindUnauthorized, "only admins can alter voter status")
+	if existingUser.IsAdmin != updatedUser.IsAdmin {
+		if err := ps.authorize(user, models.PermManageRoles); err != nil {
+			return models.User{}, errors.E(op, err, "user can't change a user's admin status")
+		}
+	}
+
+	if updatedUser.PrimaryTeam != existingUser.PrimaryTeam {
+		if err := ps.checkPrimaryTeam(updatedUser); err != nil {
+			return models.User{}, errors.E(op, err)
+		}
+	}
+	updatedUser.Team = nil
+
+	// Eligibility is only decided when the user logs in
+	updatedUser.IneligibleReason = existingUser.IneligibleReason
+
+	// Other roles are only changed through SetRoles
+	updatedUser.Roles = make([]models.Role, 0, len(existingUser.Roles))
+	for _, r := range existingUser.Roles {
+		if r != models.RoleAdmin {
+			updatedUser.Roles = append(updatedUser.Roles, r)
+		}
 	}
+	updatedUser = updatedUser.WithNormalizedRoles()
 
-	if existingUser.IsAdmin != updatedUser.IsAdmin && !user.IsAdmin {
-		return models.User{}, errors.E(op, errors.KindUnauthorized, "only admins can change a user's admin status")
+	// Users editing their own profile aren't using any privileges
+	dbClient := ps.Db
+	if user.Nickname != name || existingUser.IsVoter != updatedUser.IsVoter || existingUser.IsAdmin != updatedUser.IsAdmin {
+		dbClient, err = ps.audited(user, models.AuditUpdateUser, models.AuditTargetUser, name, existingUser, updatedUser)
+		if err != nil {
+			return models.User{}, errors.E(op, err)
+		}
 	}
 
-	err = ps.Db.UpdateUser(updatedUser)
+	err = dbClient.UpdateUser(updatedUser)
 	if err != nil {
 		return models.User{}, errors.E(op, "error updating user in db", err)
 	}
+	ps.forgetUser(name)
+
+	ps.logger().WithFields(logrus.Fields{
+		"user":     name,
+		"is_voter": updatedUser.IsVoter,
+		"is_admin": updatedUser.IsAdmin,
+	}).Info("user updated")
+
+	users := []models.User{updatedUser}
+	if err := ps.withTeams(users); err != nil {
+		return models.User{}, errors.E(op, err)
+	}
+	hideEligibility(user, users)
+
+	return users[0], nil
+}
+
+// SetRoles replaces the roles granted to the user called name.
+func (ps PollService) SetRoles(user models.UserToken, name string, roles []models.Role) (models.User, error) {
+	const op errors.Op = "app.SetRoles"
+	if err := ps.authorize(user, models.PermManageRoles); err != nil {
+		return models.User{}, errors.E(op, err, "user can't grant roles")
+	}
+
+	if err := validateRoles(roles); err != nil {
+		return models.User{}, errors.E(op, err)
+	}
+
+	existingUser, err := ps.Db.GetUser(name)
+	if err != nil {
+		return models.User{}, errors.E(op, err, "error retrieving user from db")
+	}
+
+	before := existingUser
+	existingUser.IsAdmin = false
+	existingUser.Roles = roles
+	existingUser = existingUser.WithNormalizedRoles()
+
+	dbClient, err := ps.audited(user, models.AuditSetRoles, models.AuditTargetUser, name, before, existingUser)
+	if err != nil {
+		return models.User{}, errors.E(op, err)
+	}
+
+	err = dbClient.UpdateUser(existingUser)
+	if err != nil {
+		return models.User{}, errors.E(op, err, "error updating user's roles in db")
+	}
+	ps.forgetUser(name)
+
+	ps.logger().WithFields(logrus.Fields{
+		"user":  name,
+		"roles": existingUser.Roles,
+	}).Info("user roles updated")
+
+	return existingUser, nil
+}
+
+func validateRoles(roles []models.Role) error {
+	for _, r := range roles {
+		if !r.Valid() {
+			return errors.E(errors.KindBadRequest, fmt.Sprintf("unknown role %q", r))
+		}
+	}
+	return nil
+}
+
+// checkPrimaryTeam makes sure the team u claims as their primary team exists.
+func (ps PollService) checkPrimaryTeam(u models.User) error {
+	if u.PrimaryTeam == 0 {
+		return nil
+	}
+
+	_, err := ps.Db.GetTeam(u.PrimaryTeam)
+	if errors.Kind(err) == errors.KindNotFound {
+		return errors.E(errors.KindBadRequest, fmt.Sprintf("primary team %d doesn't exist", u.PrimaryTeam))
+	}
+	if err != nil {
+		return errors.E(err, "error retrieving primary team from db")
+	}
+
+	return nil
+}
+
+// hideEligibility blanks why users can't vote, other than for user themselves,
+// unless user can manage users.
+func hideEligibility(user models.UserToken, users []models.User) {
+	if user.Can(models.PermManageUsers) {
+		return
+	}
+	for i := range users {
+		if users[i].Nickname != user.Nickname {
+			users[i].IneligibleReason = ""
+		}
+	}
+}
+
+// withTeams fills in the details of each user's primary team.
+func (ps PollService) withTeams(users []models.User) error {
+	var ids []int64
+	seen := make(map[int64]bool)
+	for _, u := range users {
+		if u.PrimaryTeam != 0 && !seen[u.PrimaryTeam] {
+			ids = append(ids, u.PrimaryTeam)
+			seen[u.PrimaryTeam] = true
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	teams, err := ps.Db.GetTeamsByID(ids)
+	if err != nil {
+		return errors.E(err, "error retrieving users' primary teams from db")
+	}
 
-	return updatedUser, nil
+	byID := make(map[int64]models.Team, len(teams))
+	for _, t := range teams {
+		byID[t.ID] = t
+	}
+	for i := range users {
+		if t, ok := byID[users[i].PrimaryTeam]; ok {
+			users[i].Team = &t
+		}
+	}
+
+	return nil
 }
 
 func (ps PollService) AddPoll(user models.UserToken, poll models.Poll) (models.Poll, error) {
 	const op errors.Op = "app.AddPoll"
-	if !user.LoggedIn() {
-		return models.Poll{}, errors.E(op, errors.KindUnauthenticated)
+	if err := ps.authorize(user, models.PermManagePolls); err != nil {
+		return models.Poll{}, errors.E(op, err, "user doesn't have sufficient permissions to add a poll")
 	}
 
-	if !user.CanManagePolls() {
-		return models.Poll{}, errors.E(op, errors.KindUnauthorized, "user doesn't have sufficient permissions to add a poll")
+	dbClient, err := ps.audited(user, models.AuditAddPoll, models.AuditTargetPoll, fmt.Sprintf("%d/%d", poll.Season, poll.Week), nil, poll)
+	if err != nil {
+		return models.Poll{}, errors.E(op, err)
 	}
 
-	newPoll, err := ps.Db.AddPoll(poll)
+	newPoll, err := dbClient.AddPoll(poll)
 	if err != nil {
 		return models.Poll{}, errors.E(op, "error adding poll to db", err)
 	}
 
+	ps.logger().WithFields(logrus.Fields{"season": newPoll.Season, "week": newPoll.Week}).Info("poll added")
+
 	return newPoll, nil
 }
 
@@ -192,7 +435,7 @@ func (ps PollService) GetPoll(season int, week int) (models.Poll, error) {
 func (ps PollService) GetPolls(user models.UserToken, opts Options) ([]models.Poll, error) {
 	const op errors.Op = "app.GetPolls"
 
-	if !user.CanManagePolls() {
+	if !user.Can(models.PermViewPolls) {
 		opts = opts.HasOpened()
 	}
 
@@ -212,10 +455,45 @@ func (ps PollService) GetResults(user models.UserToken, season int, week int) ([
 		return nil, errors.E(op, err, "error retrieving poll from db")
 	}
 
-	if poll.CloseTime.After(time.Now()) && !user.CanManagePolls() {
+	if poll.CloseTime.After(time.Now()) && !user.Can(models.PermViewPolls) {
 		return nil, errors.E(op, err, "can't view poll results until after poll close", errors.KindUnauthorized)
 	}
 
+	results, err := ps.officialResults(poll)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return results, nil
+}
+
+// GetResultsReport is GetResults along with the poll and the previous week's
+// results, which rendering the results for people needs.
+func (ps PollService) GetResultsReport(user models.UserToken, season int, week int) (render.Report, error) {
+	const op errors.Op = "app.GetResultsReport"
+
+	poll, err := ps.Db.GetPoll(season, week)
+	if err != nil {
+		return render.Report{}, errors.E(op, err, "error retrieving poll from db")
+	}
+
+	if poll.CloseTime.After(time.Now()) && !user.Can(models.PermViewPolls) {
+		return render.Report{}, errors.E(op, "can't view poll results until after poll close", errors.KindUnauthorized)
+	}
+
+	report, err := ps.report(poll)
+	if err != nil {
+		return render.Report{}, errors.E(op, err)
+	}
+
+	return report, nil
+}
+
+// officialResults returns the results of poll's official ballots, calculating
+// them if that hasn't been done since the last ballot changed.
+func (ps PollService) officialResults(poll models.Poll) ([]models.Result, error) {
+	const op errors.Op = "app.officialResults"
+
 	results, err := ps.Db.GetResults(poll, false)
 	if err != nil {
 		return nil, errors.E(op, err, "error retrieving results for poll")
@@ -231,86 +509,95 @@ func (ps PollService) GetResults(user models.UserToken, season int, week int) ([
 	return results, nil
 }
 
-func (ps PollService) AddBallot(user models.UserToken, ballot models.Ballot) (models.Ballot, error) {
-	const op errors.Op = "app.AddBallot"
-	if !user.LoggedIn() {
-		return models.Ballot{}, errors.E(op, errors.KindUnauthenticated)
+// previousResults returns the official results of the last poll of the season
+// before poll, or nil if poll is the first.
+func (ps PollService) previousResults(poll models.Poll) ([]models.Result, error) {
+	polls, err := ps.Db.GetPolls([]db.Filter{
+		{Field: "season", Operator: db.Eq, Value: poll.Season},
+		{Field: "week", Operator: db.Lt, Value: poll.Week},
+	}, db.Sort{})
+	if err != nil {
+		return nil, err
 	}
 
-	u, err := ps.Db.GetUser(ballot.User)
-	if err != nil {
-		return models.Ballot{}, errors.E(op, err, errors.KindBadRequest, "user doesn't exist")
+	if len(polls) == 0 {
+		return nil, nil
 	}
 
-	if u.Nickname != user.Nickname && !user.IsAdmin {
-		return models.Ballot{}, errors.E(op, errors.KindUnauthorized, "can't submit ballot for another user")
+	prev := polls[0]
+	for _, p := range polls[1:] {
+		if p.Week > prev.Week {
+			prev = p
+		}
 	}
 
-	// ballot.IsOfficial = u.IsVoter
-	// ballot.UpdatedTime = time.Now()
+	return ps.officialResults(prev)
+}
 
-	err = ps.validateBallot(ballot)
+// report gathers poll's official results, and those of the poll before it, for rendering.
+func (ps PollService) report(poll models.Poll) (render.Report, error) {
+	const op errors.Op = "app.report"
+
+	results, err := ps.officialResults(poll)
 	if err != nil {
-		return models.Ballot{}, errors.E(op, err, "ballot failed validation", errors.KindBadRequest)
+		return render.Report{}, errors.E(op, err, "error getting poll results")
 	}
 
-	newBallot, err := ps.Db.AddBallot(ballot)
+	previous, err := ps.previousResults(poll)
 	if err != nil {
-		return models.Ballot{}, errors.E(op, err, "error adding ballot to DB")
+		return render.Report{}, errors.E(op, err, "error getting previous poll's results")
 	}
 
-	return newBallot, nil
+	return render.Report{Poll: poll, Results: results, Previous: previous}, nil
 }
 
-func (ps PollService) validateBallot(b models.Ballot) error {
-	vs := b.Votes
-
-	if len(vs) != numRanks {
-		return errors.E(fmt.Errorf("ballots must contain exactly %v votes, found %v", numRanks, len(vs)))
+func (ps PollService) AddBallot(user models.UserToken, ballot models.Ballot) (models.Ballot, error) {
+	const op errors.Op = "app.AddBallot"
+	if !user.LoggedIn() {
+		return models.Ballot{}, errors.E(op, errors.KindUnauthenticated)
 	}
 
-	if containsDuplicates(vs) {
-		return errors.E(fmt.Errorf("ballot contains duplicate votes"))
+	u, err := ps.Db.GetUser(ballot.User)
+	if err != nil {
+		return models.Ballot{}, errors.E(op, err, errors.KindBadRequest, "user doesn't exist")
 	}
 
-	if err := checkVotes(vs, ps.Db); err != nil {
-		return err
+	if u.Nickname != user.Nickname {
+		if err := ps.authorize(user, models.PermManageBallots); err != nil {
+			return models.Ballot{}, errors.E(op, err, "can't submit ballot for another user")
+		}
 	}
 
-	return nil
-}
-
-func containsDuplicates(vs []models.Vote) bool {
-	seen := make(map[int64]struct{})
-	for _, v := range vs {
-		_, ok := seen[v.TeamID]
-		if ok {
-			return true
-		}
-		seen[v.TeamID] = struct{}{}
+	if !u.Eligible() {
+		return models.Ballot{}, errors.E(op, errors.KindUnauthorized, fmt.Sprintf("%s can't submit ballots: %s", u.Nickname, u.IneligibleReason))
 	}
-	return false
-}
 
-func checkVotes(vs []models.Vote, db db.DBClient) error {
-	teamIDs := make([]int64, len(vs))
-	for i, v := range vs {
-		teamIDs[i] = v.TeamID
-		if v.TeamID == 0 || v.Rank == 0 {
-			return fmt.Errorf("no votes can have a team_id or rank of 0")
-		}
+	// Whether a ballot counts is up to the voter's current status, not the client
+	ballot.IsOfficial = u.IsVoter
+	// ballot.UpdatedTime = time.Now()
 
-		if utf8.RuneCountInString(v.Reason) > 140 {
-			return fmt.Errorf("reasons can't be longer than 140 characters")
-		}
+	err = ps.validateBallot(ballot)
+	if _, ok := err.(*BallotValidationError); ok {
+		return models.Ballot{}, errors.E(op, err, "ballot failed validation", errors.KindBadRequest)
+	} else if err != nil {
+		return models.Ballot{}, errors.E(op, err, "error validating ballot")
 	}
 
-	_, err := db.GetTeamsByID(teamIDs)
+	newBallot, err := ps.Db.AddBallot(ballot)
 	if err != nil {
-		return fmt.Errorf("unable to retrieve ballot's teams from db")
+		return models.Ballot{}, errors.E(op, err, "error adding ballot to DB")
 	}
 
-	return nil
+	ps.logger().WithFields(logrus.Fields{
+		"ballot_id": newBallot.ID,
+		"season":    newBallot.PollSeason,
+		"week":      newBallot.PollWeek,
+		"voter":     newBallot.User,
+		"official":  newBallot.IsOfficial,
+	}).Info("ballot added")
+	metrics.BallotSubmitted(newBallot.PollSeason, newBallot.PollWeek)
+
+	return newBallot, nil
 }
 
 func (ps PollService) DeleteBallot(user models.UserToken, id int64) error {
@@ -324,8 +611,10 @@ func (ps PollService) DeleteBallot(user models.UserToken, id int64) error {
 		return errors.E(op, "error getting ballot", err)
 	}
 
-	if ballot.User != user.Nickname && !user.IsAdmin {
-		return errors.E(op, errors.KindUnauthorized, "can't delete someone else's ballot")
+	if ballot.User != user.Nickname {
+		if err := ps.authorize(user, models.PermManageBallots); err != nil {
+			return errors.E(op, err, "can't delete someone else's ballot")
+		}
 	}
 
 	poll, err := ps.Db.GetPoll(ballot.PollSeason, ballot.PollWeek)
@@ -333,15 +622,32 @@ func (ps PollService) DeleteBallot(user models.UserToken, id int64) error {
 		return errors.E(op, "error getting poll for ballot")
 	}
 
-	if poll.CloseTime.Before(time.Now()) && !user.IsAdmin {
-		return errors.E(op, errors.KindBadRequest, "can't delete a ballot for a closed poll")
+	closed := poll.CloseTime.Before(time.Now())
+	if closed {
+		if err := ps.authorize(user, models.PermManageBallots); err != nil {
+			if errors.Kind(err) == errors.KindUnauthorized {
+				return errors.E(op, errors.KindBadRequest, "can't delete a ballot for a closed poll")
+			}
+			return errors.E(op, err)
+		}
+	}
+
+	// Only deletions that needed PermManageBallots are audited
+	dbClient := ps.Db
+	if ballot.User != user.Nickname || closed {
+		dbClient, err = ps.audited(user, models.AuditDeleteBallot, models.AuditTargetBallot, strconv.FormatInt(id, 10), ballot, nil)
+		if err != nil {
+			return errors.E(op, err)
+		}
 	}
 
-	err = ps.Db.DeleteBallot(id)
+	err = dbClient.DeleteBallot(id)
 	if err != nil {
 		return errors.E(op, err, "error deleting ballot")
 	}
 
+	ps.logger().WithFields(logrus.Fields{"ballot_id": id, "voter": ballot.User}).Info("ballot deleted")
+
 	return nil
 }
 
@@ -358,9 +664,54 @@ func (ps PollService) GetBallotById(user models.UserToken, id int64) (models.Bal
 		return models.Ballot{}, errors.E(op, err, "error retrieving poll for ballot")
 	}
 
-	if poll.CloseTime.After(time.Now()) && !user.IsAdmin && ballot.User != user.Nickname {
+	if poll.CloseTime.After(time.Now()) && !user.Can(models.PermViewBallots) && ballot.User != user.Nickname {
 		return models.Ballot{}, errors.E(op, err, "users can't see other's ballots until the poll closes", errors.KindUnauthorized)
 	}
 
 	return ballot, nil
 }
+
+/*
+GetPollBallots lists who voted in a poll, along with the slug of each voter's
+primary team so clients can show their flair.  Like the ballots themselves,
+the list is private until the poll closes.
+*/
+func (ps PollService) GetPollBallots(user models.UserToken, season int, week int) ([]models.BallotRef, error) {
+	const op errors.Op = "app.GetPollBallots"
+
+	poll, err := ps.Db.GetPoll(season, week)
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving poll from db")
+	}
+
+	if poll.CloseTime.After(time.Now()) && !user.Can(models.PermViewBallots) {
+		return nil, errors.E(op, "users can't see who voted until the poll closes", errors.KindUnauthorized)
+	}
+
+	ballots, err := ps.Db.GetBallotsByPoll(poll)
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving ballots from db")
+	}
+
+	users, err := ps.Db.GetUsers(nil, db.Sort{})
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving users from db")
+	}
+	if err := ps.withTeams(users); err != nil {
+		return nil, errors.E(op, err)
+	}
+	slugs := make(map[string]string, len(users))
+	for _, u := range users {
+		if u.Team != nil {
+			slugs[u.Nickname] = u.Team.Slug
+		}
+	}
+
+	refs := make([]models.BallotRef, len(ballots))
+	for i, b := range ballots {
+		refs[i] = models.BallotRef{ID: b.ID, User: b.User, PrimaryTeamSlug: slugs[b.User]}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].ID < refs[j].ID })
+
+	return refs, nil
+}
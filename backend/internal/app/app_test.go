@@ -0,0 +1,487 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/db/memory"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+var adminToken = models.UserToken{Nickname: "Concision", IsAdmin: true}
+
+// newTestService returns a PollService backed by an in-memory database seeded
+// with numRanks+1 teams, an admin, two voters and a closed poll for 2020 week 1.
+func newTestService(t *testing.T) (*PollService, []models.Team) {
+	t.Helper()
+	ps := NewPollService(memory.NewClient())
+
+	// Permissions are checked against the database, so the admin has to exist first
+	if _, err := ps.Db.AddUser(models.User{Nickname: "Concision", IsAdmin: true}); err != nil {
+		t.Fatalf("error adding admin: %s", err.Error())
+	}
+
+	teams := make([]models.Team, numRanks+1)
+	for i := range teams {
+		team, err := ps.AddTeam(adminToken, models.Team{ShortName: fmt.Sprintf("Team %02d", i+1)})
+		if err != nil {
+			t.Fatalf("error adding team: %s", err.Error())
+		}
+		teams[i] = team
+	}
+
+	for _, u := range []models.User{
+		{Nickname: "voter1", IsVoter: true},
+		{Nickname: "voter2", IsVoter: true},
+	} {
+		if _, err := ps.AddUser(adminToken, u); err != nil {
+			t.Fatalf("error adding user: %s", err.Error())
+		}
+	}
+
+	_, err := ps.AddPoll(adminToken, models.Poll{
+		Season:    2020,
+		Week:      1,
+		OpenTime:  time.Now().Add(-48 * time.Hour),
+		CloseTime: time.Now().Add(-24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("error adding poll: %s", err.Error())
+	}
+
+	return ps, teams
+}
+
+// ballotFor ranks teams in the order given, one vote per rank.
+func ballotFor(user string, teams []models.Team) models.Ballot {
+	votes := make([]models.Vote, numRanks)
+	for i := range votes {
+		votes[i] = models.Vote{TeamID: teams[i].ID, Rank: i + 1}
+	}
+
+	return models.Ballot{
+		PollSeason: 2020,
+		PollWeek:   1,
+		User:       user,
+		Votes:      votes,
+		IsOfficial: true,
+	}
+}
+
+func TestAddBallot(t *testing.T) {
+	ps, teams := newTestService(t)
+	voter := models.UserToken{Nickname: "voter1"}
+
+	_, err := ps.AddBallot(models.UserToken{}, ballotFor("voter1", teams))
+	if errors.Kind(err) != errors.KindUnauthenticated {
+		t.Errorf("Expected KindUnauthenticated for anonymous ballot, got %v", errors.Kind(err))
+	}
+
+	_, err = ps.AddBallot(voter, ballotFor("voter2", teams))
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for ballot on behalf of another user, got %v", errors.Kind(err))
+	}
+
+	short := ballotFor("voter1", teams)
+	short.Votes = short.Votes[:numRanks-1]
+	_, err = ps.AddBallot(voter, short)
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest for short ballot, got %v", errors.Kind(err))
+	}
+
+	dupRank := ballotFor("voter1", teams)
+	dupRank.Votes[numRanks-1].Rank = 1
+	_, err = ps.AddBallot(voter, dupRank)
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest for ballot with duplicate rank, got %v", errors.Kind(err))
+	}
+
+	outOfRange := ballotFor("voter1", teams)
+	outOfRange.Votes[numRanks-1].Rank = numRanks + 1
+	_, err = ps.AddBallot(voter, outOfRange)
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest for ballot with rank out of range, got %v", errors.Kind(err))
+	}
+
+	ballot, err := ps.AddBallot(voter, ballotFor("voter1", teams))
+	if err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+
+	_, err = ps.AddBallot(voter, ballotFor("voter1", teams))
+	if errors.Kind(err) != errors.KindConflict {
+		t.Errorf("Expected KindConflict for second ballot, got %v", errors.Kind(err))
+	}
+
+	got, err := ps.GetBallotById(voter, ballot.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error retrieving ballot: %s", err.Error())
+	}
+	if len(got.Votes) != numRanks {
+		t.Errorf("Retrieved ballot has %d votes, expected %d", len(got.Votes), numRanks)
+	}
+}
+
+func TestGetResults(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	// voter1 ranks teams 1-25, voter2 ranks teams 2-26.  Unofficial ballots
+	// don't count toward official results.
+	_, err := ps.AddBallot(adminToken, ballotFor("voter1", teams))
+	if err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+	_, err = ps.AddBallot(adminToken, ballotFor("voter2", teams[1:]))
+	if err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+	unofficial := ballotFor("Concision", teams)
+	unofficial.IsOfficial = false
+	_, err = ps.AddBallot(adminToken, unofficial)
+	if err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+
+	results, err := ps.GetResults(models.UserToken{}, 2020, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error getting results: %s", err.Error())
+	}
+
+	if len(results) != numRanks+1 {
+		t.Fatalf("Expected %d teams receiving votes, got %d", numRanks+1, len(results))
+	}
+
+	// Team 02: 24 + 25 points and a first place vote
+	first := results[0]
+	if first.TeamID != teams[1].ID || first.Points != 49 || first.FirstPlaceVotes != 1 || first.Rank != 1 {
+		t.Errorf("Unexpected first place result: %v", first)
+	}
+
+	// Team 01 and Team 26 are only on one ballot each
+	last := results[len(results)-1]
+	if last.TeamID != teams[numRanks].ID || last.Points != 1 || last.Rank != 0 {
+		t.Errorf("Unexpected last place result: %v", last)
+	}
+
+	// Results are cached until a ballot changes
+	cached, err := ps.Db.GetResults(models.Poll{Season: 2020, Week: 1}, true)
+	if err != nil {
+		t.Fatalf("Unexpected error getting cached results: %s", err.Error())
+	}
+	if len(cached) != 2*(numRanks+1) {
+		t.Errorf("Expected official and provisional results to be cached, found %d rows", len(cached))
+	}
+}
+
+func TestDeleteBallot(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	ballot, err := ps.AddBallot(adminToken, ballotFor("voter1", teams))
+	if err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+
+	err = ps.DeleteBallot(models.UserToken{Nickname: "voter2"}, ballot.ID)
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized deleting another user's ballot, got %v", errors.Kind(err))
+	}
+
+	err = ps.DeleteBallot(models.UserToken{Nickname: "voter1"}, ballot.ID)
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest deleting a ballot for a closed poll, got %v", errors.Kind(err))
+	}
+
+	err = ps.DeleteBallot(adminToken, ballot.ID)
+	if err != nil {
+		t.Errorf("Unexpected error deleting ballot as admin: %s", err.Error())
+	}
+
+	_, err = ps.GetBallotById(adminToken, ballot.ID)
+	if errors.Kind(err) != errors.KindNotFound {
+		t.Errorf("Expected KindNotFound after delete, got %v", errors.Kind(err))
+	}
+}
+
+func TestValidateBallot(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	tests := []struct {
+		name     string
+		modify   func(b *models.Ballot)
+		problems []VoteProblem
+	}{
+		{
+			name:   "Valid",
+			modify: func(b *models.Ballot) {},
+		},
+		{
+			name:   "Repeated rank",
+			modify: func(b *models.Ballot) { b.Votes[6].Rank = 3 },
+			problems: []VoteProblem{
+				{Index: 6, Field: "rank", Message: "rank 3 used more than once"},
+				{Index: -1, Field: "rank", Message: "missing rank 7"},
+			},
+		},
+		{
+			name:   "Rank out of range",
+			modify: func(b *models.Ballot) { b.Votes[0].Rank = 0 },
+			problems: []VoteProblem{
+				{Index: 0, Field: "rank", Message: "rank 0 must be between 1 and 25"},
+				{Index: -1, Field: "rank", Message: "missing rank 1"},
+			},
+		},
+		{
+			name: "Unknown and repeated teams",
+			modify: func(b *models.Ballot) {
+				b.Votes[2].TeamID = 812
+				b.Votes[4].TeamID = b.Votes[1].TeamID
+				b.Votes[5].TeamID = 0
+			},
+			problems: []VoteProblem{
+				{Index: 2, Field: "team_id", Message: "team 812 unknown"},
+				{Index: 4, Field: "team_id", Message: fmt.Sprintf("team %v ranked more than once", teams[1].ID)},
+				{Index: 5, Field: "team_id", Message: "team is required"},
+			},
+		},
+		{
+			name:   "Reason too long",
+			modify: func(b *models.Ballot) { b.Votes[9].Reason = strings.Repeat("x", 141) },
+			problems: []VoteProblem{
+				{Index: 9, Field: "reason", Message: "reason can't be longer than 140 characters"},
+			},
+		},
+		{
+			name:   "Too few votes",
+			modify: func(b *models.Ballot) { b.Votes = b.Votes[:numRanks-1] },
+			problems: []VoteProblem{
+				{Index: -1, Field: "votes", Message: "ballots must contain exactly 25 votes, found 24"},
+				{Index: -1, Field: "rank", Message: "missing rank 25"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := ballotFor("voter1", teams)
+			test.modify(&b)
+
+			err := ps.validateBallot(b)
+			if test.problems == nil {
+				if err != nil {
+					t.Errorf("Unexpected error validating ballot: %s", err.Error())
+				}
+				return
+			}
+
+			verr, ok := err.(*BallotValidationError)
+			if !ok {
+				t.Fatalf("Expected *BallotValidationError, got %v", err)
+			}
+			if !reflect.DeepEqual(verr.Problems, test.problems) {
+				t.Errorf("Unexpected problems:\n%v\nexpected:\n%v", verr.Problems, test.problems)
+			}
+		})
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	ps, teams := newTestService(t)
+	moderator := models.UserToken{Nickname: "mod", Roles: []models.Role{models.RoleVoterModerator}}
+	manager := models.UserToken{Nickname: "manager", Roles: []models.Role{models.RolePollManager}}
+	editor := models.UserToken{Nickname: "editor", Roles: []models.Role{models.RoleTeamEditor}}
+	for _, token := range []models.UserToken{moderator, manager, editor} {
+		if _, err := ps.AddUser(adminToken, models.User{Nickname: token.Nickname, Roles: token.Roles}); err != nil {
+			t.Fatalf("error adding user: %s", err.Error())
+		}
+	}
+
+	// Voter moderators can remove other voters, but not change anything else about them
+	u, err := ps.UpdateUser(moderator, "voter2", models.User{Nickname: "voter2", IsVoter: false})
+	if err != nil {
+		t.Errorf("Unexpected error removing a voter as voter moderator: %s", err.Error())
+	} else if u.IsVoter {
+		t.Errorf("Expected voter2 not to be a voter, got %v", u)
+	}
+
+	_, err = ps.UpdateUser(moderator, "voter2", models.User{Nickname: "voter2", IsVoter: true, PrimaryTeam: teams[0].ID})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for voter moderator changing another user's team, got %v", errors.Kind(err))
+	}
+
+	_, err = ps.UpdateUser(manager, "voter2", models.User{Nickname: "voter2", IsVoter: true})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for poll manager changing voter status, got %v", errors.Kind(err))
+	}
+
+	_, err = ps.AddPoll(moderator, models.Poll{Season: 2020, Week: 2})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for voter moderator adding a poll, got %v", errors.Kind(err))
+	}
+
+	_, err = ps.AddPoll(manager, models.Poll{Season: 2020, Week: 2, OpenTime: time.Now(), CloseTime: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Errorf("Unexpected error adding poll as poll manager: %s", err.Error())
+	}
+
+	_, err = ps.AddTeam(manager, models.Team{ShortName: "Team 99"})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for poll manager adding a team, got %v", errors.Kind(err))
+	}
+
+	_, err = ps.AddTeam(editor, models.Team{ShortName: "Team 99"})
+	if err != nil {
+		t.Errorf("Unexpected error adding team as team editor: %s", err.Error())
+	}
+
+	ballot, err := ps.AddBallot(adminToken, ballotFor("voter1", teams))
+	if err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+
+	err = ps.DeleteBallot(manager, ballot.ID)
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for poll manager deleting another user's ballot, got %v", errors.Kind(err))
+	}
+}
+
+func TestUpdateUserRoles(t *testing.T) {
+	ps, _ := newTestService(t)
+
+	_, err := ps.AddUser(adminToken, models.User{Nickname: "mod", Roles: []models.Role{models.RoleVoterModerator}})
+	if err != nil {
+		t.Fatalf("Unexpected error adding user: %s", err.Error())
+	}
+	moderator := models.UserToken{Nickname: "mod", Roles: []models.Role{models.RoleVoterModerator}}
+
+	// Moderators can't approve themselves or change their own roles
+	_, err = ps.UpdateUser(moderator, "mod", models.User{Nickname: "mod", IsVoter: true})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for moderator making themselves a voter, got %v", errors.Kind(err))
+	}
+
+	u, err := ps.UpdateUser(moderator, "mod", models.User{Nickname: "mod", Roles: []models.Role{models.RoleAdmin}})
+	if err != nil {
+		t.Fatalf("Unexpected error updating user: %s", err.Error())
+	}
+	if u.IsVoter || u.IsAdmin || !reflect.DeepEqual(u.Roles, []models.Role{models.RoleVoterModerator}) {
+		t.Errorf("Expected roles in update to be ignored, got %v", u)
+	}
+
+	_, err = ps.UpdateUser(moderator, "mod", models.User{Nickname: "mod", IsAdmin: true})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for moderator making themselves admin, got %v", errors.Kind(err))
+	}
+
+	_, err = ps.SetRoles(moderator, "mod", []models.Role{models.RoleAdmin})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for moderator granting roles, got %v", errors.Kind(err))
+	}
+
+	_, err = ps.SetRoles(adminToken, "mod", []models.Role{"superuser"})
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest for unknown role, got %v", errors.Kind(err))
+	}
+
+	_, err = ps.SetRoles(adminToken, "nobody", []models.Role{models.RoleAuditor})
+	if errors.Kind(err) != errors.KindNotFound {
+		t.Errorf("Expected KindNotFound setting roles of a missing user, got %v", errors.Kind(err))
+	}
+
+	u, err = ps.SetRoles(adminToken, "mod", []models.Role{models.RoleAuditor, models.RoleAdmin})
+	if err != nil {
+		t.Fatalf("Unexpected error setting roles: %s", err.Error())
+	}
+	if !u.IsAdmin || !reflect.DeepEqual(u.Roles, []models.Role{models.RoleAdmin, models.RoleAuditor}) {
+		t.Errorf("Unexpected user after setting roles: %v", u)
+	}
+
+	// Removing admin status through UpdateUser keeps the other roles
+	u, err = ps.UpdateUser(adminToken, "mod", models.User{Nickname: "mod", IsVoter: true})
+	if err != nil {
+		t.Fatalf("Unexpected error updating user: %s", err.Error())
+	}
+	if u.IsAdmin || !reflect.DeepEqual(u.Roles, []models.Role{models.RoleAuditor}) {
+		t.Errorf("Expected only the admin role to be removed, got %v", u)
+	}
+}
+
+func TestLivePermissions(t *testing.T) {
+	ps, _ := newTestService(t)
+
+	_, err := ps.AddUser(adminToken, models.User{Nickname: "editor", Roles: []models.Role{models.RoleTeamEditor}})
+	if err != nil {
+		t.Fatalf("Unexpected error adding user: %s", err.Error())
+	}
+	editor := models.UserToken{Nickname: "editor", Roles: []models.Role{models.RoleTeamEditor}}
+
+	_, err = ps.AddTeam(editor, models.Team{ShortName: "Team 99"})
+	if err != nil {
+		t.Fatalf("Unexpected error adding team: %s", err.Error())
+	}
+
+	// The token still claims the role, but the database no longer grants it
+	_, err = ps.SetRoles(adminToken, "editor", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error setting roles: %s", err.Error())
+	}
+	_, err = ps.AddTeam(editor, models.Team{ShortName: "Team 100"})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized after role was revoked, got %v", errors.Kind(err))
+	}
+
+	// A token claiming roles the user never had doesn't get them either
+	forged := models.UserToken{Nickname: "voter1", IsAdmin: true}
+	_, err = ps.AddPoll(forged, models.Poll{Season: 2020, Week: 2})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for admin claim not backed by the database, got %v", errors.Kind(err))
+	}
+
+	_, err = ps.AddPoll(models.UserToken{Nickname: "nobody", IsAdmin: true}, models.Poll{Season: 2020, Week: 2})
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for deleted user, got %v", errors.Kind(err))
+	}
+}
+
+func TestPermissionCache(t *testing.T) {
+	ps, _ := newTestService(t)
+
+	u, err := ps.currentUser("voter1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	// Changes made behind the service's back are only seen once the entry expires
+	u.IsAdmin = true
+	if err = ps.Db.UpdateUser(u); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	cached, _ := ps.currentUser("voter1")
+	if cached.IsAdmin {
+		t.Errorf("Expected cached user to be returned")
+	}
+
+	ps.perms.ttl = 0
+	fresh, _ := ps.currentUser("voter1")
+	if !fresh.IsAdmin {
+		t.Errorf("Expected expired cache entry to be reloaded")
+	}
+}
+
+func TestAddBallotOfficial(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	// The client can't decide whether a ballot counts
+	ballot := ballotFor("Concision", teams)
+	ballot.IsOfficial = true
+	added, err := ps.AddBallot(adminToken, ballot)
+	if err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+	if added.IsOfficial {
+		t.Errorf("Ballot from a non-voter was marked official")
+	}
+}
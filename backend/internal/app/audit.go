@@ -0,0 +1,58 @@
+package app
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+/*
+audited returns a database client that records the privileged change user is
+about to make to a target in the audit log, in the same transaction as the
+change.  before and after are how the target looks either side of the change;
+either can be nil, when the target is being created or deleted.
+*/
+func (ps PollService) audited(user models.UserToken, action models.AuditAction, targetType string, targetID string, before interface{}, after interface{}) (db.DBClient, error) {
+	const op errors.Op = "app.audited"
+
+	entry := models.AuditEntry{
+		Time:       time.Now().UTC(),
+		Actor:      user.Nickname,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RequestID:  ps.requestID,
+	}
+
+	var err error
+	if before != nil {
+		if entry.Before, err = json.Marshal(before); err != nil {
+			return nil, errors.E(op, err, "error recording target before change")
+		}
+	}
+	if after != nil {
+		if entry.After, err = json.Marshal(after); err != nil {
+			return nil, errors.E(op, err, "error recording target after change")
+		}
+	}
+
+	return ps.Db.WithAudit(entry), nil
+}
+
+// GetAuditLog returns the privileged changes matching opts, newest first.
+func (ps PollService) GetAuditLog(user models.UserToken, opts Options) ([]models.AuditEntry, error) {
+	const op errors.Op = "app.GetAuditLog"
+	if err := ps.authorize(user, models.PermViewAudit); err != nil {
+		return nil, errors.E(op, err, "user can't view the audit log")
+	}
+
+	entries, err := ps.Db.GetAuditLog(opts.unpack())
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving audit log from db")
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+func TestAuditLog(t *testing.T) {
+	ps, teams := newTestService(t)
+	ps = ps.WithRequestID("req-1")
+	voter1 := models.UserToken{Nickname: "voter1"}
+
+	// Voters looking after their own profile and ballots aren't audited
+	self, _ := ps.GetUser(adminToken, "voter1")
+	self.PrimaryTeam = teams[0].ID
+	if _, err := ps.UpdateUser(voter1, "voter1", self); err != nil {
+		t.Fatal(err)
+	}
+	open := models.Poll{Season: 2020, Week: 2, OpenTime: time.Now().Add(-time.Hour), CloseTime: time.Now().Add(time.Hour)}
+	if _, err := ps.AddPoll(adminToken, open); err != nil {
+		t.Fatal(err)
+	}
+	b := ballotFor("voter1", teams)
+	b.PollWeek = 2
+	b, err := ps.AddBallot(voter1, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ps.DeleteBallot(voter1, b.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Admins changing anything are
+	voter2, _ := ps.GetUser(adminToken, "voter2")
+	voter2.IsVoter = false
+	if _, err = ps.UpdateUser(adminToken, "voter2", voter2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ps.SetRoles(adminToken, "voter1", []models.Role{models.RoleAuditor}); err != nil {
+		t.Fatal(err)
+	}
+	closed, err := ps.AddBallot(adminToken, ballotFor("voter1", teams))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ps.DeleteBallot(adminToken, closed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = ps.DeleteUser(adminToken, "voter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ps.GetAuditLog(models.UserToken{Nickname: "voter2"}, NewOptions())
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized viewing the audit log as a deleted user, got %v", err)
+	}
+
+	// voter1 is now an auditor
+	entries, err := ps.GetAuditLog(voter1, NewOptions().RequestID("req-1"))
+	if err != nil {
+		t.Fatalf("Unexpected error getting audit log: %s", err.Error())
+	}
+	expected := []struct {
+		action   models.AuditAction
+		targetID string
+	}{
+		{models.AuditDeleteUser, ""},
+		{models.AuditDeleteBallot, strconv.FormatInt(closed.ID, 10)},
+		{models.AuditSetRoles, "voter1"},
+		{models.AuditUpdateUser, ""},
+		{models.AuditAddPoll, "2020/2"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d audit entries, got %d: %+v", len(expected), len(entries), entries)
+	}
+	for i, e := range entries {
+		if e.Action != expected[i].action || e.Actor != adminToken.Nickname || e.RequestID != "req-1" {
+			t.Errorf("Unexpected audit entry %d: %+v", i, e)
+		}
+		if expected[i].targetID != "" && e.TargetID != expected[i].targetID {
+			t.Errorf("Expected audit entry %d to target %s, got %s", i, expected[i].targetID, e.TargetID)
+		}
+	}
+
+	// The deleted user isn't named anywhere in the log, just under the alias
+	// their deletion was recorded with
+	alias := entries[0].TargetID
+	if !strings.HasPrefix(alias, "[deleted-") || entries[0].Before != nil || entries[0].After != nil {
+		t.Errorf("Expected the deleted user to be forgotten, got %+v", entries[0])
+	}
+	if entries[3].TargetID != alias {
+		t.Errorf("Expected the earlier change to voter2 to target %s, got %s", alias, entries[3].TargetID)
+	}
+	all, err := ps.GetAuditLog(adminToken, NewOptions())
+	if err != nil {
+		t.Fatalf("Unexpected error getting audit log: %s", err.Error())
+	}
+	for _, e := range all {
+		if b, _ := json.Marshal(e); strings.Contains(string(b), "voter2") {
+			t.Errorf("Audit entry still names the deleted user: %s", b)
+		}
+	}
+
+	var before, after models.User
+	if err = json.Unmarshal(entries[3].Before, &before); err != nil || !before.IsVoter {
+		t.Errorf("Expected voter2 to be a voter before the change, got %s", entries[3].Before)
+	}
+	if err = json.Unmarshal(entries[3].After, &after); err != nil || after.IsVoter {
+		t.Errorf("Expected voter2 not to be a voter after the change, got %s", entries[3].After)
+	}
+	var ballot models.Ballot
+	if err = json.Unmarshal(entries[1].Before, &ballot); err != nil || ballot.User != "voter1" || entries[1].After != nil {
+		t.Errorf("Expected the deleted ballot as the before of its entry, got %s and %s", entries[1].Before, entries[1].After)
+	}
+
+	// Teams are recorded once they have an ID
+	teamEntries, err := ps.GetAuditLog(adminToken, NewOptions().Action(models.AuditAddTeam).TargetID(strconv.FormatInt(teams[3].ID, 10)))
+	if err != nil {
+		t.Fatalf("Unexpected error getting audit log: %s", err.Error())
+	}
+	var team models.Team
+	if len(teamEntries) != 1 || json.Unmarshal(teamEntries[0].After, &team) != nil || team != teams[3] {
+		t.Errorf("Expected one entry adding %v, got %+v", teams[3], teamEntries)
+	}
+
+	recent, err := ps.GetAuditLog(adminToken, NewOptions().Actor(adminToken.Nickname).Since(entries[2].Time).Until(time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatalf("Unexpected error getting audit log: %s", err.Error())
+	}
+	if len(recent) != 3 {
+		t.Errorf("Expected the 3 most recent entries, got %+v", recent)
+	}
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"math"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+/*
+GetPollBias reports how each official voter in a closed poll ranked their
+primary team compared to the poll's official results, and how much the
+voters favoured their own teams overall.  Voters are judged by their current
+primary team, which may not be the one they had when they voted.
+*/
+func (ps PollService) GetPollBias(season int, week int) (models.PollBias, error) {
+	const op errors.Op = "app.GetPollBias"
+
+	poll, err := ps.Db.GetPoll(season, week)
+	if err != nil {
+		return models.PollBias{}, errors.E(op, err, "error retrieving poll from db")
+	}
+
+	if poll.CloseTime.After(time.Now()) {
+		return models.PollBias{}, errors.E(op, errors.KindUnauthorized, "bias is only reported once the poll closes")
+	}
+
+	users, err := ps.Db.GetUsers(nil, db.Sort{})
+	if err != nil {
+		return models.PollBias{}, errors.E(op, err, "error retrieving users from db")
+	}
+	if err := ps.withTeams(users); err != nil {
+		return models.PollBias{}, errors.E(op, err)
+	}
+
+	teams := make(map[string]models.Team)
+	for _, u := range users {
+		if u.Team != nil {
+			teams[u.Nickname] = *u.Team
+		}
+	}
+
+	bias, err := ps.pollBias(poll, teams)
+	if err != nil {
+		return models.PollBias{}, errors.E(op, err)
+	}
+
+	return bias, nil
+}
+
+// GetUserBias reports how the user called name ranked their primary team in
+// every closed poll, along with their homer index for each season.
+func (ps PollService) GetUserBias(name string) (models.UserBias, error) {
+	const op errors.Op = "app.GetUserBias"
+
+	user, err := ps.GetUser(models.UserToken{}, name)
+	if err != nil {
+		return models.UserBias{}, errors.E(op, err)
+	}
+
+	bias := models.UserBias{User: user.Nickname, Seasons: []models.SeasonBias{}, Polls: []models.TeamBias{}}
+	if user.Team == nil {
+		return bias, nil
+	}
+
+	// Only the polls the user voted in matter, oldest first
+	ballots, err := ps.Db.GetBallotsByUser(user.Nickname)
+	if err != nil {
+		return models.UserBias{}, errors.E(op, err, "error retrieving user's ballots from db")
+	}
+	if len(ballots) == 0 {
+		return bias, nil
+	}
+
+	polls, err := ps.Db.GetPolls([]db.Filter{{Field: "close_time", Operator: db.Lt, Value: time.Now()}}, db.Sort{})
+	if err != nil {
+		return models.UserBias{}, errors.E(op, err, "error retrieving closed polls from db")
+	}
+	closed := make(map[[2]int]models.Poll, len(polls))
+	for _, p := range polls {
+		closed[[2]int{p.Season, p.Week}] = p
+	}
+
+	for _, b := range ballots {
+		poll, ok := closed[[2]int{b.PollSeason, b.PollWeek}]
+		if !ok || !b.IsOfficial {
+			continue
+		}
+		consensus, err := ps.consensus(poll)
+		if err != nil {
+			return models.UserBias{}, errors.E(op, err)
+		}
+		if tb, ok := teamBias(poll, consensus, b, *user.Team); ok {
+			bias.Polls = append(bias.Polls, tb)
+		}
+	}
+
+	for start := 0; start < len(bias.Polls); {
+		end := start
+		for end < len(bias.Polls) && bias.Polls[end].PollSeason == bias.Polls[start].PollSeason {
+			end++
+		}
+		season := bias.Polls[start:end]
+		bias.Seasons = append(bias.Seasons, models.SeasonBias{
+			Season:     season[0].PollSeason,
+			Polls:      len(season),
+			HomerIndex: homerIndex(season),
+		})
+		start = end
+	}
+
+	return bias, nil
+}
+
+/*
+pollBias compares the official ballots in poll with its official results for
+the voters in teams, which maps nicknames to primary teams.  Voters whose team
+neither they nor the consensus ranked are left out, since there's nothing to
+compare.
+*/
+func (ps PollService) pollBias(poll models.Poll, teams map[string]models.Team) (models.PollBias, error) {
+	const op errors.Op = "app.pollBias"
+
+	consensus, err := ps.consensus(poll)
+	if err != nil {
+		return models.PollBias{}, errors.E(op, err)
+	}
+
+	ballots, err := ps.Db.GetBallotsByPoll(poll)
+	if err != nil {
+		return models.PollBias{}, errors.E(op, err, "error retrieving ballots from db")
+	}
+
+	bias := models.PollBias{Season: poll.Season, Week: poll.Week, Voters: []models.TeamBias{}}
+	for _, b := range ballots {
+		team, ok := teams[b.User]
+		if !b.IsOfficial || !ok {
+			continue
+		}
+		if tb, ok := teamBias(poll, consensus, b, team); ok {
+			bias.Voters = append(bias.Voters, tb)
+		}
+	}
+	bias.HomerIndex = homerIndex(bias.Voters)
+
+	return bias, nil
+}
+
+// consensus maps each team ranked in poll's official results to its rank.
+func (ps PollService) consensus(poll models.Poll) (map[int64]int, error) {
+	const op errors.Op = "app.consensus"
+
+	results, err := ps.officialResults(poll)
+	if err != nil {
+		return nil, errors.E(op, err, "error getting poll results")
+	}
+	consensus := make(map[int64]int, len(results))
+	for _, r := range results {
+		consensus[r.TeamID] = r.Rank
+	}
+
+	return consensus, nil
+}
+
+// teamBias compares where ballot b ranked team with where the consensus did.
+// It's false if neither ranked the team.
+func teamBias(poll models.Poll, consensus map[int64]int, b models.Ballot, team models.Team) (models.TeamBias, bool) {
+	var ballotRank int
+	for _, v := range b.Votes {
+		if v.TeamID == team.ID {
+			ballotRank = v.Rank
+		}
+	}
+	consensusRank := consensus[team.ID]
+	if ballotRank == 0 && consensusRank == 0 {
+		return models.TeamBias{}, false
+	}
+
+	return models.TeamBias{
+		PollSeason:    poll.Season,
+		PollWeek:      poll.Week,
+		User:          b.User,
+		TeamID:        team.ID,
+		TeamSlug:      team.Slug,
+		BallotRank:    ballotRank,
+		ConsensusRank: consensusRank,
+		Difference:    placing(consensusRank) - placing(ballotRank),
+	}, true
+}
+
+// placing treats an unranked team, rank 0, as one place below the last rank.
+func placing(rank int) int {
+	if rank == 0 {
+		return numRanks + 1
+	}
+	return rank
+}
+
+// homerIndex is the mean difference of bs, to two decimal places.
+func homerIndex(bs []models.TeamBias) float64 {
+	if len(bs) == 0 {
+		return 0
+	}
+
+	var total int
+	for _, b := range bs {
+		total += b.Difference
+	}
+	return math.Round(float64(total)/float64(len(bs))*100) / 100
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+func TestGetPollBias(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	setTeam := func(name string, team int64) {
+		t.Helper()
+		u, err := ps.GetUser(adminToken, name)
+		if err != nil {
+			u, err = ps.AddUser(adminToken, models.User{Nickname: name})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		u.PrimaryTeam = team
+		if _, err = ps.UpdateUser(adminToken, name, u); err != nil {
+			t.Fatalf("Unexpected error updating user: %s", err.Error())
+		}
+	}
+	// voter1 puts their team first; voter2 ranks theirs as everyone else would
+	setTeam("voter1", teams[5].ID)
+	setTeam("voter2", teams[1].ID)
+	// Neither the fan of the one team nobody ranks, nor an unofficial voter, is counted
+	setTeam("voter3", teams[numRanks].ID)
+	setTeam("fan", teams[0].ID)
+
+	homer := append([]models.Team{teams[5]}, teams[:5]...)
+	homer = append(homer, teams[6:numRanks]...)
+	voter3, _ := ps.GetUser(adminToken, "voter3")
+	voter3.IsVoter = true
+	if _, err := ps.UpdateUser(adminToken, "voter3", voter3); err != nil {
+		t.Fatal(err)
+	}
+	for voter, ranked := range map[string][]models.Team{"voter1": homer, "voter2": teams, "voter3": teams, "fan": teams} {
+		if _, err := ps.AddBallot(adminToken, ballotFor(voter, ranked)); err != nil {
+			t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+		}
+	}
+
+	results, err := ps.GetResults(adminToken, 2020, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consensus := make(map[int64]int)
+	for _, r := range results {
+		consensus[r.TeamID] = r.Rank
+	}
+
+	bias, err := ps.GetPollBias(2020, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error getting poll bias: %s", err.Error())
+	}
+	if len(bias.Voters) != 2 {
+		t.Fatalf("Expected voter1 and voter2, got %v", bias.Voters)
+	}
+
+	byUser := make(map[string]models.TeamBias)
+	for _, b := range bias.Voters {
+		byUser[b.User] = b
+	}
+	v1, v2 := byUser["voter1"], byUser["voter2"]
+	if v1.BallotRank != 1 || v1.ConsensusRank != consensus[teams[5].ID] || v1.Difference != consensus[teams[5].ID]-1 {
+		t.Errorf("Unexpected bias for voter1: %+v", v1)
+	}
+	if v1.Difference <= 0 {
+		t.Errorf("Expected voter1 to have ranked their team above the consensus: %+v", v1)
+	}
+	if v2.BallotRank != 2 || v2.Difference != consensus[teams[1].ID]-2 || v2.TeamSlug != teams[1].Slug {
+		t.Errorf("Unexpected bias for voter2: %+v", v2)
+	}
+	if expected := float64(v1.Difference+v2.Difference) / 2; bias.HomerIndex != expected {
+		t.Errorf("Expected homer index %v, got %v", expected, bias.HomerIndex)
+	}
+
+	open := models.Poll{Season: 2020, Week: 2, OpenTime: time.Now().Add(-time.Hour), CloseTime: time.Now().Add(time.Hour)}
+	if _, err = ps.AddPoll(adminToken, open); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ps.GetPollBias(2020, 2); errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized for an open poll, got %v", err)
+	}
+}
+
+func TestGetUserBias(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	bias, err := ps.GetUserBias("voter1")
+	if err != nil {
+		t.Fatalf("Unexpected error getting user bias: %s", err.Error())
+	}
+	if len(bias.Polls) != 0 || len(bias.Seasons) != 0 {
+		t.Errorf("Expected no bias without a primary team, got %+v", bias)
+	}
+
+	if _, err = ps.GetUserBias("nobody"); errors.Kind(err) != errors.KindNotFound {
+		t.Errorf("Expected KindNotFound for unknown user, got %v", err)
+	}
+
+	voter1, _ := ps.GetUser(adminToken, "voter1")
+	voter1.PrimaryTeam = teams[3].ID
+	if _, err = ps.UpdateUser(adminToken, "voter1", voter1); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := func(season, week int) {
+		t.Helper()
+		poll := models.Poll{Season: season, Week: week, OpenTime: time.Now().Add(-48 * time.Hour), CloseTime: time.Now().Add(-time.Hour)}
+		if _, err := ps.AddPoll(adminToken, poll); err != nil {
+			t.Fatal(err)
+		}
+	}
+	closed(2020, 2)
+	closed(2019, 1)
+	closed(2018, 1)
+
+	// In 2020 voter2 ranks voter1's team 4th, keeping it below 1st in the consensus
+	homer := append([]models.Team{teams[3]}, teams[:3]...)
+	homer = append(homer, teams[4:numRanks]...)
+	for _, week := range []int{1, 2} {
+		for voter, ranked := range map[string][]models.Team{"voter1": homer, "voter2": teams} {
+			b := ballotFor(voter, ranked)
+			b.PollWeek = week
+			if _, err := ps.AddBallot(adminToken, b); err != nil {
+				t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+			}
+		}
+	}
+	// voter1 sat out 2018
+	b := ballotFor("voter2", teams)
+	b.PollSeason = 2018
+	if _, err = ps.AddBallot(adminToken, b); err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+	// In 2019 voter1 is the consensus
+	b = ballotFor("voter1", homer)
+	b.PollSeason = 2019
+	if _, err = ps.AddBallot(adminToken, b); err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+
+	bias, err = ps.GetUserBias("voter1")
+	if err != nil {
+		t.Fatalf("Unexpected error getting user bias: %s", err.Error())
+	}
+	if len(bias.Polls) != 3 {
+		t.Fatalf("Expected 3 polls, got %+v", bias.Polls)
+	}
+	if p := bias.Polls[0]; p.PollSeason != 2019 || p.Difference != 0 {
+		t.Errorf("Expected no bias in 2019 week 1, got %+v", p)
+	}
+	if len(bias.Seasons) != 2 || bias.Seasons[0].Season != 2019 || bias.Seasons[1].Polls != 2 {
+		t.Fatalf("Unexpected seasons %+v", bias.Seasons)
+	}
+	if d := bias.Polls[1].Difference; d <= 0 || bias.Seasons[1].HomerIndex != float64(d) {
+		t.Errorf("Expected a positive 2020 homer index of %d, got %+v", d, bias.Seasons[1])
+	}
+
+	// Polls the user didn't vote in aren't looked at
+	unvoted, err := ps.Db.GetPoll(2018, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, err := ps.Db.GetResults(unvoted, false); err != nil || len(results) != 0 {
+		t.Errorf("Expected no results calculated for a poll voter1 didn't vote in, got %v (%v)", results, err)
+	}
+}
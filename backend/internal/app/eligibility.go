@@ -0,0 +1,66 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+/*
+EligibilityRules decide which reddit accounts can submit ballots, to keep
+throwaway accounts from flooding the provisional poll.  They're checked against
+what reddit reports about the account each time the user logs in, and the
+outcome is stored on the user until their next login.  The zero value lets
+everyone vote.
+*/
+type EligibilityRules struct {
+	// How long the account has to have existed
+	MinAccountAge time.Duration
+	// Link and comment karma combined
+	MinKarma int
+	// Whether suspended accounts can vote
+	AllowSuspended bool
+	// Subreddit the account needs flair in, without the r/.  Empty if flair isn't required.
+	FlairSubreddit string
+}
+
+// RequiresFlair reports whether the account's flair has to be looked up at login.
+func (r EligibilityRules) RequiresFlair() bool {
+	return r.FlairSubreddit != ""
+}
+
+// check returns the first rule account breaks, as a reason to show the user, or
+// an empty string if it breaks none.
+func (r EligibilityRules) check(account models.RedditAccount, now time.Time) string {
+	if account.Suspended && !r.AllowSuspended {
+		return "reddit account is suspended"
+	}
+
+	if account.Age(now) < r.MinAccountAge {
+		return fmt.Sprintf("reddit account must be at least %s old", formatAge(r.MinAccountAge))
+	}
+
+	if account.Karma() < r.MinKarma {
+		return fmt.Sprintf("reddit account needs at least %d karma", r.MinKarma)
+	}
+
+	if r.RequiresFlair() && account.Flair == "" {
+		return fmt.Sprintf("reddit account needs flair in r/%s", r.FlairSubreddit)
+	}
+
+	return ""
+}
+
+// formatAge describes an account age in days where it's a whole number of them.
+func formatAge(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d == day:
+		return "1 day"
+	case d%day == 0:
+		return fmt.Sprintf("%d days", d/day)
+	default:
+		return d.String()
+	}
+}
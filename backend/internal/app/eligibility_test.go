@@ -0,0 +1,166 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+func TestEligibilityRules(t *testing.T) {
+	now := time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC)
+	rules := EligibilityRules{
+		MinAccountAge:  30 * 24 * time.Hour,
+		MinKarma:       100,
+		FlairSubreddit: "CollegeBasketball",
+	}
+	established := models.RedditAccount{
+		Name:         "Concision",
+		Created:      now.AddDate(-5, 0, 0),
+		LinkKarma:    60,
+		CommentKarma: 40,
+		Flair:        "Arizona",
+	}
+
+	tests := []struct {
+		name           string
+		rules          EligibilityRules
+		modify         func(a *models.RedditAccount)
+		expectedReason string
+	}{
+		{
+			name:   "Eligible",
+			rules:  rules,
+			modify: func(a *models.RedditAccount) {},
+		},
+		{
+			name:           "Suspended",
+			rules:          rules,
+			modify:         func(a *models.RedditAccount) { a.Suspended = true },
+			expectedReason: "suspended",
+		},
+		{
+			name:   "Suspended accounts allowed",
+			rules:  EligibilityRules{AllowSuspended: true},
+			modify: func(a *models.RedditAccount) { a.Suspended = true },
+		},
+		{
+			name:           "Too new",
+			rules:          rules,
+			modify:         func(a *models.RedditAccount) { a.Created = now.Add(-29 * 24 * time.Hour) },
+			expectedReason: "at least 30 days old",
+		},
+		{
+			name:           "Not enough karma",
+			rules:          rules,
+			modify:         func(a *models.RedditAccount) { a.CommentKarma = 39 },
+			expectedReason: "at least 100 karma",
+		},
+		{
+			name:           "No flair",
+			rules:          rules,
+			modify:         func(a *models.RedditAccount) { a.Flair = "" },
+			expectedReason: "flair in r/CollegeBasketball",
+		},
+		{
+			name:   "No rules",
+			rules:  EligibilityRules{},
+			modify: func(a *models.RedditAccount) { *a = models.RedditAccount{Name: "throwaway", Created: now} },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			account := established
+			test.modify(&account)
+
+			reason := test.rules.check(account, now)
+			if test.expectedReason == "" {
+				if reason != "" {
+					t.Errorf("Expected account to be eligible, got %q", reason)
+				}
+				return
+			}
+			if !strings.Contains(reason, test.expectedReason) {
+				t.Errorf("Expected reason mentioning %q, got %q", test.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestLoginUserEligibility(t *testing.T) {
+	ps, teams := newTestService(t)
+	ps.Eligibility = EligibilityRules{MinKarma: 100}
+
+	newAccount := models.RedditAccount{Name: "throwaway", Created: time.Now()}
+	user, created, err := ps.LoginUser(newAccount)
+	if err != nil {
+		t.Fatalf("Unexpected error logging in: %s", err.Error())
+	}
+	if !created || user.Eligible() {
+		t.Errorf("Expected new, ineligible user, got %v (created %v)", user, created)
+	}
+
+	// Being ineligible doesn't stop logging in, only voting
+	throwaway := models.UserToken{Nickname: "throwaway"}
+	_, err = ps.AddBallot(throwaway, ballotFor("throwaway", teams))
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized adding ballot for ineligible user, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "karma") {
+		t.Errorf("Expected error to give the reason: %s", err.Error())
+	}
+
+	// Nor can someone else submit one on their behalf
+	_, err = ps.AddBallot(adminToken, ballotFor("throwaway", teams))
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized adding ballot for ineligible user as admin, got %v", err)
+	}
+
+	// Only they and those who manage users can see why
+	moderator := models.UserToken{Nickname: "mod", Roles: []models.Role{models.RoleVoterModerator}}
+	for _, token := range []models.UserToken{throwaway, adminToken, {}, {Nickname: "voter1"}, moderator} {
+		expected := token.Nickname == "throwaway" || token.IsAdmin
+		if u, err := ps.GetUser(token, "throwaway"); err != nil || (u.IneligibleReason != "") != expected {
+			t.Errorf("Expected %q to see the reason %v, got %q (%v)", token.Nickname, expected, u.IneligibleReason, err)
+		}
+		users, err := ps.GetUsers(token, NewOptions())
+		if err != nil {
+			t.Fatalf("Unexpected error getting users: %s", err.Error())
+		}
+		for _, u := range users {
+			if u.Nickname == "throwaway" && (u.IneligibleReason != "") != expected {
+				t.Errorf("Expected %q to see the reason %v in the list of users, got %q", token.Nickname, expected, u.IneligibleReason)
+			}
+		}
+	}
+
+	// Nor can they declare themselves eligible
+	user.IneligibleReason = ""
+	if _, err = ps.UpdateUser(throwaway, "throwaway", user); err != nil {
+		t.Fatalf("Unexpected error updating user: %s", err.Error())
+	}
+	if stored, _ := ps.GetUser(adminToken, "throwaway"); stored.Eligible() {
+		t.Errorf("Expected user to stay ineligible after editing themselves")
+	}
+
+	// The rules are checked again on the next login
+	newAccount.LinkKarma = 100
+	user, created, err = ps.LoginUser(newAccount)
+	if err != nil {
+		t.Fatalf("Unexpected error logging in: %s", err.Error())
+	}
+	if created || !user.Eligible() {
+		t.Errorf("Expected existing, eligible user, got %v (created %v)", user, created)
+	}
+	if stored, _ := ps.GetUser(adminToken, "throwaway"); !stored.Eligible() {
+		t.Errorf("Eligibility wasn't saved: %q", stored.IneligibleReason)
+	}
+
+	_, err = ps.AddBallot(throwaway, ballotFor("throwaway", teams))
+	if err != nil {
+		t.Errorf("Unexpected error adding ballot for eligible user: %s", err.Error())
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// FlairSetter sets users' flair in the subreddit the poll is run for.
+type FlairSetter interface {
+	// SetFlair gives user flair with text and cssClass, or clears their flair if
+	// both are empty.
+	SetFlair(user string, text string, cssClass string) error
+}
+
+/*
+SyncFlair sets the subreddit flair of every user whose primary team has
+changed since their flair was last set, showing the team's short name and
+using its slug as the css class so the subreddit's stylesheet can add a logo.
+Users who've dropped their primary team have their flair cleared.
+
+A user whose flair can't be set is skipped, and tried again on the next sync.
+*/
+func (ps PollService) SyncFlair() error {
+	const op errors.Op = "app.SyncFlair"
+
+	if ps.Flair == nil {
+		return errors.E(op, errors.KindNotImplemented, "setting flair on reddit isn't configured")
+	}
+
+	users, err := ps.Db.GetUsers(nil, db.Sort{})
+	if err != nil {
+		return errors.E(op, err, "error retrieving users from db")
+	}
+	if err := ps.withTeams(users); err != nil {
+		return errors.E(op, err)
+	}
+
+	synced, err := ps.Db.GetFlairTeams()
+	if err != nil {
+		return errors.E(op, err, "error retrieving flair teams from db")
+	}
+
+	var failed int
+	for _, u := range users {
+		if synced[u.Nickname] == u.PrimaryTeam {
+			continue
+		}
+
+		err = ps.setFlair(u)
+		if err != nil {
+			failed++
+			ps.logger().WithField("user", u.Nickname).WithError(err).Warn("error setting flair")
+		}
+	}
+
+	if failed > 0 {
+		return errors.E(op, fmt.Errorf("couldn't set flair for %d users", failed))
+	}
+
+	return nil
+}
+
+func (ps PollService) setFlair(u models.User) error {
+	const op errors.Op = "app.setFlair"
+
+	var text, cssClass string
+	if u.Team != nil {
+		text, cssClass = u.Team.ShortName, u.Team.Slug
+	}
+
+	err := ps.Flair.SetFlair(u.Nickname, text, cssClass)
+	if err != nil {
+		return errors.E(op, err, "error setting flair on reddit")
+	}
+
+	err = ps.Db.SetFlairTeam(u.Nickname, u.PrimaryTeam)
+	if err != nil {
+		return errors.E(op, err, "error recording flair team in db")
+	}
+
+	ps.logger().WithFields(logrus.Fields{"user": u.Nickname, "flair": text}).Info("flair set")
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// fakeFlair records the flair it's asked to set, failing for users in fail.
+type fakeFlair struct {
+	flair map[string]string
+	calls int
+	fail  map[string]bool
+}
+
+func (f *fakeFlair) SetFlair(user string, text string, cssClass string) error {
+	f.calls++
+	if f.fail[user] {
+		return errors.E(fmt.Errorf("flair unavailable"), errors.KindServiceUnavailable)
+	}
+	f.flair[user] = fmt.Sprintf("%s/%s", text, cssClass)
+	return nil
+}
+
+func TestPrimaryTeam(t *testing.T) {
+	ps, teams := newTestService(t)
+	arizona, err := ps.AddTeam(adminToken, models.Team{ShortName: "Arizona", Slug: "arizona"})
+	if err != nil {
+		t.Fatalf("Unexpected error adding team: %s", err.Error())
+	}
+
+	_, err = ps.AddUser(adminToken, models.User{Nickname: "newcomer", PrimaryTeam: 9999})
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest adding user with unknown primary team, got %v", err)
+	}
+
+	user, err := ps.AddUser(adminToken, models.User{Nickname: "newcomer", PrimaryTeam: teams[0].ID})
+	if err != nil {
+		t.Fatalf("Unexpected error adding user: %s", err.Error())
+	}
+	if user.Team == nil || user.Team.ShortName != teams[0].ShortName {
+		t.Errorf("Expected team details on created user, got %v", user.Team)
+	}
+
+	voter := models.UserToken{Nickname: "newcomer"}
+	user.PrimaryTeam = 9999
+	_, err = ps.UpdateUser(voter, "newcomer", user)
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest changing to unknown primary team, got %v", err)
+	}
+
+	// Details sent by the client are ignored in favour of the team's own
+	user.PrimaryTeam = arizona.ID
+	user.Team = &models.Team{ShortName: "Not Arizona"}
+	if _, err = ps.UpdateUser(voter, "newcomer", user); err != nil {
+		t.Fatalf("Unexpected error updating user: %s", err.Error())
+	}
+
+	got, err := ps.GetUser(adminToken, "newcomer")
+	if err != nil {
+		t.Fatalf("Unexpected error getting user: %s", err.Error())
+	}
+	if got.Team == nil || *got.Team != arizona {
+		t.Errorf("Expected %v as the user's team, got %v", arizona, got.Team)
+	}
+
+	users, err := ps.GetUsers(adminToken, Options{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting users: %s", err.Error())
+	}
+	for _, u := range users {
+		if (u.Team != nil) != (u.PrimaryTeam != 0) {
+			t.Errorf("Expected team details exactly when %s has a primary team, got %v", u.Nickname, u.Team)
+		}
+	}
+}
+
+func TestGetPollBallots(t *testing.T) {
+	ps, teams := newTestService(t)
+	arizona, err := ps.AddTeam(adminToken, models.Team{ShortName: "Arizona", Slug: "arizona"})
+	if err != nil {
+		t.Fatalf("Unexpected error adding team: %s", err.Error())
+	}
+
+	voter1, _ := ps.GetUser(adminToken, "voter1")
+	voter1.PrimaryTeam = arizona.ID
+	if _, err = ps.UpdateUser(adminToken, "voter1", voter1); err != nil {
+		t.Fatalf("Unexpected error updating user: %s", err.Error())
+	}
+
+	for _, voter := range []string{"voter1", "voter2"} {
+		if _, err := ps.AddBallot(adminToken, ballotFor(voter, teams)); err != nil {
+			t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+		}
+	}
+
+	refs, err := ps.GetPollBallots(models.UserToken{}, 2020, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error getting poll ballots: %s", err.Error())
+	}
+	expected := []models.BallotRef{
+		{ID: 1, User: "voter1", PrimaryTeamSlug: "arizona"},
+		{ID: 2, User: "voter2"},
+	}
+	if fmt.Sprint(refs) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected ballots %v, expected %v", refs, expected)
+	}
+
+	open := models.Poll{Season: 2020, Week: 2, OpenTime: time.Now().Add(-time.Hour), CloseTime: time.Now().Add(time.Hour)}
+	if _, err = ps.AddPoll(adminToken, open); err != nil {
+		t.Fatalf("Unexpected error adding poll: %s", err.Error())
+	}
+	_, err = ps.GetPollBallots(models.UserToken{Nickname: "voter1"}, 2020, 2)
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized listing an open poll's ballots, got %v", err)
+	}
+	if _, err = ps.GetPollBallots(adminToken, 2020, 2); err != nil {
+		t.Errorf("Unexpected error listing an open poll's ballots as admin: %s", err.Error())
+	}
+}
+
+func TestSyncFlair(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	if err := ps.SyncFlair(); errors.Kind(err) != errors.KindNotImplemented {
+		t.Errorf("Expected KindNotImplemented without a flair setter, got %v", err)
+	}
+
+	flair := &fakeFlair{flair: make(map[string]string), fail: map[string]bool{"voter2": true}}
+	ps.Flair = flair
+
+	setTeam := func(name string, team int64) {
+		t.Helper()
+		u, err := ps.GetUser(adminToken, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.PrimaryTeam = team
+		if _, err = ps.UpdateUser(adminToken, name, u); err != nil {
+			t.Fatalf("Unexpected error updating user: %s", err.Error())
+		}
+	}
+	setTeam("voter1", teams[0].ID)
+	setTeam("voter2", teams[1].ID)
+
+	// voter2's flair can't be set, but everyone else's still is
+	err := ps.SyncFlair()
+	if err == nil {
+		t.Errorf("Expected an error for the user whose flair couldn't be set")
+	}
+	if flair.flair["voter1"] != teams[0].ShortName+"/"+teams[0].Slug {
+		t.Errorf("Unexpected flair for voter1: %q", flair.flair["voter1"])
+	}
+
+	// Only users whose flair is out of date are synced
+	flair.fail = nil
+	flair.calls = 0
+	if err = ps.SyncFlair(); err != nil {
+		t.Fatalf("Unexpected error syncing flair: %s", err.Error())
+	}
+	if flair.calls != 1 || flair.flair["voter2"] != teams[1].ShortName+"/"+teams[1].Slug {
+		t.Errorf("Expected only voter2's flair to be set, got %d calls and %v", flair.calls, flair.flair)
+	}
+
+	flair.calls = 0
+	if err = ps.SyncFlair(); err != nil || flair.calls != 0 {
+		t.Errorf("Expected nothing to sync, got %d calls and error %v", flair.calls, err)
+	}
+
+	// Dropping a primary team clears the flair
+	setTeam("voter1", 0)
+	if err = ps.SyncFlair(); err != nil {
+		t.Fatalf("Unexpected error syncing flair: %s", err.Error())
+	}
+	if flair.calls != 1 || flair.flair["voter1"] != "/" {
+		t.Errorf("Expected voter1's flair to be cleared, got %d calls and %q", flair.calls, flair.flair["voter1"])
+	}
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// mostRankedTeams is how many teams a user's voting stats list as most ranked.
+const mostRankedTeams = 5
+
+/*
+GetVotingHistory returns every ballot the user called name has cast, along
+with stats summarising them.  Ballots for polls that are still open are left
+out, and left out of the stats, unless user cast them or can view ballots.
+*/
+func (ps PollService) GetVotingHistory(user models.UserToken, name string) (models.VotingHistory, error) {
+	const op errors.Op = "app.GetVotingHistory"
+
+	voter, err := ps.Db.GetUser(name)
+	if err != nil {
+		return models.VotingHistory{}, errors.E(op, err, "error retrieving user from db")
+	}
+
+	ballots, err := ps.Db.GetBallotsByUser(voter.Nickname)
+	if err != nil {
+		return models.VotingHistory{}, errors.E(op, err, "error retrieving ballots from db")
+	}
+
+	polls, err := ps.Db.GetPolls(nil, db.Sort{})
+	if err != nil {
+		return models.VotingHistory{}, errors.E(op, err, "error retrieving polls from db")
+	}
+	pollsByWeek := make(map[[2]int]models.Poll, len(polls))
+	for _, p := range polls {
+		pollsByWeek[[2]int{p.Season, p.Week}] = p
+	}
+
+	canSeeOpen := voter.Nickname == user.Nickname || user.Can(models.PermViewBallots)
+	history := models.VotingHistory{User: voter.Nickname, Ballots: []models.Ballot{}}
+	var closed []models.Poll
+	for _, b := range ballots {
+		poll := pollsByWeek[[2]int{b.PollSeason, b.PollWeek}]
+		isOpen := poll.CloseTime.After(time.Now())
+		if isOpen && !canSeeOpen {
+			continue
+		}
+		history.Ballots = append(history.Ballots, b)
+		if !isOpen {
+			closed = append(closed, poll)
+		}
+	}
+
+	history.Stats, err = ps.votingStats(history.Ballots, closed)
+	if err != nil {
+		return models.VotingHistory{}, errors.E(op, err)
+	}
+
+	return history, nil
+}
+
+// votingStats summarises ballots, comparing those cast in the closed polls with
+// the polls' official results.
+func (ps PollService) votingStats(ballots []models.Ballot, closed []models.Poll) (models.VotingStats, error) {
+	const op errors.Op = "app.votingStats"
+
+	stats := models.VotingStats{PollsParticipated: len(ballots), MostRanked: []models.RankedTeam{}}
+
+	var deviation, compared int
+	for _, poll := range closed {
+		results, err := ps.officialResults(poll)
+		if err != nil {
+			return models.VotingStats{}, errors.E(op, err, "error getting poll results")
+		}
+		if len(results) == 0 {
+			continue
+		}
+		consensus := make(map[int64]int, len(results))
+		for _, r := range results {
+			consensus[r.TeamID] = r.Rank
+		}
+
+		for _, b := range ballots {
+			if b.PollSeason != poll.Season || b.PollWeek != poll.Week {
+				continue
+			}
+			for _, v := range b.Votes {
+				deviation += int(math.Abs(float64(v.Rank - placing(consensus[v.TeamID]))))
+				compared++
+			}
+		}
+	}
+	if compared > 0 {
+		stats.AverageDeviation = math.Round(float64(deviation)/float64(compared)*100) / 100
+	}
+
+	ranked := make(map[int64]*models.RankedTeam)
+	for _, b := range ballots {
+		for _, v := range b.Votes {
+			rt, ok := ranked[v.TeamID]
+			if !ok {
+				rt = &models.RankedTeam{TeamID: v.TeamID}
+				ranked[v.TeamID] = rt
+			}
+			rt.Ballots++
+			rt.AverageRank += float64(v.Rank)
+		}
+	}
+	if len(ranked) == 0 {
+		return stats, nil
+	}
+
+	ids := make([]int64, 0, len(ranked))
+	for id, rt := range ranked {
+		rt.AverageRank = math.Round(rt.AverageRank/float64(rt.Ballots)*100) / 100
+		ids = append(ids, id)
+	}
+	teams, err := ps.Db.GetTeamsByID(ids)
+	if err != nil {
+		return models.VotingStats{}, errors.E(op, err, "error retrieving ranked teams from db")
+	}
+	for _, t := range teams {
+		ranked[t.ID].TeamName = t.ShortName
+		if ranked[t.ID].TeamName == "" {
+			ranked[t.ID].TeamName = t.FullName
+		}
+		ranked[t.ID].TeamSlug = t.Slug
+	}
+
+	for _, rt := range ranked {
+		stats.MostRanked = append(stats.MostRanked, *rt)
+	}
+	sort.Slice(stats.MostRanked, func(i, j int) bool {
+		a, b := stats.MostRanked[i], stats.MostRanked[j]
+		if a.Ballots != b.Ballots {
+			return a.Ballots > b.Ballots
+		}
+		if a.AverageRank != b.AverageRank {
+			return a.AverageRank < b.AverageRank
+		}
+		return a.TeamID < b.TeamID
+	})
+	if len(stats.MostRanked) > mostRankedTeams {
+		stats.MostRanked = stats.MostRanked[:mostRankedTeams]
+	}
+
+	return stats, nil
+}
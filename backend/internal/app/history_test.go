@@ -0,0 +1,92 @@
+package app
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+func TestGetVotingHistory(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	history, err := ps.GetVotingHistory(models.UserToken{}, "voter1")
+	if err != nil {
+		t.Fatalf("Unexpected error getting voting history: %s", err.Error())
+	}
+	if len(history.Ballots) != 0 || history.Stats.PollsParticipated != 0 || len(history.Stats.MostRanked) != 0 {
+		t.Errorf("Expected an empty history for a user who hasn't voted, got %+v", history)
+	}
+
+	if _, err = ps.GetVotingHistory(models.UserToken{}, "nobody"); errors.Kind(err) != errors.KindNotFound {
+		t.Errorf("Expected KindNotFound for unknown user, got %v", err)
+	}
+
+	// voter1 moves teams[5] to the top, and votes again in a poll that's still open
+	homer := append([]models.Team{teams[5]}, teams[:5]...)
+	homer = append(homer, teams[6:numRanks]...)
+	for voter, ranked := range map[string][]models.Team{"voter1": homer, "voter2": teams} {
+		if _, err := ps.AddBallot(adminToken, ballotFor(voter, ranked)); err != nil {
+			t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+		}
+	}
+	open := models.Poll{Season: 2020, Week: 2, OpenTime: time.Now().Add(-time.Hour), CloseTime: time.Now().Add(time.Hour)}
+	if _, err = ps.AddPoll(adminToken, open); err != nil {
+		t.Fatal(err)
+	}
+	b := ballotFor("voter1", teams)
+	b.PollWeek = 2
+	if _, err = ps.AddBallot(adminToken, b); err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+
+	results, err := ps.GetResults(adminToken, 2020, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consensus := make(map[int64]int)
+	for _, r := range results {
+		consensus[r.TeamID] = r.Rank
+	}
+	var deviation int
+	for i, team := range homer {
+		deviation += int(math.Abs(float64(i + 1 - placing(consensus[team.ID]))))
+	}
+	expectedDeviation := math.Round(float64(deviation)/float64(numRanks)*100) / 100
+
+	tests := []struct {
+		name            string
+		token           models.UserToken
+		expectedBallots int
+		expectedFirst   models.RankedTeam
+	}{
+		{"Anonymous", models.UserToken{}, 1, models.RankedTeam{TeamID: teams[5].ID, TeamName: teams[5].ShortName, TeamSlug: teams[5].Slug, Ballots: 1, AverageRank: 1}},
+		{"Another voter", models.UserToken{Nickname: "voter2"}, 1, models.RankedTeam{TeamID: teams[5].ID, TeamName: teams[5].ShortName, TeamSlug: teams[5].Slug, Ballots: 1, AverageRank: 1}},
+		{"Themselves", models.UserToken{Nickname: "voter1"}, 2, models.RankedTeam{TeamID: teams[0].ID, TeamName: teams[0].ShortName, TeamSlug: teams[0].Slug, Ballots: 2, AverageRank: 1.5}},
+		{"Admin", adminToken, 2, models.RankedTeam{TeamID: teams[0].ID, TeamName: teams[0].ShortName, TeamSlug: teams[0].Slug, Ballots: 2, AverageRank: 1.5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history, err := ps.GetVotingHistory(test.token, "voter1")
+			if err != nil {
+				t.Fatalf("Unexpected error getting voting history: %s", err.Error())
+			}
+			if len(history.Ballots) != test.expectedBallots || history.Stats.PollsParticipated != test.expectedBallots {
+				t.Errorf("Expected %d ballots, got %d and %d polls participated", test.expectedBallots, len(history.Ballots), history.Stats.PollsParticipated)
+			}
+			// Ballots in the open poll have no consensus to deviate from
+			if history.Stats.AverageDeviation != expectedDeviation {
+				t.Errorf("Expected average deviation %v, got %v", expectedDeviation, history.Stats.AverageDeviation)
+			}
+			if len(history.Stats.MostRanked) != mostRankedTeams {
+				t.Fatalf("Expected %d most ranked teams, got %v", mostRankedTeams, history.Stats.MostRanked)
+			}
+			if history.Stats.MostRanked[0] != test.expectedFirst {
+				t.Errorf("Expected %+v to be ranked most, got %+v", test.expectedFirst, history.Stats.MostRanked[0])
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/models"
 	"time"
 )
 
@@ -23,11 +24,45 @@ func (opt Options) unpack() ([]db.Filter, db.Sort) {
 }
 
 func (opt Options) IsVoter(b bool) Options {
-	opt.filters = append(opt.filters, db.Filter{Field: "is_voter", Operator: "=", Value: b})
+	opt.filters = append(opt.filters, db.Filter{Field: "is_voter", Operator: db.Eq, Value: b})
 	return opt
 }
 
 func (opt Options) HasOpened() Options {
-	opt.filters = append(opt.filters, db.Filter{Field: "open_time", Operator: "<", Value: time.Now()})
+	opt.filters = append(opt.filters, db.Filter{Field: "open_time", Operator: db.Lt, Value: time.Now()})
 	return opt
-}
\ No newline at end of file
+}
+func (opt Options) Actor(name string) Options {
+	opt.filters = append(opt.filters, db.Filter{Field: "actor", Operator: db.Eq, Value: name})
+	return opt
+}
+
+func (opt Options) Action(action models.AuditAction) Options {
+	opt.filters = append(opt.filters, db.Filter{Field: "action", Operator: db.Eq, Value: string(action)})
+	return opt
+}
+
+func (opt Options) TargetType(targetType string) Options {
+	opt.filters = append(opt.filters, db.Filter{Field: "target_type", Operator: db.Eq, Value: targetType})
+	return opt
+}
+
+func (opt Options) TargetID(id string) Options {
+	opt.filters = append(opt.filters, db.Filter{Field: "target_id", Operator: db.Eq, Value: id})
+	return opt
+}
+
+func (opt Options) RequestID(id string) Options {
+	opt.filters = append(opt.filters, db.Filter{Field: "request_id", Operator: db.Eq, Value: id})
+	return opt
+}
+
+func (opt Options) Since(t time.Time) Options {
+	opt.filters = append(opt.filters, db.Filter{Field: "time", Operator: db.Gte, Value: t})
+	return opt
+}
+
+func (opt Options) Until(t time.Time) Options {
+	opt.filters = append(opt.filters, db.Filter{Field: "time", Operator: db.Lt, Value: t})
+	return opt
+}
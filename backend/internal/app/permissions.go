@@ -0,0 +1,108 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// How long a user's permissions are trusted after being read from the database.
+const permissionCacheTTL = 30 * time.Second
+
+type cachedUser struct {
+	user    models.User
+	fetched time.Time
+}
+
+// permissionCache holds recently loaded users so that a burst of privileged
+// requests doesn't cost a database round trip each.
+type permissionCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	users map[string]cachedUser
+}
+
+func newPermissionCache(ttl time.Duration) *permissionCache {
+	return &permissionCache{ttl: ttl, users: make(map[string]cachedUser)}
+}
+
+func (c *permissionCache) get(name string) (models.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cu, ok := c.users[name]
+	if !ok || time.Since(cu.fetched) > c.ttl {
+		return models.User{}, false
+	}
+	return cu.user, true
+}
+
+func (c *permissionCache) put(u models.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.users[u.Nickname] = cachedUser{user: u, fetched: time.Now()}
+}
+
+func (c *permissionCache) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.users, name)
+}
+
+// currentUser returns the user called name as the database has them now, give
+// or take permissionCacheTTL.
+func (ps PollService) currentUser(name string) (models.User, error) {
+	if ps.perms != nil {
+		if u, ok := ps.perms.get(name); ok {
+			return u, nil
+		}
+	}
+
+	u, err := ps.Db.GetUser(name)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if ps.perms != nil {
+		ps.perms.put(u)
+	}
+	return u, nil
+}
+
+// forgetUser drops any cached permissions for name, so changes made to the
+// user take effect on their next request.
+func (ps PollService) forgetUser(name string) {
+	if ps.perms != nil {
+		ps.perms.forget(name)
+	}
+}
+
+/*
+authorize checks that user has permission p according to the database, rather
+than the claims in their token, which can be out of date for as long as the
+token lives.  It's used for anything that changes data; the returned error is
+KindUnauthenticated or KindUnauthorized if the user can't go ahead.
+*/
+func (ps PollService) authorize(user models.UserToken, p models.Permission) error {
+	const op errors.Op = "app.authorize"
+	if !user.LoggedIn() {
+		return errors.E(op, errors.KindUnauthenticated)
+	}
+
+	u, err := ps.currentUser(user.Nickname)
+	if errors.Kind(err) == errors.KindNotFound {
+		return errors.E(op, err, errors.KindUnauthorized, "user no longer exists")
+	} else if err != nil {
+		return errors.E(op, err, "error retrieving user's permissions")
+	}
+
+	if !u.Can(p) {
+		return errors.E(op, errors.KindUnauthorized, "user doesn't have permission "+string(p))
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// anonymousName returns a nickname for a deleted user to leave their ballots
+// under.  Reddit usernames can't contain brackets, so it can't belong to anyone.
+func anonymousName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("[deleted-%s]", hex.EncodeToString(b)), nil
+}
+
+/*
+DeleteUser removes the user called name, either at their own request or an
+admin's.  Ballots in closed polls are kept so historical results don't change,
+but they're moved to an anonymous user and the reasons given for votes are
+removed.  Ballots in polls that are still open are deleted, and everything
+else stored about the user goes with them.
+*/
+func (ps PollService) DeleteUser(user models.UserToken, name string) error {
+	const op errors.Op = "app.DeleteUser"
+	if !user.LoggedIn() {
+		return errors.E(op, errors.KindUnauthenticated)
+	}
+
+	if user.Nickname != name {
+		if err := ps.authorize(user, models.PermManageUsers); err != nil {
+			return errors.E(op, err, "user can't delete other users")
+		}
+	}
+
+	if _, err := ps.Db.GetUser(name); err != nil {
+		return errors.E(op, err, "error retrieving user to delete from db")
+	}
+
+	ballots, err := ps.Db.GetBallotsByUser(name)
+	if err != nil {
+		return errors.E(op, err, "error retrieving user's ballots from db")
+	}
+	var open []int64
+	for _, b := range ballots {
+		poll, err := ps.Db.GetPoll(b.PollSeason, b.PollWeek)
+		if err != nil {
+			return errors.E(op, err, "error retrieving poll for ballot")
+		}
+		if !poll.CloseTime.Before(time.Now()) {
+			open = append(open, b.ID)
+		}
+	}
+
+	ps.clearFlair(name)
+
+	alias, err := anonymousName()
+	if err != nil {
+		return errors.E(op, err, "error generating anonymous name")
+	}
+
+	// Deletions by admins are audited under the alias, so the log doesn't keep
+	// the name either
+	dbClient := ps.Db
+	if user.Nickname != name {
+		dbClient, err = ps.audited(user, models.AuditDeleteUser, models.AuditTargetUser, alias, nil, nil)
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	// Ballots in open polls go in the same transaction, so a failure doesn't
+	// leave the user half deleted
+	err = dbClient.AnonymizeUser(name, alias, open)
+	if err != nil {
+		return errors.E(op, err, "error anonymizing user in db")
+	}
+	ps.forgetUser(name)
+
+	// The user's name is left out, since they asked for it to be forgotten
+	ps.logger().WithFields(logrus.Fields{
+		"alias":           alias,
+		"ballots_kept":    len(ballots) - len(open),
+		"ballots_deleted": len(open),
+		"by_self":         user.Nickname == name,
+	}).Info("user deleted")
+
+	return nil
+}
+
+// clearFlair removes the flair set for the user called name, if there is any.
+// It's best effort: failures are logged, and the flair left for moderators.
+func (ps PollService) clearFlair(name string) {
+	if ps.Flair == nil {
+		return
+	}
+
+	flairTeams, err := ps.Db.GetFlairTeams()
+	if err != nil {
+		ps.logger().WithError(err).Warn("error retrieving flair to clear for deleted user")
+		return
+	}
+	if flairTeams[name] == 0 {
+		return
+	}
+
+	if err := ps.Flair.SetFlair(name, "", ""); err != nil {
+		ps.logger().WithError(err).Warn("error clearing flair for deleted user")
+	}
+}
+
+// ExportUser gathers everything stored about the user called name, for the
+// user themselves or an admin.
+func (ps PollService) ExportUser(user models.UserToken, name string) (models.UserExport, error) {
+	const op errors.Op = "app.ExportUser"
+	if !user.LoggedIn() {
+		return models.UserExport{}, errors.E(op, errors.KindUnauthenticated)
+	}
+
+	if user.Nickname != name {
+		if err := ps.authorize(user, models.PermManageUsers); err != nil {
+			return models.UserExport{}, errors.E(op, err, "user can't export other users' data")
+		}
+	}
+
+	u, err := ps.GetUser(user, name)
+	if err != nil {
+		return models.UserExport{}, errors.E(op, err)
+	}
+
+	ballots, err := ps.Db.GetBallotsByUser(name)
+	if err != nil {
+		return models.UserExport{}, errors.E(op, err, "error retrieving user's ballots from db")
+	}
+
+	tokens, err := ps.Db.GetRefreshTokens(name)
+	if err != nil {
+		return models.UserExport{}, errors.E(op, err, "error retrieving user's sessions from db")
+	}
+	sessions := make([]models.Session, len(tokens))
+	for i, t := range tokens {
+		sessions[i] = models.Session{IssuedAt: t.IssuedAt, ExpiresAt: t.ExpiresAt, Revoked: t.Revoked}
+	}
+
+	flairTeams, err := ps.Db.GetFlairTeams()
+	if err != nil {
+		return models.UserExport{}, errors.E(op, err, "error retrieving user's flair from db")
+	}
+
+	return models.UserExport{
+		ExportedAt: time.Now().UTC(),
+		User:       u,
+		Ballots:    ballots,
+		Sessions:   sessions,
+		FlairTeam:  flairTeams[name],
+	}, nil
+}
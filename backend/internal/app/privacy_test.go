@@ -0,0 +1,144 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+func TestDeleteUser(t *testing.T) {
+	ps, teams := newTestService(t)
+	flair := &fakeFlair{flair: make(map[string]string)}
+	ps.Flair = flair
+
+	for _, voter := range []string{"voter1", "voter2"} {
+		b := ballotFor(voter, teams)
+		b.Votes[0].Reason = "best team in the country"
+		if _, err := ps.AddBallot(adminToken, b); err != nil {
+			t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+		}
+	}
+	open := models.Poll{Season: 2020, Week: 2, OpenTime: time.Now().Add(-time.Hour), CloseTime: time.Now().Add(time.Hour)}
+	if _, err := ps.AddPoll(adminToken, open); err != nil {
+		t.Fatal(err)
+	}
+	b := ballotFor("voter1", teams)
+	b.PollWeek = 2
+	if _, err := ps.AddBallot(adminToken, b); err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+	if err := ps.Db.SetFlairTeam("voter1", teams[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ps.GetResults(adminToken, 2020, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ps.DeleteUser(models.UserToken{}, "voter1"); errors.Kind(err) != errors.KindUnauthenticated {
+		t.Errorf("Expected KindUnauthenticated deleting without logging in, got %v", err)
+	}
+	if err = ps.DeleteUser(models.UserToken{Nickname: "voter2"}, "voter1"); errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized deleting another user, got %v", err)
+	}
+	if err = ps.DeleteUser(adminToken, "nobody"); errors.Kind(err) != errors.KindNotFound {
+		t.Errorf("Expected KindNotFound deleting unknown user, got %v", err)
+	}
+
+	if err = ps.DeleteUser(models.UserToken{Nickname: "voter1"}, "voter1"); err != nil {
+		t.Fatalf("Unexpected error deleting user: %s", err.Error())
+	}
+
+	if _, err = ps.GetUser(adminToken, "voter1"); errors.Kind(err) != errors.KindNotFound {
+		t.Errorf("Expected KindNotFound getting deleted user, got %v", err)
+	}
+	if flair.flair["voter1"] != "/" {
+		t.Errorf("Expected deleted user's flair to be cleared, got %q", flair.flair["voter1"])
+	}
+
+	// Results are unchanged, but the ballot no longer says who cast it or why
+	after, err := ps.GetResults(adminToken, 2020, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("Results changed after deleting user: %v, expected %v", after, before)
+	}
+	refs, err := ps.GetPollBallots(adminToken, 2020, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || !strings.HasPrefix(refs[0].User, "[deleted-") {
+		t.Fatalf("Expected voter1's ballot to be kept anonymously, got %v", refs)
+	}
+	ballot, err := ps.GetBallotById(adminToken, refs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ballot.IsOfficial || ballot.Votes[0].Reason != "" {
+		t.Errorf("Expected an official ballot without reasons, got %v", ballot)
+	}
+
+	// The ballot in the open poll is gone
+	refs, err = ps.GetPollBallots(adminToken, 2020, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 0 {
+		t.Errorf("Expected no ballots left in the open poll, got %v", refs)
+	}
+
+	// Admins can delete anyone
+	if err = ps.DeleteUser(adminToken, "voter2"); err != nil {
+		t.Fatalf("Unexpected error deleting user as admin: %s", err.Error())
+	}
+}
+
+func TestExportUser(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	if _, err := ps.AddBallot(adminToken, ballotFor("voter1", teams)); err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+	if _, err := ps.NewRefreshToken("voter1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Db.SetFlairTeam("voter1", teams[2].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	failures := []struct {
+		name         string
+		token        models.UserToken
+		user         string
+		expectedKind errors.Code
+	}{
+		{"Anonymous", models.UserToken{}, "voter1", errors.KindUnauthenticated},
+		{"Another user", models.UserToken{Nickname: "voter2"}, "voter1", errors.KindUnauthorized},
+		{"Unknown user", adminToken, "nobody", errors.KindNotFound},
+	}
+	for _, test := range failures {
+		if _, err := ps.ExportUser(test.token, test.user); errors.Kind(err) != test.expectedKind {
+			t.Errorf("%s: expected error kind %v, got %v", test.name, test.expectedKind, err)
+		}
+	}
+
+	for _, token := range []models.UserToken{{Nickname: "voter1"}, adminToken} {
+		export, err := ps.ExportUser(token, "voter1")
+		if err != nil {
+			t.Fatalf("Unexpected error exporting user as %s: %s", token.Nickname, err.Error())
+		}
+
+		if export.User.Nickname != "voter1" || len(export.Ballots) != 1 || export.FlairTeam != teams[2].ID {
+			t.Errorf("Unexpected export %+v", export)
+		}
+		if len(export.Sessions) != 1 || export.Sessions[0].Revoked || export.Sessions[0].ExpiresAt.Before(time.Now()) {
+			t.Errorf("Expected voter1's session to be exported, got %v", export.Sessions)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+	"github.com/r-cbb/cbbpoll/internal/render"
+)
+
+// Poster submits posts to the subreddit poll results are published in.
+type Poster interface {
+	// SubmitPost creates a text post with a markdown body, returning its url.
+	SubmitPost(title string, body string) (url string, err error)
+}
+
+// DefaultPublishWindow is how long after closing a poll is still published by
+// PublishClosedPolls.
+const DefaultPublishWindow = 24 * time.Hour
+
+/*
+PublishResults posts the results of a closed poll to reddit and saves the url
+of the post on the poll.  Results are only posted once; a poll that already has
+a url is a KindConflict.
+*/
+func (ps PollService) PublishResults(user models.UserToken, season int, week int) (models.Poll, error) {
+	const op errors.Op = "app.PublishResults"
+	if err := ps.authorize(user, models.PermManagePolls); err != nil {
+		return models.Poll{}, errors.E(op, err, "user can't publish poll results")
+	}
+
+	poll, err := ps.Db.GetPoll(season, week)
+	if err != nil {
+		return models.Poll{}, errors.E(op, err, "error retrieving poll from db")
+	}
+
+	poll, err = ps.publish(poll)
+	if err != nil {
+		return models.Poll{}, errors.E(op, err)
+	}
+
+	return poll, nil
+}
+
+/*
+PublishClosedPolls publishes the results of every poll that closed within the
+last PublishWindow and hasn't been posted to reddit yet, so results go up
+without anyone having to ask.  Polls that closed longer ago are left alone,
+since posting them now would be news to no one.
+*/
+func (ps PollService) PublishClosedPolls() error {
+	const op errors.Op = "app.PublishClosedPolls"
+
+	window := ps.PublishWindow
+	if window <= 0 {
+		window = DefaultPublishWindow
+	}
+
+	now := time.Now()
+	polls, err := ps.Db.GetPolls([]db.Filter{
+		{Field: "close_time", Operator: db.Lt, Value: now},
+		{Field: "close_time", Operator: db.Gt, Value: now.Add(-window)},
+	}, db.Sort{})
+	if err != nil {
+		return errors.E(op, err, "error retrieving recently closed polls")
+	}
+
+	for _, poll := range polls {
+		if poll.RedditURL != "" {
+			continue
+		}
+
+		_, err = ps.publish(poll)
+		if err != nil && errors.Kind(err) != errors.KindConflict {
+			return errors.E(op, err, fmt.Sprintf("error publishing %d week %d", poll.Season, poll.Week))
+		}
+	}
+
+	return nil
+}
+
+func (ps PollService) publish(poll models.Poll) (models.Poll, error) {
+	const op errors.Op = "app.publish"
+
+	if ps.Poster == nil {
+		return models.Poll{}, errors.E(op, errors.KindNotImplemented, "publishing results to reddit isn't configured")
+	}
+
+	if poll.CloseTime.After(time.Now()) {
+		return models.Poll{}, errors.E(op, errors.KindBadRequest, "can't publish results before the poll closes")
+	}
+
+	// Only one publish at a time, so a poll can't be posted twice
+	if ps.publishing != nil {
+		ps.publishing.Lock()
+		defer ps.publishing.Unlock()
+
+		latest, err := ps.Db.GetPoll(poll.Season, poll.Week)
+		if err != nil {
+			return models.Poll{}, errors.E(op, err, "error retrieving poll from db")
+		}
+		poll = latest
+	}
+
+	if poll.RedditURL != "" {
+		return models.Poll{}, errors.E(op, errors.KindConflict, "results have already been posted to reddit")
+	}
+
+	report, err := ps.report(poll)
+	if err != nil {
+		return models.Poll{}, errors.E(op, err)
+	}
+
+	url, err := ps.Poster.SubmitPost(render.Title(poll), render.Markdown(report))
+	if err != nil {
+		return models.Poll{}, errors.E(op, err, "error posting results to reddit")
+	}
+
+	poll.RedditURL = url
+	err = ps.Db.UpdatePoll(poll)
+	if err != nil {
+		// The post is up, so this needs fixing by hand rather than publishing again
+		ps.logger().WithFields(logrus.Fields{"season": poll.Season, "week": poll.Week, "url": url}).WithError(err).Error("results posted but url not saved")
+		return models.Poll{}, errors.E(op, err, "error saving reddit url on poll")
+	}
+
+	ps.logger().WithFields(logrus.Fields{"season": poll.Season, "week": poll.Week, "url": url}).Info("results published")
+
+	return poll, nil
+}
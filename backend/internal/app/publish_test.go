@@ -0,0 +1,148 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// fakePoster records the posts it's asked to submit.
+type fakePoster struct {
+	titles []string
+	bodies []string
+	err    error
+}
+
+func (p *fakePoster) SubmitPost(title string, body string) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	p.titles = append(p.titles, title)
+	p.bodies = append(p.bodies, body)
+	return fmt.Sprintf("https://www.reddit.com/r/CollegeBasketball/comments/%d/", len(p.titles)), nil
+}
+
+func TestPublishResults(t *testing.T) {
+	ps, teams := newTestService(t)
+
+	_, err := ps.PublishResults(adminToken, 2020, 1)
+	if errors.Kind(err) != errors.KindNotImplemented {
+		t.Errorf("Expected KindNotImplemented without a poster, got %v", err)
+	}
+
+	poster := &fakePoster{}
+	ps.Poster = poster
+
+	for _, voter := range []string{"voter1", "voter2"} {
+		if _, err := ps.AddBallot(adminToken, ballotFor(voter, teams)); err != nil {
+			t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+		}
+	}
+
+	_, err = ps.PublishResults(models.UserToken{Nickname: "voter1"}, 2020, 1)
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized publishing as a voter, got %v", err)
+	}
+
+	poll, err := ps.PublishResults(adminToken, 2020, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error publishing results: %s", err.Error())
+	}
+	if poll.RedditURL != "https://www.reddit.com/r/CollegeBasketball/comments/1/" {
+		t.Errorf("Unexpected reddit url %q", poll.RedditURL)
+	}
+	if stored, _ := ps.GetPoll(2020, 1); stored.RedditURL != poll.RedditURL {
+		t.Errorf("Reddit url wasn't saved on the poll: %q", stored.RedditURL)
+	}
+	if len(poster.titles) != 1 || poster.titles[0] != "Poll Results: 2020 Week 1" {
+		t.Errorf("Unexpected posts %v", poster.titles)
+	}
+	if !strings.Contains(poster.bodies[0], "| 1 | Team 01 | 2 | 50 |") {
+		t.Errorf("Post doesn't rank Team 01 first:\n%s", poster.bodies[0])
+	}
+
+	// Each poll's results are only posted once
+	_, err = ps.PublishResults(adminToken, 2020, 1)
+	if errors.Kind(err) != errors.KindConflict {
+		t.Errorf("Expected KindConflict publishing twice, got %v", err)
+	}
+
+	open := models.Poll{Season: 2020, Week: 2, OpenTime: time.Now().Add(-time.Hour), CloseTime: time.Now().Add(time.Hour)}
+	if _, err = ps.AddPoll(adminToken, open); err != nil {
+		t.Fatalf("Unexpected error adding poll: %s", err.Error())
+	}
+	_, err = ps.PublishResults(adminToken, 2020, 2)
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest publishing an open poll, got %v", err)
+	}
+	if len(poster.titles) != 1 {
+		t.Errorf("Expected 1 post, got %d", len(poster.titles))
+	}
+}
+
+func TestPublishClosedPolls(t *testing.T) {
+	ps, teams := newTestService(t)
+	poster := &fakePoster{}
+	ps.Poster = poster
+
+	// Closed too long ago to be news
+	old := models.Poll{Season: 2019, Week: 1, OpenTime: time.Now().Add(-96 * time.Hour), CloseTime: time.Now().Add(-72 * time.Hour)}
+	if _, err := ps.AddPoll(adminToken, old); err != nil {
+		t.Fatalf("Unexpected error adding poll: %s", err.Error())
+	}
+
+	// Ranks the last two teams first for a week after 2020 week 1
+	week2 := models.Poll{Season: 2020, Week: 2, WeekName: "Midseason", OpenTime: time.Now().Add(-2 * time.Hour), CloseTime: time.Now().Add(-time.Hour)}
+	if _, err := ps.AddPoll(adminToken, week2); err != nil {
+		t.Fatalf("Unexpected error adding poll: %s", err.Error())
+	}
+	if _, err := ps.AddBallot(adminToken, ballotFor("voter1", teams)); err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+	reordered := append([]models.Team{teams[numRanks], teams[1], teams[0]}, teams[2:numRanks-1]...)
+	ballot := ballotFor("voter1", reordered)
+	ballot.PollWeek = 2
+	if _, err := ps.AddBallot(adminToken, ballot); err != nil {
+		t.Fatalf("Unexpected error adding ballot: %s", err.Error())
+	}
+
+	ps.PublishWindow = 48 * time.Hour
+	err := ps.PublishClosedPolls()
+	if err != nil {
+		t.Fatalf("Unexpected error publishing closed polls: %s", err.Error())
+	}
+
+	titles := strings.Join(poster.titles, ", ")
+	if len(poster.titles) != 2 || !strings.Contains(titles, "2020 Week 1") || !strings.Contains(titles, "2020 Midseason") {
+		t.Fatalf("Expected both 2020 polls to be posted, got %s", titles)
+	}
+
+	// Nothing is posted twice
+	if err = ps.PublishClosedPolls(); err != nil {
+		t.Fatalf("Unexpected error publishing closed polls: %s", err.Error())
+	}
+	if len(poster.titles) != 2 {
+		t.Errorf("Expected 2 posts, got %d", len(poster.titles))
+	}
+
+	var midseason string
+	for i, title := range poster.titles {
+		if strings.Contains(title, "Midseason") {
+			midseason = poster.bodies[i]
+		}
+	}
+	for _, row := range []string{
+		fmt.Sprintf("| 1 | %s | 1 | 25 | new |", teams[numRanks].ShortName),
+		fmt.Sprintf("| 2 | %s | 0 | 24 | – |", teams[1].ShortName),
+		fmt.Sprintf("| 3 | %s | 0 | 23 | ▼2 |", teams[0].ShortName),
+		fmt.Sprintf("| 4 | %s | 0 | 22 | ▼1 |", teams[2].ShortName),
+	} {
+		if !strings.Contains(midseason, row) {
+			t.Errorf("Expected row %q in post:\n%s", row, midseason)
+		}
+	}
+}
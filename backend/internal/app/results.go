@@ -2,8 +2,12 @@ package app
 
 import (
 	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
 
 	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
 	"github.com/r-cbb/cbbpoll/internal/models"
 )
 
@@ -27,6 +31,7 @@ func (rs resultsSlice) Swap(i, j int) {
 
 func (ps PollService) calcPollResults(poll models.Poll) ([]models.Result, error) {
 	const op errors.Op = "app.calcPollResults"
+	defer func(start time.Time) { metrics.ResultsDuration.Observe(time.Since(start).Seconds()) }(time.Now())
 
 	ballots, err := ps.Db.GetBallotsByPoll(poll)
 	if err != nil {
@@ -55,6 +60,13 @@ func (ps PollService) calcPollResults(poll models.Poll) ([]models.Result, error)
 		return nil, errors.E(op, err, "error updating poll after calculating results")
 	}
 
+	ps.logger().WithFields(logrus.Fields{
+		"season":           poll.Season,
+		"week":             poll.Week,
+		"ballots":          len(ballots),
+		"official_ballots": len(official),
+	}).Info("poll results recalculated")
+
 	return []models.Result(officialResults), nil
 }
 
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+const DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+func (ps PollService) refreshTokenTTL() time.Duration {
+	if ps.RefreshTokenTTL <= 0 {
+		return DefaultRefreshTokenTTL
+	}
+	return ps.RefreshTokenTTL
+}
+
+// hashToken is what's stored in place of a refresh token.  The tokens are
+// random, so a plain hash is enough to keep them from being recovered.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken returns a new refresh token for name along with the record
+// to store for it.
+func (ps PollService) issueRefreshToken(name string) (string, models.RefreshToken, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", models.RefreshToken{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now().UTC()
+	return token, models.RefreshToken{
+		Hash:      hashToken(token),
+		User:      name,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ps.refreshTokenTTL()),
+	}, nil
+}
+
+/*
+LoginUser returns the user with the given reddit account as the database has
+them now, creating them if this is their first login, and reports whether they
+were created.  The account is checked against the eligibility rules, and the
+outcome saved on the user.  Any permissions cached for the user are replaced,
+so a new session always starts from the current state of the database.
+*/
+func (ps PollService) LoginUser(account models.RedditAccount) (models.User, bool, error) {
+	const op errors.Op = "app.LoginUser"
+
+	var created bool
+	user, err := ps.Db.GetUser(account.Name)
+	if errors.Kind(err) == errors.KindNotFound {
+		user, err = ps.NewUser(account.Name)
+		if err != nil {
+			return models.User{}, false, errors.E(op, err)
+		}
+		created = true
+	} else if err != nil {
+		return models.User{}, false, errors.E(op, err, "error retrieving user from db")
+	}
+
+	reason := ps.Eligibility.check(account, time.Now())
+	if reason != user.IneligibleReason {
+		user.IneligibleReason = reason
+		err = ps.Db.UpdateUser(user)
+		if err != nil {
+			return models.User{}, false, errors.E(op, err, "error saving user's eligibility")
+		}
+		ps.logger().WithFields(logrus.Fields{"user": user.Nickname, "reason": reason}).Info("eligibility changed")
+	}
+
+	if ps.perms != nil {
+		ps.perms.put(user)
+	}
+
+	return user, created, nil
+}
+
+// NewRefreshToken starts a session for the user called name, returning the
+// refresh token to hand to the client.
+func (ps PollService) NewRefreshToken(name string) (string, error) {
+	const op errors.Op = "app.NewRefreshToken"
+
+	token, rt, err := ps.issueRefreshToken(name)
+	if err != nil {
+		return "", errors.E(op, err, "error generating refresh token")
+	}
+
+	err = ps.Db.AddRefreshToken(rt)
+	if err != nil {
+		return "", errors.E(op, err, "error adding refresh token to db")
+	}
+
+	return token, nil
+}
+
+/*
+RefreshSession exchanges a refresh token for the current state of the user it
+was issued to and a replacement refresh token.  Each refresh token can only be
+used once: presenting one that has already been exchanged means it has leaked,
+so every session the user has is ended.
+*/
+func (ps PollService) RefreshSession(refreshToken string) (models.User, string, error) {
+	const op errors.Op = "app.RefreshSession"
+
+	if refreshToken == "" {
+		return models.User{}, "", errors.E(op, errors.KindBadRequest, "refresh token is required")
+	}
+
+	old, err := ps.Db.GetRefreshToken(hashToken(refreshToken))
+	if errors.Kind(err) == errors.KindNotFound {
+		return models.User{}, "", errors.E(op, err, errors.KindUnauthenticated, "unknown refresh token")
+	} else if err != nil {
+		return models.User{}, "", errors.E(op, err, "error retrieving refresh token")
+	}
+
+	if old.Revoked {
+		ps.logger().WithField("user", old.User).Warn("revoked refresh token reused, ending all sessions")
+		err = ps.Db.RevokeRefreshTokens(old.User)
+		if err != nil {
+			return models.User{}, "", errors.E(op, err, "error revoking refresh tokens")
+		}
+		return models.User{}, "", errors.E(op, errors.KindUnauthenticated, "refresh token has already been used")
+	}
+
+	if !old.ExpiresAt.After(time.Now()) {
+		return models.User{}, "", errors.E(op, errors.KindUnauthenticated, "refresh token has expired")
+	}
+
+	// Reload the user so changes to their roles take effect
+	user, err := ps.Db.GetUser(old.User)
+	if err != nil {
+		return models.User{}, "", errors.E(op, err, "error retrieving user from db")
+	}
+	if ps.perms != nil {
+		ps.perms.put(user)
+	}
+
+	token, rt, err := ps.issueRefreshToken(user.Nickname)
+	if err != nil {
+		return models.User{}, "", errors.E(op, err, "error generating refresh token")
+	}
+
+	err = ps.Db.RotateRefreshToken(old.Hash, rt)
+	if errors.Kind(err) == errors.KindConflict {
+		// Lost a race with another request using the same token
+		return models.User{}, "", errors.E(op, err, errors.KindUnauthenticated, "refresh token has already been used")
+	} else if err != nil {
+		return models.User{}, "", errors.E(op, err, "error rotating refresh token")
+	}
+
+	ps.logger().WithField("user", user.Nickname).Info("session refreshed")
+
+	return user, token, nil
+}
+
+// EndSession revokes the caller's access token and, if given, the refresh
+// token for the same session.
+func (ps PollService) EndSession(user models.UserToken, refreshToken string) error {
+	const op errors.Op = "app.EndSession"
+	if !user.LoggedIn() {
+		return errors.E(op, errors.KindUnauthenticated)
+	}
+
+	if refreshToken != "" {
+		hash := hashToken(refreshToken)
+		rt, err := ps.Db.GetRefreshToken(hash)
+		if err != nil && errors.Kind(err) != errors.KindNotFound {
+			return errors.E(op, err, "error retrieving refresh token")
+		}
+
+		if err == nil {
+			if rt.User != user.Nickname {
+				return errors.E(op, errors.KindUnauthorized, "can't end another user's session")
+			}
+
+			err = ps.Db.RevokeRefreshToken(hash)
+			if err != nil {
+				return errors.E(op, err, "error revoking refresh token")
+			}
+		}
+	}
+
+	if user.ID != "" {
+		err := ps.Db.RevokeAccessToken(user.ID, user.ExpiresAt)
+		if err != nil {
+			return errors.E(op, err, "error revoking access token")
+		}
+	}
+
+	ps.logger().WithFields(logrus.Fields{"user": user.Nickname}).Info("session ended")
+
+	return nil
+}
+
+// IsTokenRevoked reports whether the access token with the given id was
+// revoked by EndSession.
+func (ps PollService) IsTokenRevoked(id string) (bool, error) {
+	const op errors.Op = "app.IsTokenRevoked"
+
+	revoked, err := ps.Db.IsAccessTokenRevoked(id)
+	if err != nil {
+		return false, errors.E(op, err, "error checking revoked tokens")
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+func TestRefreshSession(t *testing.T) {
+	ps, _ := newTestService(t)
+
+	token, err := ps.NewRefreshToken("voter1")
+	if err != nil {
+		t.Fatalf("Unexpected error creating refresh token: %s", err.Error())
+	}
+
+	// Role changes are picked up on refresh
+	_, err = ps.SetRoles(adminToken, "voter1", []models.Role{models.RoleAuditor})
+	if err != nil {
+		t.Fatalf("Unexpected error setting roles: %s", err.Error())
+	}
+
+	user, next, err := ps.RefreshSession(token)
+	if err != nil {
+		t.Fatalf("Unexpected error refreshing session: %s", err.Error())
+	}
+	if user.Nickname != "voter1" || !user.HasRole(models.RoleAuditor) {
+		t.Errorf("Unexpected user from refreshed session: %v", user)
+	}
+	if next == token {
+		t.Errorf("Refresh token wasn't rotated")
+	}
+
+	_, _, err = ps.RefreshSession("not-a-token")
+	if errors.Kind(err) != errors.KindUnauthenticated {
+		t.Errorf("Expected KindUnauthenticated for unknown refresh token, got %v", errors.Kind(err))
+	}
+
+	// Reusing a token ends every session, including the one it was rotated into
+	_, _, err = ps.RefreshSession(token)
+	if errors.Kind(err) != errors.KindUnauthenticated {
+		t.Errorf("Expected KindUnauthenticated for reused refresh token, got %v", errors.Kind(err))
+	}
+	_, _, err = ps.RefreshSession(next)
+	if errors.Kind(err) != errors.KindUnauthenticated {
+		t.Errorf("Expected KindUnauthenticated after refresh token reuse, got %v", errors.Kind(err))
+	}
+}
+
+func TestRefreshSessionExpired(t *testing.T) {
+	ps, _ := newTestService(t)
+	ps.RefreshTokenTTL = time.Nanosecond
+
+	token, err := ps.NewRefreshToken("voter1")
+	if err != nil {
+		t.Fatalf("Unexpected error creating refresh token: %s", err.Error())
+	}
+	time.Sleep(time.Millisecond)
+
+	_, _, err = ps.RefreshSession(token)
+	if errors.Kind(err) != errors.KindUnauthenticated {
+		t.Errorf("Expected KindUnauthenticated for expired refresh token, got %v", errors.Kind(err))
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	ps, _ := newTestService(t)
+	voter := models.UserToken{Nickname: "voter1", ID: "abc", ExpiresAt: time.Now().Add(time.Hour)}
+
+	token, err := ps.NewRefreshToken("voter1")
+	if err != nil {
+		t.Fatalf("Unexpected error creating refresh token: %s", err.Error())
+	}
+
+	err = ps.EndSession(models.UserToken{}, token)
+	if errors.Kind(err) != errors.KindUnauthenticated {
+		t.Errorf("Expected KindUnauthenticated ending a session while logged out, got %v", errors.Kind(err))
+	}
+
+	err = ps.EndSession(models.UserToken{Nickname: "voter2"}, token)
+	if errors.Kind(err) != errors.KindUnauthorized {
+		t.Errorf("Expected KindUnauthorized ending another user's session, got %v", errors.Kind(err))
+	}
+
+	err = ps.EndSession(voter, token)
+	if err != nil {
+		t.Fatalf("Unexpected error ending session: %s", err.Error())
+	}
+
+	revoked, err := ps.IsTokenRevoked("abc")
+	if err != nil {
+		t.Fatalf("Unexpected error checking revocation: %s", err.Error())
+	}
+	if !revoked {
+		t.Errorf("Access token wasn't revoked")
+	}
+
+	_, _, err = ps.RefreshSession(token)
+	if errors.Kind(err) != errors.KindUnauthenticated {
+		t.Errorf("Expected KindUnauthenticated refreshing an ended session, got %v", errors.Kind(err))
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+const maxReasonLength = 140
+
+// VoteProblem is one thing wrong with a submitted ballot.  Index is the
+// position of the offending vote in the ballot's Votes, or -1 if the problem is
+// with the ballot as a whole (too few votes, a rank nobody used).  Field is the
+// json name of the vote field at fault.
+type VoteProblem struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// BallotValidationError lists every problem found with a ballot, so a client
+// can point at each bad row instead of fixing them one round trip at a time.
+type BallotValidationError struct {
+	Problems []VoteProblem `json:"problems"`
+}
+
+func (e *BallotValidationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		if p.Index < 0 {
+			msgs[i] = p.Message
+		} else {
+			msgs[i] = fmt.Sprintf("vote %d: %s", p.Index, p.Message)
+		}
+	}
+
+	return "invalid ballot: " + strings.Join(msgs, "; ")
+}
+
+func (e *BallotValidationError) add(index int, field string, format string, args ...interface{}) {
+	e.Problems = append(e.Problems, VoteProblem{Index: index, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateBallot checks that a ballot ranks numRanks distinct, known teams
+// using each of the ranks 1 through numRanks exactly once.  Problems with the
+// ballot are returned as a *BallotValidationError; any other error means the
+// ballot couldn't be checked.
+func (ps PollService) validateBallot(b models.Ballot) error {
+	const op errors.Op = "app.validateBallot"
+	vs := b.Votes
+	verr := &BallotValidationError{}
+
+	if len(vs) != numRanks {
+		verr.add(-1, "votes", "ballots must contain exactly %v votes, found %v", numRanks, len(vs))
+	}
+
+	teams, err := ps.Db.GetTeams()
+	if err != nil {
+		return errors.E(op, err, "unable to retrieve teams from db")
+	}
+	known := make(map[int64]bool, len(teams))
+	for _, t := range teams {
+		known[t.ID] = true
+	}
+
+	seenRanks := make(map[int]bool)
+	seenTeams := make(map[int64]bool)
+	for i, v := range vs {
+		switch {
+		case v.TeamID == 0:
+			verr.add(i, "team_id", "team is required")
+		case !known[v.TeamID]:
+			verr.add(i, "team_id", "team %v unknown", v.TeamID)
+		case seenTeams[v.TeamID]:
+			verr.add(i, "team_id", "team %v ranked more than once", v.TeamID)
+		}
+		seenTeams[v.TeamID] = true
+
+		switch {
+		case v.Rank < 1 || v.Rank > numRanks:
+			verr.add(i, "rank", "rank %v must be between 1 and %v", v.Rank, numRanks)
+		case seenRanks[v.Rank]:
+			verr.add(i, "rank", "rank %v used more than once", v.Rank)
+		}
+		seenRanks[v.Rank] = true
+
+		if utf8.RuneCountInString(v.Reason) > maxReasonLength {
+			verr.add(i, "reason", "reason can't be longer than %v characters", maxReasonLength)
+		}
+	}
+
+	for rank := 1; rank <= numRanks; rank++ {
+		if !seenRanks[rank] {
+			verr.add(-1, "rank", "missing rank %v", rank)
+		}
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+
+	return nil
+}
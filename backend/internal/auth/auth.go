@@ -2,9 +2,14 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/jwtauth"
@@ -15,16 +20,61 @@ import (
 
 type Client interface {
 	Verifier() func(http.Handler) http.Handler
-	Authenticator(http.HandlerFunc) http.HandlerFunc
+	Authenticator(next http.HandlerFunc, respond ErrorResponder) http.HandlerFunc
 	CreateJWT(u models.User) (string, error)
 	UserTokenFromCtx(ctx context.Context) models.UserToken
+	JWKS() JSONWebKeySet
+}
+
+const (
+	DefaultIssuer = "cbbpoll"
+	DefaultTTL    = 15 * time.Minute
+)
+
+const signingAlgorithm = "RS256"
+
+// ErrorResponder writes an error response for a request that was rejected.
+// The server passes in its own so that rejected credentials are reported like
+// any other error.
+type ErrorResponder func(w http.ResponseWriter, r *http.Request, err error)
+
+// RevocationList reports whether an access token was revoked before it expired,
+// e.g. because the user logged out.
+type RevocationList interface {
+	IsTokenRevoked(id string) (bool, error)
+}
+
+// Claims are what an access token says about its user.
+type Claims struct {
+	Name  string        `json:"name"`
+	Admin bool          `json:"admin"`
+	Roles []models.Role `json:"roles,omitempty"`
+	jwt.StandardClaims
 }
 
 type JwtClient struct {
-	auth *jwtauth.JWTAuth
+	signingKey *rsa.PrivateKey
+	// ID of signingKey, sent as the kid header of new tokens
+	keyID string
+	// Every key a token may be signed with, by ID
+	keys   map[string]*rsa.PublicKey
+	parser *jwt.Parser
+	// Issuer is the iss claim of new tokens.  Tokens from any other issuer are rejected.
+	Issuer string
+	// TTL is how long new tokens are accepted for.
+	TTL time.Duration
+	// Revocations, if set, is checked for every token presented.
+	Revocations RevocationList
 }
 
-func InitJwtAuth(secretReader, publicReader io.Reader) (*JwtClient, error) {
+/*
+InitJwtAuth sets up signing tokens with the RSA key pair read from secretReader
+and publicReader.  Tokens signed with any of the public keys read from
+verificationReaders are accepted as well, so that the signing key can be
+replaced without invalidating the tokens already handed out: move the old
+public key to the verification keys until its tokens have expired.
+*/
+func InitJwtAuth(secretReader, publicReader io.Reader, verificationReaders ...io.Reader) (*JwtClient, error) {
 	const op errors.Op = "auth.InitJwtAuth"
 	keytext, err := ioutil.ReadAll(secretReader)
 	if err != nil {
@@ -36,53 +86,159 @@ func InitJwtAuth(secretReader, publicReader io.Reader) (*JwtClient, error) {
 		return nil, errors.E(op, errors.KindJWTError, err, "error parsing private key")
 	}
 
-	pubtext, err := ioutil.ReadAll(publicReader)
+	pubKey, err := readPublicKey(publicReader)
 	if err != nil {
-		return nil, errors.E(op, errors.KindJWTError, err, "error reading public key")
+		return nil, errors.E(op, err)
 	}
 
-	pubKey, err := jwt.ParseRSAPublicKeyFromPEM(pubtext)
-	if err != nil {
-		return nil, errors.E(op, errors.KindJWTError, err, "error parsing public key")
+	if !sameKey(pubKey, &privateKey.PublicKey) {
+		return nil, errors.E(op, errors.KindJWTError, "public key doesn't match private key")
+	}
+
+	client := &JwtClient{
+		signingKey: privateKey,
+		keyID:      keyID(pubKey),
+		keys:       make(map[string]*rsa.PublicKey),
+		parser:     &jwt.Parser{ValidMethods: []string{signingAlgorithm}},
+		Issuer:     DefaultIssuer,
+		TTL:        DefaultTTL,
+	}
+	client.keys[client.keyID] = pubKey
+
+	for _, r := range verificationReaders {
+		key, err := readPublicKey(r)
+		if err != nil {
+			return nil, errors.E(op, err, "error reading verification key")
+		}
+		client.keys[keyID(key)] = key
 	}
 
-	return &JwtClient{auth: jwtauth.New("RS256", privateKey, pubKey)}, nil
+	return client, nil
 }
 
-func (j JwtClient) UserTokenFromCtx(ctx context.Context) (token models.UserToken) {
-	const op errors.Op = "auth.userFromContext"
-	_, claims, err := jwtauth.FromContext(ctx)
+// fromContext returns the token Verifier found for the request, along with
+// the reason it was rejected if it was.
+func fromContext(ctx context.Context) (*jwt.Token, error) {
+	token, _ := ctx.Value(jwtauth.TokenCtxKey).(*jwt.Token)
+	err, _ := ctx.Value(jwtauth.ErrorCtxKey).(error)
+	return token, err
+}
 
-	if err != nil {
+func (j JwtClient) UserTokenFromCtx(ctx context.Context) (token models.UserToken) {
+	jwtToken, err := fromContext(ctx)
+	if err != nil || jwtToken == nil || !jwtToken.Valid {
 		return
 	}
 
-	nickname, ok := claims["name"].(string)
-	if !ok {
+	claims, ok := jwtToken.Claims.(*Claims)
+	if !ok || claims.Name == "" {
 		// Always expect to have a 'name' claim.  If we don't then something is very wrong.
 		// We'll treat it like no credential at all.
 		return
 	}
 
-	isAdmin := claims["admin"].(bool)
-
 	return models.UserToken{
-		Nickname: nickname,
-		IsAdmin: isAdmin,
+		Nickname:  claims.Name,
+		IsAdmin:   claims.Admin,
+		Roles:     validRoles(claims.Roles),
+		ID:        claims.Id,
+		ExpiresAt: expiryFromClaims(claims),
+	}
+}
+
+func expiryFromClaims(claims *Claims) time.Time {
+	if claims.ExpiresAt == 0 {
+		return time.Time{}
 	}
+	return time.Unix(claims.ExpiresAt, 0)
 }
 
-func (j JwtClient) Verifier() func (http.Handler) http.Handler {
-	return jwtauth.Verifier(j.auth)
+// validRoles drops anything that isn't a known role, such as a role that has
+// since been removed.
+func validRoles(claimed []models.Role) []models.Role {
+	var roles []models.Role
+	for _, r := range claimed {
+		if r.Valid() {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+/*
+Verifier looks for a token in the jwt query parameter, the Authorization header
+or the jwt cookie, in that order, and checks its signature against the key
+named by its kid header.  The result is stored in the request context for
+Authenticator and UserTokenFromCtx.
+*/
+func (j JwtClient) Verifier() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := j.verifyRequest(r)
+			ctx := jwtauth.NewContext(r.Context(), token, err)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (j JwtClient) verifyRequest(r *http.Request) (*jwt.Token, error) {
+	var tokenString string
+	for _, find := range []func(*http.Request) string{jwtauth.TokenFromQuery, jwtauth.TokenFromHeader, jwtauth.TokenFromCookie} {
+		tokenString = find(r)
+		if tokenString != "" {
+			break
+		}
+	}
+	if tokenString == "" {
+		return nil, jwtauth.ErrNoTokenFound
+	}
+
+	return j.parse(tokenString)
+}
+
+// parse checks the signature and expiry of tokenString.  Claims that don't
+// have the expected types make the token invalid.
+func (j JwtClient) parse(tokenString string) (*jwt.Token, error) {
+	return j.parser.ParseWithClaims(tokenString, &Claims{}, j.verificationKey)
+}
+
+func (j JwtClient) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		// Issued before tokens named their key, when there was only the one
+		kid = j.keyID
+	}
+
+	key, ok := j.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
 }
 
 func (j JwtClient) CreateJWT(u models.User) (string, error) {
 	var op errors.Op = "auth.createJWT"
-	var claims jwtauth.Claims = make(map[string]interface{})
-	claims["name"] = u.Nickname
-	claims["admin"] = u.IsAdmin
+	id, err := newTokenID()
+	if err != nil {
+		return "", errors.E(op, errors.KindJWTError, err, "error generating token id")
+	}
+
+	now := time.Now()
+	claims := Claims{
+		Name:  u.Nickname,
+		Admin: u.IsAdmin,
+		Roles: u.WithNormalizedRoles().Roles,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    j.Issuer,
+			Id:        id,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(j.TTL).Unix(),
+		},
+	}
 
-	_, tokenString, err := j.auth.Encode(claims)
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(signingAlgorithm), claims)
+	token.Header["kid"] = j.keyID
+	tokenString, err := token.SignedString(j.signingKey)
 	if err != nil {
 		return "", errors.E(op, errors.KindJWTError, err, "error creating jwt for user")
 	}
@@ -90,12 +246,25 @@ func (j JwtClient) CreateJWT(u models.User) (string, error) {
 	return tokenString, nil
 }
 
-func (j JwtClient) Authenticator(next http.HandlerFunc) http.HandlerFunc {
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Authenticator rejects requests with an invalid, expired or revoked token
+// through respond, with KindUnauthenticated.  Requests without a token are let
+// through.
+func (j JwtClient) Authenticator(next http.HandlerFunc, respond ErrorResponder) http.HandlerFunc {
+	const op errors.Op = "auth.Authenticator"
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, _, err := jwtauth.FromContext(r.Context())
+		token, err := fromContext(r.Context())
 
-		if err != nil && err != jwtauth.ErrNoTokenFound{
-			http.Error(w, http.StatusText(401), 401)
+		if err != nil && err != jwtauth.ErrNoTokenFound {
+			respond(w, r, errors.E(op, errors.KindUnauthenticated, err, "invalid access token"))
 			return
 		}
 
@@ -106,10 +275,30 @@ func (j JwtClient) Authenticator(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		if !token.Valid {
-			http.Error(w, http.StatusText(401), 401)
+			respond(w, r, errors.E(op, errors.KindUnauthenticated, "invalid access token"))
+			return
+		}
+
+		// Tokens issued before expiry was added live forever, so they're
+		// rejected along with tokens from anyone else.
+		claims, ok := token.Claims.(*Claims)
+		if !ok || claims.Issuer != j.Issuer || claims.Id == "" || claims.ExpiresAt == 0 {
+			respond(w, r, errors.E(op, errors.KindUnauthenticated, "invalid access token"))
 			return
 		}
 
+		if j.Revocations != nil {
+			revoked, err := j.Revocations.IsTokenRevoked(claims.Id)
+			if err != nil {
+				respond(w, r, errors.E(op, errors.KindServiceUnavailable, err, "unable to check access token"))
+				return
+			}
+			if revoked {
+				respond(w, r, errors.E(op, errors.KindUnauthenticated, "access token has been revoked"))
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
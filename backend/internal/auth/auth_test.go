@@ -3,10 +3,18 @@ package auth
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	jwt2 "github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/jwtauth"
@@ -130,6 +138,7 @@ func TestJwtClient_EncodeDecode(t *testing.T) {
 	u := models.User{
 		Nickname: "Concision",
 		IsAdmin:  true,
+		Roles:    []models.Role{models.RolePollManager},
 	}
 
 	jwtStr, err := client.CreateJWT(u)
@@ -137,17 +146,34 @@ func TestJwtClient_EncodeDecode(t *testing.T) {
 		t.Errorf("Unexpected error from CreateJWT: %s", err.Error())
 	}
 
-	var claims = make(jwtauth.Claims)
-	claims["name"] = u.Nickname
-	claims["admin"] = u.IsAdmin
-
-	jwt, _, err := client.auth.Encode(claims)
+	jwt, err := client.parse(jwtStr)
 	if err != nil {
-		t.Errorf("Unexpected error from jwtauth.Encode: %s", err.Error())
+		t.Fatalf("Unexpected error from parse: %s", err.Error())
+	}
+
+	if jwt.Header["kid"] != client.keyID {
+		t.Errorf("Wrong kid: %v, but expected %s", jwt.Header["kid"], client.keyID)
+	}
+
+	claims := jwt.Claims.(*Claims)
+	if claims.Issuer != DefaultIssuer {
+		t.Errorf("Wrong issuer: %v, but expected %s", claims.Issuer, DefaultIssuer)
+	}
+	exp, iat := time.Unix(claims.ExpiresAt, 0), time.Unix(claims.IssuedAt, 0)
+	if exp.Sub(iat) != DefaultTTL {
+		t.Errorf("Token valid for %v, but expected %v", exp.Sub(iat), DefaultTTL)
 	}
 
-	if jwt.Raw != jwtStr {
-		t.Errorf("JWT returned from JwtClient.CreateJWT() doesn't match jwtauth.Encode()")
+	other, err := client.CreateJWT(u)
+	if err != nil {
+		t.Errorf("Unexpected error from CreateJWT: %s", err.Error())
+	}
+	otherJwt, err := client.parse(other)
+	if err != nil {
+		t.Fatalf("Unexpected error from parse: %s", err.Error())
+	}
+	if otherJwt.Claims.(*Claims).Id == claims.Id {
+		t.Errorf("Two tokens were issued with the same jti")
 	}
 
 	// Decode the JWT into a UserToken
@@ -167,6 +193,65 @@ func TestJwtClient_EncodeDecode(t *testing.T) {
 	if token.IsAdmin != u.IsAdmin {
 		t.Errorf("Mismatched IsAdmin states.  From Token: %v, From User: %v", token.IsAdmin, u.IsAdmin)
 	}
+
+	if len(token.Roles) != 2 || token.Roles[0] != models.RoleAdmin || token.Roles[1] != models.RolePollManager {
+		t.Errorf("Wrong Roles from UserToken: %v, but expected [admin poll_manager]", token.Roles)
+	}
+
+	if token.ID != claims.Id {
+		t.Errorf("Wrong ID from UserToken: %s, but expected %v", token.ID, claims.Id)
+	}
+
+	if !token.ExpiresAt.Equal(exp) {
+		t.Errorf("Wrong ExpiresAt from UserToken: %v, but expected %v", token.ExpiresAt, exp)
+	}
+}
+
+func TestJwtClient_UserTokenFromCtxClaims(t *testing.T) {
+	tests := []struct {
+		description   string
+		claims        jwt2.Claims
+		expectedToken models.UserToken
+	}{
+		{
+			description:   "Token from before roles",
+			claims:        &Claims{Name: "Concision"},
+			expectedToken: models.UserToken{Nickname: "Concision"},
+		},
+		{
+			description:   "Unknown roles are dropped",
+			claims:        &Claims{Name: "Concision", Roles: []models.Role{"auditor", "king"}},
+			expectedToken: models.UserToken{Nickname: "Concision", Roles: []models.Role{models.RoleAuditor}},
+		},
+		{
+			description:   "Admin",
+			claims:        &Claims{Name: "Concision", Admin: true},
+			expectedToken: models.UserToken{Nickname: "Concision", IsAdmin: true},
+		},
+		{
+			description:   "Missing name",
+			claims:        &Claims{Admin: true},
+			expectedToken: models.UserToken{},
+		},
+		{
+			description:   "Untyped claims",
+			claims:        jwt2.MapClaims{"name": "Concision", "admin": true},
+			expectedToken: models.UserToken{},
+		},
+	}
+
+	client := JwtClient{}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			token := &jwt2.Token{Claims: test.claims, Valid: true}
+			ctx := context.WithValue(context.Background(), jwtauth.TokenCtxKey, token)
+
+			got := client.UserTokenFromCtx(ctx)
+			if got.Nickname != test.expectedToken.Nickname || got.IsAdmin != test.expectedToken.IsAdmin || fmt.Sprint(got.Roles) != fmt.Sprint(test.expectedToken.Roles) {
+				t.Errorf("Expected token %v, got %v", test.expectedToken, got)
+			}
+		})
+	}
 }
 
 func TestJwtClient_BadTokenIsLoggedOut(t *testing.T) {
@@ -185,11 +270,28 @@ func TestJwtClient_BadTokenIsLoggedOut(t *testing.T) {
 	}
 }
 
+type revocationList map[string]bool
+
+func (l revocationList) IsTokenRevoked(id string) (bool, error) {
+	if id == "broken" {
+		return false, fmt.Errorf("some error")
+	}
+	return l[id], nil
+}
+
 func TestJwtClient_Authenticator(t *testing.T) {
-	token := jwt2.Token{Claims: jwt2.MapClaims{"foo": "bar"}, Valid: true}
+	newToken := func(issuer string, id string, exp int64) jwt2.Token {
+		return jwt2.Token{Claims: &Claims{Name: "Concision", StandardClaims: jwt2.StandardClaims{Issuer: issuer, Id: id, ExpiresAt: exp}}, Valid: true}
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	token := newToken(DefaultIssuer, "abc", exp)
 	badToken := token
 	badToken.Valid = false
-	jwt := JwtClient{}
+	legacyToken := jwt2.Token{Claims: &Claims{Name: "Concision", Admin: true}, Valid: true}
+	otherIssuer := newToken("someone-else", "abc", exp)
+	revokedToken := newToken(DefaultIssuer, "revoked", exp)
+	brokenToken := newToken(DefaultIssuer, "broken", exp)
+	jwt := JwtClient{Issuer: DefaultIssuer, Revocations: revocationList{"revoked": true}}
 	tests := []struct {
 		description  string
 		ctx          context.Context
@@ -227,9 +329,29 @@ func TestJwtClient_Authenticator(t *testing.T) {
 			ctx:          context.WithValue(context.Background(), jwtauth.TokenCtxKey, &badToken),
 			expectedCode: http.StatusUnauthorized,
 		},
+		{
+			description:  "Token without expiry",
+			ctx:          context.WithValue(context.Background(), jwtauth.TokenCtxKey, &legacyToken),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Token from another issuer",
+			ctx:          context.WithValue(context.Background(), jwtauth.TokenCtxKey, &otherIssuer),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Revoked token",
+			ctx:          context.WithValue(context.Background(), jwtauth.TokenCtxKey, &revokedToken),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Error checking revocation",
+			ctx:          context.WithValue(context.Background(), jwtauth.TokenCtxKey, &brokenToken),
+			expectedCode: http.StatusServiceUnavailable,
+		},
 	}
 
-	handler := jwt.Authenticator(GetTestHandler())
+	handler := jwt.Authenticator(GetTestHandler(), respondWithKind)
 
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
@@ -245,6 +367,19 @@ func TestJwtClient_Authenticator(t *testing.T) {
 	}
 }
 
+// respondWithKind stands in for the server's problem responses, reporting just
+// the status for the kind of error.
+func respondWithKind(w http.ResponseWriter, r *http.Request, err error) {
+	switch errors.Kind(err) {
+	case errors.KindUnauthenticated:
+		w.WriteHeader(http.StatusUnauthorized)
+	case errors.KindServiceUnavailable:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 func GetTestHandler() http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -260,3 +395,249 @@ func TestJwtClient_UserTokenFromCtxError(t *testing.T) {
 	}
 
 }
+
+func newTestClient(t *testing.T, verificationKeys ...string) *JwtClient {
+	var readers []io.Reader
+	for _, key := range verificationKeys {
+		readers = append(readers, bytes.NewBufferString(key))
+	}
+
+	client, err := InitJwtAuth(bytes.NewBufferString(privateKeyText), bytes.NewBufferString(publicKeyText), readers...)
+	if err != nil {
+		t.Fatalf("Unexpected error creating JwtClient: %s", err.Error())
+	}
+	return client
+}
+
+// newKeyPair returns a freshly generated key pair as PEM, as InitJwtAuth reads them.
+func newKeyPair(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})
+	return string(private), string(public)
+}
+
+func TestInitJwtAuth_Keys(t *testing.T) {
+	otherPrivate, otherPublic := newKeyPair(t)
+
+	_, err := InitJwtAuth(bytes.NewBufferString(otherPrivate), bytes.NewBufferString(publicKeyText))
+	if errors.Kind(err) != errors.KindJWTError {
+		t.Errorf("Expected KindJWTError for mismatched key pair, got %v", err)
+	}
+
+	_, err = InitJwtAuth(bytes.NewBufferString(privateKeyText), bytes.NewBufferString(publicKeyText), bytes.NewBufferString("Some garbage"))
+	if errors.Kind(err) != errors.KindJWTError {
+		t.Errorf("Expected KindJWTError for bad verification key, got %v", err)
+	}
+
+	client := newTestClient(t, otherPublic, otherPublic)
+	if len(client.keys) != 2 {
+		t.Errorf("Expected 2 keys, got %d", len(client.keys))
+	}
+}
+
+// signed returns a token with the given claims, signed with the test key.
+func signed(t *testing.T, method jwt2.SigningMethod, header map[string]interface{}, claims jwt2.MapClaims, key interface{}) string {
+	token := jwt2.NewWithClaims(method, claims)
+	for k, v := range header {
+		token.Header[k] = v
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJwtClient_Verifier(t *testing.T) {
+	client := newTestClient(t)
+	privateKey, err := jwt2.ParseRSAPrivateKeyFromPEM([]byte(privateKeyText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPrivate, _ := newKeyPair(t)
+	otherKey, err := jwt2.ParseRSAPrivateKeyFromPEM([]byte(otherPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	kid := map[string]interface{}{"kid": client.keyID}
+	claims := func(extra jwt2.MapClaims) jwt2.MapClaims {
+		c := jwt2.MapClaims{"name": "Concision", "iss": DefaultIssuer, "jti": "abc", "exp": exp}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		description  string
+		token        string
+		expectedCode int
+		expectedUser models.UserToken
+	}{
+		{
+			description:  "No token",
+			expectedCode: http.StatusOK,
+		},
+		{
+			description:  "Good token",
+			token:        signed(t, jwt2.SigningMethodRS256, kid, claims(jwt2.MapClaims{"admin": true}), privateKey),
+			expectedCode: http.StatusOK,
+			expectedUser: models.UserToken{Nickname: "Concision", IsAdmin: true},
+		},
+		{
+			description:  "Token without kid",
+			token:        signed(t, jwt2.SigningMethodRS256, nil, claims(nil), privateKey),
+			expectedCode: http.StatusOK,
+			expectedUser: models.UserToken{Nickname: "Concision"},
+		},
+		{
+			description:  "Malformed admin claim",
+			token:        signed(t, jwt2.SigningMethodRS256, kid, claims(jwt2.MapClaims{"admin": "yes"}), privateKey),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Malformed roles claim",
+			token:        signed(t, jwt2.SigningMethodRS256, kid, claims(jwt2.MapClaims{"roles": "admin"}), privateKey),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Malformed name claim",
+			token:        signed(t, jwt2.SigningMethodRS256, kid, claims(jwt2.MapClaims{"name": 7}), privateKey),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Expired",
+			token:        signed(t, jwt2.SigningMethodRS256, kid, claims(jwt2.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), privateKey),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Unknown kid",
+			token:        signed(t, jwt2.SigningMethodRS256, map[string]interface{}{"kid": "unknown"}, claims(nil), privateKey),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Signed with another key",
+			token:        signed(t, jwt2.SigningMethodRS256, kid, claims(nil), otherKey),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Signed with the public key as an HMAC secret",
+			token:        signed(t, jwt2.SigningMethodHS256, kid, claims(nil), []byte(publicKeyText)),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "Garbage",
+			token:        "not.a.token",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var user models.UserToken
+			handler := client.Verifier()(client.Authenticator(func(w http.ResponseWriter, r *http.Request) {
+				user = client.UserTokenFromCtx(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}, respondWithKind))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "http://cbbpoll.com", nil)
+			if test.token != "" {
+				r.Header.Set("Authorization", "Bearer "+test.token)
+			}
+
+			handler.ServeHTTP(w, r)
+			if w.Code != test.expectedCode {
+				t.Errorf("Expected status code: %v, received: %v", test.expectedCode, w.Code)
+			}
+			if user.Nickname != test.expectedUser.Nickname || user.IsAdmin != test.expectedUser.IsAdmin {
+				t.Errorf("Expected user %v, got %v", test.expectedUser, user)
+			}
+		})
+	}
+}
+
+func TestJwtClient_KeyRotation(t *testing.T) {
+	oldClient := newTestClient(t)
+	oldToken, err := oldClient.CreateJWT(models.User{Nickname: "Concision"})
+	if err != nil {
+		t.Fatalf("Unexpected error from CreateJWT: %s", err.Error())
+	}
+
+	// The new key signs, the old one is only used to verify
+	newPrivate, newPublic := newKeyPair(t)
+	newClient, err := InitJwtAuth(bytes.NewBufferString(newPrivate), bytes.NewBufferString(newPublic), bytes.NewBufferString(publicKeyText))
+	if err != nil {
+		t.Fatalf("Unexpected error creating JwtClient: %s", err.Error())
+	}
+	newToken, err := newClient.CreateJWT(models.User{Nickname: "Concision"})
+	if err != nil {
+		t.Fatalf("Unexpected error from CreateJWT: %s", err.Error())
+	}
+
+	if _, err := newClient.parse(oldToken); err != nil {
+		t.Errorf("Token signed with the old key was rejected: %s", err.Error())
+	}
+	if _, err := newClient.parse(newToken); err != nil {
+		t.Errorf("Token signed with the new key was rejected: %s", err.Error())
+	}
+	if _, err := oldClient.parse(newToken); err == nil {
+		t.Errorf("Token signed with a key the client doesn't know was accepted")
+	}
+}
+
+func TestJwtClient_JWKS(t *testing.T) {
+	_, otherPublic := newKeyPair(t)
+	client := newTestClient(t, otherPublic)
+
+	set := client.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d", len(set.Keys))
+	}
+
+	signing := set.Keys[0]
+	if signing.KeyID != client.keyID {
+		t.Errorf("Expected the signing key first, got %s", signing.KeyID)
+	}
+	if signing.KeyType != "RSA" || signing.Algorithm != "RS256" || signing.Use != "sig" || signing.Exponent != "AQAB" {
+		t.Errorf("Unexpected key %v", signing)
+	}
+
+	// The key has to round trip to the one tokens are verified with
+	n, err := base64.RawURLEncoding.DecodeString(signing.Modulus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if new(big.Int).SetBytes(n).Cmp(client.signingKey.N) != 0 {
+		t.Errorf("Published modulus doesn't match the signing key")
+	}
+
+	if set.Keys[1].KeyID == signing.KeyID {
+		t.Errorf("Keys share an id")
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	// Example from RFC 7638, section 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	expected := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	if id := keyID(key); id != expected {
+		t.Errorf("Expected key id %s, got %s", expected, id)
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"sort"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+)
+
+// JSONWebKey is the public half of an RSA key as described by RFC 7517.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// JSONWebKeySet lists the keys tokens may be signed with.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func readPublicKey(r io.Reader) (*rsa.PublicKey, error) {
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.E(errors.KindJWTError, err, "error reading public key")
+	}
+
+	key, err := jwt.ParseRSAPublicKeyFromPEM(text)
+	if err != nil {
+		return nil, errors.E(errors.KindJWTError, err, "error parsing public key")
+	}
+
+	return key, nil
+}
+
+func newJSONWebKey(key *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		KeyType:   "RSA",
+		Use:       "sig",
+		Algorithm: signingAlgorithm,
+		Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+/*
+keyID identifies a public key by its RFC 7638 thumbprint.  Deriving the id from
+the key means nothing has to be configured to keep ids unique, and a key keeps
+the same id when it moves from signing tokens to only verifying them.
+*/
+func keyID(key *rsa.PublicKey) string {
+	jwk := newJSONWebKey(key)
+
+	// The members have to be in this order, which is what encoding/json does with a map
+	thumbprint, _ := json.Marshal(map[string]string{"e": jwk.Exponent, "kty": jwk.KeyType, "n": jwk.Modulus})
+	sum := sha256.Sum256(thumbprint)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func sameKey(a, b *rsa.PublicKey) bool {
+	return a.E == b.E && a.N.Cmp(b.N) == 0
+}
+
+// JWKS returns the public keys tokens are verified with, starting with the one
+// new tokens are signed with.
+func (j JwtClient) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(j.keys))}
+	for id, key := range j.keys {
+		jwk := newJSONWebKey(key)
+		jwk.KeyID = id
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(a, b int) bool {
+		if set.Keys[a].KeyID == j.keyID || set.Keys[b].KeyID == j.keyID {
+			return set.Keys[a].KeyID == j.keyID
+		}
+		return set.Keys[a].KeyID < set.Keys[b].KeyID
+	})
+
+	return set
+}
@@ -2,6 +2,7 @@
 
 package mocks
 
+import auth "github.com/r-cbb/cbbpoll/internal/auth"
 import context "context"
 import http "net/http"
 import mock "github.com/stretchr/testify/mock"
@@ -12,13 +13,13 @@ type AuthClient struct {
 	mock.Mock
 }
 
-// Authenticator provides a mock function with given fields: _a0
-func (_m *AuthClient) Authenticator(_a0 http.HandlerFunc) http.HandlerFunc {
-	ret := _m.Called(_a0)
+// Authenticator provides a mock function with given fields: next, respond
+func (_m *AuthClient) Authenticator(next http.HandlerFunc, respond auth.ErrorResponder) http.HandlerFunc {
+	ret := _m.Called(next, respond)
 
 	var r0 http.HandlerFunc
-	if rf, ok := ret.Get(0).(func(http.HandlerFunc) http.HandlerFunc); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(http.HandlerFunc, auth.ErrorResponder) http.HandlerFunc); ok {
+		r0 = rf(next, respond)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(http.HandlerFunc)
@@ -49,6 +50,20 @@ func (_m *AuthClient) CreateJWT(u models.User) (string, error) {
 	return r0, r1
 }
 
+// JWKS provides a mock function with given fields:
+func (_m *AuthClient) JWKS() auth.JSONWebKeySet {
+	ret := _m.Called()
+
+	var r0 auth.JSONWebKeySet
+	if rf, ok := ret.Get(0).(func() auth.JSONWebKeySet); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(auth.JSONWebKeySet)
+	}
+
+	return r0
+}
+
 // UserTokenFromCtx provides a mock function with given fields: ctx
 func (_m *AuthClient) UserTokenFromCtx(ctx context.Context) models.UserToken {
 	ret := _m.Called(ctx)
@@ -0,0 +1,591 @@
+/*
+Package config holds the settings the server is started with.  Settings are
+read from a YAML file, then overridden by any environment variables that are
+set, and checked with Validate before the server starts.  Anything not set in
+either place keeps the value from Default.
+*/
+package config
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+)
+
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Database Database `yaml:"database"`
+	Auth     Auth     `yaml:"auth"`
+	Reddit   Reddit   `yaml:"reddit"`
+	CORS     CORS     `yaml:"cors"`
+	// Which reddit accounts can submit ballots
+	Eligibility Eligibility `yaml:"eligibility"`
+	// Reddit usernames of the site admins
+	Admins []string `yaml:"admins"`
+}
+
+type Server struct {
+	// Port to listen on when HTTPS is disabled
+	Port string `yaml:"port"`
+	// Port to serve Prometheus metrics on, apart from the API so it needn't be
+	// exposed publicly.  Metrics aren't served if this is empty.
+	MetricsPort string `yaml:"metrics_port"`
+	// Public base URL of the API, used to build Location headers
+	Host            string        `yaml:"host"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	HTTPS           HTTPS         `yaml:"https"`
+}
+
+// HTTPS configures serving on 443 with certificates from Let's Encrypt.
+type HTTPS struct {
+	Enabled bool `yaml:"enabled"`
+	// Only certificates for this host will be requested
+	Host     string `yaml:"host"`
+	CacheDir string `yaml:"cache_dir"`
+}
+
+type Database struct {
+	// postgres:// URL, or the path to a sqlite database file
+	URL string `yaml:"url"`
+}
+
+type Auth struct {
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PublicKeyFile  string `yaml:"public_key_file"`
+	// Public keys that no longer sign tokens, but whose tokens are still accepted
+	VerificationKeyFiles []string `yaml:"verification_key_files"`
+	// iss claim of access tokens
+	Issuer string `yaml:"issuer"`
+	// How long access tokens are accepted before they have to be refreshed
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl"`
+	// How long a session can go without being refreshed
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+}
+
+type Reddit struct {
+	BaseURL string `yaml:"base_url"`
+	// Credentials of the reddit app users log in through.  Logging in via the
+	// backend is disabled unless client_id is set.
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// Must match the redirect uri registered for the app on reddit
+	RedirectURL string `yaml:"redirect_url"`
+	// Frontend page users are sent back to once they've logged in, with the
+	// session in the URL fragment
+	FrontendURL string `yaml:"frontend_url"`
+	// Signs the cookie a browser holds while logging in.  Every instance of
+	// the server needs the same key.
+	LoginKey string   `yaml:"login_key"`
+	AuthURL  string   `yaml:"auth_url"`
+	TokenURL string   `yaml:"token_url"`
+	Scopes   []string `yaml:"scopes"`
+	// Account poll results are posted from.  Disabled unless bot.username is set.
+	Bot RedditBot `yaml:"bot"`
+}
+
+// RedditBot is a reddit account with a "script" app of its own.
+type RedditBot struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	// Without the r/
+	Subreddit string `yaml:"subreddit"`
+	// Post each poll's results once it closes, rather than waiting to be asked
+	AutoPublish bool `yaml:"auto_publish"`
+	// How long after closing a poll is still published automatically
+	PublishWindow time.Duration `yaml:"publish_window"`
+	// Set users' flair in the subreddit to their primary team.  The bot has to
+	// be a moderator there with flair permissions.
+	SyncFlair bool `yaml:"sync_flair"`
+}
+
+// Enabled reports whether poll results can be posted to reddit.
+func (b RedditBot) Enabled() bool {
+	return b.Username != ""
+}
+
+// OAuthEnabled reports whether users can log in through reddit via the backend.
+func (r Reddit) OAuthEnabled() bool {
+	return r.ClientID != ""
+}
+
+type Eligibility struct {
+	MinAccountAge time.Duration `yaml:"min_account_age"`
+	// Link and comment karma combined
+	MinKarma       int  `yaml:"min_karma"`
+	AllowSuspended bool `yaml:"allow_suspended"`
+	// Subreddit, without the r/, that voters need flair in.  Flair isn't
+	// required if this is empty.
+	FlairSubreddit string `yaml:"flair_subreddit"`
+}
+
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	MaxAge         int      `yaml:"max_age"`
+}
+
+// Default returns the settings used for anything that isn't configured.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port:            "8000",
+			MetricsPort:     "9090",
+			Host:            "http://localhost:8000",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			IdleTimeout:     120 * time.Second,
+			ShutdownTimeout: 5 * time.Second,
+			HTTPS: HTTPS{
+				Host:     "api.cbbpoll.com",
+				CacheDir: "/data/ssl_cache",
+			},
+		},
+		Database: Database{
+			URL: "/data/cbbpoll.db",
+		},
+		Auth: Auth{
+			PrivateKeyFile:  "/data/jwtRS256.key",
+			PublicKeyFile:   "/data/jwtRS256.key.pub",
+			Issuer:          "cbbpoll",
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+		},
+		Reddit: Reddit{
+			BaseURL:  "https://oauth.reddit.com/api/v1",
+			AuthURL:  "https://www.reddit.com/api/v1/authorize",
+			TokenURL: "https://www.reddit.com/api/v1/access_token",
+			Scopes:   []string{"identity"},
+			Bot: RedditBot{
+				Subreddit:     "CollegeBasketball",
+				PublishWindow: 24 * time.Hour,
+			},
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"*"},
+			AllowedHeaders: []string{"*"},
+			MaxAge:         1000,
+		},
+		Admins: []string{"Concision", "einsteins_haircut"},
+	}
+}
+
+/*
+Load reads the configuration file at path, if path isn't empty, and applies
+environment overrides on top of it.  The result isn't validated; call Validate
+before using it.
+*/
+func Load(path string) (Config, error) {
+	const op errors.Op = "config.Load"
+
+	cfg := Default()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return Config{}, errors.E(op, err, "error opening config file")
+		}
+		defer f.Close()
+
+		cfg, err = Parse(f)
+		if err != nil {
+			return Config{}, errors.E(op, err)
+		}
+	}
+
+	err := cfg.applyEnv(os.LookupEnv)
+	if err != nil {
+		return Config{}, errors.E(op, err)
+	}
+
+	return cfg, nil
+}
+
+// Parse reads YAML settings from r on top of the defaults.  Unknown keys are an
+// error, so typos don't silently leave a setting at its default.
+func Parse(r io.Reader) (Config, error) {
+	const op errors.Op = "config.Parse"
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Config{}, errors.E(op, err, "error reading config")
+	}
+
+	cfg := Default()
+	err = yaml.UnmarshalStrict(b, &cfg)
+	if err != nil {
+		return Config{}, errors.E(op, err, "error parsing config")
+	}
+
+	return cfg, nil
+}
+
+/*
+applyEnv overrides settings with environment variables:
+
+	DATABASE_URL                     database.url
+	PORT                             server.port
+	METRICS_PORT                     server.metrics_port
+	SERVER_HOST                      server.host
+	HTTPS_ENABLED                    server.https.enabled (true/false or 1/0)
+	ACME_HOST                        server.https.host
+	ACME_CACHE_DIR                   server.https.cache_dir
+	JWT_PRIVATE_KEY_FILE             auth.private_key_file
+	JWT_PUBLIC_KEY_FILE              auth.public_key_file
+	JWT_VERIFICATION_KEY_FILES       auth.verification_key_files (comma separated)
+	REDDIT_BASE_URL                  reddit.base_url
+	REDDIT_CLIENT_ID                 reddit.client_id
+	REDDIT_CLIENT_SECRET             reddit.client_secret
+	REDDIT_REDIRECT_URL              reddit.redirect_url
+	REDDIT_FRONTEND_URL              reddit.frontend_url
+	REDDIT_LOGIN_KEY                 reddit.login_key
+	REDDIT_BOT_CLIENT_ID             reddit.bot.client_id
+	REDDIT_BOT_CLIENT_SECRET         reddit.bot.client_secret
+	REDDIT_BOT_USERNAME              reddit.bot.username
+	REDDIT_BOT_PASSWORD              reddit.bot.password
+	REDDIT_BOT_SUBREDDIT             reddit.bot.subreddit
+	CORS_ALLOWED_ORIGINS             cors.allowed_origins (comma separated)
+	ADMINS                           admins (comma separated)
+	CBBPOLL_SERVER_READ_TIMEOUT      server.read_timeout (e.g. 15s or 720h)
+	CBBPOLL_SERVER_WRITE_TIMEOUT     server.write_timeout (e.g. 15s or 720h)
+	CBBPOLL_SERVER_IDLE_TIMEOUT      server.idle_timeout (e.g. 15s or 720h)
+	CBBPOLL_SERVER_SHUTDOWN_TIMEOUT  server.shutdown_timeout (e.g. 15s or 720h)
+	CBBPOLL_ACCESS_TOKEN_TTL         auth.access_token_ttl (e.g. 15s or 720h)
+	CBBPOLL_REFRESH_TOKEN_TTL        auth.refresh_token_ttl (e.g. 15s or 720h)
+	CBBPOLL_BOT_AUTO_PUBLISH         reddit.bot.auto_publish (true/false or 1/0)
+	CBBPOLL_BOT_PUBLISH_WINDOW       reddit.bot.publish_window (e.g. 15s or 720h)
+	CBBPOLL_BOT_SYNC_FLAIR           reddit.bot.sync_flair (true/false or 1/0)
+	CBBPOLL_MIN_ACCOUNT_AGE          eligibility.min_account_age (e.g. 15s or 720h)
+	CBBPOLL_MIN_KARMA                eligibility.min_karma
+	CBBPOLL_ALLOW_SUSPENDED          eligibility.allow_suspended (true/false or 1/0)
+	CBBPOLL_FLAIR_SUBREDDIT          eligibility.flair_subreddit
+	CBBPOLL_CORS_MAX_AGE             cors.max_age
+*/
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	const op errors.Op = "config.applyEnv"
+
+	strs := map[string]*string{
+		"DATABASE_URL":         &c.Database.URL,
+		"PORT":                 &c.Server.Port,
+		"METRICS_PORT":         &c.Server.MetricsPort,
+		"SERVER_HOST":          &c.Server.Host,
+		"ACME_HOST":            &c.Server.HTTPS.Host,
+		"ACME_CACHE_DIR":       &c.Server.HTTPS.CacheDir,
+		"JWT_PRIVATE_KEY_FILE": &c.Auth.PrivateKeyFile,
+		"JWT_PUBLIC_KEY_FILE":  &c.Auth.PublicKeyFile,
+		"REDDIT_BASE_URL":      &c.Reddit.BaseURL,
+		"REDDIT_CLIENT_ID":     &c.Reddit.ClientID,
+		"REDDIT_CLIENT_SECRET": &c.Reddit.ClientSecret,
+		"REDDIT_REDIRECT_URL":  &c.Reddit.RedirectURL,
+		"REDDIT_FRONTEND_URL":  &c.Reddit.FrontendURL,
+		"REDDIT_LOGIN_KEY":     &c.Reddit.LoginKey,
+
+		"REDDIT_BOT_CLIENT_ID":     &c.Reddit.Bot.ClientID,
+		"REDDIT_BOT_CLIENT_SECRET": &c.Reddit.Bot.ClientSecret,
+		"REDDIT_BOT_USERNAME":      &c.Reddit.Bot.Username,
+		"REDDIT_BOT_PASSWORD":      &c.Reddit.Bot.Password,
+		"REDDIT_BOT_SUBREDDIT":     &c.Reddit.Bot.Subreddit,
+
+		"CBBPOLL_FLAIR_SUBREDDIT": &c.Eligibility.FlairSubreddit,
+	}
+	for name, setting := range strs {
+		if v, ok := lookup(name); ok && v != "" {
+			*setting = v
+		}
+	}
+
+	lists := map[string]*[]string{
+		"CORS_ALLOWED_ORIGINS":       &c.CORS.AllowedOrigins,
+		"ADMINS":                     &c.Admins,
+		"JWT_VERIFICATION_KEY_FILES": &c.Auth.VerificationKeyFiles,
+	}
+	for name, setting := range lists {
+		if v, ok := lookup(name); ok && v != "" {
+			*setting = splitList(v)
+		}
+	}
+
+	// Values that don't parse are all reported together, like Validate does
+	var problems []string
+
+	durations := map[string]*time.Duration{
+		"CBBPOLL_SERVER_READ_TIMEOUT":     &c.Server.ReadTimeout,
+		"CBBPOLL_SERVER_WRITE_TIMEOUT":    &c.Server.WriteTimeout,
+		"CBBPOLL_SERVER_IDLE_TIMEOUT":     &c.Server.IdleTimeout,
+		"CBBPOLL_SERVER_SHUTDOWN_TIMEOUT": &c.Server.ShutdownTimeout,
+		"CBBPOLL_ACCESS_TOKEN_TTL":        &c.Auth.AccessTokenTTL,
+		"CBBPOLL_REFRESH_TOKEN_TTL":       &c.Auth.RefreshTokenTTL,
+		"CBBPOLL_BOT_PUBLISH_WINDOW":      &c.Reddit.Bot.PublishWindow,
+		"CBBPOLL_MIN_ACCOUNT_AGE":         &c.Eligibility.MinAccountAge,
+	}
+	for name, setting := range durations {
+		if v, ok := lookup(name); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be a duration such as 15s or 720h, got %q", name, v))
+				continue
+			}
+			*setting = d
+		}
+	}
+
+	ints := map[string]*int{
+		"CBBPOLL_MIN_KARMA":    &c.Eligibility.MinKarma,
+		"CBBPOLL_CORS_MAX_AGE": &c.CORS.MaxAge,
+	}
+	for name, setting := range ints {
+		if v, ok := lookup(name); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be a whole number, got %q", name, v))
+				continue
+			}
+			*setting = n
+		}
+	}
+
+	bools := map[string]*bool{
+		"HTTPS_ENABLED":            &c.Server.HTTPS.Enabled,
+		"CBBPOLL_BOT_AUTO_PUBLISH": &c.Reddit.Bot.AutoPublish,
+		"CBBPOLL_BOT_SYNC_FLAIR":   &c.Reddit.Bot.SyncFlair,
+		"CBBPOLL_ALLOW_SUSPENDED":  &c.Eligibility.AllowSuspended,
+	}
+	for name, setting := range bools {
+		if v, ok := lookup(name); ok && v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be true or false, got %q", name, v))
+				continue
+			}
+			*setting = b
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.E(op, fmt.Errorf("invalid environment: %s", strings.Join(problems, "; ")))
+	}
+
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate checks that the settings make sense together, reporting every
+// problem it finds rather than just the first.
+func (c Config) Validate() error {
+	const op errors.Op = "config.Validate"
+
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !isPort(c.Server.Port) {
+		add("server.port %q is not a valid port", c.Server.Port)
+	}
+	if c.Server.MetricsPort != "" {
+		if !isPort(c.Server.MetricsPort) {
+			add("server.metrics_port %q is not a valid port", c.Server.MetricsPort)
+		}
+		used := []string{c.Server.Port}
+		if c.Server.HTTPS.Enabled {
+			used = []string{"80", "443"}
+		}
+		if contains(used, c.Server.MetricsPort) {
+			add("server.metrics_port %q is already used by the API", c.Server.MetricsPort)
+		}
+	}
+	if !isAbsoluteURL(c.Server.Host) {
+		add("server.host %q must be an absolute URL", c.Server.Host)
+	}
+	timeouts := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+	}
+	for _, t := range timeouts {
+		if t.timeout <= 0 {
+			add("%s must be positive", t.name)
+		}
+	}
+	if c.Server.HTTPS.Enabled {
+		if c.Server.HTTPS.Host == "" {
+			add("server.https.host is required when https is enabled")
+		}
+		if c.Server.HTTPS.CacheDir == "" {
+			add("server.https.cache_dir is required when https is enabled")
+		}
+	}
+
+	if c.Database.URL == "" {
+		add("database.url is required")
+	}
+
+	if c.Auth.PrivateKeyFile == "" {
+		add("auth.private_key_file is required")
+	}
+	if c.Auth.PublicKeyFile == "" {
+		add("auth.public_key_file is required")
+	}
+	for i, f := range c.Auth.VerificationKeyFiles {
+		if f == "" {
+			add("auth.verification_key_files[%d] is empty", i)
+		}
+	}
+	if c.Auth.Issuer == "" {
+		add("auth.issuer is required")
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		add("auth.access_token_ttl must be positive")
+	}
+	if c.Auth.RefreshTokenTTL < c.Auth.AccessTokenTTL {
+		add("auth.refresh_token_ttl can't be shorter than auth.access_token_ttl")
+	}
+
+	if !isAbsoluteURL(c.Reddit.BaseURL) {
+		add("reddit.base_url %q must be an absolute URL", c.Reddit.BaseURL)
+	}
+	if c.Reddit.OAuthEnabled() {
+		if c.Reddit.ClientSecret == "" {
+			add("reddit.client_secret is required when reddit.client_id is set")
+		}
+		if len(c.Reddit.LoginKey) < minLoginKeyLength {
+			add("reddit.login_key must be at least %d characters when reddit.client_id is set", minLoginKeyLength)
+		}
+		urls := []struct {
+			name string
+			url  string
+		}{
+			{"reddit.redirect_url", c.Reddit.RedirectURL},
+			{"reddit.frontend_url", c.Reddit.FrontendURL},
+			{"reddit.auth_url", c.Reddit.AuthURL},
+			{"reddit.token_url", c.Reddit.TokenURL},
+		}
+		for _, u := range urls {
+			if !isAbsoluteURL(u.url) {
+				add("%s %q must be an absolute URL", u.name, u.url)
+			}
+		}
+		if len(c.Reddit.Scopes) == 0 {
+			add("reddit.scopes can't be empty")
+		}
+	}
+
+	if bot := c.Reddit.Bot; bot.Enabled() {
+		required := []struct {
+			name  string
+			value string
+		}{
+			{"reddit.bot.client_id", bot.ClientID},
+			{"reddit.bot.client_secret", bot.ClientSecret},
+			{"reddit.bot.password", bot.Password},
+		}
+		for _, r := range required {
+			if r.value == "" {
+				add("%s is required when reddit.bot.username is set", r.name)
+			}
+		}
+		if !subredditName.MatchString(bot.Subreddit) {
+			add("reddit.bot.subreddit %q is not a subreddit name", bot.Subreddit)
+		}
+		if bot.PublishWindow <= 0 {
+			add("reddit.bot.publish_window must be positive")
+		}
+		if !isAbsoluteURL(c.Reddit.TokenURL) {
+			add("reddit.token_url %q must be an absolute URL", c.Reddit.TokenURL)
+		}
+	} else {
+		// Turning these on does nothing without the bot, so it's likely a mistake
+		if bot.AutoPublish {
+			add("reddit.bot.auto_publish needs reddit.bot.username to be set")
+		}
+		if bot.SyncFlair {
+			add("reddit.bot.sync_flair needs reddit.bot.username to be set")
+		}
+	}
+
+	if c.Eligibility.MinAccountAge < 0 {
+		add("eligibility.min_account_age can't be negative")
+	}
+	if c.Eligibility.MinKarma < 0 {
+		add("eligibility.min_karma can't be negative")
+	}
+	if sub := c.Eligibility.FlairSubreddit; sub != "" {
+		if !subredditName.MatchString(sub) {
+			add("eligibility.flair_subreddit %q is not a subreddit name", sub)
+		}
+		// Flair is looked up with the user's token, so it has to be allowed to
+		if c.Reddit.OAuthEnabled() && !contains(c.Reddit.Scopes, "flair") {
+			add("reddit.scopes must include flair when eligibility.flair_subreddit is set")
+		}
+	}
+
+	if c.CORS.MaxAge < 0 {
+		add("cors.max_age can't be negative")
+	}
+
+	for i, admin := range c.Admins {
+		if strings.TrimSpace(admin) == "" {
+			add("admins[%d] is empty", i)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.E(op, fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; ")))
+	}
+
+	return nil
+}
+
+// Short keys could be guessed, letting anyone forge a login cookie
+const minLoginKeyLength = 32
+
+var subredditName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_]{1,20}$`)
+
+func isPort(s string) bool {
+	port, err := strconv.Atoi(s)
+	return err == nil && port >= 1 && port <= 65535
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
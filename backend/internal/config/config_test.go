@@ -0,0 +1,273 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	input := `
+server:
+  port: "9000"
+  write_timeout: 30s
+  https:
+    enabled: true
+admins:
+  - Concision
+`
+	cfg, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if cfg.Server.Port != "9000" || cfg.Server.WriteTimeout != 30*time.Second || !cfg.Server.HTTPS.Enabled {
+		t.Errorf("Settings from file not applied: %+v", cfg.Server)
+	}
+
+	// A list in the file replaces the default rather than adding to it
+	if !reflect.DeepEqual(cfg.Admins, []string{"Concision"}) {
+		t.Errorf("Unexpected admins: %v", cfg.Admins)
+	}
+
+	// Anything the file doesn't mention keeps its default
+	if cfg.Server.ReadTimeout != Default().Server.ReadTimeout || cfg.Database.URL != Default().Database.URL {
+		t.Errorf("Defaults not kept: %+v", cfg)
+	}
+
+	cfg, err = Parse(strings.NewReader("server:\n  port: \"9000\"\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(cfg.Admins, []string{"Concision", "einsteins_haircut"}) {
+		t.Errorf("Expected the default admins without an admins list, got %v", cfg.Admins)
+	}
+
+	_, err = Parse(strings.NewReader("server:\n  prot: 9000\n"))
+	if err == nil {
+		t.Errorf("Expected error for unknown key")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"DATABASE_URL":                "postgres://localhost/cbbpoll",
+		"PORT":                        "",
+		"HTTPS_ENABLED":               "1",
+		"ADMINS":                      "Concision, einsteins_haircut,",
+		"JWT_VERIFICATION_KEY_FILES":  "/data/old.key.pub",
+		"CBBPOLL_SERVER_READ_TIMEOUT": "30s",
+		"CBBPOLL_ACCESS_TOKEN_TTL":    "5m",
+		"CBBPOLL_MIN_KARMA":           "-5",
+		"CBBPOLL_MIN_ACCOUNT_AGE":     "720h",
+		"CBBPOLL_BOT_SYNC_FLAIR":      "true",
+		"CBBPOLL_CORS_MAX_AGE":        "",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cfg := Default()
+	err := cfg.applyEnv(lookup)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if cfg.Database.URL != "postgres://localhost/cbbpoll" {
+		t.Errorf("DATABASE_URL not applied, got %s", cfg.Database.URL)
+	}
+	if cfg.Server.Port != Default().Server.Port {
+		t.Errorf("Empty PORT shouldn't override default, got %s", cfg.Server.Port)
+	}
+	if !cfg.Server.HTTPS.Enabled {
+		t.Errorf("HTTPS_ENABLED not applied")
+	}
+	if !reflect.DeepEqual(cfg.Admins, []string{"Concision", "einsteins_haircut"}) {
+		t.Errorf("Unexpected admins: %v", cfg.Admins)
+	}
+	if !reflect.DeepEqual(cfg.Auth.VerificationKeyFiles, []string{"/data/old.key.pub"}) {
+		t.Errorf("Unexpected verification keys: %v", cfg.Auth.VerificationKeyFiles)
+	}
+	if cfg.Server.ReadTimeout != 30*time.Second || cfg.Auth.AccessTokenTTL != 5*time.Minute || cfg.Eligibility.MinAccountAge != 30*24*time.Hour {
+		t.Errorf("Duration overrides not applied: %+v %+v %+v", cfg.Server, cfg.Auth, cfg.Eligibility)
+	}
+	if cfg.Eligibility.MinKarma != -5 || !cfg.Reddit.Bot.SyncFlair || cfg.CORS.MaxAge != Default().CORS.MaxAge {
+		t.Errorf("Unexpected overrides: %+v %+v %+v", cfg.Eligibility, cfg.Reddit.Bot, cfg.CORS)
+	}
+
+	// Values that parse are still checked by Validate
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatalf("Expected validation error")
+	}
+	for _, p := range []string{"eligibility.min_karma", "reddit.bot.sync_flair"} {
+		if !strings.Contains(err.Error(), p) {
+			t.Errorf("Expected error to mention %s: %s", p, err.Error())
+		}
+	}
+
+	// Values that don't are all reported
+	env["HTTPS_ENABLED"] = "sometimes"
+	env["CBBPOLL_REFRESH_TOKEN_TTL"] = "30 days"
+	env["CBBPOLL_CORS_MAX_AGE"] = "1.5"
+	err = cfg.applyEnv(lookup)
+	if err == nil {
+		t.Fatalf("Expected error for invalid values")
+	}
+	for _, name := range []string{"HTTPS_ENABLED", "CBBPOLL_REFRESH_TOKEN_TTL", "CBBPOLL_CORS_MAX_AGE"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected error to mention %s: %s", name, err.Error())
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name             string
+		modify           func(c *Config)
+		expectedProblems []string
+	}{
+		{
+			name:   "Defaults",
+			modify: func(c *Config) {},
+		},
+		{
+			name: "Bad server settings",
+			modify: func(c *Config) {
+				c.Server.Port = "80a"
+				c.Server.Host = "localhost"
+				c.Server.IdleTimeout = 0
+			},
+			expectedProblems: []string{"server.port", "server.host", "server.idle_timeout"},
+		},
+		{
+			name: "Metrics on the API's port",
+			modify: func(c *Config) {
+				c.Server.MetricsPort = c.Server.Port
+			},
+			expectedProblems: []string{"server.metrics_port"},
+		},
+		{
+			name: "Metrics on a port HTTPS uses",
+			modify: func(c *Config) {
+				c.Server.HTTPS.Enabled = true
+				c.Server.MetricsPort = "443"
+			},
+			expectedProblems: []string{"server.metrics_port"},
+		},
+		{
+			name: "Metrics turned off",
+			modify: func(c *Config) {
+				c.Server.MetricsPort = ""
+			},
+		},
+		{
+			name: "HTTPS without host",
+			modify: func(c *Config) {
+				c.Server.HTTPS.Enabled = true
+				c.Server.HTTPS.Host = ""
+			},
+			expectedProblems: []string{"server.https.host"},
+		},
+		{
+			name: "Missing database and keys",
+			modify: func(c *Config) {
+				c.Database.URL = ""
+				c.Auth.PublicKeyFile = ""
+				c.Auth.VerificationKeyFiles = []string{""}
+				c.Admins = []string{"Concision", " "}
+			},
+			expectedProblems: []string{"database.url", "auth.public_key_file", "auth.verification_key_files[0]", "admins[1]"},
+		},
+		{
+			name: "Reddit login without secret",
+			modify: func(c *Config) {
+				c.Reddit.ClientID = "abc"
+				c.Reddit.RedirectURL = "/callback"
+			},
+			expectedProblems: []string{"reddit.client_secret", "reddit.login_key", "reddit.redirect_url", "reddit.frontend_url"},
+		},
+		{
+			name: "Bad eligibility rules",
+			modify: func(c *Config) {
+				c.Eligibility.MinKarma = -1
+				c.Eligibility.FlairSubreddit = "r/CollegeBasketball"
+			},
+			expectedProblems: []string{"eligibility.min_karma", "eligibility.flair_subreddit"},
+		},
+		{
+			name: "Flair required without flair scope",
+			modify: func(c *Config) {
+				c.Reddit.ClientID = "abc"
+				c.Reddit.ClientSecret = "secret"
+				c.Reddit.RedirectURL = "http://localhost:8000/v1/auth/reddit/callback"
+				c.Reddit.FrontendURL = "http://localhost:8080/"
+				c.Reddit.LoginKey = strings.Repeat("k", minLoginKeyLength)
+				c.Eligibility.FlairSubreddit = "CollegeBasketball"
+			},
+			expectedProblems: []string{"reddit.scopes"},
+		},
+		{
+			name: "Bot without password",
+			modify: func(c *Config) {
+				c.Reddit.Bot.ClientID = "abc"
+				c.Reddit.Bot.ClientSecret = "secret"
+				c.Reddit.Bot.Username = "cbbpoll-bot"
+				c.Reddit.Bot.Subreddit = "r/CollegeBasketball"
+			},
+			expectedProblems: []string{"reddit.bot.password", "reddit.bot.subreddit"},
+		},
+		{
+			name: "Bad token lifetimes",
+			modify: func(c *Config) {
+				c.Auth.AccessTokenTTL = time.Hour
+				c.Auth.RefreshTokenTTL = time.Minute
+			},
+			expectedProblems: []string{"auth.refresh_token_ttl"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := Default()
+			test.modify(&cfg)
+
+			err := cfg.Validate()
+			if len(test.expectedProblems) == 0 {
+				if err != nil {
+					t.Errorf("Unexpected error: %s", err.Error())
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Expected validation error")
+			}
+			for _, p := range test.expectedProblems {
+				if !strings.Contains(err.Error(), p) {
+					t.Errorf("Expected error to mention %s: %s", p, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	f, err := os.Open("../../config.example.yaml")
+	if err != nil {
+		t.Fatalf("error opening example config: %s", err.Error())
+	}
+	defer f.Close()
+
+	cfg, err := Parse(f)
+	if err != nil {
+		t.Fatalf("Example config doesn't parse: %s", err.Error())
+	}
+
+	if err = cfg.Validate(); err != nil {
+		t.Errorf("Example config isn't valid: %s", err.Error())
+	}
+}
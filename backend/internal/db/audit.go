@@ -0,0 +1,107 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// AuditCreated completes entry for a change that created v, whose ID wasn't
+// known until the database assigned it.
+func AuditCreated(entry models.AuditEntry, id int64, v interface{}) (models.AuditEntry, error) {
+	after, err := json.Marshal(v)
+	if err != nil {
+		return models.AuditEntry{}, err
+	}
+
+	entry.TargetID = strconv.FormatInt(id, 10)
+	entry.After = after
+	return entry, nil
+}
+
+/*
+RedactAudit replaces the user called name with alias throughout entry, for when
+the user is deleted: as its actor or target, and wherever the name appears in
+the values before and after the change.  Reasons the user gave on ballots are
+removed too, as they are from the ballots themselves.  It reports whether
+anything changed.
+*/
+func RedactAudit(entry models.AuditEntry, name string, alias string) (models.AuditEntry, bool, error) {
+	var changed bool
+	if entry.Actor == name {
+		entry.Actor = alias
+		changed = true
+	}
+	if entry.TargetType == models.AuditTargetUser && entry.TargetID == name {
+		entry.TargetID = alias
+		changed = true
+	}
+
+	for _, raw := range []*json.RawMessage{&entry.Before, &entry.After} {
+		if *raw == nil {
+			continue
+		}
+
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(*raw))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return models.AuditEntry{}, false, err
+		}
+
+		v, redacted := redactValue(v, name, alias)
+		if !redacted {
+			continue
+		}
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			return models.AuditEntry{}, false, err
+		}
+		*raw = b
+		changed = true
+	}
+
+	return entry, changed, nil
+}
+
+func redactValue(v interface{}, name string, alias string) (interface{}, bool) {
+	switch t := v.(type) {
+	case string:
+		if t == name {
+			return alias, true
+		}
+	case []interface{}:
+		var changed bool
+		for i := range t {
+			var c bool
+			t[i], c = redactValue(t[i], name, alias)
+			changed = changed || c
+		}
+		return t, changed
+	case map[string]interface{}:
+		var changed bool
+		// A ballot cast by the user
+		if t["user"] == name {
+			votes, _ := t["votes"].([]interface{})
+			for _, vote := range votes {
+				if vote, ok := vote.(map[string]interface{}); ok {
+					if _, ok := vote["reason"]; ok {
+						delete(vote, "reason")
+						changed = true
+					}
+				}
+			}
+		}
+		for k := range t {
+			var c bool
+			t[k], c = redactValue(t[k], name, alias)
+			changed = changed || c
+		}
+		return t, changed
+	}
+
+	return v, false
+}
@@ -1,6 +1,10 @@
 package db
 
-import "github.com/r-cbb/cbbpoll/internal/models"
+import (
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
 
 type DBClient interface {
 	Close() error
@@ -14,6 +18,13 @@ type DBClient interface {
 	UpdateUser(user models.User) (err error)
 	GetUser(name string) (user models.User, err error)
 	GetUsers(filter []Filter, sort Sort) ([]models.User, error)
+	// AnonymizeUser replaces the user called name with a new user called alias.
+	// The user's ballots listed in deleteBallots are deleted, invalidating their
+	// polls' results.  The rest are moved to alias, without the reasons given
+	// for votes, and everything else stored about the user is deleted.  Their
+	// name is replaced by alias throughout the audit log as well, which is the
+	// only time the log is changed.  Nothing changes if any of it fails.
+	AnonymizeUser(name string, alias string, deleteBallots []int64) error
 
 	AddPoll(newPoll models.Poll) (poll models.Poll, err error)
 	UpdatePoll(poll models.Poll) error
@@ -25,17 +36,35 @@ type DBClient interface {
 	AddBallot(newBallot models.Ballot) (ballot models.Ballot, err error)
 	GetBallot(id int64) (ballot models.Ballot, err error)
 	GetBallotsByPoll(poll models.Poll) (ballots []models.Ballot, err error)
+	// GetBallotsByUser returns all of user's ballots, oldest poll first.
+	GetBallotsByUser(user string) (ballots []models.Ballot, err error)
 	DeleteBallot(id int64) (err error)
 	UpdateBallot(ballot models.Ballot) error
-}
 
-type Filter struct {
-	Field    string // value trusted
-	Operator string // value trusted
-	Value    interface{} // untrusted user input
-}
+	AddRefreshToken(token models.RefreshToken) error
+	GetRefreshToken(hash string) (token models.RefreshToken, err error)
+	// GetRefreshTokens returns the refresh tokens issued to user, oldest first.
+	GetRefreshTokens(user string) (tokens []models.RefreshToken, err error)
+	// RotateRefreshToken revokes the token with oldHash and adds newToken in
+	// its place.  It fails with KindConflict if the old token was already revoked.
+	RotateRefreshToken(oldHash string, newToken models.RefreshToken) error
+	RevokeRefreshToken(hash string) error
+	RevokeRefreshTokens(user string) error
+	RevokeAccessToken(id string, expiresAt time.Time) error
+	IsAccessTokenRevoked(id string) (revoked bool, err error)
 
-type Sort struct {
-	field string
-	asc   bool
-}
\ No newline at end of file
+	// GetFlairTeams returns the team each user's subreddit flair was last set
+	// for, by nickname.  Users whose flair was never set are left out.
+	GetFlairTeams() (teams map[string]int64, err error)
+	// SetFlairTeam records that user's flair was set for team, or cleared if team is 0.
+	SetFlairTeam(user string, team int64) error
+
+	// WithAudit returns a client that adds entry to the audit log in the same
+	// transaction as the change made through it, so neither happens without the
+	// other.  Only AddTeam, AddUser, UpdateUser, AnonymizeUser, AddPoll and
+	// DeleteBallot record the entry; AddTeam fills in the team's ID as its
+	// TargetID and the team as added as its After.
+	WithAudit(entry models.AuditEntry) DBClient
+	// GetAuditLog returns the entries in the audit log, newest first.
+	GetAuditLog(filter []Filter, sort Sort) ([]models.AuditEntry, error)
+}
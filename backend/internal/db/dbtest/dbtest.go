@@ -0,0 +1,1189 @@
+/*
+Package dbtest is a behavioral test suite that every db.DBClient implementation
+must pass.  Backends run it from their own tests with a constructor that hands
+back a client connected to a fresh, empty, fully-migrated database:
+
+	func TestConformance(t *testing.T) {
+		dbtest.RunSuite(t, func(t *testing.T) db.DBClient {
+			return newTestClient(t)
+		})
+	}
+*/
+package dbtest
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// NewClientFunc returns a client backed by an empty database.  Implementations
+// should register any cleanup with t.Cleanup.
+type NewClientFunc func(t *testing.T) db.DBClient
+
+func RunSuite(t *testing.T, newClient NewClientFunc) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, c db.DBClient)
+	}{
+		{"Teams", testTeams},
+		{"Users", testUsers},
+		{"UserRoles", testUserRoles},
+		{"UserFilters", testUserFilters},
+		{"UserEligibility", testUserEligibility},
+		{"FlairTeams", testFlairTeams},
+		{"AnonymizeUser", testAnonymizeUser},
+		{"AnonymizeUserRedactsAudit", testAnonymizeUserRedactsAudit},
+		{"Polls", testPolls},
+		{"PollFilters", testPollFilters},
+		{"Ballots", testBallots},
+		{"UpdateBallot", testUpdateBallot},
+		{"DeleteBallot", testDeleteBallot},
+		{"BallotConstraints", testBallotConstraints},
+		{"Results", testResults},
+		{"ResultInvalidation", testResultInvalidation},
+		{"RefreshTokens", testRefreshTokens},
+		{"RevokedAccessTokens", testRevokedAccessTokens},
+		{"AuditLog", testAuditLog},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newClient(t)
+			test.fn(t, c)
+		})
+	}
+}
+
+// Times are truncated so they survive a round trip through backends with
+// coarser timestamp precision than time.Time.
+var baseTime = time.Now().UTC().Truncate(time.Second)
+
+var fixtureTeams = []models.Team{
+	{FullName: "University of Arizona", ShortName: "Arizona", Nickname: "Wildcats", Conference: "Pac-12", Slug: "arizona"},
+	{FullName: "Ohio State University", ShortName: "Ohio State", Nickname: "Buckeyes", Conference: "Big Ten", Slug: "ohio-state"},
+	{FullName: "Duke University", ShortName: "Duke", Nickname: "Blue Devils", Conference: "ACC", Slug: "duke"},
+}
+
+func fixturePoll(season, week int) models.Poll {
+	return models.Poll{
+		Season:       season,
+		Week:         week,
+		OpenTime:     baseTime.Add(-24 * time.Hour),
+		CloseTime:    baseTime.Add(24 * time.Hour),
+		LastModified: baseTime,
+		RedditURL:    "https://reddit.com/r/collegebasketball",
+	}
+}
+
+func mustAddTeams(t *testing.T, c db.DBClient) []models.Team {
+	t.Helper()
+	teams := make([]models.Team, len(fixtureTeams))
+	for i, ft := range fixtureTeams {
+		team, err := c.AddTeam(ft)
+		if err != nil {
+			t.Fatalf("error adding team fixture: %s", err.Error())
+		}
+		teams[i] = team
+	}
+	return teams
+}
+
+func mustAddUser(t *testing.T, c db.DBClient, u models.User) models.User {
+	t.Helper()
+	user, err := c.AddUser(u)
+	if err != nil {
+		t.Fatalf("error adding user fixture: %s", err.Error())
+	}
+	return user
+}
+
+func mustAddPoll(t *testing.T, c db.DBClient, p models.Poll) models.Poll {
+	t.Helper()
+	poll, err := c.AddPoll(p)
+	if err != nil {
+		t.Fatalf("error adding poll fixture: %s", err.Error())
+	}
+	return poll
+}
+
+func mustGetPoll(t *testing.T, c db.DBClient, season, week int) models.Poll {
+	t.Helper()
+	poll, err := c.GetPoll(season, week)
+	if err != nil {
+		t.Fatalf("error retrieving poll: %s", err.Error())
+	}
+	return poll
+}
+
+func fixtureBallot(user string, poll models.Poll, teams []models.Team) models.Ballot {
+	votes := make([]models.Vote, len(teams))
+	for i, team := range teams {
+		votes[i] = models.Vote{TeamID: team.ID, Rank: i + 1, Reason: "good team"}
+	}
+
+	return models.Ballot{
+		PollSeason:  poll.Season,
+		PollWeek:    poll.Week,
+		UpdatedTime: baseTime,
+		User:        user,
+		Votes:       votes,
+		IsOfficial:  true,
+	}
+}
+
+func expectKind(t *testing.T, err error, kind errors.Code, what string) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("%s: expected error of kind %v, got nil", what, kind)
+		return
+	}
+	if errors.Kind(err) != kind {
+		t.Errorf("%s: expected error of kind %v, got %v (%s)", what, kind, errors.Kind(err), err.Error())
+	}
+}
+
+func testTeams(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+
+	for i, team := range teams {
+		if team.ID == 0 {
+			t.Errorf("AddTeam didn't assign an ID")
+		}
+		fixture := fixtureTeams[i]
+		fixture.ID = team.ID
+		if team != fixture {
+			t.Errorf("AddTeam returned %v, expected %v", team, fixture)
+		}
+
+		got, err := c.GetTeam(team.ID)
+		if err != nil {
+			t.Errorf("GetTeam: unexpected error: %s", err.Error())
+		} else if got != team {
+			t.Errorf("GetTeam returned %v, expected %v", got, team)
+		}
+	}
+
+	all, err := c.GetTeams()
+	if err != nil {
+		t.Fatalf("GetTeams: unexpected error: %s", err.Error())
+	}
+	if len(all) != len(teams) {
+		t.Errorf("GetTeams returned %d teams, expected %d", len(all), len(teams))
+	}
+
+	byID, err := c.GetTeamsByID([]int64{teams[2].ID, teams[0].ID})
+	if err != nil {
+		t.Fatalf("GetTeamsByID: unexpected error: %s", err.Error())
+	}
+	if len(byID) != 2 || byID[0] != teams[2] || byID[1] != teams[0] {
+		t.Errorf("GetTeamsByID returned %v", byID)
+	}
+
+	_, err = c.GetTeam(9999)
+	expectKind(t, err, errors.KindNotFound, "GetTeam on missing team")
+
+	_, err = c.GetTeamsByID([]int64{teams[0].ID, 9999})
+	expectKind(t, err, errors.KindNotFound, "GetTeamsByID with missing team")
+}
+
+func testUsers(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+	user := mustAddUser(t, c, models.User{Nickname: "Concision", IsAdmin: true, PrimaryTeam: teams[0].ID})
+
+	got, err := c.GetUser("Concision")
+	if err != nil {
+		t.Fatalf("GetUser: unexpected error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(got, user) {
+		t.Errorf("GetUser returned %v, expected %v", got, user)
+	}
+
+	_, err = c.AddUser(models.User{Nickname: "Concision"})
+	expectKind(t, err, errors.KindConflict, "AddUser with existing nickname")
+
+	_, err = c.GetUser("nobody")
+	expectKind(t, err, errors.KindNotFound, "GetUser on missing user")
+
+	user.IsVoter = true
+	user.PrimaryTeam = 0
+	err = c.UpdateUser(user)
+	if err != nil {
+		t.Fatalf("UpdateUser: unexpected error: %s", err.Error())
+	}
+
+	got, err = c.GetUser("Concision")
+	if err != nil {
+		t.Fatalf("GetUser: unexpected error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(got, user) {
+		t.Errorf("GetUser after update returned %v, expected %v", got, user)
+	}
+}
+
+func testUserRoles(t *testing.T, c db.DBClient) {
+	user := mustAddUser(t, c, models.User{Nickname: "manager", Roles: []models.Role{models.RolePollManager, models.RoleAuditor, models.RolePollManager}})
+	expected := []models.Role{models.RoleAuditor, models.RolePollManager}
+	if !reflect.DeepEqual(user.Roles, expected) || user.IsAdmin {
+		t.Errorf("AddUser returned roles %v, admin %v, expected %v", user.Roles, user.IsAdmin, expected)
+	}
+
+	got, err := c.GetUser("manager")
+	if err != nil {
+		t.Fatalf("GetUser: unexpected error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(got.Roles, expected) {
+		t.Errorf("GetUser returned roles %v, expected %v", got.Roles, expected)
+	}
+
+	// Granting the admin role also sets IsAdmin
+	got.Roles = []models.Role{models.RoleAdmin, models.RoleTeamEditor}
+	err = c.UpdateUser(got)
+	if err != nil {
+		t.Fatalf("UpdateUser: unexpected error: %s", err.Error())
+	}
+
+	got, err = c.GetUser("manager")
+	if err != nil {
+		t.Fatalf("GetUser: unexpected error: %s", err.Error())
+	}
+	expected = []models.Role{models.RoleAdmin, models.RoleTeamEditor}
+	if !reflect.DeepEqual(got.Roles, expected) || !got.IsAdmin {
+		t.Errorf("GetUser after update returned roles %v, admin %v, expected %v and admin", got.Roles, got.IsAdmin, expected)
+	}
+
+	mustAddUser(t, c, models.User{Nickname: "fan"})
+	users, err := c.GetUsers(nil, db.Sort{})
+	if err != nil {
+		t.Fatalf("GetUsers: unexpected error: %s", err.Error())
+	}
+	for _, u := range users {
+		switch u.Nickname {
+		case "manager":
+			if !reflect.DeepEqual(u.Roles, expected) {
+				t.Errorf("GetUsers returned roles %v for manager, expected %v", u.Roles, expected)
+			}
+		case "fan":
+			if len(u.Roles) != 0 {
+				t.Errorf("GetUsers returned roles %v for fan, expected none", u.Roles)
+			}
+		}
+	}
+}
+
+func testUserFilters(t *testing.T, c db.DBClient) {
+	mustAddUser(t, c, models.User{Nickname: "voter1", IsVoter: true})
+	mustAddUser(t, c, models.User{Nickname: "voter2", IsVoter: true})
+	mustAddUser(t, c, models.User{Nickname: "fan", IsVoter: false})
+
+	all, err := c.GetUsers(nil, db.Sort{})
+	if err != nil {
+		t.Fatalf("GetUsers: unexpected error: %s", err.Error())
+	}
+	if len(all) != 3 {
+		t.Errorf("GetUsers with no filter returned %d users, expected 3", len(all))
+	}
+
+	voters, err := c.GetUsers([]db.Filter{{Field: "is_voter", Operator: db.Eq, Value: true}}, db.Sort{})
+	if err != nil {
+		t.Fatalf("GetUsers: unexpected error: %s", err.Error())
+	}
+	if len(voters) != 2 {
+		t.Errorf("GetUsers filtered on is_voter returned %d users, expected 2", len(voters))
+	}
+	for _, v := range voters {
+		if !v.IsVoter {
+			t.Errorf("GetUsers filtered on is_voter returned non-voter %s", v.Nickname)
+		}
+	}
+
+	_, err = c.GetUsers([]db.Filter{{Field: "1=1 OR is_voter", Operator: db.Eq, Value: true}}, db.Sort{})
+	expectKind(t, err, errors.KindBadRequest, "GetUsers with unknown field")
+}
+
+func testUserEligibility(t *testing.T, c db.DBClient) {
+	const reason = "reddit account is suspended"
+	mustAddUser(t, c, models.User{Nickname: "throwaway", IneligibleReason: reason})
+	mustAddUser(t, c, models.User{Nickname: "regular"})
+
+	got, err := c.GetUser("throwaway")
+	if err != nil {
+		t.Fatalf("GetUser: unexpected error: %s", err.Error())
+	}
+	if got.IneligibleReason != reason {
+		t.Errorf("GetUser returned ineligible reason %q, expected %q", got.IneligibleReason, reason)
+	}
+
+	users, err := c.GetUsers(nil, db.Sort{})
+	if err != nil {
+		t.Fatalf("GetUsers: unexpected error: %s", err.Error())
+	}
+	for _, u := range users {
+		expected := ""
+		if u.Nickname == "throwaway" {
+			expected = reason
+		}
+		if u.IneligibleReason != expected {
+			t.Errorf("GetUsers returned ineligible reason %q for %s, expected %q", u.IneligibleReason, u.Nickname, expected)
+		}
+	}
+
+	// Passing the rules on a later login clears the reason
+	got.IneligibleReason = ""
+	err = c.UpdateUser(got)
+	if err != nil {
+		t.Fatalf("UpdateUser: unexpected error: %s", err.Error())
+	}
+	got, err = c.GetUser("throwaway")
+	if err != nil {
+		t.Fatalf("GetUser: unexpected error: %s", err.Error())
+	}
+	if !got.Eligible() {
+		t.Errorf("User still ineligible after update: %q", got.IneligibleReason)
+	}
+}
+
+func testFlairTeams(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+	mustAddUser(t, c, models.User{Nickname: "Concision", PrimaryTeam: teams[0].ID})
+	mustAddUser(t, c, models.User{Nickname: "einsteins_haircut", PrimaryTeam: teams[1].ID})
+
+	got, err := c.GetFlairTeams()
+	if err != nil {
+		t.Fatalf("GetFlairTeams: unexpected error: %s", err.Error())
+	}
+	if len(got) != 0 {
+		t.Errorf("Expected no flair teams before any are set, got %v", got)
+	}
+
+	for _, set := range []struct {
+		user string
+		team int64
+	}{
+		{"Concision", teams[0].ID},
+		{"einsteins_haircut", teams[1].ID},
+		// Setting it again replaces the team
+		{"Concision", teams[2].ID},
+		// And 0 forgets it
+		{"einsteins_haircut", 0},
+	} {
+		if err := c.SetFlairTeam(set.user, set.team); err != nil {
+			t.Fatalf("SetFlairTeam(%s, %d): unexpected error: %s", set.user, set.team, err.Error())
+		}
+	}
+
+	got, err = c.GetFlairTeams()
+	if err != nil {
+		t.Fatalf("GetFlairTeams: unexpected error: %s", err.Error())
+	}
+	expected := map[string]int64{"Concision": teams[2].ID}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("GetFlairTeams returned %v, expected %v", got, expected)
+	}
+}
+
+func testAnonymizeUser(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+	mustAddUser(t, c, models.User{
+		Nickname:         "Concision",
+		IsVoter:          true,
+		PrimaryTeam:      teams[0].ID,
+		Roles:            []models.Role{models.RoleAuditor},
+		IneligibleReason: "account too new",
+	})
+	mustAddUser(t, c, models.User{Nickname: "einsteins_haircut"})
+	poll := mustAddPoll(t, c, fixturePoll(2020, 1))
+	ballot, err := c.AddBallot(fixtureBallot("Concision", poll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+	openPoll := mustAddPoll(t, c, fixturePoll(2020, 2))
+	openBallot, err := c.AddBallot(fixtureBallot("Concision", openPoll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+	otherBallot, err := c.AddBallot(fixtureBallot("einsteins_haircut", openPoll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+	if err = c.SetFlairTeam("Concision", teams[0].ID); err != nil {
+		t.Fatalf("SetFlairTeam: unexpected error: %s", err.Error())
+	}
+	if err = c.AddRefreshToken(refreshTokenFixture("token", "Concision")); err != nil {
+		t.Fatalf("AddRefreshToken: unexpected error: %s", err.Error())
+	}
+
+	// Someone else's ballot can't be deleted along with the user, and trying
+	// changes nothing
+	err = c.AnonymizeUser("Concision", "[deleted-1]", []int64{openBallot.ID, otherBallot.ID})
+	expectKind(t, err, errors.KindNotFound, "AnonymizeUser deleting another user's ballot")
+	if _, err = c.GetUser("Concision"); err != nil {
+		t.Errorf("GetUser after failed AnonymizeUser: unexpected error: %s", err.Error())
+	}
+	if _, err = c.GetBallot(openBallot.ID); err != nil {
+		t.Errorf("GetBallot after failed AnonymizeUser: unexpected error: %s", err.Error())
+	}
+
+	err = c.AnonymizeUser("Concision", "[deleted-1]", []int64{openBallot.ID})
+	if err != nil {
+		t.Fatalf("AnonymizeUser: unexpected error: %s", err.Error())
+	}
+	_, err = c.GetBallot(openBallot.ID)
+	expectKind(t, err, errors.KindNotFound, "GetBallot for deleted ballot")
+
+	_, err = c.GetUser("Concision")
+	expectKind(t, err, errors.KindNotFound, "GetUser after AnonymizeUser")
+
+	alias, err := c.GetUser("[deleted-1]")
+	if err != nil {
+		t.Fatalf("GetUser: unexpected error: %s", err.Error())
+	}
+	if alias.IsVoter || alias.IsAdmin || alias.PrimaryTeam != 0 || len(alias.Roles) != 0 || !alias.Eligible() {
+		t.Errorf("AnonymizeUser kept details of the user: %v", alias)
+	}
+
+	// The ballot still counts, but no longer says who cast it or why
+	got, err := c.GetBallot(ballot.ID)
+	if err != nil {
+		t.Fatalf("GetBallot: unexpected error: %s", err.Error())
+	}
+	expected := fixtureBallot("[deleted-1]", poll, teams)
+	for i := range expected.Votes {
+		expected.Votes[i].Reason = ""
+	}
+	expectBallot(t, got, expected)
+
+	tokens, err := c.GetRefreshTokens("Concision")
+	if err != nil {
+		t.Fatalf("GetRefreshTokens: unexpected error: %s", err.Error())
+	}
+	flair, err := c.GetFlairTeams()
+	if err != nil {
+		t.Fatalf("GetFlairTeams: unexpected error: %s", err.Error())
+	}
+	if len(tokens) != 0 || len(flair) != 0 {
+		t.Errorf("AnonymizeUser left refresh tokens %v and flair %v", tokens, flair)
+	}
+
+	err = c.AnonymizeUser("nobody", "[deleted-2]", nil)
+	expectKind(t, err, errors.KindNotFound, "AnonymizeUser on missing user")
+
+	// Failing after the ballots are deleted rolls the deletion back
+	err = c.AnonymizeUser("einsteins_haircut", "[deleted-1]", []int64{otherBallot.ID})
+	expectKind(t, err, errors.KindConflict, "AnonymizeUser with alias in use")
+	if _, err = c.GetUser("einsteins_haircut"); err != nil {
+		t.Errorf("GetUser after failed AnonymizeUser: unexpected error: %s", err.Error())
+	}
+	got, err = c.GetBallot(otherBallot.ID)
+	if err != nil {
+		t.Fatalf("GetBallot after failed AnonymizeUser: unexpected error: %s", err.Error())
+	}
+	expectBallot(t, got, fixtureBallot("einsteins_haircut", openPoll, teams))
+
+	// The name is free for a new account
+	mustAddUser(t, c, models.User{Nickname: "Concision"})
+}
+
+func testAnonymizeUserRedactsAudit(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+	mustAddUser(t, c, models.User{Nickname: "Concision"})
+	poll := mustAddPoll(t, c, fixturePoll(2020, 1))
+	ballot, err := c.AddBallot(fixtureBallot("Concision", poll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+
+	// Concision as the actor, the target, and in the values of changes
+	addUser := auditFixture(models.AuditAddUser, models.AuditTargetUser, "voter1", baseTime)
+	addUser.After = json.RawMessage(`{"nickname": "voter1", "is_voter": true}`)
+	if _, err = c.WithAudit(addUser).AddUser(models.User{Nickname: "voter1", IsVoter: true}); err != nil {
+		t.Fatalf("AddUser: unexpected error: %s", err.Error())
+	}
+	updateUser := auditFixture(models.AuditUpdateUser, models.AuditTargetUser, "Concision", baseTime)
+	updateUser.Actor = "voter1"
+	updateUser.Before = json.RawMessage(`{"nickname": "Concision", "is_voter": false}`)
+	updateUser.After = json.RawMessage(`{"nickname": "Concision", "is_voter": true}`)
+	if err = c.WithAudit(updateUser).UpdateUser(models.User{Nickname: "Concision", IsVoter: true}); err != nil {
+		t.Fatalf("UpdateUser: unexpected error: %s", err.Error())
+	}
+	deleteBallot := auditFixture(models.AuditDeleteBallot, models.AuditTargetBallot, strconv.FormatInt(ballot.ID, 10), baseTime)
+	deleteBallot.Actor = "voter1"
+	deleteBallot.Before, err = json.Marshal(ballot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.WithAudit(deleteBallot).DeleteBallot(ballot.ID); err != nil {
+		t.Fatalf("DeleteBallot: unexpected error: %s", err.Error())
+	}
+
+	err = c.AnonymizeUser("Concision", "[deleted-1]", nil)
+	if err != nil {
+		t.Fatalf("AnonymizeUser: unexpected error: %s", err.Error())
+	}
+
+	entries, err := c.GetAuditLog(nil, db.Sort{})
+	if err != nil {
+		t.Fatalf("GetAuditLog: unexpected error: %s", err.Error())
+	}
+	if len(entries) != 3 {
+		t.Fatalf("GetAuditLog returned %d entries, expected 3: %v", len(entries), entries)
+	}
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "Concision") {
+			t.Errorf("Audit entry still names the deleted user: %s", b)
+		}
+	}
+
+	if e := entries[2]; e.Actor != "[deleted-1]" || e.TargetID != "voter1" || !jsonEqual(e.After, addUser.After) {
+		t.Errorf("Expected only the actor of %+v to change", e)
+	}
+	updated := entries[1]
+	if updated.Actor != "voter1" || updated.TargetID != "[deleted-1]" ||
+		!jsonEqual(updated.After, json.RawMessage(`{"nickname": "[deleted-1]", "is_voter": true}`)) {
+		t.Errorf("Expected the deleted user to be replaced as the target of %+v", updated)
+	}
+	var deleted models.Ballot
+	if err = json.Unmarshal(entries[0].Before, &deleted); err != nil {
+		t.Fatalf("Error decoding deleted ballot %s: %s", entries[0].Before, err.Error())
+	}
+	expected := fixtureBallot("[deleted-1]", poll, teams)
+	for i := range expected.Votes {
+		expected.Votes[i].Reason = ""
+	}
+	if deleted.User != expected.User || !reflect.DeepEqual(deleted.Votes, expected.Votes) {
+		t.Errorf("Expected the deleted ballot without its reasons, got %+v", deleted)
+	}
+}
+
+func testPolls(t *testing.T, c db.DBClient) {
+	poll := mustAddPoll(t, c, fixturePoll(2020, 1))
+
+	got, err := c.GetPoll(2020, 1)
+	if err != nil {
+		t.Fatalf("GetPoll: unexpected error: %s", err.Error())
+	}
+	if !pollsEqual(got, poll) {
+		t.Errorf("GetPoll returned %v, expected %v", got, poll)
+	}
+
+	_, err = c.AddPoll(fixturePoll(2020, 1))
+	expectKind(t, err, errors.KindConflict, "AddPoll for existing week")
+
+	_, err = c.GetPoll(2020, 2)
+	expectKind(t, err, errors.KindNotFound, "GetPoll on missing week")
+
+	got.WeekName = "Preseason"
+	got.CloseTime = got.CloseTime.Add(time.Hour)
+	err = c.UpdatePoll(got)
+	if err != nil {
+		t.Fatalf("UpdatePoll: unexpected error: %s", err.Error())
+	}
+
+	updated := mustGetPoll(t, c, 2020, 1)
+	if updated.WeekName != "Preseason" || !updated.CloseTime.Equal(got.CloseTime) {
+		t.Errorf("UpdatePoll didn't persist changes, got %v", updated)
+	}
+	if !updated.LastModified.After(poll.LastModified) {
+		t.Errorf("UpdatePoll didn't bump last_modified")
+	}
+
+	err = c.UpdatePoll(fixturePoll(2020, 2))
+	expectKind(t, err, errors.KindNotFound, "UpdatePoll on missing week")
+}
+
+func testPollFilters(t *testing.T, c db.DBClient) {
+	open := fixturePoll(2020, 1)
+	future := fixturePoll(2020, 2)
+	future.OpenTime = baseTime.Add(48 * time.Hour)
+	future.CloseTime = baseTime.Add(72 * time.Hour)
+	mustAddPoll(t, c, open)
+	mustAddPoll(t, c, future)
+
+	polls, err := c.GetPolls([]db.Filter{{Field: "open_time", Operator: db.Lt, Value: baseTime}}, db.Sort{})
+	if err != nil {
+		t.Fatalf("GetPolls: unexpected error: %s", err.Error())
+	}
+	if len(polls) != 1 || polls[0].Week != 1 {
+		t.Errorf("GetPolls filtered on open_time returned %v, expected only week 1", polls)
+	}
+
+	_, err = c.GetPolls([]db.Filter{{Field: "season", Operator: "LIKE", Value: 2020}}, db.Sort{})
+	expectKind(t, err, errors.KindBadRequest, "GetPolls with unknown operator")
+}
+
+func testBallots(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+	mustAddUser(t, c, models.User{Nickname: "voter1", IsVoter: true})
+	mustAddUser(t, c, models.User{Nickname: "voter2", IsVoter: true})
+	poll := mustAddPoll(t, c, fixturePoll(2020, 1))
+
+	ballot, err := c.AddBallot(fixtureBallot("voter1", poll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+	if ballot.ID == 0 {
+		t.Errorf("AddBallot didn't assign an ID")
+	}
+
+	got, err := c.GetBallot(ballot.ID)
+	if err != nil {
+		t.Fatalf("GetBallot: unexpected error: %s", err.Error())
+	}
+	expectBallot(t, got, fixtureBallot("voter1", poll, teams))
+
+	_, err = c.AddBallot(fixtureBallot("voter1", poll, teams))
+	expectKind(t, err, errors.KindConflict, "AddBallot for user with existing ballot")
+
+	_, err = c.GetBallot(9999)
+	expectKind(t, err, errors.KindNotFound, "GetBallot on missing ballot")
+
+	second := fixtureBallot("voter2", poll, teams)
+	second.ID = 500
+	added, err := c.AddBallot(second)
+	if err != nil {
+		t.Fatalf("AddBallot with explicit ID: unexpected error: %s", err.Error())
+	}
+	if added.ID != 500 {
+		t.Errorf("AddBallot with explicit ID assigned %d, expected 500", added.ID)
+	}
+
+	ballots, err := c.GetBallotsByPoll(poll)
+	if err != nil {
+		t.Fatalf("GetBallotsByPoll: unexpected error: %s", err.Error())
+	}
+	if len(ballots) != 2 {
+		t.Errorf("GetBallotsByPoll returned %d ballots, expected 2", len(ballots))
+	}
+
+	other, err := c.GetBallotsByPoll(fixturePoll(2020, 2))
+	if err != nil {
+		t.Fatalf("GetBallotsByPoll: unexpected error: %s", err.Error())
+	}
+	if len(other) != 0 {
+		t.Errorf("GetBallotsByPoll for empty poll returned %d ballots", len(other))
+	}
+
+	earlier := mustAddPoll(t, c, fixturePoll(2019, 5))
+	if _, err = c.AddBallot(fixtureBallot("voter1", earlier, teams)); err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+	mine, err := c.GetBallotsByUser("voter1")
+	if err != nil {
+		t.Fatalf("GetBallotsByUser: unexpected error: %s", err.Error())
+	}
+	if len(mine) != 2 || mine[0].PollSeason != 2019 || mine[1].PollSeason != 2020 {
+		t.Errorf("GetBallotsByUser returned %v, expected voter1's 2019 and 2020 ballots in order", mine)
+	} else {
+		expectBallot(t, mine[1], fixtureBallot("voter1", poll, teams))
+	}
+
+	none, err := c.GetBallotsByUser("nobody")
+	if err != nil {
+		t.Fatalf("GetBallotsByUser: unexpected error: %s", err.Error())
+	}
+	if len(none) != 0 {
+		t.Errorf("GetBallotsByUser for user without ballots returned %d ballots", len(none))
+	}
+}
+
+func testUpdateBallot(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+	mustAddUser(t, c, models.User{Nickname: "voter1", IsVoter: true})
+	poll := mustAddPoll(t, c, fixturePoll(2020, 1))
+
+	ballot, err := c.AddBallot(fixtureBallot("voter1", poll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+
+	// Reverse the order of the ballot
+	updated := fixtureBallot("voter1", poll, []models.Team{teams[2], teams[1], teams[0]})
+	updated.ID = ballot.ID
+	err = c.UpdateBallot(updated)
+	if err != nil {
+		t.Fatalf("UpdateBallot: unexpected error: %s", err.Error())
+	}
+
+	got, err := c.GetBallot(ballot.ID)
+	if err != nil {
+		t.Fatalf("GetBallot: unexpected error: %s", err.Error())
+	}
+	expectBallot(t, got, updated)
+
+	missing := updated
+	missing.ID = 9999
+	err = c.UpdateBallot(missing)
+	expectKind(t, err, errors.KindNotFound, "UpdateBallot on missing ballot")
+}
+
+func testDeleteBallot(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+	mustAddUser(t, c, models.User{Nickname: "voter1", IsVoter: true})
+	poll := mustAddPoll(t, c, fixturePoll(2020, 1))
+
+	ballot, err := c.AddBallot(fixtureBallot("voter1", poll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+
+	err = c.DeleteBallot(ballot.ID)
+	if err != nil {
+		t.Fatalf("DeleteBallot: unexpected error: %s", err.Error())
+	}
+
+	_, err = c.GetBallot(ballot.ID)
+	expectKind(t, err, errors.KindNotFound, "GetBallot after delete")
+
+	err = c.DeleteBallot(ballot.ID)
+	expectKind(t, err, errors.KindNotFound, "DeleteBallot on missing ballot")
+
+	// The user's slot for the poll is free again
+	_, err = c.AddBallot(fixtureBallot("voter1", poll, teams))
+	if err != nil {
+		t.Errorf("AddBallot after delete: unexpected error: %s", err.Error())
+	}
+}
+
+func testBallotConstraints(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+	mustAddUser(t, c, models.User{Nickname: "voter1", IsVoter: true})
+	poll := mustAddPoll(t, c, fixturePoll(2020, 1))
+
+	tests := []struct {
+		name   string
+		modify func(b *models.Ballot)
+		kind   errors.Code
+	}{
+		{"duplicate rank", func(b *models.Ballot) { b.Votes[1].Rank = 1 }, errors.KindConflict},
+		{"duplicate team", func(b *models.Ballot) { b.Votes[1].TeamID = teams[0].ID }, errors.KindConflict},
+		{"rank too low", func(b *models.Ballot) { b.Votes[0].Rank = 0 }, errors.KindBadRequest},
+		{"rank too high", func(b *models.Ballot) { b.Votes[0].Rank = 26 }, errors.KindBadRequest},
+		{"unknown team", func(b *models.Ballot) { b.Votes[0].TeamID = 9999 }, errors.KindBadRequest},
+		{"unknown user", func(b *models.Ballot) { b.User = "nobody" }, errors.KindBadRequest},
+		{"unknown poll", func(b *models.Ballot) { b.PollWeek = 9 }, errors.KindBadRequest},
+	}
+
+	for _, test := range tests {
+		b := fixtureBallot("voter1", poll, teams)
+		test.modify(&b)
+		_, err := c.AddBallot(b)
+		expectKind(t, err, test.kind, "AddBallot with "+test.name)
+	}
+
+	// None of the rejected ballots were partially written
+	ballots, err := c.GetBallotsByPoll(poll)
+	if err != nil {
+		t.Fatalf("GetBallotsByPoll: unexpected error: %s", err.Error())
+	}
+	if len(ballots) != 0 {
+		t.Errorf("Rejected ballots left %d ballots behind", len(ballots))
+	}
+
+	ballot, err := c.AddBallot(fixtureBallot("voter1", poll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+
+	// A failed update leaves the original ballot in place
+	bad := fixtureBallot("voter1", poll, teams)
+	bad.ID = ballot.ID
+	bad.Votes[2].Rank = 1
+	err = c.UpdateBallot(bad)
+	expectKind(t, err, errors.KindConflict, "UpdateBallot with duplicate rank")
+
+	got, err := c.GetBallot(ballot.ID)
+	if err != nil {
+		t.Fatalf("GetBallot: unexpected error: %s", err.Error())
+	}
+	expectBallot(t, got, fixtureBallot("voter1", poll, teams))
+}
+
+func testResults(t *testing.T, c db.DBClient) {
+	mustAddPoll(t, c, fixturePoll(2020, 1))
+	poll := mustGetPoll(t, c, 2020, 1)
+
+	official := []models.Result{
+		{TeamID: 1, TeamName: "Arizona", TeamSlug: "arizona", Rank: 1, FirstPlaceVotes: 1, Points: 25},
+	}
+	all := []models.Result{
+		{TeamID: 1, TeamName: "Arizona", TeamSlug: "arizona", Rank: 1, FirstPlaceVotes: 1, Points: 49},
+		{TeamID: 2, TeamName: "Duke", TeamSlug: "duke", Rank: 2, FirstPlaceVotes: 1, Points: 25},
+	}
+
+	err := c.SetResults(poll, official, all)
+	if err != nil {
+		t.Fatalf("SetResults: unexpected error: %s", err.Error())
+	}
+
+	got, err := c.GetResults(poll, false)
+	if err != nil {
+		t.Fatalf("GetResults: unexpected error: %s", err.Error())
+	}
+	if len(got) != 1 || got[0] != official[0] {
+		t.Errorf("GetResults (official) returned %v, expected %v", got, official)
+	}
+
+	got, err = c.GetResults(poll, true)
+	if err != nil {
+		t.Fatalf("GetResults: unexpected error: %s", err.Error())
+	}
+	if len(got) != 3 {
+		t.Errorf("GetResults (provisional) returned %d results, expected 3", len(got))
+	}
+
+	stale := poll
+	stale.LastModified = poll.LastModified.Add(-time.Hour)
+	err = c.SetResults(stale, official, all)
+	expectKind(t, err, errors.KindConcurrencyProblem, "SetResults with stale poll")
+
+	err = c.SetResults(fixturePoll(2020, 2), official, all)
+	expectKind(t, err, errors.KindNotFound, "SetResults on missing poll")
+}
+
+func testResultInvalidation(t *testing.T, c db.DBClient) {
+	teams := mustAddTeams(t, c)
+	mustAddUser(t, c, models.User{Nickname: "voter1", IsVoter: true})
+	mustAddUser(t, c, models.User{Nickname: "voter2", IsVoter: true})
+	mustAddPoll(t, c, fixturePoll(2020, 1))
+	poll := mustGetPoll(t, c, 2020, 1)
+
+	official := []models.Result{{TeamID: teams[0].ID, TeamName: "Arizona", Rank: 1, FirstPlaceVotes: 1, Points: 25}}
+	setResults := func() {
+		t.Helper()
+		if err := c.SetResults(poll, official, official); err != nil {
+			t.Fatalf("SetResults: unexpected error: %s", err.Error())
+		}
+	}
+	expectNoResults := func(after string) {
+		t.Helper()
+		rs, err := c.GetResults(poll, true)
+		if err != nil {
+			t.Fatalf("GetResults: unexpected error: %s", err.Error())
+		}
+		if len(rs) != 0 {
+			t.Errorf("results not invalidated after %s, found %d", after, len(rs))
+		}
+	}
+
+	setResults()
+	ballot, err := c.AddBallot(fixtureBallot("voter1", poll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+	expectNoResults("AddBallot")
+
+	setResults()
+	err = c.UpdateBallot(ballot)
+	if err != nil {
+		t.Fatalf("UpdateBallot: unexpected error: %s", err.Error())
+	}
+	expectNoResults("UpdateBallot")
+
+	setResults()
+	err = c.DeleteBallot(ballot.ID)
+	if err != nil {
+		t.Fatalf("DeleteBallot: unexpected error: %s", err.Error())
+	}
+	expectNoResults("DeleteBallot")
+
+	// A failed write must leave the existing results alone
+	setResults()
+	_, err = c.AddBallot(fixtureBallot("voter2", poll, teams))
+	if err != nil {
+		t.Fatalf("AddBallot: unexpected error: %s", err.Error())
+	}
+	setResults()
+	_, err = c.AddBallot(fixtureBallot("voter2", poll, teams))
+	expectKind(t, err, errors.KindConflict, "AddBallot for user with existing ballot")
+	rs, err := c.GetResults(poll, true)
+	if err != nil {
+		t.Fatalf("GetResults: unexpected error: %s", err.Error())
+	}
+	if len(rs) != 2 {
+		t.Errorf("failed AddBallot invalidated results")
+	}
+}
+
+func pollsEqual(a, b models.Poll) bool {
+	return a.Season == b.Season &&
+		a.Week == b.Week &&
+		a.WeekName == b.WeekName &&
+		a.OpenTime.Equal(b.OpenTime) &&
+		a.CloseTime.Equal(b.CloseTime) &&
+		a.LastModified.Equal(b.LastModified) &&
+		a.RedditURL == b.RedditURL
+}
+
+func expectBallot(t *testing.T, got, expected models.Ballot) {
+	t.Helper()
+	if got.PollSeason != expected.PollSeason || got.PollWeek != expected.PollWeek ||
+		got.User != expected.User || got.IsOfficial != expected.IsOfficial ||
+		!got.UpdatedTime.Equal(expected.UpdatedTime) {
+		t.Errorf("ballot returned %v, expected %v", got, expected)
+	}
+
+	votes := append([]models.Vote(nil), got.Votes...)
+	sort.Slice(votes, func(i, j int) bool { return votes[i].Rank < votes[j].Rank })
+	if len(votes) != len(expected.Votes) {
+		t.Errorf("ballot has %d votes, expected %d", len(votes), len(expected.Votes))
+		return
+	}
+	for i := range votes {
+		if votes[i] != expected.Votes[i] {
+			t.Errorf("vote %d is %v, expected %v", i, votes[i], expected.Votes[i])
+		}
+	}
+}
+
+func refreshTokenFixture(hash string, user string) models.RefreshToken {
+	return models.RefreshToken{
+		Hash:      hash,
+		User:      user,
+		IssuedAt:  baseTime,
+		ExpiresAt: baseTime.Add(24 * time.Hour),
+	}
+}
+
+func testRefreshTokens(t *testing.T, c db.DBClient) {
+	mustAddUser(t, c, models.User{Nickname: "Concision"})
+	first := refreshTokenFixture("first", "Concision")
+
+	err := c.AddRefreshToken(first)
+	if err != nil {
+		t.Fatalf("AddRefreshToken: unexpected error: %s", err.Error())
+	}
+
+	got, err := c.GetRefreshToken("first")
+	if err != nil {
+		t.Fatalf("GetRefreshToken: unexpected error: %s", err.Error())
+	}
+	if got.User != first.User || !got.IssuedAt.Equal(first.IssuedAt) || !got.ExpiresAt.Equal(first.ExpiresAt) || got.Revoked {
+		t.Errorf("GetRefreshToken returned %v, expected %v", got, first)
+	}
+
+	_, err = c.GetRefreshToken("missing")
+	expectKind(t, err, errors.KindNotFound, "GetRefreshToken on missing token")
+
+	err = c.AddRefreshToken(refreshTokenFixture("orphan", "nobody"))
+	expectKind(t, err, errors.KindBadRequest, "AddRefreshToken for missing user")
+
+	err = c.RotateRefreshToken("first", refreshTokenFixture("second", "Concision"))
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: unexpected error: %s", err.Error())
+	}
+
+	got, err = c.GetRefreshToken("first")
+	if err != nil {
+		t.Fatalf("GetRefreshToken: unexpected error: %s", err.Error())
+	}
+	if !got.Revoked {
+		t.Errorf("RotateRefreshToken didn't revoke the old token")
+	}
+
+	// A token can only be rotated once
+	err = c.RotateRefreshToken("first", refreshTokenFixture("third", "Concision"))
+	expectKind(t, err, errors.KindConflict, "RotateRefreshToken on revoked token")
+	_, err = c.GetRefreshToken("third")
+	expectKind(t, err, errors.KindNotFound, "GetRefreshToken after failed rotation")
+
+	err = c.AddRefreshToken(refreshTokenFixture("other", "Concision"))
+	if err != nil {
+		t.Fatalf("AddRefreshToken: unexpected error: %s", err.Error())
+	}
+
+	err = c.RevokeRefreshToken("second")
+	if err != nil {
+		t.Fatalf("RevokeRefreshToken: unexpected error: %s", err.Error())
+	}
+	got, _ = c.GetRefreshToken("second")
+	if !got.Revoked {
+		t.Errorf("RevokeRefreshToken didn't revoke the token")
+	}
+	got, _ = c.GetRefreshToken("other")
+	if got.Revoked {
+		t.Errorf("RevokeRefreshToken revoked another token")
+	}
+
+	err = c.RevokeRefreshTokens("Concision")
+	if err != nil {
+		t.Fatalf("RevokeRefreshTokens: unexpected error: %s", err.Error())
+	}
+	got, _ = c.GetRefreshToken("other")
+	if !got.Revoked {
+		t.Errorf("RevokeRefreshTokens didn't revoke all of the user's tokens")
+	}
+
+	tokens, err := c.GetRefreshTokens("Concision")
+	if err != nil {
+		t.Fatalf("GetRefreshTokens: unexpected error: %s", err.Error())
+	}
+	hashes := make([]string, len(tokens))
+	for i, token := range tokens {
+		hashes[i] = token.Hash
+	}
+	sort.Strings(hashes)
+	if !reflect.DeepEqual(hashes, []string{"first", "other", "second"}) {
+		t.Errorf("GetRefreshTokens returned %v, expected first, other and second", hashes)
+	}
+
+	tokens, err = c.GetRefreshTokens("nobody")
+	if err != nil {
+		t.Fatalf("GetRefreshTokens: unexpected error: %s", err.Error())
+	}
+	if len(tokens) != 0 {
+		t.Errorf("GetRefreshTokens for user without tokens returned %v", tokens)
+	}
+}
+
+func testRevokedAccessTokens(t *testing.T, c db.DBClient) {
+	revoked, err := c.IsAccessTokenRevoked("abc")
+	if err != nil {
+		t.Fatalf("IsAccessTokenRevoked: unexpected error: %s", err.Error())
+	}
+	if revoked {
+		t.Errorf("IsAccessTokenRevoked reported a token revoked before it was")
+	}
+
+	for i := 0; i < 2; i++ {
+		err = c.RevokeAccessToken("abc", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("RevokeAccessToken: unexpected error: %s", err.Error())
+		}
+	}
+
+	revoked, err = c.IsAccessTokenRevoked("abc")
+	if err != nil {
+		t.Fatalf("IsAccessTokenRevoked: unexpected error: %s", err.Error())
+	}
+	if !revoked {
+		t.Errorf("IsAccessTokenRevoked didn't report a revoked token")
+	}
+
+	revoked, _ = c.IsAccessTokenRevoked("def")
+	if revoked {
+		t.Errorf("IsAccessTokenRevoked reported the wrong token as revoked")
+	}
+}
+
+func auditFixture(action models.AuditAction, targetType string, targetID string, at time.Time) models.AuditEntry {
+	return models.AuditEntry{
+		Time:       at,
+		Actor:      "Concision",
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RequestID:  "req-" + string(action),
+	}
+}
+
+// jsonEqual compares JSON documents by value, since backends may store them
+// reformatted.
+func jsonEqual(a, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func testAuditLog(t *testing.T, c db.DBClient) {
+	addTeam := auditFixture(models.AuditAddTeam, models.AuditTargetTeam, "", baseTime)
+	team, err := c.WithAudit(addTeam).AddTeam(fixtureTeams[0])
+	if err != nil {
+		t.Fatalf("AddTeam: unexpected error: %s", err.Error())
+	}
+
+	addUser := auditFixture(models.AuditAddUser, models.AuditTargetUser, "voter1", baseTime.Add(time.Minute))
+	addUser.After = json.RawMessage(`{"nickname": "voter1", "is_voter": true}`)
+	_, err = c.WithAudit(addUser).AddUser(models.User{Nickname: "voter1", IsVoter: true})
+	if err != nil {
+		t.Fatalf("AddUser: unexpected error: %s", err.Error())
+	}
+
+	// Nothing is recorded for a change that fails, or one made without an entry
+	_, err = c.WithAudit(addUser).AddUser(models.User{Nickname: "voter1"})
+	expectKind(t, err, errors.KindConflict, "audited AddUser with existing nickname")
+	err = c.WithAudit(auditFixture(models.AuditDeleteBallot, models.AuditTargetBallot, "1", baseTime)).DeleteBallot(1)
+	expectKind(t, err, errors.KindNotFound, "audited DeleteBallot on missing ballot")
+	mustAddUser(t, c, models.User{Nickname: "voter2"})
+
+	updateUser := auditFixture(models.AuditUpdateUser, models.AuditTargetUser, "voter1", baseTime.Add(2*time.Minute))
+	updateUser.Before = addUser.After
+	updateUser.After = json.RawMessage(`{"nickname": "voter1", "is_voter": false}`)
+	updateUser.RequestID = ""
+	err = c.WithAudit(updateUser).UpdateUser(models.User{Nickname: "voter1"})
+	if err != nil {
+		t.Fatalf("UpdateUser: unexpected error: %s", err.Error())
+	}
+
+	entries, err := c.GetAuditLog(nil, db.Sort{})
+	if err != nil {
+		t.Fatalf("GetAuditLog: unexpected error: %s", err.Error())
+	}
+	if len(entries) != 3 {
+		t.Fatalf("GetAuditLog returned %d entries, expected 3: %v", len(entries), entries)
+	}
+
+	addTeam.TargetID = "1"
+	if team.ID != 1 {
+		t.Fatalf("AddTeam: expected the first team to have ID 1, got %d", team.ID)
+	}
+	expected := []models.AuditEntry{updateUser, addUser, addTeam}
+	for i, e := range entries {
+		if i > 0 && e.ID >= entries[i-1].ID {
+			t.Errorf("GetAuditLog returned entry %d before entry %d", entries[i-1].ID, e.ID)
+		}
+		x := expected[i]
+		if !e.Time.Equal(x.Time) || e.Actor != x.Actor || e.Action != x.Action || e.TargetType != x.TargetType ||
+			e.TargetID != x.TargetID || e.RequestID != x.RequestID || (x.Before != nil && !jsonEqual(e.Before, x.Before)) {
+			t.Errorf("GetAuditLog entry %d is %+v, expected %+v", i, e, x)
+		}
+		if x.After != nil && !jsonEqual(e.After, x.After) {
+			t.Errorf("GetAuditLog entry %d has after %s, expected %s", i, e.After, x.After)
+		}
+	}
+	if entries[2].Before != nil {
+		t.Errorf("GetAuditLog returned a before %s for the new team", entries[2].Before)
+	}
+	var added models.Team
+	if err = json.Unmarshal(entries[2].After, &added); err != nil || added != team {
+		t.Errorf("Expected the added team %v as the after of its entry, got %s", team, entries[2].After)
+	}
+
+	filters := []struct {
+		filter   []db.Filter
+		expected int
+	}{
+		{[]db.Filter{{Field: "target_type", Operator: db.Eq, Value: models.AuditTargetUser}}, 2},
+		{[]db.Filter{{Field: "action", Operator: db.Eq, Value: string(models.AuditAddTeam)}}, 1},
+		{[]db.Filter{{Field: "time", Operator: db.Gte, Value: baseTime.Add(time.Minute)}, {Field: "target_id", Operator: db.Eq, Value: "voter1"}}, 2},
+		{[]db.Filter{{Field: "actor", Operator: db.Eq, Value: "voter1"}}, 0},
+	}
+	for _, f := range filters {
+		got, err := c.GetAuditLog(f.filter, db.Sort{})
+		if err != nil {
+			t.Fatalf("GetAuditLog: unexpected error: %s", err.Error())
+		}
+		if len(got) != f.expected {
+			t.Errorf("GetAuditLog filtered on %v returned %d entries, expected %d", f.filter, len(got), f.expected)
+		}
+	}
+
+	_, err = c.GetAuditLog([]db.Filter{{Field: "before", Operator: db.Eq, Value: ""}}, db.Sort{})
+	expectKind(t, err, errors.KindBadRequest, "GetAuditLog with unknown field")
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+)
+
+// Operator is a comparison that can be applied to a filterable field.  Only the
+// constants below are accepted; anything else is rejected during validation.
+type Operator string
+
+const (
+	Eq  Operator = "="
+	Neq Operator = "!="
+	Lt  Operator = "<"
+	Lte Operator = "<="
+	Gt  Operator = ">"
+	Gte Operator = ">="
+)
+
+type FieldType int
+
+const (
+	BoolField FieldType = iota
+	IntField
+	StringField
+	TimeField
+)
+
+func (t FieldType) String() string {
+	switch t {
+	case BoolField:
+		return "bool"
+	case IntField:
+		return "int"
+	case StringField:
+		return "string"
+	case TimeField:
+		return "time"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	equality   = []Operator{Eq, Neq}
+	comparison = []Operator{Eq, Neq, Lt, Lte, Gt, Gte}
+)
+
+// Field describes a single filterable attribute of a model: the column it is
+// stored in, the type of value it can be compared against, and the operators
+// that make sense for it.
+type Field struct {
+	Column    string
+	Type      FieldType
+	Operators []Operator
+}
+
+func (f Field) allows(op Operator) bool {
+	for _, o := range f.Operators {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Schema is the registry of filterable fields for a model, keyed by the name
+// callers use in a Filter.
+type Schema map[string]Field
+
+var UserFields = Schema{
+	"nickname":     {Column: "nickname", Type: StringField, Operators: equality},
+	"is_admin":     {Column: "is_admin", Type: BoolField, Operators: equality},
+	"is_voter":     {Column: "is_voter", Type: BoolField, Operators: equality},
+	"primary_team": {Column: "primary_team", Type: IntField, Operators: equality},
+}
+
+var PollFields = Schema{
+	"season":        {Column: "season", Type: IntField, Operators: comparison},
+	"week":          {Column: "week", Type: IntField, Operators: comparison},
+	"open_time":     {Column: "open_time", Type: TimeField, Operators: comparison},
+	"close_time":    {Column: "close_time", Type: TimeField, Operators: comparison},
+	"last_modified": {Column: "last_modified", Type: TimeField, Operators: comparison},
+}
+
+var AuditFields = Schema{
+	"id":          {Column: "id", Type: IntField, Operators: comparison},
+	"time":        {Column: "time", Type: TimeField, Operators: comparison},
+	"actor":       {Column: "actor", Type: StringField, Operators: equality},
+	"action":      {Column: "action", Type: StringField, Operators: equality},
+	"target_type": {Column: "target_type", Type: StringField, Operators: equality},
+	"target_id":   {Column: "target_id", Type: StringField, Operators: equality},
+	"request_id":  {Column: "request_id", Type: StringField, Operators: equality},
+}
+
+// Filter restricts the rows returned by a DBClient query.  Field and Operator
+// must be known to the Schema of the model being queried; Value must match the
+// Field's type.  Filters are validated by every DBClient implementation, so
+// they're safe to build from user input.
+type Filter struct {
+	Field    string
+	Operator Operator
+	Value    interface{}
+}
+
+type Sort struct {
+	field string
+	asc   bool
+}
+
+// Validate checks every filter against the schema, returning a KindBadRequest
+// error describing the first problem found.
+func (s Schema) Validate(filters []Filter) error {
+	const op errors.Op = "db.Validate"
+
+	for _, f := range filters {
+		field, ok := s[f.Field]
+		if !ok {
+			return errors.E(op, errors.KindBadRequest, fmt.Sprintf("unknown filter field '%s'", f.Field))
+		}
+
+		if !field.allows(f.Operator) {
+			return errors.E(op, errors.KindBadRequest, fmt.Sprintf("operator '%s' not allowed on field '%s'", f.Operator, f.Field))
+		}
+
+		if !field.Type.accepts(f.Value) {
+			return errors.E(op, errors.KindBadRequest, fmt.Sprintf("field '%s' requires a %s value, got %T", f.Field, field.Type, f.Value))
+		}
+	}
+
+	return nil
+}
+
+// Where validates the filters and renders them as a SQL WHERE clause using
+// bindvar (?) placeholders.  Only column names and operators from the schema
+// are interpolated; values are always returned as args.  An empty filter list
+// renders an empty clause.
+func (s Schema) Where(filters []Filter) (string, []interface{}, error) {
+	const op errors.Op = "db.Where"
+
+	if err := s.Validate(filters); err != nil {
+		return "", nil, errors.E(op, err)
+	}
+
+	if len(filters) == 0 {
+		return "", nil, nil
+	}
+
+	conds := make([]string, len(filters))
+	args := make([]interface{}, len(filters))
+	for i, f := range filters {
+		conds[i] = fmt.Sprintf("%s %s ?", s[f.Field].Column, f.Operator)
+		args[i] = f.Value
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+// Match validates the filters and reports whether a record satisfies all of
+// them.  values maps each field name in the schema to the record's value for it;
+// it's meant for DBClient implementations that can't push filters down to SQL.
+func (s Schema) Match(filters []Filter, values map[string]interface{}) (bool, error) {
+	const op errors.Op = "db.Match"
+
+	if err := s.Validate(filters); err != nil {
+		return false, errors.E(op, err)
+	}
+
+	for _, f := range filters {
+		c, ok := compare(values[f.Field], f.Value)
+		if !ok {
+			return false, errors.E(op, fmt.Sprintf("no comparable value for field '%s'", f.Field))
+		}
+
+		var match bool
+		switch f.Operator {
+		case Eq:
+			match = c == 0
+		case Neq:
+			match = c != 0
+		case Lt:
+			match = c < 0
+		case Lte:
+			match = c <= 0
+		case Gt:
+			match = c > 0
+		case Gte:
+			match = c >= 0
+		}
+
+		if !match {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// compare returns -1, 0 or 1 as a is less than, equal to or greater than b.
+// The bool result is false if the values can't be compared.
+func compare(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case bool:
+		b, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		if a == b {
+			return 0, true
+		}
+		if !a {
+			return -1, true
+		}
+		return 1, true
+	case int, int64:
+		if !isInt(b) {
+			return 0, false
+		}
+		x, y := toInt64(a), toInt64(b)
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	case time.Time:
+		b, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a.Before(b):
+			return -1, true
+		case a.After(b):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+func isInt(v interface{}) bool {
+	switch v.(type) {
+	case int, int64:
+		return true
+	}
+	return false
+}
+
+func toInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
+
+func (t FieldType) accepts(v interface{}) bool {
+	switch v.(type) {
+	case bool:
+		return t == BoolField
+	case int, int64:
+		return t == IntField
+	case string:
+		return t == StringField
+	case time.Time:
+		return t == TimeField
+	default:
+		return false
+	}
+}
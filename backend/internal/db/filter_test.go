@@ -0,0 +1,170 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  Schema
+		filters []Filter
+		valid   bool
+	}{
+		{
+			name:    "No filters",
+			schema:  UserFields,
+			filters: nil,
+			valid:   true,
+		},
+		{
+			name:    "Known field",
+			schema:  UserFields,
+			filters: []Filter{{Field: "is_voter", Operator: Eq, Value: true}},
+			valid:   true,
+		},
+		{
+			name:    "Unknown field",
+			schema:  UserFields,
+			filters: []Filter{{Field: "is_voter; DROP TABLE user; --", Operator: Eq, Value: true}},
+			valid:   false,
+		},
+		{
+			name:    "Field from another model",
+			schema:  UserFields,
+			filters: []Filter{{Field: "open_time", Operator: Lt, Value: time.Now()}},
+			valid:   false,
+		},
+		{
+			name:    "Unknown operator",
+			schema:  PollFields,
+			filters: []Filter{{Field: "season", Operator: "= 1 OR 1 =", Value: 2020}},
+			valid:   false,
+		},
+		{
+			name:    "Operator not allowed on field",
+			schema:  UserFields,
+			filters: []Filter{{Field: "is_admin", Operator: Gt, Value: false}},
+			valid:   false,
+		},
+		{
+			name:    "Wrong value type",
+			schema:  PollFields,
+			filters: []Filter{{Field: "open_time", Operator: Lt, Value: "yesterday"}},
+			valid:   false,
+		},
+		{
+			name:   "Multiple filters, one bad",
+			schema: PollFields,
+			filters: []Filter{
+				{Field: "season", Operator: Eq, Value: 2020},
+				{Field: "week", Operator: Gte, Value: int64(3)},
+				{Field: "close_time", Operator: Gt, Value: 12},
+			},
+			valid: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.schema.Validate(test.filters)
+			if test.valid && err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+
+			if !test.valid {
+				if err == nil {
+					t.Errorf("Expected filters to be rejected")
+					return
+				}
+				if errors.Kind(err) != errors.KindBadRequest {
+					t.Errorf("Expected KindBadRequest, got %v", errors.Kind(err))
+				}
+			}
+		})
+	}
+}
+
+func TestWhere(t *testing.T) {
+	now := time.Now()
+	where, args, err := PollFields.Where([]Filter{
+		{Field: "season", Operator: Eq, Value: 2020},
+		{Field: "open_time", Operator: Lt, Value: now},
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		return
+	}
+
+	expected := " WHERE season = ? AND open_time < ?"
+	if where != expected {
+		t.Errorf("Expected clause %q, got %q", expected, where)
+	}
+
+	if !reflect.DeepEqual(args, []interface{}{2020, now}) {
+		t.Errorf("Unexpected args: %v", args)
+	}
+
+	where, args, err = PollFields.Where(nil)
+	if err != nil || where != "" || len(args) != 0 {
+		t.Errorf("Expected empty clause for no filters, got %q %v %v", where, args, err)
+	}
+
+	_, _, err = UserFields.Where([]Filter{{Field: "bogus", Operator: Eq, Value: 1}})
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest for unknown field, got %v", errors.Kind(err))
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Now()
+	values := map[string]interface{}{
+		"season":     2020,
+		"week":       3,
+		"open_time":  now.Add(-time.Hour),
+		"close_time": now.Add(time.Hour),
+	}
+
+	tests := []struct {
+		name    string
+		filters []Filter
+		match   bool
+	}{
+		{"No filters", nil, true},
+		{"Equal", []Filter{{Field: "season", Operator: Eq, Value: 2020}}, true},
+		{"Not equal", []Filter{{Field: "season", Operator: Neq, Value: 2020}}, false},
+		{"Mixed int types", []Filter{{Field: "week", Operator: Gte, Value: int64(3)}}, true},
+		{"Time before", []Filter{{Field: "open_time", Operator: Lt, Value: now}}, true},
+		{"Time after", []Filter{{Field: "close_time", Operator: Lte, Value: now}}, false},
+		{
+			name: "All must match",
+			filters: []Filter{
+				{Field: "season", Operator: Eq, Value: 2020},
+				{Field: "week", Operator: Gt, Value: 3},
+			},
+			match: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match, err := PollFields.Match(test.filters, values)
+			if err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+				return
+			}
+			if match != test.match {
+				t.Errorf("Expected match to be %v", test.match)
+			}
+		})
+	}
+
+	_, err := PollFields.Match([]Filter{{Field: "bogus", Operator: Eq, Value: 1}}, values)
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest for unknown field, got %v", errors.Kind(err))
+	}
+}
@@ -0,0 +1,822 @@
+/*
+Package memory is a DBClient that keeps everything in process memory.  It
+mirrors the sqlite client's semantics (conflict and not-found errors, foreign
+keys, result invalidation) closely enough to pass the dbtest suite, which makes
+it suitable for end-to-end tests of the app and server packages and for running
+a throwaway demo instance.  Nothing is persisted.
+*/
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// maxRank matches the CHECK constraint on vote.rank in the sql schemas.
+const maxRank = 25
+
+type pollKey struct {
+	season int
+	week   int
+}
+
+type result struct {
+	models.Result
+	official bool
+}
+
+// store holds everything, shared by a Client and those returned by WithAudit.
+type store struct {
+	mu sync.RWMutex
+
+	teams        map[int64]models.Team
+	lastTeamID   int64
+	users        map[string]models.User
+	polls        map[pollKey]models.Poll
+	ballots      map[int64]models.Ballot
+	lastBallotID int64
+	results      map[pollKey][]result
+
+	refreshTokens map[string]models.RefreshToken
+	revokedTokens map[string]time.Time
+
+	flairTeams map[string]int64
+
+	auditLog    []models.AuditEntry
+	lastAuditID int64
+}
+
+type Client struct {
+	*store
+
+	// audit is written with the next audited change, if set
+	audit *models.AuditEntry
+}
+
+func NewClient() *Client {
+	return &Client{store: &store{
+		teams:   make(map[int64]models.Team),
+		users:   make(map[string]models.User),
+		polls:   make(map[pollKey]models.Poll),
+		ballots: make(map[int64]models.Ballot),
+		results: make(map[pollKey][]result),
+
+		refreshTokens: make(map[string]models.RefreshToken),
+		revokedTokens: make(map[string]time.Time),
+
+		flairTeams: make(map[string]int64),
+	}}
+}
+
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *Client) AddTeam(newTeam models.Team) (models.Team, error) {
+	const op errors.Op = "memory.AddTeam"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Like the sqlite client, the ID on newTeam is ignored.
+	c.lastTeamID++
+	newTeam.ID = c.lastTeamID
+	c.teams[newTeam.ID] = newTeam
+
+	if c.audit != nil {
+		entry, err := db.AuditCreated(*c.audit, newTeam.ID, newTeam)
+		if err != nil {
+			delete(c.teams, newTeam.ID)
+			return models.Team{}, errors.E(op, err, "error recording team in audit entry")
+		}
+		c.addAuditEntry(entry)
+	}
+
+	return newTeam, nil
+}
+
+func (c *Client) GetTeam(id int64) (models.Team, error) {
+	const op errors.Op = "memory.GetTeam"
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	t, ok := c.teams[id]
+	if !ok {
+		return models.Team{}, errors.E(op, "team not found", errors.KindNotFound)
+	}
+
+	return t, nil
+}
+
+func (c *Client) GetTeams() ([]models.Team, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ts := make([]models.Team, 0, len(c.teams))
+	for _, t := range c.teams {
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].ID < ts[j].ID })
+
+	return ts, nil
+}
+
+func (c *Client) GetTeamsByID(ids []int64) ([]models.Team, error) {
+	const op errors.Op = "memory.GetTeamsByID"
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ts := make([]models.Team, len(ids))
+	for i, id := range ids {
+		t, ok := c.teams[id]
+		if !ok {
+			return nil, errors.E(op, fmt.Sprintf("team %d not found", id), errors.KindNotFound)
+		}
+		ts[i] = t
+	}
+
+	return ts, nil
+}
+
+func (c *Client) checkPrimaryTeam(u models.User) error {
+	if u.PrimaryTeam == 0 {
+		return nil
+	}
+
+	if _, ok := c.teams[u.PrimaryTeam]; !ok {
+		return fmt.Errorf("primary team %d doesn't exist", u.PrimaryTeam)
+	}
+
+	return nil
+}
+
+func (c *Client) AddUser(newUser models.User) (models.User, error) {
+	const op errors.Op = "memory.AddUser"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.users[newUser.Nickname]; ok {
+		return models.User{}, errors.E(op, "user already exists", errors.KindConflict)
+	}
+
+	if err := c.checkPrimaryTeam(newUser); err != nil {
+		return models.User{}, errors.E(op, err, "error adding user to db", errors.KindBadRequest)
+	}
+
+	newUser = newUser.WithNormalizedRoles()
+	c.users[newUser.Nickname] = newUser
+	c.writeAudit()
+
+	return newUser, nil
+}
+
+func (c *Client) UpdateUser(user models.User) error {
+	const op errors.Op = "memory.UpdateUser"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Matches an UPDATE that affects no rows
+	if _, ok := c.users[user.Nickname]; !ok {
+		c.writeAudit()
+		return nil
+	}
+
+	if err := c.checkPrimaryTeam(user); err != nil {
+		return errors.E(op, err, "err updating user", errors.KindBadRequest)
+	}
+
+	c.users[user.Nickname] = user.WithNormalizedRoles()
+	c.writeAudit()
+
+	return nil
+}
+
+func (c *Client) GetUser(name string) (models.User, error) {
+	const op errors.Op = "memory.GetUser"
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	u, ok := c.users[name]
+	if !ok {
+		return models.User{}, errors.E(op, "user doesn't exist", errors.KindNotFound)
+	}
+
+	return u, nil
+}
+
+func userValues(u models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"nickname":     u.Nickname,
+		"is_admin":     u.IsAdmin,
+		"is_voter":     u.IsVoter,
+		"primary_team": u.PrimaryTeam,
+	}
+}
+
+func (c *Client) GetUsers(filter []db.Filter, sort db.Sort) ([]models.User, error) {
+	const op errors.Op = "memory.GetUsers"
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := db.UserFields.Validate(filter); err != nil {
+		return nil, errors.E(op, err, "invalid user filter")
+	}
+
+	us := make([]models.User, 0)
+	for _, u := range c.users {
+		ok, err := db.UserFields.Match(filter, userValues(u))
+		if err != nil {
+			return nil, errors.E(op, err, "error filtering users")
+		}
+		if ok {
+			us = append(us, u)
+		}
+	}
+	sortUsers(us)
+
+	return us, nil
+}
+
+func sortUsers(us []models.User) {
+	sort.Slice(us, func(i, j int) bool { return us[i].Nickname < us[j].Nickname })
+}
+
+func (c *Client) AnonymizeUser(name string, alias string, deleteBallots []int64) error {
+	const op errors.Op = "memory.AnonymizeUser"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Everything is checked before anything changes, as the sql clients'
+	// transactions would roll back
+	if _, ok := c.users[name]; !ok {
+		return errors.E(op, "user doesn't exist", errors.KindNotFound)
+	}
+	for _, id := range deleteBallots {
+		if b, ok := c.ballots[id]; !ok || b.User != name {
+			return errors.E(op, fmt.Sprintf("user has no ballot %d", id), errors.KindNotFound)
+		}
+	}
+	if _, ok := c.users[alias]; ok {
+		return errors.E(op, "error adding anonymous user to db", errors.KindConflict)
+	}
+	redacted, err := c.redactAudit(name, alias)
+	if err != nil {
+		return errors.E(op, err, "error removing user from audit log")
+	}
+
+	for _, id := range deleteBallots {
+		b := c.ballots[id]
+		delete(c.ballots, id)
+		c.invalidateResults(b.PollSeason, b.PollWeek)
+	}
+
+	c.users[alias] = models.User{Nickname: alias}.WithNormalizedRoles()
+	for id, b := range c.ballots {
+		if b.User != name {
+			continue
+		}
+		b = copyBallot(b)
+		b.User = alias
+		for i := range b.Votes {
+			b.Votes[i].Reason = ""
+		}
+		c.ballots[id] = b
+	}
+
+	delete(c.users, name)
+	for hash, t := range c.refreshTokens {
+		if t.User == name {
+			delete(c.refreshTokens, hash)
+		}
+	}
+	delete(c.flairTeams, name)
+	c.auditLog = redacted
+	c.writeAudit()
+
+	return nil
+}
+
+func (c *Client) AddPoll(newPoll models.Poll) (models.Poll, error) {
+	const op errors.Op = "memory.AddPoll"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := pollKey{newPoll.Season, newPoll.Week}
+	if _, ok := c.polls[key]; ok {
+		return models.Poll{}, errors.E(op, "poll already exists for week", errors.KindConflict)
+	}
+
+	c.polls[key] = newPoll
+	c.writeAudit()
+
+	return newPoll, nil
+}
+
+func (c *Client) UpdatePoll(poll models.Poll) error {
+	const op errors.Op = "memory.UpdatePoll"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := pollKey{poll.Season, poll.Week}
+	if _, ok := c.polls[key]; !ok {
+		return errors.E(op, "poll not found to update", errors.KindNotFound)
+	}
+
+	poll.LastModified = time.Now()
+	c.polls[key] = poll
+
+	return nil
+}
+
+func (c *Client) GetPoll(season int, week int) (models.Poll, error) {
+	const op errors.Op = "memory.GetPoll"
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	p, ok := c.polls[pollKey{season, week}]
+	if !ok {
+		return models.Poll{}, errors.E(op, "no poll found for week", errors.KindNotFound)
+	}
+
+	return p, nil
+}
+
+func pollValues(p models.Poll) map[string]interface{} {
+	return map[string]interface{}{
+		"season":        p.Season,
+		"week":          p.Week,
+		"open_time":     p.OpenTime,
+		"close_time":    p.CloseTime,
+		"last_modified": p.LastModified,
+	}
+}
+
+func (c *Client) GetPolls(filter []db.Filter, sort db.Sort) ([]models.Poll, error) {
+	const op errors.Op = "memory.GetPolls"
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := db.PollFields.Validate(filter); err != nil {
+		return nil, errors.E(op, err, "invalid poll filter")
+	}
+
+	ps := make([]models.Poll, 0)
+	for _, p := range c.polls {
+		ok, err := db.PollFields.Match(filter, pollValues(p))
+		if err != nil {
+			return nil, errors.E(op, err, "error filtering polls")
+		}
+		if ok {
+			ps = append(ps, p)
+		}
+	}
+	sortPolls(ps)
+
+	return ps, nil
+}
+
+func sortPolls(ps []models.Poll) {
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].Season == ps[j].Season {
+			return ps[i].Week < ps[j].Week
+		}
+		return ps[i].Season < ps[j].Season
+	})
+}
+
+func (c *Client) SetResults(poll models.Poll, official []models.Result, allBallots []models.Result) error {
+	const op errors.Op = "memory.SetResults"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := pollKey{poll.Season, poll.Week}
+	p, ok := c.polls[key]
+	if !ok {
+		return errors.E(op, "poll not found", errors.KindNotFound)
+	}
+
+	if !p.LastModified.Equal(poll.LastModified) {
+		return errors.E(op, "results set out of date with poll in db", errors.KindConcurrencyProblem)
+	}
+
+	rs := c.results[key]
+	for _, r := range official {
+		rs = append(rs, result{Result: r, official: true})
+	}
+	for _, r := range allBallots {
+		rs = append(rs, result{Result: r, official: false})
+	}
+	c.results[key] = rs
+
+	return nil
+}
+
+func (c *Client) GetResults(poll models.Poll, includeProvisional bool) ([]models.Result, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rs := make([]models.Result, 0)
+	for _, r := range c.results[pollKey{poll.Season, poll.Week}] {
+		if r.official || includeProvisional {
+			rs = append(rs, r.Result)
+		}
+	}
+
+	return rs, nil
+}
+
+// invalidateResults must be called with the write lock held.
+func (c *Client) invalidateResults(season int, week int) {
+	delete(c.results, pollKey{season, week})
+}
+
+func copyBallot(b models.Ballot) models.Ballot {
+	b.Votes = append([]models.Vote(nil), b.Votes...)
+	return b
+}
+
+// addBallot must be called with the write lock held.
+func (c *Client) addBallot(b models.Ballot) (models.Ballot, error) {
+	const op errors.Op = "memory.addBallot"
+
+	for _, existing := range c.ballots {
+		if existing.User == b.User && existing.PollSeason == b.PollSeason && existing.PollWeek == b.PollWeek {
+			return models.Ballot{}, errors.E(op, "ballot already exists for user", errors.KindConflict)
+		}
+	}
+
+	if _, ok := c.polls[pollKey{b.PollSeason, b.PollWeek}]; !ok {
+		return models.Ballot{}, errors.E(op, "ballot's poll doesn't exist", errors.KindBadRequest)
+	}
+
+	if _, ok := c.users[b.User]; !ok {
+		return models.Ballot{}, errors.E(op, "ballot's user doesn't exist", errors.KindBadRequest)
+	}
+
+	// Mirrors the constraints on the sql clients' vote table
+	ranks := make(map[int]bool)
+	teams := make(map[int64]bool)
+	for _, v := range b.Votes {
+		if _, ok := c.teams[v.TeamID]; !ok {
+			return models.Ballot{}, errors.E(op, fmt.Sprintf("team %d doesn't exist", v.TeamID), errors.KindBadRequest)
+		}
+		if v.Rank < 1 || v.Rank > maxRank {
+			return models.Ballot{}, errors.E(op, fmt.Sprintf("rank %d out of range", v.Rank), errors.KindBadRequest)
+		}
+		if ranks[v.Rank] {
+			return models.Ballot{}, errors.E(op, fmt.Sprintf("rank %d used more than once", v.Rank), errors.KindConflict)
+		}
+		if teams[v.TeamID] {
+			return models.Ballot{}, errors.E(op, fmt.Sprintf("team %d ranked more than once", v.TeamID), errors.KindConflict)
+		}
+		ranks[v.Rank] = true
+		teams[v.TeamID] = true
+	}
+
+	// If ID is provided, accept it, otherwise generate one.
+	if b.ID == 0 {
+		b.ID = c.lastBallotID + 1
+	} else if _, ok := c.ballots[b.ID]; ok {
+		return models.Ballot{}, errors.E(op, "ballot id already in use", errors.KindConflict)
+	}
+	if b.ID > c.lastBallotID {
+		c.lastBallotID = b.ID
+	}
+
+	c.ballots[b.ID] = copyBallot(b)
+
+	return b, nil
+}
+
+func (c *Client) AddBallot(newBallot models.Ballot) (models.Ballot, error) {
+	const op errors.Op = "memory.AddBallot"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.addBallot(newBallot)
+	if err != nil {
+		return models.Ballot{}, errors.E(op, err, "error during ballot creation")
+	}
+
+	c.invalidateResults(b.PollSeason, b.PollWeek)
+
+	return copyBallot(b), nil
+}
+
+func (c *Client) GetBallot(id int64) (models.Ballot, error) {
+	const op errors.Op = "memory.GetBallot"
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	b, ok := c.ballots[id]
+	if !ok {
+		return models.Ballot{}, errors.E(op, "ballot not found", errors.KindNotFound)
+	}
+
+	return copyBallot(b), nil
+}
+
+func (c *Client) GetBallotsByPoll(poll models.Poll) ([]models.Ballot, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	bs := make([]models.Ballot, 0)
+	for _, b := range c.ballots {
+		if b.PollSeason == poll.Season && b.PollWeek == poll.Week {
+			bs = append(bs, copyBallot(b))
+		}
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i].ID < bs[j].ID })
+
+	return bs, nil
+}
+
+func (c *Client) GetBallotsByUser(user string) ([]models.Ballot, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	bs := make([]models.Ballot, 0)
+	for _, b := range c.ballots {
+		if b.User == user {
+			bs = append(bs, copyBallot(b))
+		}
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		if bs[i].PollSeason != bs[j].PollSeason {
+			return bs[i].PollSeason < bs[j].PollSeason
+		}
+		return bs[i].PollWeek < bs[j].PollWeek
+	})
+
+	return bs, nil
+}
+
+func (c *Client) DeleteBallot(id int64) error {
+	const op errors.Op = "memory.DeleteBallot"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.ballots[id]
+	if !ok {
+		return errors.E(op, "ballot not found", errors.KindNotFound)
+	}
+
+	delete(c.ballots, id)
+	c.invalidateResults(b.PollSeason, b.PollWeek)
+	c.writeAudit()
+
+	return nil
+}
+
+func (c *Client) UpdateBallot(ballot models.Ballot) error {
+	const op errors.Op = "memory.UpdateBallot"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old, ok := c.ballots[ballot.ID]
+	if !ok {
+		return errors.E(op, "no ballot found with given id", errors.KindNotFound)
+	}
+
+	// Same delete-then-add as the sql clients, restoring the old ballot if the
+	// add fails so the update is all-or-nothing.
+	delete(c.ballots, ballot.ID)
+	b, err := c.addBallot(ballot)
+	if err != nil {
+		c.ballots[old.ID] = old
+		return errors.E(op, err, "error adding updated ballot to db, rolling back")
+	}
+
+	c.invalidateResults(b.PollSeason, b.PollWeek)
+
+	return nil
+}
+
+func (c *Client) AddRefreshToken(token models.RefreshToken) error {
+	const op errors.Op = "memory.AddRefreshToken"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.users[token.User]; !ok {
+		return errors.E(op, fmt.Errorf("user %s doesn't exist", token.User), "error adding refresh token to db", errors.KindBadRequest)
+	}
+
+	if _, ok := c.refreshTokens[token.Hash]; ok {
+		return errors.E(op, "refresh token already exists", errors.KindConflict)
+	}
+
+	now := time.Now()
+	for hash, t := range c.refreshTokens {
+		if t.User == token.User && t.ExpiresAt.Before(now) {
+			delete(c.refreshTokens, hash)
+		}
+	}
+
+	c.refreshTokens[token.Hash] = token
+
+	return nil
+}
+
+func (c *Client) GetRefreshToken(hash string) (models.RefreshToken, error) {
+	const op errors.Op = "memory.GetRefreshToken"
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	t, ok := c.refreshTokens[hash]
+	if !ok {
+		return models.RefreshToken{}, errors.E(op, "refresh token not found", errors.KindNotFound)
+	}
+
+	return t, nil
+}
+
+func (c *Client) GetRefreshTokens(user string) ([]models.RefreshToken, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ts := make([]models.RefreshToken, 0)
+	for _, t := range c.refreshTokens {
+		if t.User == user {
+			ts = append(ts, t)
+		}
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].IssuedAt.Before(ts[j].IssuedAt) })
+
+	return ts, nil
+}
+
+func (c *Client) RotateRefreshToken(oldHash string, newToken models.RefreshToken) error {
+	const op errors.Op = "memory.RotateRefreshToken"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old, ok := c.refreshTokens[oldHash]
+	if !ok || old.Revoked {
+		return errors.E(op, "refresh token missing or already revoked", errors.KindConflict)
+	}
+
+	if _, ok := c.users[newToken.User]; !ok {
+		return errors.E(op, fmt.Errorf("user %s doesn't exist", newToken.User), "error adding refresh token to db", errors.KindBadRequest)
+	}
+
+	if _, ok := c.refreshTokens[newToken.Hash]; ok {
+		return errors.E(op, "refresh token already exists", errors.KindConflict)
+	}
+
+	old.Revoked = true
+	c.refreshTokens[oldHash] = old
+	c.refreshTokens[newToken.Hash] = newToken
+
+	return nil
+}
+
+func (c *Client) RevokeRefreshToken(hash string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, ok := c.refreshTokens[hash]; ok {
+		t.Revoked = true
+		c.refreshTokens[hash] = t
+	}
+
+	return nil
+}
+
+func (c *Client) RevokeRefreshTokens(user string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for hash, t := range c.refreshTokens {
+		if t.User == user {
+			t.Revoked = true
+			c.refreshTokens[hash] = t
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) RevokeAccessToken(id string, expiresAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for revoked, exp := range c.revokedTokens {
+		if exp.Before(now) {
+			delete(c.revokedTokens, revoked)
+		}
+	}
+
+	if _, ok := c.revokedTokens[id]; !ok {
+		c.revokedTokens[id] = expiresAt
+	}
+
+	return nil
+}
+
+func (c *Client) IsAccessTokenRevoked(id string) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.revokedTokens[id]
+	return ok, nil
+}
+
+func (c *Client) GetFlairTeams() (map[string]int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	teams := make(map[string]int64, len(c.flairTeams))
+	for user, team := range c.flairTeams {
+		teams[user] = team
+	}
+
+	return teams, nil
+}
+
+func (c *Client) SetFlairTeam(user string, team int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if team == 0 {
+		delete(c.flairTeams, user)
+	} else {
+		c.flairTeams[user] = team
+	}
+
+	return nil
+}
+
+// WithAudit returns a client that writes entry to the audit log along with the
+// next audited change made through it.
+func (c *Client) WithAudit(entry models.AuditEntry) db.DBClient {
+	return &Client{store: c.store, audit: &entry}
+}
+
+// writeAudit adds the client's audit entry, if it has one.  c.mu must be held.
+func (c *Client) writeAudit() {
+	if c.audit != nil {
+		c.addAuditEntry(*c.audit)
+	}
+}
+
+// addAuditEntry appends entry to the audit log.  c.mu must be held.
+func (c *store) addAuditEntry(entry models.AuditEntry) {
+	c.lastAuditID++
+	entry.ID = c.lastAuditID
+	c.auditLog = append(c.auditLog, entry)
+}
+
+// redactAudit returns a copy of the audit log with the user called name
+// replaced by alias throughout.  c.mu must be held.
+func (c *store) redactAudit(name string, alias string) ([]models.AuditEntry, error) {
+	redacted := make([]models.AuditEntry, len(c.auditLog))
+	for i, entry := range c.auditLog {
+		var err error
+		redacted[i], _, err = db.RedactAudit(entry, name, alias)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return redacted, nil
+}
+
+func auditValues(a models.AuditEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          a.ID,
+		"time":        a.Time,
+		"actor":       a.Actor,
+		"action":      string(a.Action),
+		"target_type": a.TargetType,
+		"target_id":   a.TargetID,
+		"request_id":  a.RequestID,
+	}
+}
+
+func (c *Client) GetAuditLog(filter []db.Filter, sort db.Sort) ([]models.AuditEntry, error) {
+	const op errors.Op = "memory.GetAuditLog"
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if err := db.AuditFields.Validate(filter); err != nil {
+		return nil, errors.E(op, err, "invalid audit filter")
+	}
+
+	entries := make([]models.AuditEntry, 0)
+	for i := len(c.auditLog) - 1; i >= 0; i-- {
+		ok, err := db.AuditFields.Match(filter, auditValues(c.auditLog[i]))
+		if err != nil {
+			return nil, errors.E(op, err, "error filtering audit log")
+		}
+		if ok {
+			entries = append(entries, c.auditLog[i])
+		}
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/db/dbtest"
+)
+
+func TestConformance(t *testing.T) {
+	dbtest.RunSuite(t, func(t *testing.T) db.DBClient {
+		return NewClient()
+	})
+}
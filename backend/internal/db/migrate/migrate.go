@@ -0,0 +1,452 @@
+/*
+Package migrate applies versioned schema migrations and records which ones have
+run in a schema_migrations table.
+
+Migrations are pairs of sql files named NN_description.up.sql and
+NN_description.down.sql, where NN is the version.  Versions are applied in
+ascending order, each in its own transaction.  Database clients embed their own
+migrations directory and hand back a Migrator for it.
+*/
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+)
+
+// NoVersion is reported as the version of a database with no migrations applied.
+const NoVersion = -1
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	db          *sqlx.DB
+	migrations  []Migration
+	legacyTable string
+}
+
+// Load reads the migrations in dir of fsys.  Every version must have an up
+// script; down scripts are optional, but a migration without one can't be
+// rolled back.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	const op errors.Op = "migrate.Load"
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.E(op, err, "error reading migrations directory")
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		filename := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(filename, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(filename, ".sql")
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down"):
+			direction = "down"
+		default:
+			return nil, errors.E(op, fmt.Sprintf("migration %s must end in .up.sql or .down.sql", filename))
+		}
+		base = strings.TrimSuffix(base, "."+direction)
+
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, errors.E(op, err, fmt.Sprintf("migration %s must be named NN_description", filename))
+		}
+
+		contents, err := fs.ReadFile(fsys, path.Join(dir, filename))
+		if err != nil {
+			return nil, errors.E(op, err, fmt.Sprintf("error reading migration %s", filename))
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		} else if m.Name != parts[1] {
+			return nil, errors.E(op, fmt.Sprintf("migration version %d has conflicting names %s and %s", version, m.Name, parts[1]))
+		}
+
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, errors.E(op, fmt.Sprintf("migration version %d has no up script", m.Version))
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+func New(db *sqlx.DB, migrations []Migration) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Latest is the newest version this Migrator knows about.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return NoVersion
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// AdoptLegacySchema has Up and Down treat a database that contains table but
+// has never recorded a migration as being at version 0, the way databases were
+// set up before migrations were tracked.  They baseline it before doing
+// anything else; the read-only methods leave it alone and report it as
+// unversioned.
+func (m *Migrator) AdoptLegacySchema(table string) {
+	m.legacyTable = table
+}
+
+// Unversioned reports whether the database has never recorded a migration,
+// i.e. it has no schema_migrations table.  It doesn't create one.
+func (m *Migrator) Unversioned() (bool, error) {
+	const op errors.Op = "migrate.Unversioned"
+
+	exists, err := m.tableExists("schema_migrations")
+	if err != nil {
+		return false, errors.E(op, err)
+	}
+
+	return !exists, nil
+}
+
+func (m *Migrator) tableExists(name string) (bool, error) {
+	const op errors.Op = "migrate.tableExists"
+
+	query := "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	if m.db.DriverName() == "sqlite3" {
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	}
+
+	var count int
+	err := m.db.Get(&count, m.db.Rebind(query), name)
+	if err != nil {
+		return false, errors.E(op, err, "error inspecting schema", errors.KindDatabaseError)
+	}
+
+	return count > 0, nil
+}
+
+// adopt baselines a legacy schema at version 0, see AdoptLegacySchema.
+func (m *Migrator) adopt() error {
+	const op errors.Op = "migrate.adopt"
+
+	if m.legacyTable == "" {
+		return nil
+	}
+
+	unversioned, err := m.Unversioned()
+	if err != nil || !unversioned {
+		return err
+	}
+
+	legacy, err := m.tableExists(m.legacyTable)
+	if err != nil || !legacy {
+		return err
+	}
+
+	if err = m.Baseline(0); err != nil {
+		return errors.E(op, err, "error baselining existing schema")
+	}
+
+	return nil
+}
+
+func (m *Migrator) ensureTable() error {
+	const op errors.Op = "migrate.ensureTable"
+
+	_, err := m.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name VARCHAR(64), applied_at TIMESTAMP)")
+	if err != nil {
+		return errors.E(op, err, "error creating schema_migrations table", errors.KindDatabaseError)
+	}
+
+	return nil
+}
+
+type appliedRow struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+func (m *Migrator) applied() ([]appliedRow, error) {
+	const op errors.Op = "migrate.applied"
+
+	unversioned, err := m.Unversioned()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if unversioned {
+		return nil, nil
+	}
+
+	var rows []appliedRow
+	err = m.db.Select(&rows, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, errors.E(op, err, "error reading schema_migrations", errors.KindDatabaseError)
+	}
+
+	return rows, nil
+}
+
+// Version is the newest migration applied to the database, or NoVersion.
+func (m *Migrator) Version() (int, error) {
+	const op errors.Op = "migrate.Version"
+
+	rows, err := m.applied()
+	if err != nil {
+		return NoVersion, errors.E(op, err)
+	}
+
+	if len(rows) == 0 {
+		return NoVersion, nil
+	}
+
+	return rows[len(rows)-1].Version, nil
+}
+
+// Check returns an error if the database has migrations applied that this
+// Migrator doesn't know about, i.e. it was migrated by a newer build.  It's
+// not safe to run against such a schema.
+func (m *Migrator) Check() error {
+	const op errors.Op = "migrate.Check"
+
+	version, err := m.Version()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if version > m.Latest() {
+		return errors.E(op, errors.KindDatabaseError,
+			fmt.Sprintf("database schema version %d is newer than the latest known migration %d", version, m.Latest()))
+	}
+
+	return nil
+}
+
+// Pending lists the migrations that haven't been applied yet, oldest first.
+func (m *Migrator) Pending() ([]Migration, error) {
+	const op errors.Op = "migrate.Pending"
+
+	rows, err := m.applied()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	done := make(map[int]bool)
+	for _, r := range rows {
+		done[r.Version] = true
+	}
+
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if !done[mig.Version] {
+			pending = append(pending, mig)
+		}
+	}
+
+	return pending, nil
+}
+
+// Up applies every pending migration, returning the ones it applied.  It
+// refuses to run against a database that is newer than this Migrator.
+func (m *Migrator) Up() ([]Migration, error) {
+	const op errors.Op = "migrate.Up"
+
+	if err := m.adopt(); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	if err := m.Check(); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	if err := m.ensureTable(); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	for i, mig := range pending {
+		err := m.run(mig, mig.Up, func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(tx.Rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+				mig.Version, mig.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return pending[:i], errors.E(op, err)
+		}
+	}
+
+	return pending, nil
+}
+
+// Down rolls back the newest applied migration.
+func (m *Migrator) Down() (Migration, error) {
+	const op errors.Op = "migrate.Down"
+
+	if err := m.adopt(); err != nil {
+		return Migration{}, errors.E(op, err)
+	}
+
+	if err := m.Check(); err != nil {
+		return Migration{}, errors.E(op, err)
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		return Migration{}, errors.E(op, err)
+	}
+
+	if version == NoVersion {
+		return Migration{}, errors.E(op, errors.KindBadRequest, "no migrations to roll back")
+	}
+
+	var mig Migration
+	for _, candidate := range m.migrations {
+		if candidate.Version == version {
+			mig = candidate
+		}
+	}
+
+	if mig.Down == "" {
+		return Migration{}, errors.E(op, errors.KindBadRequest, fmt.Sprintf("migration %d has no down script", version))
+	}
+
+	err = m.run(mig, mig.Down, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(tx.Rebind("DELETE FROM schema_migrations WHERE version = ?"), mig.Version)
+		return err
+	})
+	if err != nil {
+		return Migration{}, errors.E(op, err)
+	}
+
+	return mig, nil
+}
+
+// Baseline records version (and everything before it) as applied without
+// running the scripts.  It's for adopting a database whose schema was created
+// by hand before migrations were tracked.
+func (m *Migrator) Baseline(version int) error {
+	const op errors.Op = "migrate.Baseline"
+
+	if err := m.ensureTable(); err != nil {
+		return errors.E(op, err)
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version > version {
+			break
+		}
+		_, err := m.db.Exec(m.db.Rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			mig.Version, mig.Name, time.Now().UTC())
+		if err != nil {
+			return errors.E(op, err, fmt.Sprintf("error recording migration %d", mig.Version), errors.KindDatabaseError)
+		}
+	}
+
+	return nil
+}
+
+// Status reports every known migration and whether it has been applied, plus
+// any applied versions this Migrator doesn't know about.
+func (m *Migrator) Status() ([]Status, error) {
+	const op errors.Op = "migrate.Status"
+
+	rows, err := m.applied()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	byVersion := make(map[int]appliedRow)
+	for _, r := range rows {
+		byVersion[r.Version] = r
+	}
+
+	var statuses []Status
+	for _, mig := range m.migrations {
+		s := Status{Version: mig.Version, Name: mig.Name}
+		if r, ok := byVersion[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = r.AppliedAt
+			delete(byVersion, mig.Version)
+		}
+		statuses = append(statuses, s)
+	}
+
+	for _, r := range byVersion {
+		statuses = append(statuses, Status{Version: r.Version, Name: r.Name, Applied: true, AppliedAt: r.AppliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+func (m *Migrator) run(mig Migration, script string, record func(tx *sqlx.Tx) error) error {
+	const op errors.Op = "migrate.run"
+
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return errors.E(op, err, "error creating transaction", errors.KindDatabaseError)
+	}
+
+	_, err = tx.Exec(script)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, fmt.Sprintf("error running migration %d_%s", mig.Version, mig.Name), errors.KindDatabaseError)
+	}
+
+	err = record(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, fmt.Sprintf("error recording migration %d_%s", mig.Version, mig.Name), errors.KindDatabaseError)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.E(op, err, "error committing transaction", errors.KindDatabaseError)
+	}
+
+	return nil
+}
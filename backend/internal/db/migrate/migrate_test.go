@@ -0,0 +1,259 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+)
+
+var testFiles = fstest.MapFS{
+	"migrations/00_init.up.sql":       {Data: []byte("CREATE TABLE team (id INTEGER PRIMARY KEY);")},
+	"migrations/00_init.down.sql":     {Data: []byte("DROP TABLE team;")},
+	"migrations/01_add_user.up.sql":   {Data: []byte("CREATE TABLE user (name TEXT PRIMARY KEY);")},
+	"migrations/01_add_user.down.sql": {Data: []byte("DROP TABLE user;")},
+	"migrations/02_no_down.up.sql":    {Data: []byte("CREATE TABLE poll (id INTEGER PRIMARY KEY);")},
+	"migrations/README.md":            {Data: []byte("ignored")},
+}
+
+func newTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "cbbpoll-migrate")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	db, err := sqlx.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("error opening db: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+func newTestMigrator(t *testing.T, db *sqlx.DB) *Migrator {
+	t.Helper()
+
+	ms, err := Load(testFiles, "migrations")
+	if err != nil {
+		t.Fatalf("error loading migrations: %s", err.Error())
+	}
+
+	return New(db, ms)
+}
+
+func expectVersion(t *testing.T, m *Migrator, expected int) {
+	t.Helper()
+	v, err := m.Version()
+	if err != nil {
+		t.Fatalf("Version: unexpected error: %s", err.Error())
+	}
+	if v != expected {
+		t.Errorf("Expected version %d, got %d", expected, v)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ms, err := Load(testFiles, "migrations")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if len(ms) != 3 {
+		t.Fatalf("Expected 3 migrations, got %d", len(ms))
+	}
+
+	for i, name := range []string{"init", "add_user", "no_down"} {
+		if ms[i].Version != i || ms[i].Name != name {
+			t.Errorf("Migration %d loaded as %d_%s", i, ms[i].Version, ms[i].Name)
+		}
+	}
+
+	if ms[2].Down != "" {
+		t.Errorf("Expected no down script for version 2")
+	}
+
+	bad := []fstest.MapFS{
+		{"m/00_init.sql": {Data: []byte("")}},
+		{"m/init.up.sql": {Data: []byte("")}},
+		{"m/00_init.down.sql": {Data: []byte("")}},
+		{"m/00_init.up.sql": {Data: []byte("")}, "m/00_other.down.sql": {Data: []byte("")}},
+	}
+	for _, fsys := range bad {
+		if _, err := Load(fsys, "m"); err == nil {
+			t.Errorf("Expected error loading %v", fsys)
+		}
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	db := newTestDB(t)
+	m := newTestMigrator(t, db)
+
+	expectVersion(t, m, NoVersion)
+
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatalf("Up: unexpected error: %s", err.Error())
+	}
+	if len(applied) != 3 {
+		t.Errorf("Expected Up to apply 3 migrations, applied %d", len(applied))
+	}
+	expectVersion(t, m, 2)
+
+	if _, err := db.Exec("INSERT INTO user (name) VALUES ('Concision')"); err != nil {
+		t.Errorf("Schema not migrated: %s", err.Error())
+	}
+
+	applied, err = m.Up()
+	if err != nil {
+		t.Fatalf("Up: unexpected error: %s", err.Error())
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected second Up to be a no-op, applied %d", len(applied))
+	}
+
+	_, err = m.Down()
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest rolling back migration without down script, got %v", errors.Kind(err))
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status: unexpected error: %s", err.Error())
+	}
+	for _, s := range statuses {
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Errorf("Expected migration %d to be applied, got %v", s.Version, s)
+		}
+	}
+}
+
+func TestDown(t *testing.T) {
+	db := newTestDB(t)
+	ms, err := Load(testFiles, "migrations")
+	if err != nil {
+		t.Fatalf("error loading migrations: %s", err.Error())
+	}
+	m := New(db, ms[:2])
+
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("Up: unexpected error: %s", err.Error())
+	}
+
+	for _, expected := range []int{0, NoVersion} {
+		if _, err := m.Down(); err != nil {
+			t.Fatalf("Down: unexpected error: %s", err.Error())
+		}
+		expectVersion(t, m, expected)
+	}
+
+	_, err = m.Down()
+	if errors.Kind(err) != errors.KindBadRequest {
+		t.Errorf("Expected KindBadRequest with nothing to roll back, got %v", errors.Kind(err))
+	}
+
+	pending, err := m.Pending()
+	if err != nil {
+		t.Fatalf("Pending: unexpected error: %s", err.Error())
+	}
+	if len(pending) != 2 {
+		t.Errorf("Expected 2 pending migrations after rolling back, got %d", len(pending))
+	}
+}
+
+func TestNewerSchema(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := newTestMigrator(t, db).Up(); err != nil {
+		t.Fatalf("Up: unexpected error: %s", err.Error())
+	}
+
+	// An older build only knows about the first two migrations
+	ms, err := Load(testFiles, "migrations")
+	if err != nil {
+		t.Fatalf("error loading migrations: %s", err.Error())
+	}
+	old := New(db, ms[:2])
+
+	if err := old.Check(); errors.Kind(err) != errors.KindDatabaseError {
+		t.Errorf("Expected Check to fail with KindDatabaseError, got %v", err)
+	}
+
+	if _, err := old.Up(); err == nil {
+		t.Errorf("Expected Up to refuse to run against a newer schema")
+	}
+
+	if _, err := old.Down(); err == nil {
+		t.Errorf("Expected Down to refuse to run against a newer schema")
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	db := newTestDB(t)
+	m := New(db, []Migration{
+		{Version: 0, Name: "init", Up: "CREATE TABLE team (id INTEGER PRIMARY KEY);"},
+		{Version: 1, Name: "broken", Up: "CREATE TABLE poll (id INTEGER); SELECT * FROM nonexistent;"},
+	})
+
+	applied, err := m.Up()
+	if errors.Kind(err) != errors.KindDatabaseError {
+		t.Errorf("Expected KindDatabaseError from broken migration, got %v", err)
+	}
+	if len(applied) != 1 {
+		t.Errorf("Expected only the first migration to be reported applied, got %d", len(applied))
+	}
+	expectVersion(t, m, 0)
+
+	if _, err := db.Exec("SELECT * FROM poll"); err == nil {
+		t.Errorf("Broken migration's partial changes weren't rolled back")
+	}
+}
+
+func TestReadsDontWrite(t *testing.T) {
+	db := newTestDB(t)
+	m := newTestMigrator(t, db)
+	m.AdoptLegacySchema("team")
+
+	if _, err := db.Exec("CREATE TABLE team (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("error creating legacy schema: %s", err.Error())
+	}
+
+	expectVersion(t, m, NoVersion)
+	if err := m.Check(); err != nil {
+		t.Errorf("Check: unexpected error: %s", err.Error())
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status: unexpected error: %s", err.Error())
+	}
+	if len(statuses) != 3 || statuses[0].Applied {
+		t.Errorf("Expected 3 pending migrations, got %v", statuses)
+	}
+
+	unversioned, err := m.Unversioned()
+	if err != nil {
+		t.Fatalf("Unversioned: unexpected error: %s", err.Error())
+	}
+	if !unversioned {
+		t.Errorf("Expected reading the status not to create schema_migrations")
+	}
+
+	// Migrating adopts the legacy schema rather than re-running 00_init
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatalf("Up: unexpected error: %s", err.Error())
+	}
+	if len(applied) != 2 || applied[0].Version != 1 {
+		t.Errorf("Expected Up to apply versions 1 and 2, applied %v", applied)
+	}
+	expectVersion(t, m, 2)
+}
@@ -5,6 +5,7 @@ package mocks
 import db "github.com/r-cbb/cbbpoll/internal/db"
 import mock "github.com/stretchr/testify/mock"
 import models "github.com/r-cbb/cbbpoll/internal/models"
+import time "time"
 
 // DBClient is an autogenerated mock type for the DBClient type
 type DBClient struct {
@@ -53,6 +54,20 @@ func (_m *DBClient) AddPoll(newPoll models.Poll) (models.Poll, error) {
 	return r0, r1
 }
 
+// AddRefreshToken provides a mock function with given fields: token
+func (_m *DBClient) AddRefreshToken(token models.RefreshToken) error {
+	ret := _m.Called(token)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(models.RefreshToken) error); ok {
+		r0 = rf(token)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // AddTeam provides a mock function with given fields: newTeam
 func (_m *DBClient) AddTeam(newTeam models.Team) (models.Team, error) {
 	ret := _m.Called(newTeam)
@@ -95,6 +110,20 @@ func (_m *DBClient) AddUser(newUser models.User) (models.User, error) {
 	return r0, r1
 }
 
+// AnonymizeUser provides a mock function with given fields: name, alias, deleteBallots
+func (_m *DBClient) AnonymizeUser(name string, alias string, deleteBallots []int64) error {
+	ret := _m.Called(name, alias, deleteBallots)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, []int64) error); ok {
+		r0 = rf(name, alias, deleteBallots)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Close provides a mock function with given fields:
 func (_m *DBClient) Close() error {
 	ret := _m.Called()
@@ -123,6 +152,29 @@ func (_m *DBClient) DeleteBallot(id int64) error {
 	return r0
 }
 
+// GetAuditLog provides a mock function with given fields: filter, sort
+func (_m *DBClient) GetAuditLog(filter []db.Filter, sort db.Sort) ([]models.AuditEntry, error) {
+	ret := _m.Called(filter, sort)
+
+	var r0 []models.AuditEntry
+	if rf, ok := ret.Get(0).(func([]db.Filter, db.Sort) []models.AuditEntry); ok {
+		r0 = rf(filter, sort)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.AuditEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func([]db.Filter, db.Sort) error); ok {
+		r1 = rf(filter, sort)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetBallot provides a mock function with given fields: id
 func (_m *DBClient) GetBallot(id int64) (models.Ballot, error) {
 	ret := _m.Called(id)
@@ -167,6 +219,52 @@ func (_m *DBClient) GetBallotsByPoll(poll models.Poll) ([]models.Ballot, error)
 	return r0, r1
 }
 
+// GetBallotsByUser provides a mock function with given fields: user
+func (_m *DBClient) GetBallotsByUser(user string) ([]models.Ballot, error) {
+	ret := _m.Called(user)
+
+	var r0 []models.Ballot
+	if rf, ok := ret.Get(0).(func(string) []models.Ballot); ok {
+		r0 = rf(user)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Ballot)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(user)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetFlairTeams provides a mock function with given fields:
+func (_m *DBClient) GetFlairTeams() (map[string]int64, error) {
+	ret := _m.Called()
+
+	var r0 map[string]int64
+	if rf, ok := ret.Get(0).(func() map[string]int64); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]int64)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetPoll provides a mock function with given fields: season, week
 func (_m *DBClient) GetPoll(season int, week int) (models.Poll, error) {
 	ret := _m.Called(season, week)
@@ -211,6 +309,50 @@ func (_m *DBClient) GetPolls(filter []db.Filter, sort db.Sort) ([]models.Poll, e
 	return r0, r1
 }
 
+// GetRefreshToken provides a mock function with given fields: hash
+func (_m *DBClient) GetRefreshToken(hash string) (models.RefreshToken, error) {
+	ret := _m.Called(hash)
+
+	var r0 models.RefreshToken
+	if rf, ok := ret.Get(0).(func(string) models.RefreshToken); ok {
+		r0 = rf(hash)
+	} else {
+		r0 = ret.Get(0).(models.RefreshToken)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(hash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRefreshTokens provides a mock function with given fields: user
+func (_m *DBClient) GetRefreshTokens(user string) ([]models.RefreshToken, error) {
+	ret := _m.Called(user)
+
+	var r0 []models.RefreshToken
+	if rf, ok := ret.Get(0).(func(string) []models.RefreshToken); ok {
+		r0 = rf(user)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.RefreshToken)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(user)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetResults provides a mock function with given fields: poll, includeProvisional
 func (_m *DBClient) GetResults(poll models.Poll, includeProvisional bool) ([]models.Result, error) {
 	ret := _m.Called(poll, includeProvisional)
@@ -345,6 +487,97 @@ func (_m *DBClient) GetUsers(filter []db.Filter, sort db.Sort) ([]models.User, e
 	return r0, r1
 }
 
+// IsAccessTokenRevoked provides a mock function with given fields: id
+func (_m *DBClient) IsAccessTokenRevoked(id string) (bool, error) {
+	ret := _m.Called(id)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(id)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RevokeAccessToken provides a mock function with given fields: id, expiresAt
+func (_m *DBClient) RevokeAccessToken(id string, expiresAt time.Time) error {
+	ret := _m.Called(id, expiresAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, time.Time) error); ok {
+		r0 = rf(id, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeRefreshToken provides a mock function with given fields: hash
+func (_m *DBClient) RevokeRefreshToken(hash string) error {
+	ret := _m.Called(hash)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(hash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeRefreshTokens provides a mock function with given fields: user
+func (_m *DBClient) RevokeRefreshTokens(user string) error {
+	ret := _m.Called(user)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(user)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RotateRefreshToken provides a mock function with given fields: oldHash, newToken
+func (_m *DBClient) RotateRefreshToken(oldHash string, newToken models.RefreshToken) error {
+	ret := _m.Called(oldHash, newToken)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, models.RefreshToken) error); ok {
+		r0 = rf(oldHash, newToken)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetFlairTeam provides a mock function with given fields: user, team
+func (_m *DBClient) SetFlairTeam(user string, team int64) error {
+	ret := _m.Called(user, team)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int64) error); ok {
+		r0 = rf(user, team)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetResults provides a mock function with given fields: poll, official, allBallots
 func (_m *DBClient) SetResults(poll models.Poll, official []models.Result, allBallots []models.Result) error {
 	ret := _m.Called(poll, official, allBallots)
@@ -400,3 +633,19 @@ func (_m *DBClient) UpdateUser(user models.User) error {
 
 	return r0
 }
+
+// WithAudit provides a mock function with given fields: entry
+func (_m *DBClient) WithAudit(entry models.AuditEntry) db.DBClient {
+	ret := _m.Called(entry)
+
+	var r0 db.DBClient
+	if rf, ok := ret.Get(0).(func(models.AuditEntry) db.DBClient); ok {
+		r0 = rf(entry)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(db.DBClient)
+		}
+	}
+
+	return r0
+}
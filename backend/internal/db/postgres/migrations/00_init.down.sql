@@ -0,0 +1,6 @@
+DROP TABLE result;
+DROP TABLE vote;
+DROP TABLE ballot;
+DROP TABLE poll;
+DROP TABLE users;
+DROP TABLE team;
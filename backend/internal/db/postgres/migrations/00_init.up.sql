@@ -0,0 +1,68 @@
+CREATE TABLE team
+(
+  id         BIGSERIAL PRIMARY KEY,
+  full_name  VARCHAR(64),
+  short_name VARCHAR(32),
+  nickname   VARCHAR(32),
+  conference VARCHAR(32),
+  slug       VARCHAR(32)
+);
+
+-- "user" is reserved in postgres, so the table and ballot column are named
+-- differently than in the sqlite schema.
+CREATE TABLE users
+(
+  nickname     VARCHAR(32),
+  is_admin     BOOLEAN,
+  is_voter     BOOLEAN,
+  primary_team BIGINT,
+  PRIMARY KEY (nickname),
+  FOREIGN KEY (primary_team) REFERENCES team (id)
+);
+
+CREATE TABLE poll
+(
+  season        INTEGER,
+  week          INTEGER,
+  week_name     VARCHAR(64),
+  open_time     TIMESTAMPTZ,
+  close_time    TIMESTAMPTZ,
+  last_modified TIMESTAMPTZ,
+  reddit_url    TEXT,
+  PRIMARY KEY (season, week)
+);
+
+CREATE TABLE ballot
+(
+  id           BIGSERIAL PRIMARY KEY,
+  poll_season  INTEGER,
+  poll_week    INTEGER,
+  updated_time TIMESTAMPTZ,
+  username     VARCHAR(32),
+  is_official  BOOLEAN,
+  FOREIGN KEY (poll_season, poll_week) REFERENCES poll (season, week),
+  FOREIGN KEY (username) REFERENCES users (nickname)
+);
+
+CREATE TABLE vote
+(
+  ballot_id BIGINT,
+  team_id   BIGINT,
+  rank      INTEGER,
+  reason    VARCHAR(150),
+  FOREIGN KEY (team_id) REFERENCES team (id)
+);
+
+CREATE TABLE result
+(
+  poll_season       INTEGER,
+  poll_week         INTEGER,
+  team_id           BIGINT,
+  team_name         VARCHAR(64),
+  team_slug         VARCHAR(32),
+  rank              INTEGER,
+  first_place_votes INTEGER,
+  points            INTEGER,
+  official          BOOLEAN,
+  FOREIGN KEY (poll_season, poll_week) REFERENCES poll (season, week)
+);
@@ -0,0 +1,11 @@
+ALTER TABLE ballot
+  DROP CONSTRAINT ballot_user_poll_key;
+
+ALTER TABLE vote
+  DROP CONSTRAINT vote_ballot_id_fkey,
+  DROP CONSTRAINT vote_rank_check,
+  DROP CONSTRAINT vote_ballot_team_key,
+  DROP CONSTRAINT vote_pkey,
+  ALTER COLUMN rank DROP NOT NULL,
+  ALTER COLUMN team_id DROP NOT NULL,
+  ALTER COLUMN ballot_id DROP NOT NULL;
@@ -0,0 +1,15 @@
+-- Votes whose ballot is gone are unreachable, so they're dropped rather than
+-- failing the foreign key.
+DELETE FROM vote WHERE ballot_id NOT IN (SELECT id FROM ballot);
+
+ALTER TABLE vote
+  ALTER COLUMN ballot_id SET NOT NULL,
+  ALTER COLUMN team_id SET NOT NULL,
+  ALTER COLUMN rank SET NOT NULL,
+  ADD CONSTRAINT vote_pkey PRIMARY KEY (ballot_id, rank),
+  ADD CONSTRAINT vote_ballot_team_key UNIQUE (ballot_id, team_id),
+  ADD CONSTRAINT vote_rank_check CHECK (rank BETWEEN 1 AND 25),
+  ADD CONSTRAINT vote_ballot_id_fkey FOREIGN KEY (ballot_id) REFERENCES ballot (id) ON DELETE CASCADE;
+
+ALTER TABLE ballot
+  ADD CONSTRAINT ballot_user_poll_key UNIQUE (username, poll_season, poll_week);
@@ -0,0 +1,11 @@
+CREATE TABLE user_role
+(
+  username VARCHAR(32) NOT NULL,
+  role     VARCHAR(32) NOT NULL,
+  PRIMARY KEY (username, role),
+  FOREIGN KEY (username) REFERENCES users (nickname) ON DELETE CASCADE
+);
+
+-- is_admin is kept in step with the admin role for older builds
+INSERT INTO user_role (username, role)
+SELECT nickname, 'admin' FROM users WHERE is_admin;
@@ -0,0 +1,21 @@
+CREATE TABLE refresh_token
+(
+  hash       VARCHAR(64) NOT NULL,
+  username   VARCHAR(32) NOT NULL,
+  issued_at  TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked    BOOLEAN     NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (hash),
+  FOREIGN KEY (username) REFERENCES users (nickname) ON DELETE CASCADE
+);
+
+CREATE INDEX refresh_token_username ON refresh_token (username);
+
+-- Access tokens that were revoked before they expired.  Rows are only needed
+-- until expires_at, after which the token is rejected anyway.
+CREATE TABLE revoked_token
+(
+  id         VARCHAR(64) NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (id)
+);
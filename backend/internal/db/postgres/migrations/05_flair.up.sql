@@ -0,0 +1,10 @@
+-- The team each user's subreddit flair was last set for, so flair is only
+-- set again when their primary team changes.
+CREATE TABLE user_flair
+(
+  username VARCHAR(32) NOT NULL,
+  team_id  BIGINT      NOT NULL,
+  PRIMARY KEY (username),
+  FOREIGN KEY (username) REFERENCES users (nickname) ON DELETE CASCADE,
+  FOREIGN KEY (team_id) REFERENCES team (id) ON DELETE CASCADE
+);
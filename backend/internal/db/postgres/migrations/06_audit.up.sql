@@ -0,0 +1,26 @@
+-- Privileged changes, each written in the same transaction as the change.
+-- actor isn't a foreign key, since the log outlives the users in it.
+CREATE TABLE audit_log
+(
+  id          BIGSERIAL PRIMARY KEY,
+  time        TIMESTAMPTZ NOT NULL,
+  actor       VARCHAR(32) NOT NULL,
+  action      VARCHAR(32) NOT NULL,
+  target_type VARCHAR(16) NOT NULL,
+  target_id   VARCHAR(64) NOT NULL,
+  before      JSONB,
+  after       JSONB,
+  request_id  VARCHAR(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_log_time ON audit_log (time);
+
+-- The log is append-only
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log
+  FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
@@ -0,0 +1,5 @@
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,11 @@
+-- Deleting a user replaces their name throughout the audit log.  The log can
+-- only be updated while cbbpoll.redacting_audit is on, which AnonymizeUser sets
+-- for the rest of its transaction; deletes are still refused.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' AND current_setting('cbbpoll.redacting_audit', TRUE) = 'on' THEN
+    RETURN NEW;
+  END IF;
+  RAISE EXCEPTION 'the audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -3,26 +3,43 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"embed"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 
 	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/db/migrate"
 	"github.com/r-cbb/cbbpoll/internal/errors"
 	"github.com/r-cbb/cbbpoll/internal/models"
 )
 
 type Client struct {
 	db *sqlx.DB
+
+	// audit is written with the next audited change, if set
+	audit *models.AuditEntry
 }
 
 func (c *Client) Close() error {
 	return c.db.Close()
 }
 
+// Ping checks that the database can still be reached.
+func (c *Client) Ping(ctx context.Context) error {
+	const op errors.Op = "sqlite.Ping"
+
+	err := c.db.PingContext(ctx)
+	if err != nil {
+		return errors.E(op, err, "error connecting to database", errors.KindDatabaseError)
+	}
+
+	return nil
+}
+
 func NewClient(filename string) (*Client, error) {
 	const op errors.Op = "sqlite.NewClient"
 
@@ -39,23 +56,85 @@ func NewClient(filename string) (*Client, error) {
 	return &Client{db: sqliteDb}, nil
 }
 
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrator returns a Migrator for the sqlite schema.  Databases created from
+// 00_init.sql before migrations were tracked are recorded as being at version 0
+// the first time they're migrated up or down, rather than having the init
+// script re-run.
+func (c *Client) Migrator() (*migrate.Migrator, error) {
+	const op errors.Op = "sqlite.Migrator"
+
+	ms, err := migrate.Load(migrationFiles, "migrations")
+	if err != nil {
+		return nil, errors.E(op, err, "error loading migrations")
+	}
+	m := migrate.New(c.db, ms)
+	m.AdoptLegacySchema("team")
+
+	return m, nil
+}
+
+// constraintKind classifies a database error: KindConflict for a duplicate
+// key, KindBadRequest for any other constraint violation (a missing referenced
+// row, an out of range value), and KindDatabaseError for everything else.
+func constraintKind(err error) errors.Code {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if !ok || sqliteErr.Code != sqlite3.ErrConstraint {
+		return errors.KindDatabaseError
+	}
+
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return errors.KindConflict
+	default:
+		return errors.KindBadRequest
+	}
+}
+
 func (c *Client) AddTeam(newTeam models.Team) (team models.Team, err error) {
 	const op errors.Op = "sqlite.AddTeam"
 	var t Team
 	t.fromContract(newTeam)
 
-	res, err := c.db.Exec("INSERT INTO team (full_name, short_name, nickname, conference, slug) VALUES ($1, $2, $3, $4, $5)",
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return models.Team{}, errors.E(op, err, "error creating transaction", errors.KindDatabaseError)
+	}
+
+	res, err := tx.Exec("INSERT INTO team (full_name, short_name, nickname, conference, slug) VALUES ($1, $2, $3, $4, $5)",
 		t.FullName, t.ShortName, t.Nickname, t.Conference, t.Slug)
 
 	if err != nil {
+		_ = tx.Rollback()
 		return models.Team{}, errors.E(op, err, "error adding team to db", errors.KindDatabaseError)
 	}
 
 	t.ID, err = res.LastInsertId()
 	if err != nil {
+		_ = tx.Rollback()
 		return models.Team{}, errors.E(op, err, "error getting id for new team", errors.KindDatabaseError)
 	}
 
+	if c.audit != nil {
+		entry, err := db.AuditCreated(*c.audit, t.ID, t.toContract())
+		if err != nil {
+			_ = tx.Rollback()
+			return models.Team{}, errors.E(op, err, "error recording team in audit entry")
+		}
+		err = addAuditEntry(tx, entry)
+		if err != nil {
+			_ = tx.Rollback()
+			return models.Team{}, errors.E(op, err, "error writing audit log")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return models.Team{}, errors.E(op, err, "error committing transaction", errors.KindDatabaseError)
+	}
+
 	return t.toContract(), nil
 }
 
@@ -65,6 +144,9 @@ func (c *Client) GetTeam(id int64) (team models.Team, err error) {
 	err = c.db.Get(&t, "SELECT * FROM team WHERE id = ?", id)
 
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.Team{}, errors.E(op, err, "team not found", errors.KindNotFound)
+		}
 		return models.Team{}, errors.E(op, err, "error retrieving team from db", errors.KindDatabaseError)
 	}
 
@@ -88,8 +170,65 @@ func (c *Client) GetTeams() (teams []models.Team, err error) {
 	return cs, nil
 }
 
+// userRole is a row of user_role.
+type userRole struct {
+	User string      `db:"user"`
+	Role models.Role `db:"role"`
+}
+
+func getRoles(q sqlx.Queryer, name string) ([]models.Role, error) {
+	var roles []models.Role
+	err := sqlx.Select(q, &roles, "SELECT role FROM user_role WHERE user = ?", name)
+	return roles, err
+}
+
+// setRoles replaces the roles granted to name.
+func setRoles(tx *sqlx.Tx, name string, roles []models.Role) error {
+	_, err := tx.Exec("DELETE FROM user_role WHERE user = ?", name)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range roles {
+		_, err = tx.Exec("INSERT INTO user_role (user, role) VALUES (?, ?)", name, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ineligibleUser is a row of ineligible_user.
+type ineligibleUser struct {
+	User   string `db:"user"`
+	Reason string `db:"reason"`
+}
+
+// getIneligibleReason returns why name can't vote, which is empty if they can.
+func getIneligibleReason(q sqlx.Queryer, name string) (string, error) {
+	var reason string
+	err := sqlx.Get(q, &reason, "SELECT reason FROM ineligible_user WHERE user = ?", name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return reason, err
+}
+
+// setIneligibleReason records why name can't vote, or that they can if reason is empty.
+func setIneligibleReason(tx *sqlx.Tx, name string, reason string) error {
+	_, err := tx.Exec("DELETE FROM ineligible_user WHERE user = ?", name)
+	if err != nil || reason == "" {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO ineligible_user (user, reason) VALUES (?, ?)", name, reason)
+	return err
+}
+
 func (c *Client) AddUser(newUser models.User) (models.User, error) {
 	const op errors.Op = "sqlite.AddUser"
+	newUser = newUser.WithNormalizedRoles()
 	var u User
 	u.fromContract(newUser)
 
@@ -111,7 +250,25 @@ func (c *Client) AddUser(newUser models.User) (models.User, error) {
 	_, err = tx.Exec("INSERT INTO user (nickname, is_admin, is_voter, primary_team) VALUES ($1, $2, $3, $4)", u.Nickname, u.IsAdmin, u.IsVoter, u.PrimaryTeam)
 	if err != nil {
 		_ = tx.Rollback()
-		return models.User{}, errors.E(op, err, "error adding user to db", errors.KindDatabaseError)
+		return models.User{}, errors.E(op, err, "error adding user to db", constraintKind(err))
+	}
+
+	err = setRoles(tx, u.Nickname, newUser.Roles)
+	if err != nil {
+		_ = tx.Rollback()
+		return models.User{}, errors.E(op, err, "error adding user's roles to db", constraintKind(err))
+	}
+
+	err = setIneligibleReason(tx, u.Nickname, newUser.IneligibleReason)
+	if err != nil {
+		_ = tx.Rollback()
+		return models.User{}, errors.E(op, err, "error adding user's eligibility to db", constraintKind(err))
+	}
+
+	err = c.writeAudit(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return models.User{}, errors.E(op, err, "error writing audit log")
 	}
 
 	err = tx.Commit()
@@ -119,17 +276,47 @@ func (c *Client) AddUser(newUser models.User) (models.User, error) {
 		return models.User{}, errors.E(op, err, "error committing transaction", errors.KindDatabaseError)
 	}
 
-	return u.toContract(), nil
+	return newUser, nil
 }
 
 func (c *Client) UpdateUser(user models.User) error {
 	const op errors.Op = "sqlite.UpdateUser"
+	user = user.WithNormalizedRoles()
 	var u User
 	u.fromContract(user)
 
-	_, err := c.db.Exec("UPDATE user SET is_admin = $1, is_voter = $2, primary_team = $3 WHERE nickname = ?", u.IsAdmin, u.IsVoter, u.PrimaryTeam, u.Nickname)
+	tx, err := c.db.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return errors.E(op, err, "error creating transaction", errors.KindDatabaseError)
+	}
+
+	_, err = tx.Exec("UPDATE user SET is_admin = $1, is_voter = $2, primary_team = $3 WHERE nickname = ?", u.IsAdmin, u.IsVoter, u.PrimaryTeam, u.Nickname)
 	if err != nil {
-		return errors.E(op, err, "err updating user", errors.KindDatabaseError)
+		_ = tx.Rollback()
+		return errors.E(op, err, "err updating user", constraintKind(err))
+	}
+
+	err = setRoles(tx, u.Nickname, user.Roles)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error updating user's roles", constraintKind(err))
+	}
+
+	err = setIneligibleReason(tx, u.Nickname, user.IneligibleReason)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error updating user's eligibility", constraintKind(err))
+	}
+
+	err = c.writeAudit(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error writing audit log")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.E(op, err, "error committing transaction", errors.KindDatabaseError)
 	}
 
 	return nil
@@ -147,7 +334,105 @@ func (c *Client) GetUser(name string) (models.User, error) {
 		return models.User{}, errors.E(op, err, "error retrieving user", errors.KindDatabaseError)
 	}
 
-	return u.toContract(), nil
+	cu := u.toContract()
+	cu.Roles, err = getRoles(c.db, name)
+	if err != nil {
+		return models.User{}, errors.E(op, err, "error retrieving user's roles", errors.KindDatabaseError)
+	}
+
+	cu.IneligibleReason, err = getIneligibleReason(c.db, name)
+	if err != nil {
+		return models.User{}, errors.E(op, err, "error retrieving user's eligibility", errors.KindDatabaseError)
+	}
+
+	return cu.WithNormalizedRoles(), nil
+}
+
+func (c *Client) AnonymizeUser(name string, alias string, deleteBallots []int64) error {
+	const op errors.Op = "sqlite.AnonymizeUser"
+
+	tx, err := c.db.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return errors.E(op, err, "error creating transaction", errors.KindDatabaseError)
+	}
+
+	var tmp User
+	err = tx.Get(&tmp, "SELECT * FROM user WHERE nickname = ?", name)
+	if err == sql.ErrNoRows {
+		_ = tx.Rollback()
+		return errors.E(op, err, "user doesn't exist", errors.KindNotFound)
+	} else if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error retrieving user", errors.KindDatabaseError)
+	}
+
+	for _, id := range deleteBallots {
+		var b Ballot
+		err = tx.Get(&b, "SELECT * FROM ballot WHERE id = ? AND user = ?", id, name)
+		if err == sql.ErrNoRows {
+			_ = tx.Rollback()
+			return errors.E(op, err, fmt.Sprintf("user has no ballot %d", id), errors.KindNotFound)
+		} else if err != nil {
+			_ = tx.Rollback()
+			return errors.E(op, err, "error retrieving ballot", errors.KindDatabaseError)
+		}
+
+		err = invalidateResults(tx, b.PollSeason, b.PollWeek)
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.E(op, err, "error invalidating poll results")
+		}
+
+		err = deleteBallotAndVotes(tx, id)
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.E(op, err, "error deleting user's ballot")
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO user (nickname, is_admin, is_voter) VALUES (?, FALSE, FALSE)", alias)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error adding anonymous user to db", constraintKind(err))
+	}
+
+	_, err = tx.Exec("UPDATE vote SET reason = '' WHERE ballot_id IN (SELECT id FROM ballot WHERE user = ?)", name)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error removing reasons from user's votes", errors.KindDatabaseError)
+	}
+
+	_, err = tx.Exec("UPDATE ballot SET user = ? WHERE user = ?", alias, name)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error moving user's ballots", errors.KindDatabaseError)
+	}
+
+	// Roles, sessions, eligibility and flair go with the user through ON DELETE CASCADE
+	_, err = tx.Exec("DELETE FROM user WHERE nickname = ?", name)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error deleting user", constraintKind(err))
+	}
+
+	err = redactAudit(tx, name, alias)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error removing user from audit log")
+	}
+
+	err = c.writeAudit(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error writing audit log")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.E(op, err, "error committing transaction", errors.KindDatabaseError)
+	}
+
+	return nil
 }
 
 func (c *Client) AddPoll(newPoll models.Poll) (models.Poll, error) {
@@ -174,7 +459,13 @@ func (c *Client) AddPoll(newPoll models.Poll) (models.Poll, error) {
 		p.Season, p.Week, p.WeekName, p.OpenTime, p.CloseTime, p.LastModified, p.RedditURL)
 	if err != nil {
 		_ = tx.Rollback()
-		return models.Poll{}, errors.E(op, err, "error adding poll to db", errors.KindDatabaseError)
+		return models.Poll{}, errors.E(op, err, "error adding poll to db", constraintKind(err))
+	}
+
+	err = c.writeAudit(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return models.Poll{}, errors.E(op, err, "error writing audit log")
 	}
 
 	err = tx.Commit()
@@ -223,7 +514,7 @@ func addBallotAndVotes(tx *sqlx.Tx, b Ballot, vs []Vote) (Ballot, error) {
 
 	res, err := tx.Exec(query, args...)
 	if err != nil {
-		return Ballot{}, errors.E(op, err, "error adding ballot to db", errors.KindDatabaseError)
+		return Ballot{}, errors.E(op, err, "error adding ballot to db", constraintKind(err))
 	}
 
 	b.ID, err = res.LastInsertId()
@@ -234,7 +525,7 @@ func addBallotAndVotes(tx *sqlx.Tx, b Ballot, vs []Vote) (Ballot, error) {
 	for _, v := range vs {
 		_, err = tx.Exec("INSERT INTO vote (ballot_id, team_id, rank, reason) VALUES ($1, $2, $3, $4)", b.ID, v.TeamID, v.Rank, v.Reason)
 		if err != nil {
-			return Ballot{}, errors.E(op, err, "error adding votes to db", errors.KindDatabaseError)
+			return Ballot{}, errors.E(op, err, "error adding votes to db", constraintKind(err))
 		}
 	}
 
@@ -254,7 +545,7 @@ func (c *Client) AddBallot(newBallot models.Ballot) (models.Ballot, error) {
 	b, err = addBallotAndVotes(tx, b, vs)
 	if err != nil {
 		_ = tx.Rollback()
-		return models.Ballot{}, errors.E(op, err, "error during ballot creation", errors.KindDatabaseError)
+		return models.Ballot{}, errors.E(op, err, "error during ballot creation")
 	}
 
 	err = invalidateResults(tx, b.PollSeason, b.PollWeek)
@@ -316,6 +607,9 @@ func (c *Client) GetTeamsByID(ids []int64) ([]models.Team, error) {
 	for i := range ids {
 		err := c.db.Get(&ts[i], "SELECT * FROM team WHERE id = ?", ids[i])
 		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errors.E(op, err, fmt.Sprintf("team %d not found", ids[i]), errors.KindNotFound)
+			}
 			return nil, errors.E(op, err, "error retrieving team from db", errors.KindDatabaseError)
 		}
 		cts[i] = ts[i].toContract()
@@ -328,26 +622,42 @@ func (c *Client) GetUsers(filter []db.Filter, sort db.Sort) ([]models.User, erro
 	const op errors.Op = "sqlite.GetUsers"
 	var us []User
 
-	query := "SELECT * FROM user"
-	var args []interface{}
-
-	for i, f := range filter {
-		if i == 0 {
-			query += fmt.Sprintf(" WHERE %s %s ?", f.Field, f.Operator)
-		} else {
-			query += fmt.Sprintf(" AND %s %s ?", f.Field, f.Operator)
-		}
-		args = append(args, f.Value)
+	where, args, err := db.UserFields.Where(filter)
+	if err != nil {
+		return nil, errors.E(op, err, "invalid user filter")
 	}
 
-	err := c.db.Select(&us, query, args...)
+	err = c.db.Select(&us, "SELECT * FROM user"+where, args...)
 	if err != nil {
 		return nil, errors.E(op, err, "error retrieving users", errors.KindDatabaseError)
 	}
 
+	var urs []userRole
+	err = c.db.Select(&urs, "SELECT user, role FROM user_role")
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving users' roles", errors.KindDatabaseError)
+	}
+	roles := make(map[string][]models.Role)
+	for _, ur := range urs {
+		roles[ur.User] = append(roles[ur.User], ur.Role)
+	}
+
+	var ius []ineligibleUser
+	err = c.db.Select(&ius, "SELECT user, reason FROM ineligible_user")
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving users' eligibility", errors.KindDatabaseError)
+	}
+	reasons := make(map[string]string)
+	for _, iu := range ius {
+		reasons[iu.User] = iu.Reason
+	}
+
 	cus := make([]models.User, len(us))
 	for i := range us {
 		cus[i] = us[i].toContract()
+		cus[i].Roles = roles[cus[i].Nickname]
+		cus[i].IneligibleReason = reasons[cus[i].Nickname]
+		cus[i] = cus[i].WithNormalizedRoles()
 	}
 
 	return cus, nil
@@ -367,8 +677,13 @@ func (c *Client) UpdatePoll(poll models.Poll) error {
 		return errors.E(op, err, "error updating poll", errors.KindDatabaseError)
 	}
 
-	if aff, err := res.RowsAffected(); aff != 0 || err != nil {
-		return errors.E(op, err, "poll not found to update", errors.KindNotFound)
+	aff, err := res.RowsAffected()
+	if err != nil {
+		return errors.E(op, err, "error getting affected rows", errors.KindDatabaseError)
+	}
+
+	if aff == 0 {
+		return errors.E(op, "poll not found to update", errors.KindNotFound)
 	}
 
 	return nil
@@ -378,19 +693,12 @@ func (c *Client) GetPolls(filter []db.Filter, sort db.Sort) ([]models.Poll, erro
 	const op errors.Op = "sqlite.GetPolls"
 	var ps []Poll
 
-	query := "SELECT * FROM poll"
-	var args []interface{}
-
-	for i, f := range filter {
-		if i == 0 {
-			query += fmt.Sprintf(" WHERE %s %s ?", f.Field, f.Operator)
-		} else {
-			query += fmt.Sprintf(" AND %s %s ?", f.Field, f.Operator)
-		}
-		args = append(args, f.Value)
+	where, args, err := db.PollFields.Where(filter)
+	if err != nil {
+		return nil, errors.E(op, err, "invalid poll filter")
 	}
 
-	err := c.db.Select(&ps, query, args...)
+	err = c.db.Select(&ps, "SELECT * FROM poll"+where, args...)
 	if err != nil {
 		return nil, errors.E(op, err, "error retrieving polls", errors.KindDatabaseError)
 	}
@@ -507,14 +815,11 @@ func (c *Client) GetBallotsByPoll(poll models.Poll) ([]models.Ballot, error) {
 	return cbs, nil
 }
 
+// deleteBallotAndVotes removes a ballot; its votes are removed along with it by
+// the ON DELETE CASCADE on vote.ballot_id.
 func deleteBallotAndVotes(tx *sqlx.Tx, id int64) error {
 	const op errors.Op = "sqlite.deleteBallotAndVotes"
 
-	_, err := tx.Exec("DELETE FROM vote WHERE ballot_id = ?", id)
-	if err != nil {
-		return errors.E(op, err, "error deleting votes", errors.KindDatabaseError)
-	}
-
 	res, err := tx.Exec("DELETE FROM ballot WHERE id = ?", id)
 	if err != nil {
 		return errors.E(op, err, "error deleting ballot", errors.KindDatabaseError)
@@ -532,6 +837,27 @@ func deleteBallotAndVotes(tx *sqlx.Tx, id int64) error {
 	return nil
 }
 
+func (c *Client) GetBallotsByUser(user string) ([]models.Ballot, error) {
+	const op errors.Op = "sqlite.GetBallotsByUser"
+	var bs []Ballot
+
+	err := c.db.Select(&bs, "SELECT * FROM ballot WHERE user = ? ORDER BY poll_season, poll_week", user)
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving ballots for user", errors.KindDatabaseError)
+	}
+
+	cbs := make([]models.Ballot, len(bs))
+	for i := range bs {
+		cb, err := bs[i].toContract(c)
+		if err != nil {
+			return nil, errors.E(op, err, "error converting ballots to contracts", errors.KindDatabaseError)
+		}
+		cbs[i] = cb
+	}
+
+	return cbs, nil
+}
+
 func (c *Client) DeleteBallot(id int64) error {
 	const op errors.Op = "sqlite.DeleteBallot"
 
@@ -564,6 +890,12 @@ func (c *Client) DeleteBallot(id int64) error {
 		return errors.E(op, err, "error during ballot deletion")
 	}
 
+	err = c.writeAudit(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error writing audit log")
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return errors.E(op, err, "error committing transaction", errors.KindDatabaseError)
@@ -605,3 +937,275 @@ func (c *Client) UpdateBallot(ballot models.Ballot) error {
 
 	return nil
 }
+
+func addRefreshToken(e sqlx.Execer, t RefreshToken) error {
+	_, err := e.Exec("INSERT INTO refresh_token (hash, user, issued_at, expires_at, revoked) VALUES ($1, $2, $3, $4, $5)",
+		t.Hash, t.User, t.IssuedAt, t.ExpiresAt, t.Revoked)
+	return err
+}
+
+func (c *Client) AddRefreshToken(token models.RefreshToken) error {
+	const op errors.Op = "sqlite.AddRefreshToken"
+	var t RefreshToken
+	t.fromContract(token)
+
+	// Expired tokens are of no use to anyone, so clear out the user's old ones
+	_, err := c.db.Exec("DELETE FROM refresh_token WHERE user = $1 AND expires_at < $2", t.User, time.Now().UTC())
+	if err != nil {
+		return errors.E(op, err, "error removing expired refresh tokens", errors.KindDatabaseError)
+	}
+
+	err = addRefreshToken(c.db, t)
+	if err != nil {
+		return errors.E(op, err, "error adding refresh token to db", constraintKind(err))
+	}
+
+	return nil
+}
+
+func (c *Client) GetRefreshToken(hash string) (models.RefreshToken, error) {
+	const op errors.Op = "sqlite.GetRefreshToken"
+	var t RefreshToken
+	err := c.db.Get(&t, "SELECT * FROM refresh_token WHERE hash = $1", hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return models.RefreshToken{}, errors.E(op, err, "refresh token not found", errors.KindNotFound)
+		}
+		return models.RefreshToken{}, errors.E(op, err, "error retrieving refresh token", errors.KindDatabaseError)
+	}
+
+	return t.toContract(), nil
+}
+
+func (c *Client) GetRefreshTokens(user string) ([]models.RefreshToken, error) {
+	const op errors.Op = "sqlite.GetRefreshTokens"
+	var ts []RefreshToken
+	err := c.db.Select(&ts, "SELECT * FROM refresh_token WHERE user = $1 ORDER BY issued_at", user)
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving user's refresh tokens", errors.KindDatabaseError)
+	}
+
+	cts := make([]models.RefreshToken, len(ts))
+	for i := range ts {
+		cts[i] = ts[i].toContract()
+	}
+
+	return cts, nil
+}
+
+func (c *Client) RotateRefreshToken(oldHash string, newToken models.RefreshToken) error {
+	const op errors.Op = "sqlite.RotateRefreshToken"
+	var t RefreshToken
+	t.fromContract(newToken)
+
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return errors.E(op, err, "error creating transaction", errors.KindDatabaseError)
+	}
+
+	res, err := tx.Exec("UPDATE refresh_token SET revoked = TRUE WHERE hash = $1 AND NOT revoked", oldHash)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error revoking refresh token", errors.KindDatabaseError)
+	}
+
+	aff, err := res.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error getting affected rows", errors.KindDatabaseError)
+	}
+
+	if aff == 0 {
+		_ = tx.Rollback()
+		return errors.E(op, "refresh token missing or already revoked", errors.KindConflict)
+	}
+
+	err = addRefreshToken(tx, t)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.E(op, err, "error adding refresh token to db", constraintKind(err))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.E(op, err, "error committing transaction", errors.KindDatabaseError)
+	}
+
+	return nil
+}
+
+func (c *Client) RevokeRefreshToken(hash string) error {
+	const op errors.Op = "sqlite.RevokeRefreshToken"
+	_, err := c.db.Exec("UPDATE refresh_token SET revoked = TRUE WHERE hash = $1", hash)
+	if err != nil {
+		return errors.E(op, err, "error revoking refresh token", errors.KindDatabaseError)
+	}
+
+	return nil
+}
+
+func (c *Client) RevokeRefreshTokens(user string) error {
+	const op errors.Op = "sqlite.RevokeRefreshTokens"
+	_, err := c.db.Exec("UPDATE refresh_token SET revoked = TRUE WHERE user = $1", user)
+	if err != nil {
+		return errors.E(op, err, "error revoking user's refresh tokens", errors.KindDatabaseError)
+	}
+
+	return nil
+}
+
+func (c *Client) RevokeAccessToken(id string, expiresAt time.Time) error {
+	const op errors.Op = "sqlite.RevokeAccessToken"
+
+	// Tokens past their expiry are rejected without consulting the list
+	_, err := c.db.Exec("DELETE FROM revoked_token WHERE expires_at < $1", time.Now().UTC())
+	if err != nil {
+		return errors.E(op, err, "error removing expired tokens", errors.KindDatabaseError)
+	}
+
+	_, err = c.db.Exec("INSERT OR IGNORE INTO revoked_token (id, expires_at) VALUES ($1, $2)", id, expiresAt.UTC())
+	if err != nil {
+		return errors.E(op, err, "error revoking access token", errors.KindDatabaseError)
+	}
+
+	return nil
+}
+
+func (c *Client) IsAccessTokenRevoked(id string) (bool, error) {
+	const op errors.Op = "sqlite.IsAccessTokenRevoked"
+	var n int
+	err := c.db.Get(&n, "SELECT COUNT(*) FROM revoked_token WHERE id = $1", id)
+	if err != nil {
+		return false, errors.E(op, err, "error checking revoked tokens", errors.KindDatabaseError)
+	}
+
+	return n > 0, nil
+}
+
+func (c *Client) GetFlairTeams() (map[string]int64, error) {
+	const op errors.Op = "sqlite.GetFlairTeams"
+	var rows []struct {
+		User   string `db:"user"`
+		TeamID int64  `db:"team_id"`
+	}
+	err := c.db.Select(&rows, "SELECT user, team_id FROM user_flair")
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving flair teams", errors.KindDatabaseError)
+	}
+
+	teams := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		teams[r.User] = r.TeamID
+	}
+
+	return teams, nil
+}
+
+func (c *Client) SetFlairTeam(user string, team int64) error {
+	const op errors.Op = "sqlite.SetFlairTeam"
+	var err error
+	if team == 0 {
+		_, err = c.db.Exec("DELETE FROM user_flair WHERE user = $1", user)
+	} else {
+		_, err = c.db.Exec("INSERT OR REPLACE INTO user_flair (user, team_id) VALUES ($1, $2)", user, team)
+	}
+	if err != nil {
+		return errors.E(op, err, "error recording flair team", errors.KindDatabaseError)
+	}
+
+	return nil
+}
+
+// WithAudit returns a client that writes entry to the audit log along with the
+// next audited change made through it.
+func (c *Client) WithAudit(entry models.AuditEntry) db.DBClient {
+	return &Client{db: c.db, audit: &entry}
+}
+
+// writeAudit adds the client's audit entry, if it has one, within tx.
+func (c *Client) writeAudit(tx *sqlx.Tx) error {
+	if c.audit == nil {
+		return nil
+	}
+	return addAuditEntry(tx, *c.audit)
+}
+
+func addAuditEntry(tx *sqlx.Tx, entry models.AuditEntry) error {
+	const op errors.Op = "sqlite.addAuditEntry"
+	var a AuditEntry
+	a.fromContract(entry)
+
+	_, err := tx.Exec("INSERT INTO audit_log (time, actor, action, target_type, target_id, before, after, request_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		a.Time, a.Actor, a.Action, a.TargetType, a.TargetID, a.Before, a.After, a.RequestID)
+	if err != nil {
+		return errors.E(op, err, "error adding audit entry", errors.KindDatabaseError)
+	}
+
+	return nil
+}
+
+// redactAudit replaces the user called name with alias throughout the audit
+// log.  The log's trigger allows updates only while audit_redaction has a row,
+// which is added and removed again within tx.
+func redactAudit(tx *sqlx.Tx, name string, alias string) error {
+	const op errors.Op = "sqlite.redactAudit"
+
+	var as []AuditEntry
+	pattern := "%" + name + "%"
+	err := tx.Select(&as, "SELECT * FROM audit_log WHERE actor = ? OR target_id = ? OR before LIKE ? OR after LIKE ?", name, name, pattern, pattern)
+	if err != nil {
+		return errors.E(op, err, "error retrieving audit entries", errors.KindDatabaseError)
+	}
+
+	_, err = tx.Exec("INSERT INTO audit_redaction (redacting) VALUES (TRUE)")
+	if err != nil {
+		return errors.E(op, err, "error starting redaction", errors.KindDatabaseError)
+	}
+
+	for _, a := range as {
+		entry, changed, err := db.RedactAudit(a.toContract(), name, alias)
+		if err != nil {
+			return errors.E(op, err, "error redacting audit entry")
+		}
+		if !changed {
+			continue
+		}
+
+		var r AuditEntry
+		r.fromContract(entry)
+		_, err = tx.Exec("UPDATE audit_log SET actor = ?, target_id = ?, before = ?, after = ? WHERE id = ?",
+			r.Actor, r.TargetID, r.Before, r.After, r.ID)
+		if err != nil {
+			return errors.E(op, err, "error updating audit entry", errors.KindDatabaseError)
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM audit_redaction")
+	if err != nil {
+		return errors.E(op, err, "error finishing redaction", errors.KindDatabaseError)
+	}
+
+	return nil
+}
+
+func (c *Client) GetAuditLog(filter []db.Filter, sort db.Sort) ([]models.AuditEntry, error) {
+	const op errors.Op = "sqlite.GetAuditLog"
+	var as []AuditEntry
+
+	where, args, err := db.AuditFields.Where(filter)
+	if err != nil {
+		return nil, errors.E(op, err, "invalid audit filter")
+	}
+
+	err = c.db.Select(&as, "SELECT * FROM audit_log"+where+" ORDER BY id DESC", args...)
+	if err != nil {
+		return nil, errors.E(op, err, "error retrieving audit log", errors.KindDatabaseError)
+	}
+
+	cas := make([]models.AuditEntry, len(as))
+	for i := range as {
+		cas[i] = as[i].toContract()
+	}
+
+	return cas, nil
+}
@@ -0,0 +1,196 @@
+package sqlite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/db/dbtest"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "cbbpoll-sqlite")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	c, err := NewClient(filepath.Join(dir, "cbbpoll.db"))
+	if err != nil {
+		t.Fatalf("error creating client: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	m, err := c.Migrator()
+	if err != nil {
+		t.Fatalf("error creating migrator: %s", err.Error())
+	}
+
+	_, err = m.Up()
+	if err != nil {
+		t.Fatalf("error applying migrations: %s", err.Error())
+	}
+
+	return c
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.RunSuite(t, func(t *testing.T) db.DBClient {
+		return newTestClient(t)
+	})
+}
+
+func TestMigratorAdoptsExistingSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cbbpoll-sqlite")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewClient(filepath.Join(dir, "cbbpoll.db"))
+	if err != nil {
+		t.Fatalf("error creating client: %s", err.Error())
+	}
+	defer c.Close()
+
+	// Schema created by hand, the way databases were set up before migrations
+	schema, err := migrationFiles.ReadFile("migrations/00_init.up.sql")
+	if err != nil {
+		t.Fatalf("error reading schema: %s", err.Error())
+	}
+	if _, err = c.db.Exec(string(schema)); err != nil {
+		t.Fatalf("error applying schema: %s", err.Error())
+	}
+
+	m, err := c.Migrator()
+	if err != nil {
+		t.Fatalf("error creating migrator: %s", err.Error())
+	}
+
+	// Reading the status leaves the database untouched
+	if _, err = m.Status(); err != nil {
+		t.Fatalf("error getting status: %s", err.Error())
+	}
+	unversioned, err := m.Unversioned()
+	if err != nil {
+		t.Fatalf("error checking version: %s", err.Error())
+	}
+	if !unversioned {
+		t.Errorf("Expected existing schema to be unversioned until migrated")
+	}
+
+	if _, err = m.Up(); err != nil {
+		t.Errorf("Up failed on adopted schema: %s", err.Error())
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatalf("error getting status: %s", err.Error())
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Errorf("Expected migration %d to be applied, got %v", s.Version, s)
+		}
+	}
+
+	// Everything after the baseline rolls back and reapplies cleanly
+	for v, _ := m.Version(); v > 0; v, _ = m.Version() {
+		if _, err = m.Down(); err != nil {
+			t.Fatalf("Down failed from version %d: %s", v, err.Error())
+		}
+	}
+	if _, err = m.Up(); err != nil {
+		t.Errorf("Up failed after rolling back: %s", err.Error())
+	}
+
+	// A new Migrator doesn't baseline twice
+	m, err = c.Migrator()
+	if err != nil {
+		t.Fatalf("error creating migrator: %s", err.Error())
+	}
+	if _, err = m.Up(); err != nil {
+		t.Errorf("Up failed with a second Migrator: %s", err.Error())
+	}
+}
+
+func TestDeleteBallotCascadesVotes(t *testing.T) {
+	c := newTestClient(t)
+
+	team, err := c.AddTeam(models.Team{ShortName: "Arizona"})
+	if err != nil {
+		t.Fatalf("error adding team: %s", err.Error())
+	}
+	if _, err = c.AddUser(models.User{Nickname: "voter1"}); err != nil {
+		t.Fatalf("error adding user: %s", err.Error())
+	}
+	if _, err = c.AddPoll(models.Poll{Season: 2020, Week: 1}); err != nil {
+		t.Fatalf("error adding poll: %s", err.Error())
+	}
+
+	ballot, err := c.AddBallot(models.Ballot{PollSeason: 2020, PollWeek: 1, User: "voter1", Votes: []models.Vote{{TeamID: team.ID, Rank: 1}}})
+	if err != nil {
+		t.Fatalf("error adding ballot: %s", err.Error())
+	}
+
+	if err = c.DeleteBallot(ballot.ID); err != nil {
+		t.Fatalf("error deleting ballot: %s", err.Error())
+	}
+
+	var count int
+	if err = c.db.Get(&count, "SELECT COUNT(*) FROM vote WHERE ballot_id = ?", ballot.ID); err != nil {
+		t.Fatalf("error counting votes: %s", err.Error())
+	}
+	if count != 0 {
+		t.Errorf("Expected votes to be deleted with their ballot, found %d", count)
+	}
+}
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	c := newTestClient(t)
+
+	entry := models.AuditEntry{Actor: "Concision", Action: models.AuditAddPoll, TargetType: models.AuditTargetPoll, TargetID: "2020/1"}
+	if _, err := c.WithAudit(entry).AddPoll(models.Poll{Season: 2020, Week: 1}); err != nil {
+		t.Fatalf("error adding poll: %s", err.Error())
+	}
+
+	// Redacting a deleted user only opens the log up for the length of it
+	if _, err := c.AddUser(models.User{Nickname: "voter1"}); err != nil {
+		t.Fatalf("error adding user: %s", err.Error())
+	}
+	if err := c.AnonymizeUser("voter1", "[deleted-1]", nil); err != nil {
+		t.Fatalf("error anonymizing user: %s", err.Error())
+	}
+
+	if _, err := c.db.Exec("UPDATE audit_log SET actor = 'someone_else'"); err == nil {
+		t.Errorf("Expected error updating the audit log")
+	}
+	if _, err := c.db.Exec("DELETE FROM audit_log"); err == nil {
+		t.Errorf("Expected error deleting from the audit log")
+	}
+
+	var count int
+	if err := c.db.Get(&count, "SELECT COUNT(*) FROM audit_log WHERE actor = 'Concision'"); err != nil {
+		t.Fatalf("error counting audit entries: %s", err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Expected the audit entry to be left alone, found %d", count)
+	}
+}
+
+func TestPing(t *testing.T) {
+	c := newTestClient(t)
+
+	if err := c.Ping(context.Background()); err != nil {
+		t.Errorf("Unexpected error pinging open database: %s", err.Error())
+	}
+
+	_ = c.Close()
+	if err := c.Ping(context.Background()); err == nil {
+		t.Errorf("Expected error pinging closed database")
+	}
+}
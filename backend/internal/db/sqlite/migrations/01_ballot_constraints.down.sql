@@ -0,0 +1,17 @@
+DROP INDEX ballot_user_poll;
+
+CREATE TABLE vote_old
+(
+  ballot_id INTEGER,
+  team_id   INTEGER,
+  rank      INTEGER,
+  reason    VARCHAR(150),
+  FOREIGN KEY (team_id) REFERENCES team (id)
+);
+
+INSERT INTO vote_old (ballot_id, team_id, rank, reason)
+SELECT ballot_id, team_id, rank, reason
+FROM vote;
+
+DROP TABLE vote;
+ALTER TABLE vote_old RENAME TO vote;
@@ -0,0 +1,24 @@
+-- sqlite can't add constraints to an existing table, so vote is rebuilt.
+CREATE TABLE vote_new
+(
+  ballot_id INTEGER NOT NULL,
+  team_id   INTEGER NOT NULL,
+  rank      INTEGER NOT NULL CHECK (rank BETWEEN 1 AND 25),
+  reason    VARCHAR(150),
+  PRIMARY KEY (ballot_id, rank),
+  UNIQUE (ballot_id, team_id),
+  FOREIGN KEY (ballot_id) REFERENCES ballot (id) ON DELETE CASCADE,
+  FOREIGN KEY (team_id) REFERENCES team (id)
+);
+
+-- Votes whose ballot is gone are unreachable, so they're dropped rather than
+-- failing the migration.
+INSERT INTO vote_new (ballot_id, team_id, rank, reason)
+SELECT ballot_id, team_id, rank, reason
+FROM vote
+WHERE ballot_id IN (SELECT id FROM ballot);
+
+DROP TABLE vote;
+ALTER TABLE vote_new RENAME TO vote;
+
+CREATE UNIQUE INDEX ballot_user_poll ON ballot (user, poll_season, poll_week);
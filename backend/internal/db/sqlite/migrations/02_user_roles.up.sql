@@ -0,0 +1,11 @@
+CREATE TABLE user_role
+(
+  user VARCHAR(32) NOT NULL,
+  role VARCHAR(32) NOT NULL,
+  PRIMARY KEY (user, role),
+  FOREIGN KEY (user) REFERENCES user (nickname) ON DELETE CASCADE
+);
+
+-- is_admin is kept in step with the admin role for older builds
+INSERT INTO user_role (user, role)
+SELECT nickname, 'admin' FROM user WHERE is_admin;
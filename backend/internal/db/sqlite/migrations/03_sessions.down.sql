@@ -0,0 +1,3 @@
+DROP TABLE revoked_token;
+DROP INDEX refresh_token_user;
+DROP TABLE refresh_token;
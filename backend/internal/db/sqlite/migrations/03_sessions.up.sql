@@ -0,0 +1,21 @@
+CREATE TABLE refresh_token
+(
+  hash       VARCHAR(64) NOT NULL,
+  user       VARCHAR(32) NOT NULL,
+  issued_at  DATETIME    NOT NULL,
+  expires_at DATETIME    NOT NULL,
+  revoked    BOOLEAN     NOT NULL DEFAULT FALSE,
+  PRIMARY KEY (hash),
+  FOREIGN KEY (user) REFERENCES user (nickname) ON DELETE CASCADE
+);
+
+CREATE INDEX refresh_token_user ON refresh_token (user);
+
+-- Access tokens that were revoked before they expired.  Rows are only needed
+-- until expires_at, after which the token is rejected anyway.
+CREATE TABLE revoked_token
+(
+  id         VARCHAR(64) NOT NULL,
+  expires_at DATETIME    NOT NULL,
+  PRIMARY KEY (id)
+);
@@ -0,0 +1 @@
+DROP TABLE ineligible_user;
@@ -0,0 +1,9 @@
+-- Users who failed the eligibility rules when they last logged in, and why.
+-- Users without a row can vote.
+CREATE TABLE ineligible_user
+(
+  user   VARCHAR(32) NOT NULL,
+  reason TEXT        NOT NULL,
+  PRIMARY KEY (user),
+  FOREIGN KEY (user) REFERENCES user (nickname) ON DELETE CASCADE
+);
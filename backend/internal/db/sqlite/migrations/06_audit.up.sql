@@ -0,0 +1,27 @@
+-- Privileged changes, each written in the same transaction as the change.
+-- actor isn't a foreign key, since the log outlives the users in it.
+CREATE TABLE audit_log
+(
+  id          INTEGER PRIMARY KEY,
+  time        DATETIME    NOT NULL,
+  actor       VARCHAR(32) NOT NULL,
+  action      VARCHAR(32) NOT NULL,
+  target_type VARCHAR(16) NOT NULL,
+  target_id   VARCHAR(64) NOT NULL,
+  before      TEXT,
+  after       TEXT,
+  request_id  VARCHAR(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_log_time ON audit_log (time);
+
+-- The log is append-only
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+  SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+  SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
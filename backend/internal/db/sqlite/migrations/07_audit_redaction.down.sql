@@ -0,0 +1,8 @@
+DROP TRIGGER audit_log_no_update;
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+  SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+
+DROP TABLE audit_redaction;
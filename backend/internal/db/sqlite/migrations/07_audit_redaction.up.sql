@@ -0,0 +1,15 @@
+-- Deleting a user replaces their name throughout the audit log.  The log can
+-- only be updated while this table has a row, which AnonymizeUser adds and
+-- removes within its transaction; deletes are still refused.
+CREATE TABLE audit_redaction
+(
+  redacting BOOLEAN NOT NULL
+);
+
+DROP TRIGGER audit_log_no_update;
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+WHEN NOT EXISTS (SELECT 1 FROM audit_redaction)
+BEGIN
+  SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
@@ -1,6 +1,8 @@
 package sqlite
 
 import (
+	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/r-cbb/cbbpoll/internal/models"
@@ -216,3 +218,75 @@ func (r *Result) toContract() models.Result {
 
 	return cr
 }
+
+type RefreshToken struct {
+	Hash      string
+	User      string    `db:"user"`
+	IssuedAt  time.Time `db:"issued_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+	Revoked   bool
+}
+
+func (t *RefreshToken) fromContract(ct models.RefreshToken) {
+	t.Hash = ct.Hash
+	t.User = ct.User
+	t.IssuedAt = ct.IssuedAt.UTC()
+	t.ExpiresAt = ct.ExpiresAt.UTC()
+	t.Revoked = ct.Revoked
+}
+
+func (t *RefreshToken) toContract() models.RefreshToken {
+	ct := models.RefreshToken{
+		Hash:      t.Hash,
+		User:      t.User,
+		IssuedAt:  t.IssuedAt,
+		ExpiresAt: t.ExpiresAt,
+		Revoked:   t.Revoked,
+	}
+
+	return ct
+}
+
+type AuditEntry struct {
+	ID         int64
+	Time       time.Time
+	Actor      string
+	Action     string
+	TargetType string `db:"target_type"`
+	TargetID   string `db:"target_id"`
+	Before     sql.NullString
+	After      sql.NullString
+	RequestID  string `db:"request_id"`
+}
+
+func (a *AuditEntry) fromContract(ca models.AuditEntry) {
+	a.ID = ca.ID
+	a.Time = ca.Time.UTC()
+	a.Actor = ca.Actor
+	a.Action = string(ca.Action)
+	a.TargetType = ca.TargetType
+	a.TargetID = ca.TargetID
+	a.Before = sql.NullString{String: string(ca.Before), Valid: ca.Before != nil}
+	a.After = sql.NullString{String: string(ca.After), Valid: ca.After != nil}
+	a.RequestID = ca.RequestID
+}
+
+func (a *AuditEntry) toContract() models.AuditEntry {
+	ca := models.AuditEntry{
+		ID:         a.ID,
+		Time:       a.Time,
+		Actor:      a.Actor,
+		Action:     models.AuditAction(a.Action),
+		TargetType: a.TargetType,
+		TargetID:   a.TargetID,
+		RequestID:  a.RequestID,
+	}
+	if a.Before.Valid {
+		ca.Before = json.RawMessage(a.Before.String)
+	}
+	if a.After.Valid {
+		ca.After = json.RawMessage(a.After.String)
+	}
+
+	return ca
+}
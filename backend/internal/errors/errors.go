@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	stderrors "errors"
+	"fmt"
+)
 
 type Op string
 
@@ -40,6 +43,18 @@ func (e Error) Error() string {
 	return e.Err.Error()
 }
 
+// Unwrap lets the standard library's errors.Is and errors.As see through an
+// Error to the error it wraps.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// As finds the first error in err's chain that can be assigned to target, as
+// errors.As in the standard library does.
+func As(err error, target interface{}) bool {
+	return stderrors.As(err, target)
+}
+
 func Kind(err error) Code {
 	e, ok := err.(*Error)
 	if !ok {
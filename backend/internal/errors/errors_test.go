@@ -98,4 +98,28 @@ func TestError_Error(t *testing.T) {
 	if err.Error() != E(err).Error() {
 		t.Errorf("Error() message incorrect when no msg provided")
 	}
-}
\ No newline at end of file
+}
+
+type customError struct {
+	detail string
+}
+
+func (e *customError) Error() string {
+	return e.detail
+}
+
+func TestAs(t *testing.T) {
+	err := E(E(Op("outer"), KindBadRequest, &customError{detail: "details"}), "wrapped")
+
+	var target *customError
+	if !As(err, &target) {
+		t.Fatalf("Expected As to find customError in chain")
+	}
+	if target.detail != "details" {
+		t.Errorf("As found wrong error: %v", target)
+	}
+
+	if As(E(KindNotFound, fmt.Errorf("Not found")), &target) {
+		t.Errorf("As found customError in chain without one")
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Package logging provides the structured logger used across the backend.  A
+logger tagged with request details travels in the request's context, so
+anything handling the request can log with those fields attached.
+*/
+package logging
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+)
+
+type ctxKey struct{}
+
+// New returns a logger that writes JSON lines to w.
+func New(w io.Writer) *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(w)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	return l
+}
+
+// Discard returns a logger that throws everything away, for tests.
+func Discard() *logrus.Logger {
+	return New(ioutil.Discard)
+}
+
+// NewContext returns a copy of ctx carrying entry.
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, ctxKey{}, entry)
+}
+
+// FromContext returns the logger carried by ctx, or one writing to the
+// standard logrus logger if there isn't one.
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry, ok := ctx.Value(ctxKey{}).(*logrus.Entry)
+	if !ok {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+
+	return entry
+}
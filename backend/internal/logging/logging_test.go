@@ -0,0 +1,30 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf)
+
+	ctx := NewContext(context.Background(), logger.WithField("request_id", "abc123"))
+	FromContext(ctx).WithField("user", "Concision").Info("ballot added")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Log line isn't json: %s", buf.String())
+	}
+
+	if line["request_id"] != "abc123" || line["user"] != "Concision" || line["msg"] != "ballot added" {
+		t.Errorf("Unexpected log line: %v", line)
+	}
+
+	// A context without a logger still gets one
+	if FromContext(context.Background()) == nil {
+		t.Errorf("Expected a default logger")
+	}
+}
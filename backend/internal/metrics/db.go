@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/db"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// dbClient wraps a DBClient, timing every call into DBQueryDuration.
+type dbClient struct {
+	next db.DBClient
+}
+
+// InstrumentDB returns a DBClient that records the latency of each call made
+// to next.
+func InstrumentDB(next db.DBClient) db.DBClient {
+	return dbClient{next: next}
+}
+
+func observeDB(method string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+
+	DBQueryDuration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+}
+
+func (c dbClient) Close() error {
+	return c.next.Close()
+}
+
+func (c dbClient) AddTeam(newTeam models.Team) (team models.Team, err error) {
+	defer func(start time.Time) { observeDB("AddTeam", start, err) }(time.Now())
+	return c.next.AddTeam(newTeam)
+}
+
+func (c dbClient) GetTeam(id int64) (team models.Team, err error) {
+	defer func(start time.Time) { observeDB("GetTeam", start, err) }(time.Now())
+	return c.next.GetTeam(id)
+}
+
+func (c dbClient) GetTeams() (teams []models.Team, err error) {
+	defer func(start time.Time) { observeDB("GetTeams", start, err) }(time.Now())
+	return c.next.GetTeams()
+}
+
+func (c dbClient) GetTeamsByID(ids []int64) (teams []models.Team, err error) {
+	defer func(start time.Time) { observeDB("GetTeamsByID", start, err) }(time.Now())
+	return c.next.GetTeamsByID(ids)
+}
+
+func (c dbClient) AddUser(newUser models.User) (user models.User, err error) {
+	defer func(start time.Time) { observeDB("AddUser", start, err) }(time.Now())
+	return c.next.AddUser(newUser)
+}
+
+func (c dbClient) UpdateUser(user models.User) (err error) {
+	defer func(start time.Time) { observeDB("UpdateUser", start, err) }(time.Now())
+	return c.next.UpdateUser(user)
+}
+
+func (c dbClient) GetUser(name string) (user models.User, err error) {
+	defer func(start time.Time) { observeDB("GetUser", start, err) }(time.Now())
+	return c.next.GetUser(name)
+}
+
+func (c dbClient) GetUsers(filter []db.Filter, sort db.Sort) (users []models.User, err error) {
+	defer func(start time.Time) { observeDB("GetUsers", start, err) }(time.Now())
+	return c.next.GetUsers(filter, sort)
+}
+
+func (c dbClient) AnonymizeUser(name string, alias string, deleteBallots []int64) (err error) {
+	defer func(start time.Time) { observeDB("AnonymizeUser", start, err) }(time.Now())
+	return c.next.AnonymizeUser(name, alias, deleteBallots)
+}
+
+func (c dbClient) AddPoll(newPoll models.Poll) (poll models.Poll, err error) {
+	defer func(start time.Time) { observeDB("AddPoll", start, err) }(time.Now())
+	return c.next.AddPoll(newPoll)
+}
+
+func (c dbClient) UpdatePoll(poll models.Poll) (err error) {
+	defer func(start time.Time) { observeDB("UpdatePoll", start, err) }(time.Now())
+	return c.next.UpdatePoll(poll)
+}
+
+func (c dbClient) GetPoll(season int, week int) (poll models.Poll, err error) {
+	defer func(start time.Time) { observeDB("GetPoll", start, err) }(time.Now())
+	return c.next.GetPoll(season, week)
+}
+
+func (c dbClient) GetPolls(filter []db.Filter, sort db.Sort) (polls []models.Poll, err error) {
+	defer func(start time.Time) { observeDB("GetPolls", start, err) }(time.Now())
+	return c.next.GetPolls(filter, sort)
+}
+
+func (c dbClient) SetResults(poll models.Poll, official []models.Result, allBallots []models.Result) (err error) {
+	defer func(start time.Time) { observeDB("SetResults", start, err) }(time.Now())
+	return c.next.SetResults(poll, official, allBallots)
+}
+
+func (c dbClient) GetResults(poll models.Poll, includeProvisional bool) (results []models.Result, err error) {
+	defer func(start time.Time) { observeDB("GetResults", start, err) }(time.Now())
+	return c.next.GetResults(poll, includeProvisional)
+}
+
+func (c dbClient) AddBallot(newBallot models.Ballot) (ballot models.Ballot, err error) {
+	defer func(start time.Time) { observeDB("AddBallot", start, err) }(time.Now())
+	return c.next.AddBallot(newBallot)
+}
+
+func (c dbClient) GetBallot(id int64) (ballot models.Ballot, err error) {
+	defer func(start time.Time) { observeDB("GetBallot", start, err) }(time.Now())
+	return c.next.GetBallot(id)
+}
+
+func (c dbClient) GetBallotsByPoll(poll models.Poll) (ballots []models.Ballot, err error) {
+	defer func(start time.Time) { observeDB("GetBallotsByPoll", start, err) }(time.Now())
+	return c.next.GetBallotsByPoll(poll)
+}
+
+func (c dbClient) GetBallotsByUser(user string) (ballots []models.Ballot, err error) {
+	defer func(start time.Time) { observeDB("GetBallotsByUser", start, err) }(time.Now())
+	return c.next.GetBallotsByUser(user)
+}
+
+func (c dbClient) DeleteBallot(id int64) (err error) {
+	defer func(start time.Time) { observeDB("DeleteBallot", start, err) }(time.Now())
+	return c.next.DeleteBallot(id)
+}
+
+func (c dbClient) UpdateBallot(ballot models.Ballot) (err error) {
+	defer func(start time.Time) { observeDB("UpdateBallot", start, err) }(time.Now())
+	return c.next.UpdateBallot(ballot)
+}
+
+func (c dbClient) AddRefreshToken(token models.RefreshToken) (err error) {
+	defer func(start time.Time) { observeDB("AddRefreshToken", start, err) }(time.Now())
+	return c.next.AddRefreshToken(token)
+}
+
+func (c dbClient) GetRefreshToken(hash string) (token models.RefreshToken, err error) {
+	defer func(start time.Time) { observeDB("GetRefreshToken", start, err) }(time.Now())
+	return c.next.GetRefreshToken(hash)
+}
+
+func (c dbClient) GetRefreshTokens(user string) (tokens []models.RefreshToken, err error) {
+	defer func(start time.Time) { observeDB("GetRefreshTokens", start, err) }(time.Now())
+	return c.next.GetRefreshTokens(user)
+}
+
+func (c dbClient) RotateRefreshToken(oldHash string, newToken models.RefreshToken) (err error) {
+	defer func(start time.Time) { observeDB("RotateRefreshToken", start, err) }(time.Now())
+	return c.next.RotateRefreshToken(oldHash, newToken)
+}
+
+func (c dbClient) RevokeRefreshToken(hash string) (err error) {
+	defer func(start time.Time) { observeDB("RevokeRefreshToken", start, err) }(time.Now())
+	return c.next.RevokeRefreshToken(hash)
+}
+
+func (c dbClient) RevokeRefreshTokens(user string) (err error) {
+	defer func(start time.Time) { observeDB("RevokeRefreshTokens", start, err) }(time.Now())
+	return c.next.RevokeRefreshTokens(user)
+}
+
+func (c dbClient) RevokeAccessToken(id string, expiresAt time.Time) (err error) {
+	defer func(start time.Time) { observeDB("RevokeAccessToken", start, err) }(time.Now())
+	return c.next.RevokeAccessToken(id, expiresAt)
+}
+
+func (c dbClient) IsAccessTokenRevoked(id string) (revoked bool, err error) {
+	defer func(start time.Time) { observeDB("IsAccessTokenRevoked", start, err) }(time.Now())
+	return c.next.IsAccessTokenRevoked(id)
+}
+
+func (c dbClient) GetFlairTeams() (teams map[string]int64, err error) {
+	defer func(start time.Time) { observeDB("GetFlairTeams", start, err) }(time.Now())
+	return c.next.GetFlairTeams()
+}
+
+func (c dbClient) SetFlairTeam(user string, team int64) (err error) {
+	defer func(start time.Time) { observeDB("SetFlairTeam", start, err) }(time.Now())
+	return c.next.SetFlairTeam(user, team)
+}
+
+// WithAudit keeps the returned client instrumented too.
+func (c dbClient) WithAudit(entry models.AuditEntry) db.DBClient {
+	return dbClient{next: c.next.WithAudit(entry)}
+}
+
+func (c dbClient) GetAuditLog(filter []db.Filter, sort db.Sort) (entries []models.AuditEntry, err error) {
+	defer func(start time.Time) { observeDB("GetAuditLog", start, err) }(time.Now())
+	return c.next.GetAuditLog(filter, sort)
+}
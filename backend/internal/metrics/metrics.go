@@ -0,0 +1,111 @@
+/*
+Package metrics defines the Prometheus metrics exported by the backend and the
+handler that serves them.  Everything is registered with Registry rather than
+the global default registry, so only our own metrics (plus the standard Go and
+process collectors) show up on /metrics.
+*/
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cbbpoll"
+
+// Outcomes of a call to the reddit API, used as the outcome label of
+// RedditRequests.
+const (
+	RedditSuccess      = "success"
+	RedditUnauthorized = "unauthorized"
+	RedditUnavailable  = "unavailable"
+	RedditError        = "error"
+)
+
+var (
+	Registry = prometheus.NewRegistry()
+
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route name, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route name and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	BallotsSubmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ballots_submitted_total",
+		Help:      "Ballots successfully submitted, by poll season and week.",
+	}, []string{"season", "week"})
+
+	ResultsDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "results_calculation_duration_seconds",
+		Help:      "Time taken to recalculate a poll's results.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of DBClient calls, by method and whether they returned an error.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"method", "outcome"})
+
+	RedditRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reddit_requests_total",
+		Help:      "Calls to the reddit API, by operation and outcome.",
+	}, []string{"operation", "outcome"})
+
+	RedditRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reddit_retries_total",
+		Help:      "Calls to the reddit API that were retried after a server error or rate limiting, by operation.",
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		BallotsSubmitted,
+		ResultsDuration,
+		DBQueryDuration,
+		RedditRequests,
+		RedditRetries,
+	)
+}
+
+// Handler serves the metrics in Registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a served HTTP request.  route is the name of the
+// matched route, so that paths containing ids don't each get their own series.
+func ObserveRequest(route string, method string, status int, elapsed time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+
+	HTTPRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	HTTPRequestDuration.WithLabelValues(route, method).Observe(elapsed.Seconds())
+}
+
+// BallotSubmitted counts a ballot added to the poll for season and week.
+func BallotSubmitted(season int, week int) {
+	BallotsSubmitted.WithLabelValues(strconv.Itoa(season), strconv.Itoa(week)).Inc()
+}
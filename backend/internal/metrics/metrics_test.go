@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/r-cbb/cbbpoll/internal/db/memory"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+func TestObserveRequest(t *testing.T) {
+	tests := []struct {
+		description   string
+		route         string
+		expectedRoute string
+	}{
+		{
+			description:   "Matched route",
+			route:         "/v1/teams/{id:[0-9]+}",
+			expectedRoute: "/v1/teams/{id:[0-9]+}",
+		},
+		{
+			description:   "No route",
+			route:         "",
+			expectedRoute: "unmatched",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			counter := HTTPRequests.WithLabelValues(test.expectedRoute, http.MethodGet, "404")
+			before := testutil.ToFloat64(counter)
+
+			ObserveRequest(test.route, http.MethodGet, http.StatusNotFound, time.Millisecond)
+
+			if testutil.ToFloat64(counter) != before+1 {
+				t.Errorf("Expected request to be counted under route %s", test.expectedRoute)
+			}
+		})
+	}
+}
+
+func TestInstrumentDB(t *testing.T) {
+	client := InstrumentDB(memory.NewClient())
+
+	team, err := client.AddTeam(models.Team{ShortName: "Arizona"})
+	if err != nil {
+		t.Fatalf("error adding team: %s", err.Error())
+	}
+
+	if _, err = client.GetTeam(team.ID); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+
+	if _, err = client.GetTeam(team.ID + 1); err == nil {
+		t.Errorf("Expected error getting missing team")
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+
+	for _, expected := range []string{
+		`cbbpoll_db_query_duration_seconds_count{method="AddTeam",outcome="success"}`,
+		`cbbpoll_db_query_duration_seconds_count{method="GetTeam",outcome="success"}`,
+		`cbbpoll_db_query_duration_seconds_count{method="GetTeam",outcome="error"}`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected metrics to include %s", expected)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditAction is a kind of privileged change recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditAddTeam      AuditAction = "team.add"
+	AuditAddUser      AuditAction = "user.add"
+	AuditUpdateUser   AuditAction = "user.update"
+	AuditSetRoles     AuditAction = "user.roles"
+	AuditDeleteUser   AuditAction = "user.delete"
+	AuditAddPoll      AuditAction = "poll.add"
+	AuditDeleteBallot AuditAction = "ballot.delete"
+)
+
+// What an audit entry's TargetID identifies.
+const (
+	AuditTargetTeam   = "team"
+	AuditTargetUser   = "user"
+	AuditTargetPoll   = "poll"
+	AuditTargetBallot = "ballot"
+)
+
+// AuditEntry records a privileged change: who made it, to what, and how it
+// left things.
+type AuditEntry struct {
+	// example: 1
+	ID int64 `json:"id"`
+	// example: 2020-11-18T21:04:05Z
+	Time time.Time `json:"time"`
+	// The user who made the change
+	// example: Concision
+	Actor string `json:"actor"`
+	// example: user.update
+	Action AuditAction `json:"action"`
+	// example: user
+	TargetType string `json:"target_type"`
+	// A team or ballot's ID, a user's nickname, or season/week for a poll
+	// example: einsteins_haircut
+	TargetID string `json:"target_id"`
+	// The target before the change, absent if it was created
+	Before json.RawMessage `json:"before,omitempty"`
+	// The target after the change, absent if it was deleted
+	After json.RawMessage `json:"after,omitempty"`
+	// The request the change was made in, to find it in the logs
+	// example: 5f3c2a9d8e7b6a1c0d4e3f2a1b0c9d8e
+	RequestID string `json:"request_id,omitempty"`
+}
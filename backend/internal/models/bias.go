@@ -0,0 +1,60 @@
+package models
+
+/*
+TeamBias compares where a voter ranked their primary team with where the
+poll's official results put it.  Ranks are 0 when the team wasn't ranked, which
+counts as one place below the last rank when working out the difference.
+*/
+type TeamBias struct {
+	// example: 2020
+	PollSeason int `json:"poll_season"`
+	// example: 3
+	PollWeek int `json:"poll_week"`
+	// example: Concision
+	User string `json:"user"`
+	// example: 1
+	TeamID int64 `json:"team_id"`
+	// example: arizona
+	TeamSlug string `json:"team_slug"`
+	// example: 4
+	BallotRank int `json:"ballot_rank"`
+	// example: 9
+	ConsensusRank int `json:"consensus_rank"`
+	// How many places higher the voter ranked their team than the consensus did,
+	// negative if they ranked it lower.
+	// example: 5
+	Difference int `json:"difference"`
+}
+
+// PollBias is how much a poll's voters favoured their own teams.
+type PollBias struct {
+	// example: 2020
+	Season int `json:"season"`
+	// example: 3
+	Week int `json:"week"`
+	// The mean difference of the voters below, 0 if there are none
+	// example: 1.5
+	HomerIndex float64 `json:"homer_index"`
+	// Official voters with a primary team that they or the consensus ranked
+	Voters []TeamBias `json:"voters"`
+}
+
+// SeasonBias is how much a voter favoured their team over a season.
+type SeasonBias struct {
+	// example: 2020
+	Season int `json:"season"`
+	// Polls the voter's team was ranked in, by them or the consensus
+	// example: 12
+	Polls int `json:"polls"`
+	// The mean difference across those polls
+	// example: 2.25
+	HomerIndex float64 `json:"homer_index"`
+}
+
+// UserBias is how much a voter has favoured their team, poll by poll and season by season.
+type UserBias struct {
+	// example: Concision
+	User    string       `json:"user"`
+	Seasons []SeasonBias `json:"seasons"`
+	Polls   []TeamBias   `json:"polls"`
+}
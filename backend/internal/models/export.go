@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// UserExport is everything stored about a user, for them to keep.
+type UserExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+	User       User      `json:"user"`
+	Ballots    []Ballot  `json:"ballots"`
+	Sessions   []Session `json:"sessions"`
+	// The team the user's subreddit flair was last set for, 0 if it never was
+	// example: 1
+	FlairTeam int64 `json:"flair_team"`
+}
+
+// Session is a refresh token issued to a user, without the token itself.
+type Session struct {
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Set once the token has been exchanged or the session ended
+	Revoked bool `json:"revoked"`
+}
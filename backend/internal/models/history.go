@@ -0,0 +1,35 @@
+package models
+
+// VotingHistory is every ballot a user has cast, with a summary of how they vote.
+type VotingHistory struct {
+	// example: Concision
+	User    string      `json:"user"`
+	Ballots []Ballot    `json:"ballots"`
+	Stats   VotingStats `json:"stats"`
+}
+
+// VotingStats summarises the ballots in a user's voting history.
+type VotingStats struct {
+	// example: 14
+	PollsParticipated int `json:"polls_participated"`
+	// The mean number of places between where the user ranked each team and
+	// where the official results put it, over polls that have closed
+	// example: 2.4
+	AverageDeviation float64 `json:"average_deviation"`
+	// The teams the user has ranked most often, most often first
+	MostRanked []RankedTeam `json:"most_ranked"`
+}
+
+// RankedTeam is how often, and how highly, a user has ranked a team.
+type RankedTeam struct {
+	// example: 1
+	TeamID int64 `json:"team_id"`
+	// example: Arizona
+	TeamName string `json:"team_name"`
+	// example: arizona
+	TeamSlug string `json:"team_slug"`
+	// example: 12
+	Ballots int `json:"ballots"`
+	// example: 3.5
+	AverageRank float64 `json:"average_rank"`
+}
@@ -5,6 +5,12 @@ import "time"
 type VersionInfo struct {
 	// example: v1.0.0
 	Version string `json:"version"`
+	// example: 51c52de0c1e7a3c1d6f1b0c0e7c6b5f1a2d3e4f5
+	Commit string `json:"commit,omitempty"`
+	// example: 2020-01-02T15:04:05Z
+	BuildTime string `json:"build_time,omitempty"`
+	// example: go1.16
+	GoVersion string `json:"go_version"`
 }
 
 type Team struct {
@@ -25,11 +31,54 @@ type User struct {
 	// example: Concision
 	// required: true
 	Nickname string `json:"nickname"`
+	// Shorthand for having the admin role
 	// example: false
 	IsAdmin bool `json:"is_admin"`
+	// example: ["poll_manager"]
+	Roles []Role `json:"roles"`
 	// example: true
 	IsVoter     bool         `json:"is_voter"`
 	PrimaryTeam int64        `json:"primary_team"`
+	// Details of the primary team, filled in on users returned by the API
+	Team *Team `json:"team,omitempty"`
+	// Why the user can't submit ballots, as of their last login.  Empty if they can,
+	// and hidden from anyone but the user themselves and those who manage users.
+	// example: reddit account must be at least 30 days old
+	IneligibleReason string `json:"ineligible_reason,omitempty"`
+}
+
+// Eligible reports whether u passed the eligibility rules the last time they logged in.
+func (u User) Eligible() bool {
+	return u.IneligibleReason == ""
+}
+
+// HasRole reports whether u has been granted r.
+func (u User) HasRole(r Role) bool {
+	if r == RoleAdmin && u.IsAdmin {
+		return true
+	}
+	for _, role := range u.Roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether u's roles grant p.
+func (u User) Can(p Permission) bool {
+	return UserToken{Nickname: u.Nickname, IsAdmin: u.IsAdmin, Roles: u.Roles}.Can(p)
+}
+
+/*
+WithNormalizedRoles returns a copy of u with IsAdmin and Roles agreeing with
+each other: RoleAdmin is in Roles exactly when IsAdmin is set, if either of
+them said so before.  Roles are sorted with duplicates removed.
+*/
+func (u User) WithNormalizedRoles() User {
+	u.IsAdmin = u.HasRole(RoleAdmin)
+	u.Roles = normalizeRoles(u.Roles, u.IsAdmin)
+	return u
 }
 
 type Poll struct {
@@ -93,12 +142,37 @@ type UserToken struct {
 	Nickname string `json:"nickname"`
 	// example: true
 	IsAdmin bool `json:"is_admin"`
+	// example: ["poll_manager"]
+	Roles []Role `json:"roles,omitempty"`
+	// Identifies the token so it can be revoked
+	ID string `json:"-"`
+	// When the token stops being accepted
+	ExpiresAt time.Time `json:"-"`
 }
 
 func (u UserToken) LoggedIn() bool {
 	return u.Nickname != ""
 }
 
+// Can reports whether any of the user's roles grant p.  Admins can do anything.
+func (u UserToken) Can(p Permission) bool {
+	if !u.LoggedIn() {
+		return false
+	}
+
+	if u.IsAdmin {
+		return true
+	}
+
+	for _, r := range u.Roles {
+		if r.Grants(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (u UserToken) CanManagePolls() bool {
-	return u.IsAdmin
+	return u.Can(PermManagePolls)
 }
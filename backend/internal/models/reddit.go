@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// RedditAccount is what reddit reports about a user's account when they log in.
+type RedditAccount struct {
+	// example: Concision
+	Name string `json:"name"`
+	// When the account was created
+	Created time.Time `json:"created"`
+	// example: 1200
+	LinkKarma int `json:"link_karma"`
+	// example: 35000
+	CommentKarma int `json:"comment_karma"`
+	// example: false
+	Suspended bool `json:"suspended"`
+	// Flair in the subreddit voters need flair in, if there is one
+	// example: Arizona
+	Flair string `json:"flair,omitempty"`
+}
+
+// Karma is the account's link and comment karma combined.
+func (a RedditAccount) Karma() int {
+	return a.LinkKarma + a.CommentKarma
+}
+
+// Age is how long before now the account was created.
+func (a RedditAccount) Age(now time.Time) time.Duration {
+	return now.Sub(a.Created)
+}
@@ -0,0 +1,105 @@
+package models
+
+import "sort"
+
+// Role is a named set of permissions that can be granted to a user.
+type Role string
+
+const (
+	RoleAdmin          Role = "admin"
+	RolePollManager    Role = "poll_manager"
+	RoleVoterModerator Role = "voter_moderator"
+	RoleTeamEditor     Role = "team_editor"
+	RoleAuditor        Role = "auditor"
+)
+
+// Permission is something a user may be allowed to do beyond what every
+// logged in user can do with their own data.
+type Permission string
+
+const (
+	// Add and edit polls
+	PermManagePolls Permission = "polls:manage"
+	// See polls before they open, and results before polls close
+	PermViewPolls Permission = "polls:view"
+	// See other users' ballots before polls close
+	PermViewBallots Permission = "ballots:view"
+	// Submit and delete ballots for other users, and after polls close
+	PermManageBallots Permission = "ballots:manage"
+	// Change whether users are voters
+	PermManageVoters Permission = "voters:manage"
+	// Add and edit teams
+	PermManageTeams Permission = "teams:manage"
+	// Add users and edit other users' profiles
+	PermManageUsers Permission = "users:manage"
+	// Grant and revoke roles
+	PermManageRoles Permission = "roles:manage"
+	// Read the audit log of privileged changes
+	PermViewAudit Permission = "audit:view"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermManagePolls, PermViewPolls, PermViewBallots, PermManageBallots,
+		PermManageVoters, PermManageTeams, PermManageUsers, PermManageRoles,
+		PermViewAudit,
+	},
+	RolePollManager:    {PermManagePolls, PermViewPolls, PermViewBallots},
+	RoleVoterModerator: {PermManageVoters},
+	RoleTeamEditor:     {PermManageTeams},
+	RoleAuditor:        {PermViewPolls, PermViewBallots, PermViewAudit},
+}
+
+// RoleInfo describes a role and the permissions it grants.
+type RoleInfo struct {
+	// example: poll_manager
+	Name Role `json:"name"`
+	// example: ["polls:manage","polls:view","ballots:view"]
+	Permissions []Permission `json:"permissions"`
+}
+
+// AllRoles lists every role, in a stable order.
+func AllRoles() []Role {
+	return []Role{RoleAdmin, RolePollManager, RoleVoterModerator, RoleTeamEditor, RoleAuditor}
+}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Permissions lists what r allows.
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// Grants reports whether r includes permission p.
+func (r Role) Grants(p Permission) bool {
+	for _, perm := range rolePermissions[r] {
+		if perm == p {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRoles sorts roles and removes duplicates, adding or removing
+// RoleAdmin to match isAdmin.
+func normalizeRoles(roles []Role, isAdmin bool) []Role {
+	seen := make(map[Role]bool)
+	out := make([]Role, 0, len(roles)+1)
+	for _, r := range roles {
+		if seen[r] || r == RoleAdmin {
+			continue
+		}
+		seen[r] = true
+		out = append(out, r)
+	}
+	if isAdmin {
+		out = append(out, RoleAdmin)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+/*
+RefreshToken is a long-lived credential that a client can exchange, once, for a
+new access token and a new refresh token.  Only a hash of the token is stored,
+so the database alone can't be used to start a session.
+*/
+type RefreshToken struct {
+	Hash      string
+	User      string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Set once the token has been exchanged or the session ended
+	Revoked bool
+}
@@ -0,0 +1,205 @@
+/*
+Package render formats poll results for people rather than programs: the
+markdown tables posted to reddit, CSV for spreadsheets and plain text.  It works
+only from models, so the API, the reddit bot and command line tools all render
+results the same way.
+*/
+package render
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+// Report is everything needed to render a poll's results.
+type Report struct {
+	Poll    models.Poll
+	Results []models.Result
+	// Results of the previous poll of the season, to show each team's
+	// movement against.  Nil if there wasn't one.
+	Previous []models.Result
+}
+
+// PollName is how a poll is referred to in titles, e.g. "2020 Week 3" or "2020 Preseason".
+func PollName(poll models.Poll) string {
+	if poll.WeekName != "" {
+		return fmt.Sprintf("%d %s", poll.Season, poll.WeekName)
+	}
+	return fmt.Sprintf("%d Week %d", poll.Season, poll.Week)
+}
+
+// Title is the title results are posted to reddit under.
+func Title(poll models.Poll) string {
+	return fmt.Sprintf("Poll Results: %s", PollName(poll))
+}
+
+// previousRanks maps team ids to their rank in the previous poll, or is nil
+// if there's nothing to compare with.
+func (r Report) previousRanks() map[int64]int {
+	if r.Previous == nil {
+		return nil
+	}
+
+	ranks := make(map[int64]int, len(r.Previous))
+	for _, p := range r.Previous {
+		ranks[p.TeamID] = p.Rank
+	}
+	return ranks
+}
+
+/*
+movement describes how a team's rank changed since the previous poll: "▲2",
+"▼1", "–" for no change, or "new" if it wasn't ranked before.  It's empty when
+there's no previous poll to compare with.
+*/
+func movement(r models.Result, previous map[int64]int) string {
+	if previous == nil {
+		return ""
+	}
+
+	before, ok := previous[r.TeamID]
+	switch {
+	case !ok || before == 0:
+		return "new"
+	case before > r.Rank:
+		return fmt.Sprintf("▲%d", before-r.Rank)
+	case before < r.Rank:
+		return fmt.Sprintf("▼%d", r.Rank-before)
+	default:
+		return "–"
+	}
+}
+
+// others lists the teams receiving votes outside the rankings, with their points.
+func others(results []models.Result, escape func(string) string) string {
+	var teams []string
+	for _, r := range results {
+		if r.Rank == 0 {
+			teams = append(teams, fmt.Sprintf("%s %d", escape(r.TeamName), r.Points))
+		}
+	}
+	return strings.Join(teams, ", ")
+}
+
+// markdownEscaper keeps team names from breaking out of a reddit markdown table cell.
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, `*`, `\*`, `_`, `\_`, `^`, `\^`, `~`, `\~`)
+
+/*
+Markdown renders a report as a reddit markdown table of the ranked teams,
+followed by the others receiving votes.  The table has a column for movement
+when there's a previous poll.
+*/
+func Markdown(r Report) string {
+	prevRanks := r.previousRanks()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", PollName(r.Poll))
+
+	if prevRanks != nil {
+		b.WriteString("| Rank | Team | First Place Votes | Points | Change |\n")
+		b.WriteString("|--:|:--|--:|--:|:-:|\n")
+	} else {
+		b.WriteString("| Rank | Team | First Place Votes | Points |\n")
+		b.WriteString("|--:|:--|--:|--:|\n")
+	}
+
+	for _, result := range r.Results {
+		if result.Rank == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "| %d | %s | %d | %d |", result.Rank, markdownEscaper.Replace(result.TeamName), result.FirstPlaceVotes, result.Points)
+		if prevRanks != nil {
+			fmt.Fprintf(&b, " %s |", movement(result, prevRanks))
+		}
+		b.WriteString("\n")
+	}
+
+	if o := others(r.Results, markdownEscaper.Replace); o != "" {
+		fmt.Fprintf(&b, "\n**Others receiving votes:** %s\n", o)
+	}
+
+	return b.String()
+}
+
+/*
+CSV writes a report as CSV with a header row, one row per team including the
+others receiving votes, which have a rank of 0 as they do in the API.  The
+change column is empty when there's no previous poll.
+*/
+func CSV(w io.Writer, r Report) error {
+	prevRanks := r.previousRanks()
+
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"rank", "team_id", "team", "team_slug", "first_place_votes", "points", "change"})
+	if err != nil {
+		return err
+	}
+
+	for _, result := range r.Results {
+		change := ""
+		if result.Rank != 0 {
+			change = movement(result, prevRanks)
+		}
+
+		err = cw.Write([]string{
+			strconv.Itoa(result.Rank),
+			strconv.FormatInt(result.TeamID, 10),
+			result.TeamName,
+			result.TeamSlug,
+			strconv.Itoa(result.FirstPlaceVotes),
+			strconv.Itoa(result.Points),
+			change,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func noEscape(s string) string { return s }
+
+// Text renders a report as a plain text table for terminals and anywhere else
+// markdown isn't understood.
+func Text(r Report) string {
+	prevRanks := r.previousRanks()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", PollName(r.Poll))
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	if prevRanks != nil {
+		fmt.Fprintln(tw, "Rank\tTeam\tFirst Place Votes\tPoints\tChange")
+	} else {
+		fmt.Fprintln(tw, "Rank\tTeam\tFirst Place Votes\tPoints")
+	}
+
+	for _, result := range r.Results {
+		if result.Rank == 0 {
+			continue
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d", result.Rank, result.TeamName, result.FirstPlaceVotes, result.Points)
+		if prevRanks != nil {
+			fmt.Fprintf(tw, "\t%s", movement(result, prevRanks))
+		}
+		fmt.Fprintln(tw)
+	}
+	// Writes to a strings.Builder can't fail
+	_ = tw.Flush()
+
+	if o := others(r.Results, noEscape); o != "" {
+		fmt.Fprintf(&b, "\nOthers receiving votes: %s\n", o)
+	}
+
+	return b.String()
+}
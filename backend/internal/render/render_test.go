@@ -0,0 +1,115 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+var testReport = Report{
+	Poll: models.Poll{Season: 2020, Week: 3},
+	Results: []models.Result{
+		{TeamID: 1, TeamName: "Arizona", TeamSlug: "arizona", Rank: 1, FirstPlaceVotes: 3, Points: 75},
+		{TeamID: 2, TeamName: "Texas A&M | Corpus Christi", TeamSlug: "texas-am-cc", Rank: 2, Points: 48},
+		{TeamID: 3, TeamName: "Duke", TeamSlug: "duke", Rank: 0, Points: 4},
+		{TeamID: 4, TeamName: "Kansas", TeamSlug: "kansas", Rank: 0, Points: 1},
+	},
+}
+
+// withPrevious is testReport compared against a week where the top two were swapped.
+func withPrevious() Report {
+	r := testReport
+	r.Previous = []models.Result{
+		{TeamID: 2, Rank: 1},
+		{TeamID: 1, Rank: 2},
+	}
+	return r
+}
+
+func TestPollName(t *testing.T) {
+	if name := PollName(models.Poll{Season: 2020, Week: 3}); name != "2020 Week 3" {
+		t.Errorf("Unexpected name %q", name)
+	}
+	if name := PollName(models.Poll{Season: 2020, Week: 0, WeekName: "Preseason"}); name != "2020 Preseason" {
+		t.Errorf("Unexpected name %q", name)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	md := Markdown(testReport)
+	expected := "# 2020 Week 3\n\n" +
+		"| Rank | Team | First Place Votes | Points |\n" +
+		"|--:|:--|--:|--:|\n" +
+		"| 1 | Arizona | 3 | 75 |\n" +
+		"| 2 | Texas A&M \\| Corpus Christi | 0 | 48 |\n" +
+		"\n**Others receiving votes:** Duke 4, Kansas 1\n"
+	if md != expected {
+		t.Errorf("Unexpected markdown:\n%s\nexpected:\n%s", md, expected)
+	}
+
+	md = Markdown(withPrevious())
+	for _, row := range []string{"| Change |", "| 1 | Arizona | 3 | 75 | ▲1 |", "| 0 | 48 | ▼1 |"} {
+		if !strings.Contains(md, row) {
+			t.Errorf("Expected %q in markdown:\n%s", row, md)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := CSV(&buf, withPrevious()); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := "rank,team_id,team,team_slug,first_place_votes,points,change\n" +
+		"1,1,Arizona,arizona,3,75,▲1\n" +
+		"2,2,Texas A&M | Corpus Christi,texas-am-cc,0,48,▼1\n" +
+		"0,3,Duke,duke,0,4,\n" +
+		"0,4,Kansas,kansas,0,1,\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected csv:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestText(t *testing.T) {
+	text := Text(testReport)
+	expected := "2020 Week 3\n\n" +
+		"Rank  Team                        First Place Votes  Points\n" +
+		"1     Arizona                     3                  75\n" +
+		"2     Texas A&M | Corpus Christi  0                  48\n" +
+		"\nOthers receiving votes: Duke 4, Kansas 1\n"
+	if text != expected {
+		t.Errorf("Unexpected text:\n%s\nexpected:\n%s", text, expected)
+	}
+
+	text = Text(withPrevious())
+	if !strings.Contains(text, "Points  Change\n") || !strings.Contains(text, "75      ▲1\n") {
+		t.Errorf("Expected change column in text:\n%s", text)
+	}
+}
+
+func TestMovement(t *testing.T) {
+	previous := map[int64]int{1: 3, 2: 1, 3: 0}
+
+	tests := []struct {
+		result   models.Result
+		expected string
+	}{
+		{models.Result{TeamID: 1, Rank: 1}, "▲2"},
+		{models.Result{TeamID: 2, Rank: 4}, "▼3"},
+		{models.Result{TeamID: 1, Rank: 3}, "–"},
+		{models.Result{TeamID: 3, Rank: 5}, "new"},
+		{models.Result{TeamID: 4, Rank: 6}, "new"},
+	}
+
+	for _, test := range tests {
+		if m := movement(test.result, previous); m != test.expected {
+			t.Errorf("movement(%v) = %q, expected %q", test.result, m, test.expected)
+		}
+	}
+	if m := movement(models.Result{TeamID: 1, Rank: 1}, nil); m != "" {
+		t.Errorf("Expected no movement without a previous poll, got %q", m)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/r-cbb/cbbpoll/internal/app"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/logging"
+)
+
+const problemContentType = "application/problem+json"
+
+/*
+problem is the body of every error response, following RFC 7807 (problem details
+for HTTP APIs).  Code is a stable, machine readable name for the kind of error;
+Message is meant for people.  Problems is only set for ballots that fail
+validation, listing what is wrong with each vote.
+*/
+type problem struct {
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	RequestID string            `json:"request_id,omitempty"`
+	Problems  []app.VoteProblem `json:"problems,omitempty"`
+}
+
+// statusForKind maps an error kind to the HTTP status and problem code clients
+// see.  Kinds that are our fault rather than the client's all look the same.
+func statusForKind(kind errors.Code) (int, string) {
+	switch kind {
+	case errors.KindBadRequest:
+		return http.StatusBadRequest, "bad_request"
+	case errors.KindUnauthenticated, errors.KindAuthError:
+		return http.StatusUnauthorized, "unauthenticated"
+	case errors.KindUnauthorized:
+		return http.StatusForbidden, "forbidden"
+	case errors.KindNotFound:
+		return http.StatusNotFound, "not_found"
+	case errors.KindConflict:
+		return http.StatusConflict, "conflict"
+	case errors.KindNotImplemented:
+		return http.StatusNotImplemented, "not_implemented"
+	case errors.KindServiceUnavailable:
+		return http.StatusServiceUnavailable, "service_unavailable"
+	default:
+		return http.StatusInternalServerError, "internal"
+	}
+}
+
+// clientMessage finds the message attached where err was given its kind, which
+// is the most specific explanation of what the client did wrong.  Server errors
+// get a generic message so internal details aren't leaked.
+func clientMessage(err error, status int) string {
+	if status < http.StatusInternalServerError || status == http.StatusServiceUnavailable {
+		for e, ok := err.(*errors.Error); ok; e, ok = e.Err.(*errors.Error) {
+			if e.Kind != errors.KindUnexpected {
+				if e.Msg != "" {
+					return e.Msg
+				}
+				break
+			}
+		}
+	}
+
+	return http.StatusText(status)
+}
+
+// requestID is the id assigned to the request by logRequests, so it can be
+// quoted back when reporting a problem.
+func requestID(r *http.Request) string {
+	if info, ok := requestInfoFromCtx(r.Context()); ok {
+		return info.id
+	}
+	return r.Header.Get(requestIDHeader)
+}
+
+// respondError writes err as a problem+json response with a status derived
+// from its kind, and logs it along with the chain of ops it passed through.
+func (s *Server) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := statusForKind(errors.Kind(err))
+
+	p := problem{
+		Status:    status,
+		Code:      code,
+		RequestID: requestID(r),
+	}
+
+	var verr *app.BallotValidationError
+	if errors.As(err, &verr) {
+		p.Status = http.StatusUnprocessableEntity
+		p.Code = "invalid_ballot"
+		p.Message = "ballot failed validation"
+		p.Problems = verr.Problems
+	} else {
+		p.Message = clientMessage(err, status)
+	}
+	p.Title = http.StatusText(p.Status)
+
+	entry := logging.FromContext(r.Context()).WithFields(logrus.Fields{
+		"status": p.Status,
+		"code":   p.Code,
+		"ops":    errors.Ops(err),
+	}).WithError(err)
+	if p.Status >= http.StatusInternalServerError {
+		entry.Error("request failed")
+	} else {
+		entry.Info("request rejected")
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+
+	err = json.NewEncoder(w).Encode(p)
+	if err != nil {
+		logging.FromContext(r.Context()).WithError(err).Error("error encoding problem")
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/r-cbb/cbbpoll/internal/app"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+)
+
+func TestRespondError(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		expectedStatus  int
+		expectedCode    string
+		expectedMessage string
+	}{
+		{
+			name:            "NotFound",
+			err:             errors.E(errors.Op("app.GetTeam"), errors.E(errors.Op("sqlite.GetTeam"), fmt.Errorf("sql: no rows"), "team not found", errors.KindNotFound), "error retrieving team"),
+			expectedStatus:  http.StatusNotFound,
+			expectedCode:    "not_found",
+			expectedMessage: "team not found",
+		},
+		{
+			name:            "Conflict",
+			err:             errors.E("user already exists", errors.KindConflict),
+			expectedStatus:  http.StatusConflict,
+			expectedCode:    "conflict",
+			expectedMessage: "user already exists",
+		},
+		{
+			name:            "BadRequest",
+			err:             errors.E("unknown filter field 'foo'", errors.KindBadRequest),
+			expectedStatus:  http.StatusBadRequest,
+			expectedCode:    "bad_request",
+			expectedMessage: "unknown filter field 'foo'",
+		},
+		{
+			name:            "Unauthenticated without message",
+			err:             errors.E(errors.KindUnauthenticated),
+			expectedStatus:  http.StatusUnauthorized,
+			expectedCode:    "unauthenticated",
+			expectedMessage: "Unauthorized",
+		},
+		{
+			name:            "Unauthorized",
+			err:             errors.E("only admins can add teams", errors.KindUnauthorized),
+			expectedStatus:  http.StatusForbidden,
+			expectedCode:    "forbidden",
+			expectedMessage: "only admins can add teams",
+		},
+		{
+			name:            "ServiceUnavailable",
+			err:             errors.E("reddit is down", errors.KindServiceUnavailable),
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedCode:    "service_unavailable",
+			expectedMessage: "reddit is down",
+		},
+		{
+			name:            "DatabaseError details stay private",
+			err:             errors.E(fmt.Errorf("disk I/O error"), "error adding user to db", errors.KindDatabaseError),
+			expectedStatus:  http.StatusInternalServerError,
+			expectedCode:    "internal",
+			expectedMessage: "Internal Server Error",
+		},
+		{
+			name:            "Plain error",
+			err:             fmt.Errorf("something broke"),
+			expectedStatus:  http.StatusInternalServerError,
+			expectedCode:    "internal",
+			expectedMessage: "Internal Server Error",
+		},
+		{
+			name:            "Ballot validation",
+			err:             errors.E(&app.BallotValidationError{Problems: []app.VoteProblem{{Index: 2, Field: "rank", Message: "rank 3 used more than once"}}}, errors.KindBadRequest),
+			expectedStatus:  http.StatusUnprocessableEntity,
+			expectedCode:    "invalid_ballot",
+			expectedMessage: "ballot failed validation",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := NewServer()
+			r := httptest.NewRequest(http.MethodGet, "/v1/teams", nil)
+			r.Header.Set("X-Request-ID", "abc123")
+			w := httptest.NewRecorder()
+
+			srv.respondError(w, r, test.err)
+
+			if w.Code != test.expectedStatus {
+				t.Errorf("Expected status %v, got %v", test.expectedStatus, w.Code)
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("Expected Content-Type %s, got %s", problemContentType, ct)
+			}
+
+			var p problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatalf("Error decoding problem: %s", err.Error())
+			}
+
+			if p.Status != test.expectedStatus || p.Code != test.expectedCode || p.Message != test.expectedMessage {
+				t.Errorf("Unexpected problem body: %+v", p)
+			}
+
+			if p.RequestID != "abc123" {
+				t.Errorf("Expected request id to be echoed, got %s", p.RequestID)
+			}
+		})
+	}
+}
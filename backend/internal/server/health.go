@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/logging"
+	"github.com/r-cbb/cbbpoll/internal/version"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// Check reports whether something the server depends on is usable.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// healthStatus is the body of /healthz and /readyz.  Checks maps the name of
+// each readiness check to "ok" or "failed"; details of failures are logged
+// rather than returned.
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// AddReadinessCheck registers a check that has to pass for /readyz to report
+// the server as ready to take traffic.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.checks = append(s.checks, namedCheck{name: name, check: check})
+}
+
+// checkAuth fails until JWT keys have been loaded and the auth routes set up.
+func (s *Server) checkAuth(ctx context.Context) error {
+	if !s.authEnabled {
+		return fmt.Errorf("jwt keys not loaded")
+	}
+	return nil
+}
+
+func (s *Server) handleVersion() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.respond(w, r, version.Get(), http.StatusOK)
+	}
+}
+
+// handleHealthz reports that the process is up and serving requests.  It
+// doesn't look at any dependencies; that's what /readyz is for.
+func (s *Server) handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.respond(w, r, healthStatus{Status: "ok"}, http.StatusOK)
+	}
+}
+
+// handleReadyz runs every readiness check, responding 503 if any of them fail.
+func (s *Server) handleReadyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		checks := append([]namedCheck{{name: "jwt_keys", check: s.checkAuth}}, s.checks...)
+
+		status := healthStatus{Status: "ok", Checks: make(map[string]string)}
+		for _, c := range checks {
+			err := c.check(ctx)
+			if err != nil {
+				logging.FromContext(r.Context()).WithError(err).WithField("check", c.name).Warn("readiness check failed")
+				status.Status = "unavailable"
+				status.Checks[c.name] = "failed"
+				continue
+			}
+			status.Checks[c.name] = "ok"
+		}
+
+		if status.Status != "ok" {
+			s.respond(w, r, status, http.StatusServiceUnavailable)
+			return
+		}
+
+		s.respond(w, r, status, http.StatusOK)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	srv := NewServer()
+
+	r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	w := httptest.NewRecorder()
+
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected StatusOK, got: %v", w.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	passing := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return fmt.Errorf("connection refused") }
+
+	tests := []struct {
+		name           string
+		authEnabled    bool
+		checks         map[string]Check
+		expectedStatus int
+		expectedChecks map[string]string
+	}{
+		{
+			name:           "Ready",
+			authEnabled:    true,
+			checks:         map[string]Check{"database": passing, "migrations": passing},
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"jwt_keys": "ok", "database": "ok", "migrations": "ok"},
+		},
+		{
+			name:           "Database down",
+			authEnabled:    true,
+			checks:         map[string]Check{"database": failing, "migrations": passing},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"jwt_keys": "ok", "database": "failed", "migrations": "ok"},
+		},
+		{
+			name:           "No JWT keys",
+			authEnabled:    false,
+			checks:         map[string]Check{"database": passing},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"jwt_keys": "failed", "database": "ok"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := NewServer()
+			srv.authEnabled = test.authEnabled
+			for name, check := range test.checks {
+				srv.AddReadinessCheck(name, check)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			w := httptest.NewRecorder()
+
+			srv.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Errorf("Expected status %v, got %v", test.expectedStatus, w.Code)
+			}
+
+			var status healthStatus
+			if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+				t.Fatalf("Error decoding response: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(status.Checks, test.expectedChecks) {
+				t.Errorf("Expected checks %v, got %v", test.expectedChecks, status.Checks)
+			}
+		})
+	}
+}
@@ -1,9 +1,17 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/r-cbb/cbbpoll/internal/logging"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
 )
 
 type Middleware func(http.HandlerFunc) http.HandlerFunc
@@ -32,3 +40,142 @@ func SelectiveMiddleware(m Middleware, excludes []*mux.Route) func(http.Handler)
 	}
 }
 
+// authenticate rejects requests with bad credentials, reporting them as
+// problems like any other error.
+func (s *Server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return s.AuthClient.Authenticator(next, s.respondError)
+}
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 64
+)
+
+type requestInfoKey struct{}
+
+// requestInfo collects what the access log needs to know about a request as it
+// makes its way through the router and handler.
+type requestInfo struct {
+	id    string
+	route string
+	// name of the matched route, or its template if it has none, for metrics
+	name string
+	user string
+}
+
+func requestInfoFromCtx(ctx context.Context) (*requestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info, ok
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether an id sent by a client or proxy is safe to
+// reuse in our logs and responses.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+/*
+logRequests gives every request an id, reusing a well formed X-Request-ID sent by
+the client or a proxy, and echoes it back in the response.  A logger tagged with
+the id is put in the request context, and an access log line is written and the
+request metrics recorded once the request has been served.
+*/
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		info := &requestInfo{id: id}
+		logger := s.Logger.WithField("request_id", id)
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		ctx = logging.NewContext(ctx, logger)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+		metrics.ObserveRequest(info.name, r.Method, rec.status, elapsed)
+
+		fields := logrus.Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"route":      info.route,
+			"status":     rec.status,
+			"bytes":      rec.bytes,
+			"latency_ms": float64(elapsed.Microseconds()) / 1000,
+		}
+		if info.user != "" {
+			fields["user"] = info.user
+		}
+		logger.WithFields(fields).Info("request")
+	})
+}
+
+// annotateRoute records the template of the matched route for the access log,
+// and its name for request metrics.
+// It's router middleware because the route isn't known until mux matches it.
+func annotateRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := requestInfoFromCtx(r.Context()); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				if tmpl, err := route.GetPathTemplate(); err == nil {
+					info.route = tmpl
+				}
+				info.name = route.GetName()
+				if info.name == "" {
+					info.name = info.route
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,11 +1,22 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/r-cbb/cbbpoll/internal/app"
+	"github.com/r-cbb/cbbpoll/internal/db/memory"
+	"github.com/r-cbb/cbbpoll/internal/logging"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
+	"github.com/r-cbb/cbbpoll/internal/models"
 )
 
 func TestSelectiveMiddleware(t *testing.T) {
@@ -69,3 +80,118 @@ func GetTestHandler() http.Handler {
 	}
 	return http.HandlerFunc(fn)
 }
+
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	srv := NewServer()
+	srv.Logger = logging.New(&buf)
+	db := memory.NewClient()
+	if _, err := db.AddUser(models.User{Nickname: "Concision", IsAdmin: true}); err != nil {
+		t.Fatal(err)
+	}
+	srv.App = app.NewPollService(db)
+	srv.AuthClient = getAuth(models.UserToken{Nickname: "Concision", IsAdmin: true})
+
+	tests := []struct {
+		description    string
+		method         string
+		path           string
+		body           interface{}
+		requestID      string
+		expectedRoute  string
+		expectedName   string
+		expectedStatus int
+		expectedUser   string
+	}{
+		{
+			description:    "Generated id",
+			method:         http.MethodGet,
+			path:           "/v1/teams/12",
+			expectedRoute:  "/v1/teams/{id:[0-9]+}",
+			expectedName:   "team",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			description:    "Propagated id with user",
+			method:         http.MethodPost,
+			path:           "/v1/teams",
+			body:           models.Team{ShortName: "Arizona"},
+			requestID:      "abc-123",
+			expectedRoute:  "/v1/teams",
+			expectedName:   "addTeam",
+			expectedStatus: http.StatusCreated,
+			expectedUser:   "Concision",
+		},
+		{
+			description:    "Malformed id replaced",
+			method:         http.MethodGet,
+			path:           "/v1/ping",
+			requestID:      "not a valid\nid",
+			expectedRoute:  "/v1/ping",
+			expectedName:   "ping",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			description:    "No route",
+			method:         http.MethodGet,
+			path:           "/v1/nothing",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			buf.Reset()
+
+			var body bytes.Buffer
+			if test.body != nil {
+				if err := json.NewEncoder(&body).Encode(test.body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := httptest.NewRequest(test.method, test.path, &body)
+			if test.requestID != "" {
+				r.Header.Set(requestIDHeader, test.requestID)
+			}
+			route := test.expectedName
+			if route == "" {
+				route = "unmatched"
+			}
+			counter := metrics.HTTPRequests.WithLabelValues(route, test.method, strconv.Itoa(test.expectedStatus))
+			before := testutil.ToFloat64(counter)
+
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+
+			if testutil.ToFloat64(counter) != before+1 {
+				t.Errorf("Expected request to be counted under route %s", route)
+			}
+
+			id := w.Header().Get(requestIDHeader)
+			if !validRequestID(id) {
+				t.Errorf("Response has invalid request id %q", id)
+			}
+			if validRequestID(test.requestID) && id != test.requestID {
+				t.Errorf("Expected request id %s to be propagated, got %s", test.requestID, id)
+			}
+
+			// The access log is the last line written
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+				t.Fatalf("Access log isn't json: %s", buf.String())
+			}
+
+			if entry["msg"] != "request" || entry["request_id"] != id || entry["route"] != test.expectedRoute ||
+				entry["status"] != float64(test.expectedStatus) || entry["path"] != test.path {
+				t.Errorf("Unexpected access log: %v", entry)
+			}
+			if user, _ := entry["user"].(string); user != test.expectedUser {
+				t.Errorf("Expected user %q in access log, got %q", test.expectedUser, user)
+			}
+			if _, ok := entry["latency_ms"]; !ok {
+				t.Errorf("Access log missing latency: %v", entry)
+			}
+		})
+	}
+}
@@ -1,69 +1,284 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
+	"github.com/r-cbb/cbbpoll/internal/models"
 )
 
 type RedditClient interface {
-	UsernameFromToken(token string) (name string, err error)
+	// AccountFromToken describes the account an access token belongs to.
+	AccountFromToken(ctx context.Context, token string) (models.RedditAccount, error)
+	// FlairFromToken returns the token owner's flair text in subreddit, which is
+	// empty if they have none.  The token needs the flair scope.
+	FlairFromToken(ctx context.Context, token string, subreddit string) (string, error)
 }
 
+const (
+	userAgent = "cbbpoll_backend/0.1.0"
+	// Limit on each request to reddit, including reading the response
+	redditTimeout = 10 * time.Second
+	// Responses from reddit are small; anything bigger is a mistake
+	maxRedditResponseSize = 1 << 20
+	// Attempts at each call before giving up on server errors and rate limiting
+	redditAttempts = 3
+	// Wait before the first retry, doubling for each one after
+	redditBackoff = 500 * time.Millisecond
+	// Longest to wait for reddit's rate limit to reset before giving up
+	redditMaxWait = 10 * time.Second
+)
+
 type redditClient struct {
-	baseUrl string
+	baseUrl  string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	maxWait  time.Duration
+
+	mu sync.Mutex
+	// Reddit has said not to make any more requests before this
+	limitedUntil time.Time
 }
 
 func NewRedditClient(baseUrl string) RedditClient {
 	rc := redditClient{
-		baseUrl: baseUrl,
+		baseUrl:  baseUrl,
+		client:   &http.Client{Timeout: redditTimeout},
+		attempts: redditAttempts,
+		backoff:  redditBackoff,
+		maxWait:  redditMaxWait,
 	}
 
-	return rc
+	return &rc
 }
 
-func (rc redditClient) UsernameFromToken(token string) (name string, err error) {
-	var op errors.Op = "reddit.UsernameFromToken"
-	url := rc.baseUrl + "/me"
+// redditOutcome classifies the result of a reddit API call for metrics, so
+// rejected tokens can be told apart from reddit being down.
+func redditOutcome(err error) string {
+	if err == nil {
+		return metrics.RedditSuccess
+	}
+
+	switch errors.Kind(err) {
+	case errors.KindAuthError:
+		return metrics.RedditUnauthorized
+	case errors.KindServiceUnavailable:
+		return metrics.RedditUnavailable
+	default:
+		return metrics.RedditError
+	}
+}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+type meResponse struct {
+	Name         string  `json:"name"`
+	CreatedUTC   float64 `json:"created_utc"`
+	LinkKarma    int     `json:"link_karma"`
+	CommentKarma int     `json:"comment_karma"`
+	IsSuspended  bool    `json:"is_suspended"`
+}
+
+func (rc *redditClient) AccountFromToken(ctx context.Context, token string) (account models.RedditAccount, err error) {
+	var op errors.Op = "reddit.AccountFromToken"
+	defer func() { metrics.RedditRequests.WithLabelValues("account_from_token", redditOutcome(err)).Inc() }()
+
+	var me meResponse
+	err = rc.call(ctx, "account_from_token", http.MethodGet, rc.baseUrl+"/me", token, &me)
 	if err != nil {
-		return "", errors.E(op, err, "error creating http request")
+		return models.RedditAccount{}, errors.E(op, err)
+	}
+
+	if me.Name == "" {
+		return models.RedditAccount{}, errors.E(op, fmt.Errorf("response from reddit API doesn't include expected field 'name'"))
 	}
 
+	sec, frac := math.Modf(me.CreatedUTC)
+	return models.RedditAccount{
+		Name:         me.Name,
+		Created:      time.Unix(int64(sec), int64(frac*1e9)).UTC(),
+		LinkKarma:    me.LinkKarma,
+		CommentKarma: me.CommentKarma,
+		Suspended:    me.IsSuspended,
+	}, nil
+}
+
+type flairSelectorResponse struct {
+	Current struct {
+		FlairText string `json:"flair_text"`
+	} `json:"current"`
+}
+
+func (rc *redditClient) FlairFromToken(ctx context.Context, token string, subreddit string) (flair string, err error) {
+	var op errors.Op = "reddit.FlairFromToken"
+	defer func() { metrics.RedditRequests.WithLabelValues("flair_from_token", redditOutcome(err)).Inc() }()
+
+	// Subreddit endpoints aren't under the api version in baseUrl
+	base, err := url.Parse(rc.baseUrl)
+	if err != nil {
+		return "", errors.E(op, err, "invalid reddit base url")
+	}
+	path := &url.URL{Path: fmt.Sprintf("/r/%s/api/flairselector", subreddit)}
+
+	var selector flairSelectorResponse
+	err = rc.call(ctx, "flair_from_token", http.MethodPost, base.ResolveReference(path).String(), token, &selector)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+
+	return selector.Current.FlairText, nil
+}
+
+/*
+call makes a request to endpoint with the user's access token, decoding the response into v.
+Server errors and rate limiting are retried with exponential backoff, waiting
+as long as reddit asks to when it says how long that is.  Giving up, or ctx
+ending first, is KindServiceUnavailable.
+*/
+func (rc *redditClient) call(ctx context.Context, operation string, method string, endpoint string, token string, v interface{}) error {
+	const op errors.Op = "reddit.call"
+
+	var err error
+	for attempt := 0; attempt < rc.attempts; attempt++ {
+		var wait time.Duration
+		if attempt > 0 {
+			wait = rc.backoff << uint(attempt-1)
+		}
+		if limited := rc.rateLimitWait(); limited > wait {
+			wait = limited
+		}
+		if wait > rc.maxWait {
+			return errors.E(op, errors.KindServiceUnavailable, fmt.Sprintf("reddit rate limit doesn't reset for %v", wait))
+		}
+
+		if err := sleep(ctx, wait); err != nil {
+			return errors.E(op, err, errors.KindServiceUnavailable, "gave up waiting to retry reddit API")
+		}
+
+		if attempt > 0 {
+			metrics.RedditRetries.WithLabelValues(operation).Inc()
+		}
+
+		var retry bool
+		retry, err = rc.try(ctx, method, endpoint, token, v)
+		if !retry {
+			return err
+		}
+	}
+
+	return errors.E(op, err, fmt.Sprintf("giving up after %d attempts", rc.attempts))
+}
+
+// try makes a single request, reporting whether it's worth trying again if it fails.
+func (rc *redditClient) try(ctx context.Context, method string, endpoint string, token string, v interface{}) (retry bool, err error) {
+	const op errors.Op = "reddit.try"
+
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return false, errors.E(op, err, "error creating http request")
+	}
+	req = req.WithContext(ctx)
+
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("User-Agent", "cbbpoll_backend/0.1.0")
+	req.Header.Set("User-Agent", userAgent)
 
-	client := &http.Client{}
-	var resp *http.Response
-	resp, err = client.Do(req)
+	resp, err := rc.client.Do(req)
 	if err != nil {
-		return "", errors.E(op, err, "error on http request to reddit API", errors.KindServiceUnavailable)
+		// Nothing will change if the caller has given up
+		return ctx.Err() == nil, errors.E(op, err, "error on http request to reddit API", errors.KindServiceUnavailable)
 	}
+	defer resp.Body.Close()
+
+	rc.noteRateLimit(resp)
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		return "", errors.E(op, fmt.Errorf("reddit api returned status %d %s", resp.StatusCode, resp.Status), errors.KindAuthError)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return false, errors.E(op, fmt.Errorf("reddit api returned status %d %s", resp.StatusCode, resp.Status), errors.KindAuthError)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, errors.E(op, fmt.Errorf("reddit api returned status %d %s", resp.StatusCode, resp.Status), errors.KindServiceUnavailable)
+	case resp.StatusCode != http.StatusOK:
+		return false, errors.E(op, fmt.Errorf("reddit api returned status %d %s", resp.StatusCode, resp.Status), errors.KindServiceUnavailable)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", errors.E(op, fmt.Errorf("reddit api returned status %d %s", resp.StatusCode, resp.Status), errors.KindServiceUnavailable)
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRedditResponseSize))
+	if err != nil {
+		return ctx.Err() == nil, errors.E(op, err, "error reading response from reddit API", errors.KindServiceUnavailable)
 	}
 
-	var content []byte
-	content, err = ioutil.ReadAll(resp.Body)
-	data := make(map[string]interface{})
-	err = json.Unmarshal(content, &data)
+	err = json.Unmarshal(content, v)
 	if err != nil {
-		return "", errors.E(op, err, "error unmarshaling response from reddit API")
+		return false, errors.E(op, err, "error unmarshaling response from reddit API")
+	}
+
+	return false, nil
+}
+
+/*
+noteRateLimit remembers when reddit's rate limit resets if the response says
+there are no requests left, or asks for requests to stop with a 429.  Reddit
+reports the requests left as X-Ratelimit-Remaining and the seconds until they
+reset as X-Ratelimit-Reset; a 429 may also come with Retry-After.
+*/
+func (rc *redditClient) noteRateLimit(resp *http.Response) {
+	var wait time.Duration
+
+	reset, resetErr := strconv.Atoi(resp.Header.Get("X-Ratelimit-Reset"))
+	remaining, remainingErr := strconv.ParseFloat(resp.Header.Get("X-Ratelimit-Remaining"), 64)
+	if resetErr == nil && remainingErr == nil && remaining < 1 {
+		wait = time.Duration(reset) * time.Second
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if after, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			wait = time.Duration(after) * time.Second
+		} else if resetErr == nil {
+			wait = time.Duration(reset) * time.Second
+		}
+	}
+
+	if wait <= 0 {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if until := time.Now().Add(wait); until.After(rc.limitedUntil) {
+		rc.limitedUntil = until
 	}
+}
+
+// rateLimitWait is how long to wait before the next request to stay inside
+// reddit's rate limit.
+func (rc *redditClient) rateLimitWait() time.Duration {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return time.Until(rc.limitedUntil)
+}
 
-	name, ok := data["name"].(string)
-	if !ok {
-		return "", errors.E(op, fmt.Errorf("response from reddit API doesn't include expected field 'name'"))
+// sleep waits for d, unless ctx ends first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
 	}
 
-	return name, nil
-}
\ No newline at end of file
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
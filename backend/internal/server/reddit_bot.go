@@ -0,0 +1,292 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/app"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
+)
+
+// BotConfig describes the reddit account poll results are posted from, which
+// logs in through a "script" app registered to it.
+type BotConfig struct {
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+	// Subreddit to post in, without the r/
+	Subreddit string
+	TokenURL  string
+	// Reddit's oauth api; only the scheme and host are used
+	BaseURL string
+}
+
+type redditBot struct {
+	cfg    BotConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// RedditBot acts on reddit as the bot account.
+type RedditBot interface {
+	app.Poster
+	app.FlairSetter
+}
+
+func NewRedditBot(cfg BotConfig) RedditBot {
+	return &redditBot{cfg: cfg, client: &http.Client{Timeout: redditTimeout}}
+}
+
+// endpoint resolves path against the reddit api the bot talks to.
+func (rb *redditBot) endpoint(path string) (string, error) {
+	base, err := url.Parse(rb.cfg.BaseURL)
+	if err != nil {
+		return "", errors.E(err, "invalid reddit base url")
+	}
+	return base.ResolveReference(&url.URL{Path: path}).String(), nil
+}
+
+type submitResponse struct {
+	JSON struct {
+		// Each error is a list of its code, message and the field it's about
+		Errors [][]interface{} `json:"errors"`
+		Data   struct {
+			URL string `json:"url"`
+		} `json:"data"`
+	} `json:"json"`
+}
+
+/*
+SubmitPost creates a text post in the bot's subreddit.  Like Exchange it isn't
+retried, since reddit may have created the post even if it responds with an
+error, except when reddit rejects the bot's access token before doing anything.
+*/
+func (rb *redditBot) SubmitPost(title string, body string) (postURL string, err error) {
+	const op errors.Op = "reddit.SubmitPost"
+	defer func() { metrics.RedditRequests.WithLabelValues("submit_post", redditOutcome(err)).Inc() }()
+
+	endpoint, err := rb.endpoint("/api/submit")
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+
+	form := url.Values{}
+	form.Set("api_type", "json")
+	form.Set("kind", "self")
+	form.Set("sr", rb.cfg.Subreddit)
+	form.Set("title", title)
+	form.Set("text", body)
+	form.Set("sendreplies", "false")
+
+	var resp submitResponse
+	err = rb.call(endpoint, form, &resp)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+
+	if len(resp.JSON.Errors) > 0 {
+		return "", errors.E(op, fmt.Errorf("reddit refused the post: %v", resp.JSON.Errors))
+	}
+	if resp.JSON.Data.URL == "" {
+		return "", errors.E(op, fmt.Errorf("response from reddit API doesn't include expected field 'url'"))
+	}
+
+	return resp.JSON.Data.URL, nil
+}
+
+type flairResponse struct {
+	JSON struct {
+		Errors [][]interface{} `json:"errors"`
+	} `json:"json"`
+}
+
+/*
+SetFlair sets user's flair in the bot's subreddit, which needs the bot to be a
+moderator there with permission to manage flair.  Empty text and cssClass
+clear the user's flair.
+*/
+func (rb *redditBot) SetFlair(user string, text string, cssClass string) (err error) {
+	const op errors.Op = "reddit.SetFlair"
+	defer func() { metrics.RedditRequests.WithLabelValues("set_flair", redditOutcome(err)).Inc() }()
+
+	endpoint, err := rb.endpoint(fmt.Sprintf("/r/%s/api/flair", rb.cfg.Subreddit))
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	form := url.Values{}
+	form.Set("api_type", "json")
+	form.Set("name", user)
+	form.Set("text", text)
+	form.Set("css_class", cssClass)
+
+	var resp flairResponse
+	err = rb.call(endpoint, form, &resp)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if len(resp.JSON.Errors) > 0 {
+		return errors.E(op, fmt.Errorf("reddit refused to set flair: %v", resp.JSON.Errors))
+	}
+
+	return nil
+}
+
+// call posts form to endpoint as the bot, logging in again and retrying once
+// if reddit rejects its access token.
+func (rb *redditBot) call(endpoint string, form url.Values, v interface{}) error {
+	const op errors.Op = "reddit.botCall"
+
+	for attempt := 0; ; attempt++ {
+		token, err := rb.accessToken()
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		err = rb.post(endpoint, token, form, v)
+		if errors.Kind(err) == errors.KindAuthError && attempt == 0 {
+			// The token may have been revoked early, so get a new one
+			rb.forgetToken(token)
+			continue
+		}
+		if errors.Kind(err) == errors.KindAuthError {
+			// Not the caller's fault, so it mustn't look like their credentials were rejected
+			return errors.E(op, fmt.Errorf("reddit rejected the bot's access token: %v", err))
+		}
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		return nil
+	}
+}
+
+func (rb *redditBot) post(endpoint string, token string, form url.Values, v interface{}) error {
+	const op errors.Op = "reddit.post"
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.E(op, err, "error creating http request")
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := rb.client.Do(req)
+	if err != nil {
+		return errors.E(op, err, "error on http request to reddit API", errors.KindServiceUnavailable)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.E(op, fmt.Errorf("reddit api returned status %d %s", resp.StatusCode, resp.Status), errors.KindAuthError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.E(op, fmt.Errorf("reddit api returned status %d %s", resp.StatusCode, resp.Status), errors.KindServiceUnavailable)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRedditResponseSize))
+	if err != nil {
+		return errors.E(op, err, "error reading response from reddit API", errors.KindServiceUnavailable)
+	}
+
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		return errors.E(op, err, "error unmarshaling response from reddit API")
+	}
+
+	return nil
+}
+
+type passwordTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	Error       string `json:"error"`
+}
+
+// accessToken returns a token for the bot account, logging in again when the
+// last one is about to expire.
+func (rb *redditBot) accessToken() (string, error) {
+	const op errors.Op = "reddit.botAccessToken"
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.token != "" && time.Now().Before(rb.expires) {
+		return rb.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "password")
+	form.Set("username", rb.cfg.Username)
+	form.Set("password", rb.cfg.Password)
+
+	req, err := http.NewRequest(http.MethodPost, rb.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.E(op, err, "error creating http request")
+	}
+	req.SetBasicAuth(rb.cfg.ClientID, rb.cfg.ClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := rb.client.Do(req)
+	if err != nil {
+		return "", errors.E(op, err, "error on http request to reddit API", errors.KindServiceUnavailable)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", errors.E(op, fmt.Errorf("reddit rejected the bot's client credentials: status %d", resp.StatusCode))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.E(op, fmt.Errorf("reddit api returned status %d %s", resp.StatusCode, resp.Status), errors.KindServiceUnavailable)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRedditResponseSize))
+	if err != nil {
+		return "", errors.E(op, err, "error reading response from reddit API", errors.KindServiceUnavailable)
+	}
+
+	var token passwordTokenResponse
+	err = json.Unmarshal(content, &token)
+	if err != nil {
+		return "", errors.E(op, err, "error unmarshaling response from reddit API")
+	}
+
+	// Wrong bot credentials come back as a 200 with an error field
+	if token.Error != "" {
+		return "", errors.E(op, fmt.Errorf("reddit refused to log in the bot: %s", token.Error))
+	}
+	if token.AccessToken == "" {
+		return "", errors.E(op, fmt.Errorf("response from reddit API doesn't include expected field 'access_token'"))
+	}
+
+	rb.token = token.AccessToken
+	// Leave a minute's margin so a token doesn't expire on its way to reddit
+	rb.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+
+	return rb.token, nil
+}
+
+// forgetToken drops token, if it's still the one in use, so the next call logs in again.
+func (rb *redditBot) forgetToken(token string) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.token == token {
+		rb.token = ""
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/r-cbb/cbbpoll/internal/app"
+	authMocks "github.com/r-cbb/cbbpoll/internal/auth/mocks"
+	"github.com/r-cbb/cbbpoll/internal/db/memory"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+const (
+	fakeBotUser     = "cbbpoll-bot"
+	fakeBotPassword = "hunter2"
+)
+
+/*
+fakeRedditBotAPI logs in the bot with the password grant, accepts its posts
+and lets it set flair in r/CollegeBasketball.  Setting revoke makes reddit
+reject the next request's token, as if it had been revoked early, and refuse
+makes it refuse requests the way reddit does, with a 200 listing the errors.
+*/
+type fakeRedditBotAPI struct {
+	*httptest.Server
+	t *testing.T
+
+	mu     sync.Mutex
+	logins int
+	tokens map[string]bool
+	posts  []map[string]string
+	flair  map[string]string
+	revoke bool
+	refuse bool
+}
+
+func newFakeRedditBotAPI(t *testing.T) *fakeRedditBotAPI {
+	f := &fakeRedditBotAPI{t: t, tokens: make(map[string]bool), flair: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/access_token", f.accessToken)
+	mux.HandleFunc("/api/submit", f.submit)
+	mux.HandleFunc("/r/CollegeBasketball/api/flair", f.setFlair)
+	f.Server = httptest.NewServer(mux)
+
+	return f
+}
+
+func (f *fakeRedditBotAPI) botConfig() BotConfig {
+	return BotConfig{
+		ClientID:     fakeClientID,
+		ClientSecret: fakeClientSecret,
+		Username:     fakeBotUser,
+		Password:     fakeBotPassword,
+		Subreddit:    "CollegeBasketball",
+		TokenURL:     f.URL + "/api/v1/access_token",
+		BaseURL:      f.URL + "/api/v1",
+	}
+}
+
+func (f *fakeRedditBotAPI) accessToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != fakeClientID || secret != fakeClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.PostFormValue("grant_type") != "password" || r.PostFormValue("username") != fakeBotUser {
+		f.t.Errorf("Unexpected token request: %v", r.PostForm)
+	}
+	if r.PostFormValue("password") != fakeBotPassword {
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	f.logins++
+	token := fmt.Sprintf("bot-token-%d", f.logins)
+	f.tokens[token] = true
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": token, "token_type": "bearer", "expires_in": 3600})
+}
+
+func (f *fakeRedditBotAPI) submit(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if f.refuse {
+		_, _ = w.Write([]byte(`{"json": {"errors": [["SUBREDDIT_NOTALLOWED", "you aren't allowed to post there.", "sr"]]}}`))
+		return
+	}
+
+	post := map[string]string{}
+	for _, field := range []string{"api_type", "kind", "sr", "title", "text"} {
+		post[field] = r.PostFormValue(field)
+	}
+	f.posts = append(f.posts, post)
+
+	postURL := fmt.Sprintf("https://www.reddit.com/r/%s/comments/%d/", post["sr"], len(f.posts))
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"json": map[string]interface{}{"errors": []interface{}{}, "data": map[string]string{"url": postURL}},
+	})
+}
+
+// authorized reports whether r has a token the bot was given.  f.mu must be held.
+func (f *fakeRedditBotAPI) authorized(r *http.Request) bool {
+	var token string
+	_, _ = fmt.Sscanf(r.Header.Get("Authorization"), "Bearer %s", &token)
+	if f.revoke {
+		delete(f.tokens, token)
+		f.revoke = false
+	}
+	return f.tokens[token]
+}
+
+func (f *fakeRedditBotAPI) setFlair(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if f.refuse {
+		_, _ = w.Write([]byte(`{"json": {"errors": [["USER_DOESNT_EXIST", "that user doesn't exist", "name"]]}}`))
+		return
+	}
+
+	f.flair[r.PostFormValue("name")] = r.PostFormValue("text") + "/" + r.PostFormValue("css_class")
+	_, _ = w.Write([]byte(`{"json": {"errors": []}}`))
+}
+
+func TestRedditBot_SubmitPost(t *testing.T) {
+	reddit := newFakeRedditBotAPI(t)
+	defer reddit.Close()
+
+	bot := NewRedditBot(reddit.botConfig())
+
+	postURL, err := bot.SubmitPost("Poll Results: 2020 Week 1", "# 2020 Week 1")
+	if err != nil {
+		t.Fatalf("Unexpected error submitting post: %s", err.Error())
+	}
+	if postURL != "https://www.reddit.com/r/CollegeBasketball/comments/1/" {
+		t.Errorf("Unexpected post url %q", postURL)
+	}
+	expected := map[string]string{"api_type": "json", "kind": "self", "sr": "CollegeBasketball", "title": "Poll Results: 2020 Week 1", "text": "# 2020 Week 1"}
+	if len(reddit.posts) != 1 || fmt.Sprint(reddit.posts[0]) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected posts %v", reddit.posts)
+	}
+
+	// The token is reused until it expires
+	if _, err = bot.SubmitPost("Second", "post"); err != nil {
+		t.Fatalf("Unexpected error submitting post: %s", err.Error())
+	}
+	if reddit.logins != 1 {
+		t.Errorf("Expected 1 login, got %d", reddit.logins)
+	}
+
+	// A revoked token is replaced and the post tried again
+	reddit.revoke = true
+	if _, err = bot.SubmitPost("Third", "post"); err != nil {
+		t.Fatalf("Unexpected error submitting post with revoked token: %s", err.Error())
+	}
+	if reddit.logins != 2 || len(reddit.posts) != 3 {
+		t.Errorf("Expected 2 logins and 3 posts, got %d and %d", reddit.logins, len(reddit.posts))
+	}
+
+	reddit.refuse = true
+	_, err = bot.SubmitPost("Fourth", "post")
+	if err == nil || !strings.Contains(err.Error(), "SUBREDDIT_NOTALLOWED") {
+		t.Errorf("Expected reddit's refusal to be reported, got %v", err)
+	}
+}
+
+func TestRedditBot_SetFlair(t *testing.T) {
+	reddit := newFakeRedditBotAPI(t)
+	defer reddit.Close()
+
+	bot := NewRedditBot(reddit.botConfig())
+
+	if err := bot.SetFlair("Concision", "Arizona", "arizona"); err != nil {
+		t.Fatalf("Unexpected error setting flair: %s", err.Error())
+	}
+
+	// A revoked token is replaced here too
+	reddit.revoke = true
+	if err := bot.SetFlair("einsteins_haircut", "", ""); err != nil {
+		t.Fatalf("Unexpected error clearing flair: %s", err.Error())
+	}
+
+	expected := map[string]string{"Concision": "Arizona/arizona", "einsteins_haircut": "/"}
+	if fmt.Sprint(reddit.flair) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected flair %v, expected %v", reddit.flair, expected)
+	}
+
+	reddit.refuse = true
+	err := bot.SetFlair("nobody", "Duke", "duke")
+	if err == nil || !strings.Contains(err.Error(), "USER_DOESNT_EXIST") {
+		t.Errorf("Expected reddit's refusal to be reported, got %v", err)
+	}
+}
+
+func TestRedditBot_Login(t *testing.T) {
+	reddit := newFakeRedditBotAPI(t)
+	defer reddit.Close()
+
+	tests := []struct {
+		description  string
+		modify       func(cfg *BotConfig)
+		expectedKind errors.Code
+	}{
+		{
+			description:  "Wrong password",
+			modify:       func(cfg *BotConfig) { cfg.Password = "hunter3" },
+			expectedKind: errors.KindUnexpected,
+		},
+		{
+			description:  "Wrong client secret",
+			modify:       func(cfg *BotConfig) { cfg.ClientSecret = "wrong" },
+			expectedKind: errors.KindUnexpected,
+		},
+		{
+			description:  "Reddit down",
+			modify:       func(cfg *BotConfig) { cfg.TokenURL = reddit.URL + "/nowhere" },
+			expectedKind: errors.KindServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			cfg := reddit.botConfig()
+			test.modify(&cfg)
+
+			_, err := NewRedditBot(cfg).SubmitPost("title", "body")
+			if err == nil {
+				t.Fatal("Expected error, got none")
+			}
+			if errors.Kind(err) != test.expectedKind {
+				t.Errorf("Expected error kind %v, got %v: %s", test.expectedKind, errors.Kind(err), err.Error())
+			}
+		})
+	}
+
+	if len(reddit.posts) != 0 {
+		t.Errorf("Expected no posts, got %v", reddit.posts)
+	}
+}
+
+func TestPublishResults(t *testing.T) {
+	reddit := newFakeRedditBotAPI(t)
+	defer reddit.Close()
+
+	db := memory.NewClient()
+	srv := NewServer()
+	srv.App = app.NewPollService(db)
+	srv.App.Poster = NewRedditBot(reddit.botConfig())
+
+	var current models.UserToken
+	authClient := authMocks.AuthClient{}
+	authClient.On("UserTokenFromCtx", mock.Anything).Return(func(context.Context) models.UserToken {
+		return current
+	})
+	srv.AuthClient = &authClient
+
+	if _, err := db.AddUser(testAdmin); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.AddPoll(models.Poll{Season: 2020, Week: 1, CloseTime: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := models.UserToken{Nickname: testAdmin.Nickname, IsAdmin: true}
+	steps := []struct {
+		name           string
+		token          models.UserToken
+		path           string
+		expectedStatus int
+	}{
+		{"Voters can't publish", models.UserToken{Nickname: testUser.Nickname}, "/v1/polls/2020/1/publish", http.StatusForbidden},
+		{"Unknown poll", admin, "/v1/polls/2020/2/publish", http.StatusNotFound},
+		{"Admin publishes", admin, "/v1/polls/2020/1/publish", http.StatusOK},
+		{"Only published once", admin, "/v1/polls/2020/1/publish", http.StatusConflict},
+	}
+
+	for _, step := range steps {
+		current = step.token
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, step.path, nil))
+		if w.Code != step.expectedStatus {
+			t.Errorf("%s: POST %s returned %v, expected %v", step.name, step.path, w.Code, step.expectedStatus)
+		}
+	}
+
+	poll, err := db.GetPoll(2020, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poll.RedditURL != "https://www.reddit.com/r/CollegeBasketball/comments/1/" {
+		t.Errorf("Unexpected reddit url on poll %q", poll.RedditURL)
+	}
+	if len(reddit.posts) != 1 || reddit.posts[0]["title"] != "Poll Results: 2020 Week 1" {
+		t.Errorf("Unexpected posts %v", reddit.posts)
+	}
+}
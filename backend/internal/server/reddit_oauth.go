@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
+)
+
+// OAuthConfig describes the reddit app users log in through.
+type OAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	// Where reddit sends the user back to, which has to match the app's settings on reddit
+	RedirectURL string
+	AuthURL     string
+	TokenURL    string
+	Scopes      []string
+}
+
+// RedditOAuth runs the server side of reddit's authorization code flow.
+type RedditOAuth interface {
+	// AuthCodeURL is the reddit page that asks the user to let us log them in.
+	AuthCodeURL(state string, challenge string) string
+	// Exchange trades the code reddit sent the user back with for an access token.
+	Exchange(ctx context.Context, code string, verifier string) (accessToken string, err error)
+}
+
+type redditOAuth struct {
+	cfg    OAuthConfig
+	client *http.Client
+}
+
+func NewRedditOAuth(cfg OAuthConfig) RedditOAuth {
+	return redditOAuth{cfg: cfg, client: &http.Client{Timeout: redditTimeout}}
+}
+
+func (ro redditOAuth) AuthCodeURL(state string, challenge string) string {
+	v := url.Values{}
+	v.Set("client_id", ro.cfg.ClientID)
+	v.Set("response_type", "code")
+	v.Set("state", state)
+	v.Set("redirect_uri", ro.cfg.RedirectURL)
+	// Only the user's identity is needed, and only while logging in
+	v.Set("duration", "temporary")
+	v.Set("scope", strings.Join(ro.cfg.Scopes, " "))
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(ro.cfg.AuthURL, "?") {
+		sep = "&"
+	}
+	return ro.cfg.AuthURL + sep + v.Encode()
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+}
+
+// Exchange isn't retried, since reddit may have used up the code even if it
+// responds with an error.
+func (ro redditOAuth) Exchange(ctx context.Context, code string, verifier string) (accessToken string, err error) {
+	var op errors.Op = "reddit.Exchange"
+	defer func() { metrics.RedditRequests.WithLabelValues("exchange_code", redditOutcome(err)).Inc() }()
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", ro.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, ro.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.E(op, err, "error creating http request")
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(ro.cfg.ClientID, ro.cfg.ClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := ro.client.Do(req)
+	if err != nil {
+		return "", errors.E(op, err, "error on http request to reddit API", errors.KindServiceUnavailable)
+	}
+	defer resp.Body.Close()
+
+	// Reddit rejecting our own credentials isn't the user's fault
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", errors.E(op, fmt.Errorf("reddit rejected the client credentials: status %d", resp.StatusCode))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.E(op, fmt.Errorf("reddit api returned status %d %s", resp.StatusCode, resp.Status), errors.KindServiceUnavailable)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRedditResponseSize))
+	if err != nil {
+		return "", errors.E(op, err, "error reading response from reddit API", errors.KindServiceUnavailable)
+	}
+
+	var token tokenResponse
+	err = json.Unmarshal(content, &token)
+	if err != nil {
+		return "", errors.E(op, err, "error unmarshaling response from reddit API")
+	}
+
+	// Reddit reports a bad or reused code with a 200 and an error field
+	if token.Error != "" {
+		return "", errors.E(op, fmt.Errorf("reddit refused the authorization code: %s", token.Error), errors.KindAuthError)
+	}
+
+	if token.AccessToken == "" {
+		return "", errors.E(op, fmt.Errorf("response from reddit API doesn't include expected field 'access_token'"))
+	}
+
+	return token.AccessToken, nil
+}
+
+const (
+	// How long a user has to approve the login on reddit
+	loginTimeout = 10 * time.Minute
+	// Holds the login a browser started, until reddit sends it back
+	loginCookie = "cbbpoll_reddit_login"
+)
+
+/*
+loginCookies keep the PKCE verifier of each login sent to reddit in a cookie
+on the browser that started it, rather than on the server, so any instance
+with the same key can finish a login another one started.  The cookie is
+signed, and only accepted alongside the state reddit sends back with the code.
+*/
+type loginCookies struct {
+	key []byte
+}
+
+// start begins a login, returning its state, the PKCE challenge to send to
+// reddit, and the cookie value for the browser to hold.
+func (lc loginCookies) start(now time.Time) (state string, challenge string, cookie string, err error) {
+	const op errors.Op = "server.startLogin"
+
+	if len(lc.key) == 0 {
+		return "", "", "", errors.E(op, "no key configured for login cookies")
+	}
+
+	state, err = randomString()
+	if err != nil {
+		return "", "", "", errors.E(op, err, "error generating login state")
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", "", errors.E(op, err, "error generating code verifier")
+	}
+
+	payload := strings.Join([]string{state, verifier, strconv.FormatInt(now.Add(loginTimeout).Unix(), 10)}, ".")
+	return state, codeChallenge(verifier), payload + "." + lc.sign(payload), nil
+}
+
+// finish returns the verifier from a cookie set by start, if the cookie is
+// genuine, hasn't expired, and belongs to the login with the given state.
+func (lc loginCookies) finish(cookie string, state string, now time.Time) (string, bool) {
+	i := strings.LastIndex(cookie, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload, mac := cookie[:i], cookie[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(lc.sign(payload))) {
+		return "", false
+	}
+
+	fields := strings.Split(payload, ".")
+	if len(fields) != 3 || subtle.ConstantTimeCompare([]byte(fields[0]), []byte(state)) != 1 {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || !now.Before(time.Unix(expires, 0)) {
+		return "", false
+	}
+	return fields[1], true
+}
+
+func (lc loginCookies) sign(payload string) string {
+	h := hmac.New(sha256.New, lc.key)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallenge is the S256 PKCE challenge for verifier, per RFC 7636.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
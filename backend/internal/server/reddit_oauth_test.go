@@ -0,0 +1,486 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/r-cbb/cbbpoll/internal/app"
+	"github.com/r-cbb/cbbpoll/internal/auth"
+	authMocks "github.com/r-cbb/cbbpoll/internal/auth/mocks"
+	"github.com/r-cbb/cbbpoll/internal/db/memory"
+	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+const (
+	fakeClientID     = "client-id"
+	fakeClientSecret = "client-secret"
+	fakeRedirectURL  = "http://localhost:8000/v1/auth/reddit/callback"
+	fakeFrontendURL  = "http://localhost:8080/"
+)
+
+/*
+fakeReddit plays reddit's part in the authorization code flow.  Its authorize
+page approves every request as user, sending them back with a code, which the
+token endpoint exchanges for an access token if the client proves it started
+the login.  /api/v1/me reports who an access token belongs to.
+*/
+type fakeReddit struct {
+	*httptest.Server
+	t    *testing.T
+	user string
+
+	mu         sync.Mutex
+	challenges map[string]string // code -> PKCE challenge
+	tokens     map[string]string // access token -> user
+	exchanges  int
+	// Status for the token endpoint to respond with instead of doing its job
+	tokenStatus int
+}
+
+func newFakeReddit(t *testing.T, user string) *fakeReddit {
+	f := &fakeReddit{t: t, user: user, challenges: make(map[string]string), tokens: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/authorize", f.authorize)
+	mux.HandleFunc("/api/v1/access_token", f.accessToken)
+	mux.HandleFunc("/api/v1/me", f.me)
+	f.Server = httptest.NewServer(mux)
+
+	return f
+}
+
+func (f *fakeReddit) oauthConfig() OAuthConfig {
+	return OAuthConfig{
+		ClientID:     fakeClientID,
+		ClientSecret: fakeClientSecret,
+		RedirectURL:  fakeRedirectURL,
+		AuthURL:      f.URL + "/api/v1/authorize",
+		TokenURL:     f.URL + "/api/v1/access_token",
+		Scopes:       []string{"identity"},
+	}
+}
+
+func (f *fakeReddit) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != fakeClientID || q.Get("response_type") != "code" || q.Get("scope") != "identity" {
+		f.t.Errorf("Unexpected authorize request: %v", q)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		f.t.Errorf("Authorize request without PKCE challenge: %v", q)
+	}
+
+	f.mu.Lock()
+	code := fmt.Sprintf("code-%d", len(f.challenges))
+	f.challenges[code] = q.Get("code_challenge")
+	f.mu.Unlock()
+
+	back := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (f *fakeReddit) accessToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exchanges++
+
+	if f.tokenStatus != 0 {
+		w.WriteHeader(f.tokenStatus)
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != fakeClientID || secret != fakeClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != fakeRedirectURL {
+		f.t.Errorf("Unexpected token request: %v", r.PostForm)
+	}
+
+	code := r.PostFormValue("code")
+	challenge, ok := f.challenges[code]
+	delete(f.challenges, code)
+	if !ok || codeChallenge(r.PostFormValue("code_verifier")) != challenge {
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	token := "access-" + code
+	f.tokens[token] = f.user
+	_ = json.NewEncoder(w).Encode(map[string]string{"access_token": token, "token_type": "bearer"})
+}
+
+func (f *fakeReddit) me(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var token string
+	_, _ = fmt.Sscanf(r.Header.Get("Authorization"), "Bearer %s", &token)
+	name, ok := f.tokens[token]
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"name": name})
+}
+
+func newOAuthTestServer(t *testing.T, reddit *fakeReddit) *Server {
+	srv := NewServer()
+	srv.App = app.NewPollService(memory.NewClient())
+	srv.RedditClient = NewRedditClient(reddit.URL + "/api/v1")
+	srv.RedditOAuth = NewRedditOAuth(reddit.oauthConfig())
+	srv.FrontendURL = fakeFrontendURL
+	srv.LoginKey = []byte("login-key")
+
+	authClient := authMocks.AuthClient{}
+	authClient.On("UserTokenFromCtx", mock.Anything).Return(func(context.Context) models.UserToken { return models.UserToken{} })
+	authClient.On("CreateJWT", mock.AnythingOfType("models.User")).Return("some.token.value", nil)
+	authClient.On("Verifier").Return(func(next http.Handler) http.Handler { return next })
+	authClient.On("Authenticator", mock.Anything, mock.Anything).Return(func(next http.HandlerFunc, _ auth.ErrorResponder) http.HandlerFunc { return next })
+	srv.AuthClient = &authClient
+	srv.AuthRoutes()
+
+	return srv
+}
+
+// approve starts a login and has the fake reddit approve it, returning the
+// callback request reddit sends the user back with, carrying the cookie the
+// login set.
+func approve(t *testing.T, srv *Server) *http.Request {
+	cookie, location := startLogin(t, srv)
+
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirects.Get(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	r.AddCookie(cookie)
+	return r
+}
+
+// startLogin starts a login, returning the cookie it set and where it sent the user.
+func startLogin(t *testing.T, srv *Server) (*http.Cookie, string) {
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/auth/reddit/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("GET /v1/auth/reddit/login returned %v, expected %v", w.Code, http.StatusFound)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != loginCookie {
+		t.Fatalf("Expected the login cookie to be set, got %v", cookies)
+	}
+	c := cookies[0]
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/v1/auth/reddit/callback" || c.MaxAge <= 0 {
+		t.Errorf("Unexpected login cookie %v", c)
+	}
+	return c, w.Header().Get("Location")
+}
+
+func TestRedditLogin(t *testing.T) {
+	reddit := newFakeReddit(t, "Concision")
+	defer reddit.Close()
+	srv := newOAuthTestServer(t, reddit)
+
+	callback := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	// The user is sent on to the frontend with their session in the fragment
+	session := func(r *http.Request) url.Values {
+		t.Helper()
+		w := callback(r)
+		if w.Code != http.StatusFound {
+			t.Fatalf("GET %s returned %v, expected %v", r.URL, w.Code, http.StatusFound)
+		}
+		if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != loginCookie || cookies[0].MaxAge >= 0 {
+			t.Errorf("Expected the login cookie to be cleared, got %v", cookies)
+		}
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if location.Scheme+"://"+location.Host+location.Path != fakeFrontendURL || location.RawQuery != "" {
+			t.Errorf("Expected redirect to %s, got %s", fakeFrontendURL, location)
+		}
+		fragment, err := url.ParseQuery(location.Fragment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fragment
+	}
+
+	first := approve(t, srv)
+	s := session(first)
+	if s.Get("nickname") != "Concision" || s.Get("token") != "some.token.value" || s.Get("refresh_token") == "" || s.Get("new_user") != "true" {
+		t.Errorf("Unexpected session %v", s)
+	}
+
+	// The refresh token in the fragment works like any other
+	body := fmt.Sprintf(`{"refresh_token": %q}`, s.Get("refresh_token"))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/sessions/refresh", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Errorf("Refreshing the session returned %v, expected %v", w.Code, http.StatusOK)
+	}
+
+	// The same user logging in again isn't new
+	if s := session(approve(t, srv)); s.Get("nickname") != "Concision" || s.Get("new_user") != "" {
+		t.Errorf("Unexpected session for second login %v", s)
+	}
+
+	// Each login can only be finished once, since reddit won't exchange its code again
+	if w := callback(first); w.Code != http.StatusUnauthorized {
+		t.Errorf("Reused login returned %v, expected %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// Any server with the same key can finish a login another one started
+	other := newOAuthTestServer(t, reddit)
+	w = httptest.NewRecorder()
+	other.ServeHTTP(w, approve(t, srv))
+	if w.Code != http.StatusFound {
+		t.Errorf("Finishing a login on another server returned %v, expected %v", w.Code, http.StatusFound)
+	}
+
+	exchanges := reddit.exchanges
+	tests := []struct {
+		description    string
+		uri            string
+		expectedStatus int
+	}{
+		{
+			description:    "Unknown state",
+			uri:            "/v1/auth/reddit/callback?code=code-0&state=made-up",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			description:    "Missing code",
+			uri:            "/v1/auth/reddit/callback?state=made-up",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			description:    "User declined",
+			uri:            "/v1/auth/reddit/callback?error=access_denied&state=made-up",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if w := callback(httptest.NewRequest(http.MethodGet, test.uri, nil)); w.Code != test.expectedStatus {
+				t.Errorf("GET %s returned %v, expected %v", test.uri, w.Code, test.expectedStatus)
+			}
+		})
+	}
+	if reddit.exchanges != exchanges {
+		t.Errorf("Code was exchanged for a login that wasn't started")
+	}
+}
+
+func TestRedditLogin_WrongLogin(t *testing.T) {
+	reddit := newFakeReddit(t, "Concision")
+	defer reddit.Close()
+	srv := newOAuthTestServer(t, reddit)
+
+	// A code from one login presented with the state of another fails PKCE
+	first := approve(t, srv)
+	second := approve(t, srv)
+	swapped := url.Values{"code": {first.URL.Query().Get("code")}, "state": {second.URL.Query().Get("state")}}
+	r := httptest.NewRequest(http.MethodGet, "/v1/auth/reddit/callback?"+swapped.Encode(), nil)
+	cookie, err := second.Cookie(loginCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(cookie)
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Code from another login returned %v, expected %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRedditLogin_OtherBrowser(t *testing.T) {
+	reddit := newFakeReddit(t, "attacker")
+	defer reddit.Close()
+	srv := newOAuthTestServer(t, reddit)
+
+	// Someone approves a login with their own account, then sends the
+	// callback link to somebody else
+	link := approve(t, srv).URL.RequestURI()
+	victim, _ := startLogin(t, srv)
+
+	tests := []struct {
+		description string
+		cookie      *http.Cookie
+	}{
+		{
+			description: "No login started",
+		},
+		{
+			description: "Another login started",
+			cookie:      victim,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, link, nil)
+			if test.cookie != nil {
+				r.AddCookie(test.cookie)
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("GET %s returned %v, expected %v", link, w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+	if reddit.exchanges != 0 {
+		t.Errorf("Code was exchanged for a login started in another browser")
+	}
+}
+
+func TestRedditOAuth_Exchange(t *testing.T) {
+	reddit := newFakeReddit(t, "Concision")
+	defer reddit.Close()
+
+	tests := []struct {
+		description  string
+		cfg          func(OAuthConfig) OAuthConfig
+		tokenStatus  int
+		expectedKind errors.Code
+	}{
+		{
+			description:  "Bad code",
+			cfg:          func(c OAuthConfig) OAuthConfig { return c },
+			expectedKind: errors.KindAuthError,
+		},
+		{
+			description:  "Bad client credentials",
+			cfg:          func(c OAuthConfig) OAuthConfig { c.ClientSecret = "wrong"; return c },
+			expectedKind: errors.KindUnexpected,
+		},
+		{
+			description:  "Reddit down",
+			cfg:          func(c OAuthConfig) OAuthConfig { return c },
+			tokenStatus:  http.StatusServiceUnavailable,
+			expectedKind: errors.KindServiceUnavailable,
+		},
+		{
+			description:  "Reddit unreachable",
+			cfg:          func(c OAuthConfig) OAuthConfig { c.TokenURL = "http://127.0.0.1:0/token"; return c },
+			expectedKind: errors.KindServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			reddit.tokenStatus = test.tokenStatus
+			client := NewRedditOAuth(test.cfg(reddit.oauthConfig()))
+
+			_, err := client.Exchange(context.Background(), "not-a-code", "verifier")
+			if err == nil {
+				t.Fatalf("Expected error and didn't get one")
+			}
+			if errors.Kind(err) != test.expectedKind {
+				t.Errorf("Expected error kind %v, got %v", test.expectedKind, errors.Kind(err))
+			}
+		})
+	}
+}
+
+func TestLoginCookies(t *testing.T) {
+	logins := loginCookies{key: []byte("login-key")}
+	now := time.Now()
+
+	state, challenge, cookie, err := logins.start(now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	verifier, ok := logins.finish(cookie, state, now)
+	if !ok {
+		t.Fatalf("Expected login to be accepted")
+	}
+	if codeChallenge(verifier) != challenge {
+		t.Errorf("Challenge doesn't match verifier")
+	}
+
+	other, _, _, err := logins.start(now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	i := strings.LastIndex(cookie, ".")
+	tests := []struct {
+		description string
+		logins      loginCookies
+		cookie      string
+		state       string
+		now         time.Time
+	}{
+		{
+			description: "Another login's state",
+			cookie:      cookie,
+			state:       other,
+		},
+		{
+			description: "Expired",
+			cookie:      cookie,
+			state:       state,
+			now:         now.Add(loginTimeout),
+		},
+		{
+			description: "Different key",
+			logins:      loginCookies{key: []byte("another-key")},
+			cookie:      cookie,
+			state:       state,
+		},
+		{
+			description: "Tampered",
+			cookie:      strings.Replace(cookie[:i], state, other, 1) + cookie[i:],
+			state:       other,
+		},
+		{
+			description: "Garbage",
+			cookie:      "not-a-login",
+			state:       state,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if test.logins.key == nil {
+				test.logins = logins
+			}
+			if test.now.IsZero() {
+				test.now = now
+			}
+			if _, ok := test.logins.finish(test.cookie, test.state, test.now); ok {
+				t.Errorf("Expected login to be refused")
+			}
+		})
+	}
+
+	if _, _, _, err := (loginCookies{}).start(now); err == nil {
+		t.Errorf("Expected an error starting a login without a key")
+	}
+}
@@ -1,18 +1,32 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
+	"github.com/r-cbb/cbbpoll/internal/models"
 )
 
 var token = "This is the token"
 
-func TestRedditClient_UsernameFromToken(t *testing.T) {
-	goodResponse := struct{Name string `json:"name"`}{Name: "Concision"}
+// newTestRedditClient returns a client for url that doesn't wait long between retries.
+func newTestRedditClient(url string) *redditClient {
+	client := NewRedditClient(url).(*redditClient)
+	client.backoff = time.Millisecond
+	return client
+}
+
+func TestRedditClient_AccountFromToken(t *testing.T) {
+	goodResponse := meResponse{Name: "Concision", CreatedUTC: 1262304000, LinkKarma: 12, CommentKarma: 30, IsSuspended: true}
 	badResponse := struct {Foo string}{Foo: "Bar"}
 
 	tests := []struct {
@@ -20,12 +34,14 @@ func TestRedditClient_UsernameFromToken(t *testing.T) {
 		expectedCode int
 		errorExpected bool
 		expectedKind errors.Code
+		expectedOutcome string
 		response interface{}
 	} {
 		{
 			description: "Positive test",
 			expectedCode: http.StatusOK,
 			errorExpected: false,
+			expectedOutcome: metrics.RedditSuccess,
 			response: goodResponse,
 		},
 		{
@@ -33,17 +49,20 @@ func TestRedditClient_UsernameFromToken(t *testing.T) {
 			expectedCode: http.StatusServiceUnavailable,
 			errorExpected: true,
 			expectedKind: errors.KindServiceUnavailable,
+			expectedOutcome: metrics.RedditUnavailable,
 		},
 		{
 			description: "Bad token",
 			expectedCode: http.StatusUnauthorized,
 			errorExpected: true,
 			expectedKind: errors.KindAuthError,
+			expectedOutcome: metrics.RedditUnauthorized,
 		},
 		{
 			description: "Bad data from Reddit",
 			expectedCode: http.StatusOK,
 			errorExpected: true,
+			expectedOutcome: metrics.RedditError,
 			response: badResponse,
 		},
 	}
@@ -53,8 +72,14 @@ func TestRedditClient_UsernameFromToken(t *testing.T) {
 			ts := httptest.NewServer(FakeRedditHandler(t, test.expectedCode, test.response))
 			defer ts.Close()
 
-			client := NewRedditClient(ts.URL)
-			name, err := client.UsernameFromToken(token)
+			counter := metrics.RedditRequests.WithLabelValues("account_from_token", test.expectedOutcome)
+			before := testutil.ToFloat64(counter)
+
+			client := newTestRedditClient(ts.URL)
+			account, err := client.AccountFromToken(context.Background(), token)
+			if testutil.ToFloat64(counter) != before+1 {
+				t.Errorf("Expected reddit call to be counted with outcome %s", test.expectedOutcome)
+			}
 			if test.errorExpected && err == nil {
 				t.Errorf("Expected error and didn't get one")
 			}
@@ -67,8 +92,9 @@ func TestRedditClient_UsernameFromToken(t *testing.T) {
 				t.Errorf("Unexpected error: %v", err.Error())
 			}
 
-			if !test.errorExpected && name != "Concision" {
-				t.Errorf("Received wrong name")
+			expected := models.RedditAccount{Name: "Concision", Created: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC), LinkKarma: 12, CommentKarma: 30, Suspended: true}
+			if !test.errorExpected && account != expected {
+				t.Errorf("Received wrong account %v, expected %v", account, expected)
 			}
 		})
 	}
@@ -92,3 +118,171 @@ func FakeRedditHandler(t *testing.T, status int, response interface{}) http.Hand
 
 	return http.HandlerFunc(fn)
 }
+
+// flakyReddit fails with the given responses before succeeding.
+func flakyReddit(t *testing.T, failures []func(w http.ResponseWriter)) (*httptest.Server, *int32) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if int(n) <= len(failures) {
+			failures[n-1](w)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(meResponse{Name: "Concision"}); err != nil {
+			t.Errorf("Error encoding json: %v", err.Error())
+		}
+	}))
+	return ts, &calls
+}
+
+func status(code int, headers map[string]string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for k, v := range headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(code)
+	}
+}
+
+func TestRedditClient_Retries(t *testing.T) {
+	tests := []struct {
+		description   string
+		failures      []func(w http.ResponseWriter)
+		expectedCalls int32
+		expectedKind  errors.Code
+		errorExpected bool
+	}{
+		{
+			description:   "Recovers from server errors",
+			failures:      []func(w http.ResponseWriter){status(http.StatusBadGateway, nil), status(http.StatusInternalServerError, nil)},
+			expectedCalls: 3,
+		},
+		{
+			description:   "Recovers from rate limiting",
+			failures:      []func(w http.ResponseWriter){status(http.StatusTooManyRequests, nil)},
+			expectedCalls: 2,
+		},
+		{
+			description:   "Gives up",
+			failures:      []func(w http.ResponseWriter){status(http.StatusServiceUnavailable, nil), status(http.StatusServiceUnavailable, nil), status(http.StatusServiceUnavailable, nil)},
+			expectedCalls: 3,
+			errorExpected: true,
+			expectedKind:  errors.KindServiceUnavailable,
+		},
+		{
+			description:   "Doesn't retry a bad token",
+			failures:      []func(w http.ResponseWriter){status(http.StatusUnauthorized, nil)},
+			expectedCalls: 1,
+			errorExpected: true,
+			expectedKind:  errors.KindAuthError,
+		},
+		{
+			description:   "Doesn't retry other client errors",
+			failures:      []func(w http.ResponseWriter){status(http.StatusForbidden, nil)},
+			expectedCalls: 1,
+			errorExpected: true,
+			expectedKind:  errors.KindServiceUnavailable,
+		},
+		{
+			description:   "Rate limit too far off to wait for",
+			failures:      []func(w http.ResponseWriter){status(http.StatusTooManyRequests, map[string]string{"Retry-After": "600"})},
+			expectedCalls: 1,
+			errorExpected: true,
+			expectedKind:  errors.KindServiceUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ts, calls := flakyReddit(t, test.failures)
+			defer ts.Close()
+
+			retries := metrics.RedditRetries.WithLabelValues("account_from_token")
+			before := testutil.ToFloat64(retries)
+
+			_, err := newTestRedditClient(ts.URL).AccountFromToken(context.Background(), token)
+			if test.errorExpected && errors.Kind(err) != test.expectedKind {
+				t.Errorf("Expected error of kind %v, got %v", test.expectedKind, err)
+			}
+			if !test.errorExpected && err != nil {
+				t.Errorf("Unexpected error: %v", err.Error())
+			}
+			if *calls != test.expectedCalls {
+				t.Errorf("Expected %d calls to reddit, got %d", test.expectedCalls, *calls)
+			}
+			if retried := testutil.ToFloat64(retries) - before; retried != float64(*calls-1) {
+				t.Errorf("Expected %d retries to be counted, got %v", *calls-1, retried)
+			}
+		})
+	}
+}
+
+func TestRedditClient_RateLimitHeaders(t *testing.T) {
+	// The first response uses up the rate limit, which resets a second later
+	exhausted := func(w http.ResponseWriter) {
+		w.Header().Set("X-Ratelimit-Remaining", "0.0")
+		w.Header().Set("X-Ratelimit-Reset", "1")
+		if err := json.NewEncoder(w).Encode(meResponse{Name: "Concision"}); err != nil {
+			t.Errorf("Error encoding json: %v", err.Error())
+		}
+	}
+	ts, calls := flakyReddit(t, []func(w http.ResponseWriter){exhausted})
+	defer ts.Close()
+
+	client := newTestRedditClient(ts.URL)
+	if _, err := client.AccountFromToken(context.Background(), token); err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+
+	// Waits for the reset rather than going over the limit
+	start := time.Now()
+	if _, err := client.AccountFromToken(context.Background(), token); err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+	if waited := time.Since(start); waited < 500*time.Millisecond {
+		t.Errorf("Expected to wait for the rate limit to reset, waited %v", waited)
+	}
+
+	// Unless the caller can't wait that long
+	client.noteRateLimit(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {"5"}}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.AccountFromToken(ctx, token)
+	if errors.Kind(err) != errors.KindServiceUnavailable {
+		t.Errorf("Expected KindServiceUnavailable when the context ends first, got %v", err)
+	}
+	if *calls != 2 {
+		t.Errorf("Expected 2 calls to reddit, got %d", *calls)
+	}
+}
+
+func TestRedditClient_FlairFromToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/r/CollegeBasketball/api/flairselector" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"current": {"flair_text": "Arizona", "flair_css_class": "arizona"}, "choices": []}`))
+	}))
+	defer ts.Close()
+
+	// Subreddit endpoints are found from the root of the api host
+	client := newTestRedditClient(ts.URL + "/api/v1")
+	flair, err := client.FlairFromToken(context.Background(), token, "CollegeBasketball")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err.Error())
+	}
+	if flair != "Arizona" {
+		t.Errorf("Expected flair Arizona, got %q", flair)
+	}
+
+	_, err = client.FlairFromToken(context.Background(), "not the token", "CollegeBasketball")
+	if errors.Kind(err) != errors.KindAuthError {
+		t.Errorf("Expected KindAuthError for bad token, got %v", err)
+	}
+}
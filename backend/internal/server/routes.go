@@ -2,103 +2,125 @@ package server
 
 import (
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/r-cbb/cbbpoll/internal/app"
 	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/logging"
 	"github.com/r-cbb/cbbpoll/internal/models"
+	"github.com/r-cbb/cbbpoll/internal/render"
+	"github.com/r-cbb/cbbpoll/internal/version"
 )
 
 const v1 = "/v1"
 
 func (s *Server) Routes() {
 	s.router = mux.NewRouter()
+	s.router.Use(annotateRoute)
+
 	// API Health & Version
-	s.router.HandleFunc(fmt.Sprintf("%s/ping", v1), s.handlePing()).Methods(http.MethodGet)
+	s.router.HandleFunc(fmt.Sprintf("%s/ping", v1), s.handlePing()).Methods(http.MethodGet).Name("ping")
+	s.router.HandleFunc(fmt.Sprintf("%s/version", v1), s.handleVersion()).Methods(http.MethodGet).Name("version")
+	s.router.HandleFunc("/healthz", s.handleHealthz()).Methods(http.MethodGet).Name("healthz")
+	s.router.HandleFunc("/readyz", s.handleReadyz()).Methods(http.MethodGet).Name("readyz")
 
 	// Teams
-	s.router.HandleFunc(fmt.Sprintf("%s/teams", v1), s.handleAddTeam()).Methods(http.MethodPost)
-	s.router.HandleFunc(fmt.Sprintf("%s/teams", v1), s.handleListTeams()).Methods(http.MethodGet)
+	s.router.HandleFunc(fmt.Sprintf("%s/teams", v1), s.handleAddTeam()).Methods(http.MethodPost).Name("addTeam")
+	s.router.HandleFunc(fmt.Sprintf("%s/teams", v1), s.handleListTeams()).Methods(http.MethodGet).Name("listTeams")
 	s.router.HandleFunc(fmt.Sprintf("%s/teams/{id:[0-9]+}", v1), s.handleGetTeam()).Methods(http.MethodGet).Name("team")
 
 	// Users
-	s.router.HandleFunc(fmt.Sprintf("%s/users", v1), s.handleAddUser()).Methods(http.MethodPost)
-	s.router.HandleFunc(fmt.Sprintf("%s/users", v1), s.handleListUsers()).Methods(http.MethodGet)
-	s.router.HandleFunc(fmt.Sprintf("%s/users/me", v1), s.handleUsersMe()).Methods(http.MethodGet)
+	s.router.HandleFunc(fmt.Sprintf("%s/users", v1), s.handleAddUser()).Methods(http.MethodPost).Name("addUser")
+	s.router.HandleFunc(fmt.Sprintf("%s/users", v1), s.handleListUsers()).Methods(http.MethodGet).Name("listUsers")
+	s.router.HandleFunc(fmt.Sprintf("%s/users/me", v1), s.handleUsersMe()).Methods(http.MethodGet).Name("me")
 	s.router.HandleFunc(fmt.Sprintf("%s/users/{name}", v1), s.handleGetUser()).Methods(http.MethodGet).Name("user")
-	s.router.HandleFunc(fmt.Sprintf("%s/users/{name}", v1), s.handleUpdateUser()).Methods(http.MethodPut)
+	s.router.HandleFunc(fmt.Sprintf("%s/users/{name}", v1), s.handleUpdateUser()).Methods(http.MethodPut).Name("updateUser")
+	s.router.HandleFunc(fmt.Sprintf("%s/users/{name}", v1), s.handleDeleteUser()).Methods(http.MethodDelete).Name("deleteUser")
+	s.router.HandleFunc(fmt.Sprintf("%s/users/{name}/roles", v1), s.handleSetRoles()).Methods(http.MethodPut).Name("setRoles")
+	s.router.HandleFunc(fmt.Sprintf("%s/users/{name}/bias", v1), s.handleGetUserBias()).Methods(http.MethodGet).Name("userBias")
+	s.router.HandleFunc(fmt.Sprintf("%s/users/{name}/ballots", v1), s.handleGetVotingHistory()).Methods(http.MethodGet).Name("votingHistory")
+	s.router.HandleFunc(fmt.Sprintf("%s/users/{name}/export", v1), s.handleExportUser()).Methods(http.MethodGet).Name("exportUser")
+
+	// Roles
+	s.router.HandleFunc(fmt.Sprintf("%s/roles", v1), s.handleListRoles()).Methods(http.MethodGet).Name("listRoles")
 
 	// Polls
-	s.router.HandleFunc(fmt.Sprintf("%s/polls", v1), s.handleAddPoll()).Methods(http.MethodPost)
-	s.router.HandleFunc(fmt.Sprintf("%s/polls", v1), s.handleListPolls()).Methods(http.MethodGet)
+	s.router.HandleFunc(fmt.Sprintf("%s/polls", v1), s.handleAddPoll()).Methods(http.MethodPost).Name("addPoll")
+	s.router.HandleFunc(fmt.Sprintf("%s/polls", v1), s.handleListPolls()).Methods(http.MethodGet).Name("listPolls")
 	// %s/polls/&is_open=true
 	// ability to sort by close time to find most recent
 	s.router.HandleFunc(fmt.Sprintf("%s/polls/{season:[0-9]+}/{week:[0-9]+}", v1), s.handleGetPoll()).Methods(http.MethodGet).Name("poll")
-	s.router.HandleFunc(fmt.Sprintf("%s/polls/{season:[0-9]+}/{week:[0-9]+}/results", v1), s.handleGetResults()).Methods(http.MethodGet)
-	/// %s/polls/{season:[0-9]+}/{week:[0-9]+}/ballots --
+	s.router.HandleFunc(fmt.Sprintf("%s/polls/{season:[0-9]+}/{week:[0-9]+}/results", v1), s.handleGetResults()).Methods(http.MethodGet).Name("results")
+	s.router.HandleFunc(fmt.Sprintf("%s/polls/{season:[0-9]+}/{week:[0-9]+}/publish", v1), s.handlePublishResults()).Methods(http.MethodPost).Name("publishResults")
+	s.router.HandleFunc(fmt.Sprintf("%s/polls/{season:[0-9]+}/{week:[0-9]+}/ballots", v1), s.handleListPollBallots()).Methods(http.MethodGet).Name("pollBallots")
+	s.router.HandleFunc(fmt.Sprintf("%s/polls/{season:[0-9]+}/{week:[0-9]+}/bias", v1), s.handleGetPollBias()).Methods(http.MethodGet).Name("pollBias")
 
 	// Ballots
-	s.router.HandleFunc(fmt.Sprintf("%s/ballots", v1), s.handleAddBallot()).Methods(http.MethodPost)
-	s.router.HandleFunc(fmt.Sprintf("%s/ballots", v1), s.handleListBallots()).Methods(http.MethodGet)
+	s.router.HandleFunc(fmt.Sprintf("%s/ballots", v1), s.handleAddBallot()).Methods(http.MethodPost).Name("addBallot")
+	s.router.HandleFunc(fmt.Sprintf("%s/ballots", v1), s.handleListBallots()).Methods(http.MethodGet).Name("listBallots")
 	// ?user=Concision&season=2020&week=0
-	s.router.HandleFunc(fmt.Sprintf("%s/ballots/{id:[0-9]+}", v1), s.handleEditBallot()).Methods(http.MethodPut)
+	s.router.HandleFunc(fmt.Sprintf("%s/ballots/{id:[0-9]+}", v1), s.handleEditBallot()).Methods(http.MethodPut).Name("editBallot")
 	s.router.HandleFunc(fmt.Sprintf("%s/ballots/{id:[0-9]+}", v1), s.handleGetBallot()).Methods(http.MethodGet).Name("ballot")
-	s.router.HandleFunc(fmt.Sprintf("%s/ballots/{id:[0-9]+}", v1), s.handleDeleteBallot()).Methods(http.MethodDelete)
+	s.router.HandleFunc(fmt.Sprintf("%s/ballots/{id:[0-9]+}", v1), s.handleDeleteBallot()).Methods(http.MethodDelete).Name("deleteBallot")
+
+	// Audit Log
+	s.router.HandleFunc(fmt.Sprintf("%s/audit", v1), s.handleListAudit()).Methods(http.MethodGet).Name("listAudit")
 }
 
 func (s *Server) AuthRoutes() {
-	newSession := s.router.HandleFunc(fmt.Sprintf("%s/sessions", v1), s.handleNewSession()).Methods(http.MethodPost)
+	newSession := s.router.HandleFunc(fmt.Sprintf("%s/sessions", v1), s.handleNewSession()).Methods(http.MethodPost).Name("newSession")
+	// The access token is likely to have expired by the time a client refreshes
+	refreshSession := s.router.HandleFunc(fmt.Sprintf("%s/sessions/refresh", v1), s.handleRefreshSession()).Methods(http.MethodPost).Name("refreshSession")
+	s.router.HandleFunc(fmt.Sprintf("%s/sessions", v1), s.handleEndSession()).Methods(http.MethodDelete).Name("endSession")
+	jwks := s.router.HandleFunc("/.well-known/jwks.json", s.handleJWKS()).Methods(http.MethodGet).Name("jwks")
+	unauthenticated := []*mux.Route{newSession, refreshSession, jwks}
+
+	if s.RedditOAuth != nil {
+		login := s.router.HandleFunc(fmt.Sprintf("%s/auth/reddit/login", v1), s.handleRedditLogin()).Methods(http.MethodGet).Name("redditLogin")
+		callback := s.router.HandleFunc(fmt.Sprintf("%s/auth/reddit/callback", v1), s.handleRedditCallback()).Methods(http.MethodGet).Name("redditCallback")
+		unauthenticated = append(unauthenticated, login, callback)
+	}
 
 	s.router.Use(s.AuthClient.Verifier())
-	s.router.Use(SelectiveMiddleware(s.AuthClient.Authenticator, []*mux.Route{newSession}))
+	s.router.Use(SelectiveMiddleware(s.authenticate, unauthenticated))
+	s.authEnabled = true
 }
 
 func (s *Server) handlePing() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		version := models.VersionInfo{
-			Version: s.version(),
-		}
-		s.respond(w, r, version, http.StatusOK)
+		s.respond(w, r, version.Get(), http.StatusOK)
 		return
 	}
 }
 
 func (s *Server) handleAddTeam() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 
 		var newTeam models.Team
 		err := s.decode(w, r, &newTeam)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, err)
 			return
 		}
 
-		createdTeam, err := s.App.AddTeam(token, newTeam)
+		createdTeam, err := s.app(r).AddTeam(token, newTeam)
 
 		if err != nil {
-			switch errors.Kind(err) {
-			case errors.KindUnauthenticated:
-				s.respond(w, r, nil, http.StatusUnauthorized)
-				return
-			case errors.KindUnauthorized:
-				s.respond(w, r, nil, http.StatusForbidden)
-				return
-			default:
-				log.Println(err.Error())
-				s.respond(w, r, nil, http.StatusInternalServerError)
-				return
-			}
+			s.respondError(w, r, err)
+			return
 		}
 
 		teamURL, err := s.router.Get("team").URLPath("id", fmt.Sprintf("%d", createdTeam.ID))
 		if err != nil {
-			log.Println("Unable to get url for created team")
+			logging.FromContext(r.Context()).WithError(err).Warn("unable to get url for created team")
 		} else {
 			w.Header().Set("Location", fmt.Sprintf("%s%s", s.host, teamURL.String()))
 		}
@@ -114,20 +136,14 @@ func (s *Server) handleGetTeam() http.HandlerFunc {
 		id := vars["id"]
 		intId, err := strconv.ParseInt(id, 10, 64)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid team id"))
 			return
 		}
 
-		team, err := s.App.GetTeam(intId)
+		team, err := s.app(r).GetTeam(intId)
 
 		if err != nil {
-			if errors.Kind(err) == errors.KindNotFound {
-				s.respond(w, r, nil, http.StatusNotFound)
-				return
-			}
-
-			log.Println(err.Error())
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
@@ -138,10 +154,10 @@ func (s *Server) handleGetTeam() http.HandlerFunc {
 
 func (s *Server) handleListTeams() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		teams, err := s.App.AllTeams()
+		teams, err := s.app(r).AllTeams()
 
 		if err != nil {
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
@@ -152,36 +168,24 @@ func (s *Server) handleListTeams() http.HandlerFunc {
 
 func (s *Server) handleAddUser() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 
 		var user models.User
 		err := s.decode(w, r, &user)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, err)
 			return
 		}
-		createdUser, err := s.App.AddUser(token, user)
+		createdUser, err := s.app(r).AddUser(token, user)
 
 		if err != nil {
-			switch errors.Kind(err) {
-			case errors.KindUnauthenticated:
-				s.respond(w, r, nil, http.StatusUnauthorized)
-				return
-			case errors.KindUnauthorized:
-				s.respond(w, r, nil, http.StatusForbidden)
-				return
-			case errors.KindConflict:
-				s.respond(w, r, nil, http.StatusConflict)
-				return
-			default:
-				s.respond(w, r, nil, http.StatusInternalServerError)
-				return
-			}
+			s.respondError(w, r, err)
+			return
 		}
 
 		url, err := s.router.Get("user").URLPath("name", createdUser.Nickname)
 		if err != nil {
-			log.Println("Error retrieving url for created user")
+			logging.FromContext(r.Context()).WithError(err).Warn("error retrieving url for created user")
 		} else {
 			w.Header().Set("Location", fmt.Sprintf("%s%s", s.host, url))
 		}
@@ -193,7 +197,7 @@ func (s *Server) handleAddUser() http.HandlerFunc {
 
 func (s *Server) handleListUsers() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 		opts := app.NewOptions()
 
 		voters, err := strconv.ParseBool(r.URL.Query().Get("is_voter"))
@@ -201,10 +205,9 @@ func (s *Server) handleListUsers() http.HandlerFunc {
 			opts = opts.IsVoter(voters)
 		}
 
-		users, err := s.App.GetUsers(token, opts)
+		users, err := s.app(r).GetUsers(token, opts)
 		if err != nil {
-			log.Println(err.Error())
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
@@ -215,16 +218,16 @@ func (s *Server) handleListUsers() http.HandlerFunc {
 
 func (s *Server) handleUsersMe() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 
 		if !token.LoggedIn() {
-			s.respond(w, r, nil, http.StatusUnauthorized)
+			s.respondError(w, r, errors.E(errors.KindUnauthenticated, "not logged in"))
 			return
 		}
 
-		user, err := s.App.GetUser(token.Nickname)
+		user, err := s.app(r).GetUser(token, token.Nickname)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
@@ -238,14 +241,9 @@ func (s *Server) handleGetUser() http.HandlerFunc {
 		vars := mux.Vars(r)
 		name := vars["name"]
 
-		user, err := s.App.GetUser(name)
+		user, err := s.app(r).GetUser(s.userToken(r), name)
 		if err != nil {
-			if errors.Kind(err) == errors.KindNotFound {
-				s.respond(w, r, nil, http.StatusNotFound)
-				return
-			}
-
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
@@ -254,39 +252,95 @@ func (s *Server) handleGetUser() http.HandlerFunc {
 	}
 }
 
+// handleGetUserBias reports how much a voter has favoured their primary team.
+func (s *Server) handleGetUserBias() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		name := vars["name"]
+
+		bias, err := s.app(r).GetUserBias(name)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, bias, http.StatusOK)
+		return
+	}
+}
+
+// handleGetVotingHistory lists a user's ballots along with stats summarising them.
+func (s *Server) handleGetVotingHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.userToken(r)
+		vars := mux.Vars(r)
+		name := vars["name"]
+
+		history, err := s.app(r).GetVotingHistory(token, name)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, history, http.StatusOK)
+		return
+	}
+}
+
+// handleDeleteUser anonymizes a user's ballots and deletes everything else about them.
+func (s *Server) handleDeleteUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.userToken(r)
+		vars := mux.Vars(r)
+		name := vars["name"]
+
+		err := s.app(r).DeleteUser(token, name)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, nil, http.StatusNoContent)
+		return
+	}
+}
+
+// handleExportUser responds with everything stored about a user as a JSON file to download.
+func (s *Server) handleExportUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.userToken(r)
+		vars := mux.Vars(r)
+		name := vars["name"]
+
+		export, err := s.app(r).ExportUser(token, name)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"cbbpoll-%s.json\"", export.User.Nickname))
+		s.respond(w, r, export, http.StatusOK)
+		return
+	}
+}
+
 func (s *Server) handleUpdateUser() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 		vars := mux.Vars(r)
 		name := vars["name"]
 
 		var user models.User
 		err := s.decode(w, r, &user)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, err)
 			return
 		}
 
-		updatedUser, err := s.App.UpdateUser(token, name, user)
+		updatedUser, err := s.app(r).UpdateUser(token, name, user)
 		if err != nil {
-			log.Println(err.Error())
-			switch errors.Kind(err) {
-			case errors.KindUnauthenticated:
-				s.respond(w, r, nil, http.StatusUnauthorized)
-				return
-			case errors.KindUnauthorized:
-				s.respond(w, r, nil, http.StatusForbidden)
-				return
-			case errors.KindNotFound:
-				s.respond(w, r, nil, http.StatusNotFound)
-				return
-			case errors.KindBadRequest:
-				s.respond(w, r, nil, http.StatusBadRequest)
-				return
-			default:
-				s.respond(w, r, nil, http.StatusInternalServerError)
-				return
-			}
+			s.respondError(w, r, err)
+			return
 		}
 
 		s.respond(w, r, updatedUser, http.StatusOK)
@@ -294,22 +348,60 @@ func (s *Server) handleUpdateUser() http.HandlerFunc {
 	}
 }
 
+func (s *Server) handleSetRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.userToken(r)
+		vars := mux.Vars(r)
+		name := vars["name"]
+
+		var body struct {
+			Roles []models.Role `json:"roles"`
+		}
+		err := s.decode(w, r, &body)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		user, err := s.app(r).SetRoles(token, name, body.Roles)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, user, http.StatusOK)
+		return
+	}
+}
+
+func (s *Server) handleListRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roles := models.AllRoles()
+		infos := make([]models.RoleInfo, len(roles))
+		for i, role := range roles {
+			infos[i] = models.RoleInfo{Name: role, Permissions: role.Permissions()}
+		}
+
+		s.respond(w, r, infos, http.StatusOK)
+		return
+	}
+}
+
 func (s *Server) handleAddPoll() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 
 		var poll models.Poll
 		err := s.decode(w, r, &poll)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, err)
 			return
 		}
 
-		newPoll, err := s.App.AddPoll(token, poll)
+		newPoll, err := s.app(r).AddPoll(token, poll)
 
 		if err != nil {
-			log.Println(err.Error())
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
@@ -317,7 +409,7 @@ func (s *Server) handleAddPoll() http.HandlerFunc {
 			"season", strconv.FormatInt(int64(newPoll.Season), 10),
 			"week", strconv.FormatInt(int64(newPoll.Week), 10))
 		if err != nil {
-			log.Println(fmt.Sprintf("Error retrieving url for created poll: %s", err.Error()))
+			logging.FromContext(r.Context()).WithError(err).Warn("error retrieving url for created poll")
 		} else {
 			w.Header().Set("Location", fmt.Sprintf("%s%s", s.host, url))
 		}
@@ -329,9 +421,9 @@ func (s *Server) handleAddPoll() http.HandlerFunc {
 
 func (s *Server) handleListPolls() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// token := s.AuthClient.UserTokenFromCtx(r.Context())
+		// token := s.userToken(r)
 		//
-		// polls, err := s.App.GetPolls(token, app.NewOptions())
+		// polls, err := s.app(r).GetPolls(token, app.NewOptions())
 		// if err != nil {
 		//
 		// }
@@ -343,23 +435,18 @@ func (s *Server) handleGetPoll() http.HandlerFunc {
 		vars := mux.Vars(r)
 		season, err := strconv.Atoi(vars["season"])
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid season"))
 			return
 		}
 		week, err := strconv.Atoi(vars["week"])
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid week"))
 			return
 		}
 
-		poll, err := s.App.GetPoll(season, week)
+		poll, err := s.app(r).GetPoll(season, week)
 		if err != nil {
-			if errors.Kind(err) == errors.KindNotFound {
-				s.respond(w, r, nil, http.StatusNotFound)
-				return
-			}
-
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
@@ -368,60 +455,201 @@ func (s *Server) handleGetPoll() http.HandlerFunc {
 	}
 }
 
+// handleGetResults responds with a poll's results as json, or rendered as
+// markdown, csv or text for people; see resultsFormat.
 func (s *Server) handleGetResults() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 		vars := mux.Vars(r)
 		season, err := strconv.Atoi(vars["season"])
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid season"))
 			return
 		}
 		week, err := strconv.Atoi(vars["week"])
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid week"))
 			return
 		}
 
-		results, err := s.App.GetResults(token, season, week)
+		format, err := resultsFormat(r)
 		if err != nil {
-			if errors.Kind(err) == errors.KindNotFound {
-				s.respond(w, r, nil, http.StatusNotFound)
+			s.respondError(w, r, err)
+			return
+		}
+		w.Header().Set("Vary", "Accept")
+
+		if format == "json" {
+			results, err := s.app(r).GetResults(token, season, week)
+			if err != nil {
+				s.respondError(w, r, err)
 				return
 			}
 
-			log.Println(err.Error())
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respond(w, r, results, http.StatusOK)
+			return
+		}
+
+		report, err := s.app(r).GetResultsReport(token, season, week)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", resultsFormats[format]+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		switch format {
+		case "markdown":
+			_, err = io.WriteString(w, render.Markdown(report))
+		case "csv":
+			err = render.CSV(w, report)
+		case "text":
+			_, err = io.WriteString(w, render.Text(report))
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).WithError(err).Error("error writing results")
+		}
+		return
+	}
+}
+
+// resultsFormats maps the formats results can be requested in to their content types.
+var resultsFormats = map[string]string{
+	"json":     "application/json",
+	"markdown": "text/markdown",
+	"csv":      "text/csv",
+	"text":     "text/plain",
+}
+
+/*
+resultsFormat picks the format to respond with results in: the format query
+parameter if there is one, otherwise the first type in the Accept header that
+results can be rendered as, otherwise json.
+*/
+func resultsFormat(r *http.Request) (string, error) {
+	const op errors.Op = "server.resultsFormat"
+	if format := r.URL.Query().Get("format"); format != "" {
+		if _, ok := resultsFormats[format]; !ok {
+			return "", errors.E(op, errors.KindBadRequest, fmt.Sprintf("unknown format %q, expected json, markdown, csv or text", format))
+		}
+		return format, nil
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0])
+		for format, contentType := range resultsFormats {
+			if mediaType == contentType {
+				return format, nil
+			}
+		}
+	}
+
+	return "json", nil
+}
+
+// handleListPollBallots lists who voted in a poll and their primary teams.
+func (s *Server) handleListPollBallots() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.userToken(r)
+		vars := mux.Vars(r)
+		season, err := strconv.Atoi(vars["season"])
+		if err != nil {
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid season"))
+			return
+		}
+		week, err := strconv.Atoi(vars["week"])
+		if err != nil {
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid week"))
+			return
+		}
+
+		ballots, err := s.app(r).GetPollBallots(token, season, week)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, ballots, http.StatusOK)
+		return
+	}
+}
+
+// handleGetPollBias reports how much a closed poll's voters favoured their primary teams.
+func (s *Server) handleGetPollBias() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		season, err := strconv.Atoi(vars["season"])
+		if err != nil {
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid season"))
+			return
+		}
+		week, err := strconv.Atoi(vars["week"])
+		if err != nil {
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid week"))
+			return
+		}
+
+		bias, err := s.app(r).GetPollBias(season, week)
+		if err != nil {
+			s.respondError(w, r, err)
 			return
 		}
 
-		s.respond(w, r, results, http.StatusOK)
+		s.respond(w, r, bias, http.StatusOK)
+		return
+	}
+}
+
+// handlePublishResults posts a closed poll's results to reddit, responding with
+// the poll and the url of the post.
+func (s *Server) handlePublishResults() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.userToken(r)
+		vars := mux.Vars(r)
+		season, err := strconv.Atoi(vars["season"])
+		if err != nil {
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid season"))
+			return
+		}
+		week, err := strconv.Atoi(vars["week"])
+		if err != nil {
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid week"))
+			return
+		}
+
+		poll, err := s.app(r).PublishResults(token, season, week)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, poll, http.StatusOK)
 		return
 	}
 }
 
 func (s *Server) handleAddBallot() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 
 		var ballot models.Ballot
 		err := s.decode(w, r, &ballot)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, err)
 			return
 		}
 
-		newBallot, err := s.App.AddBallot(token, ballot)
+		newBallot, err := s.app(r).AddBallot(token, ballot)
 
 		if err != nil {
-			log.Println(err.Error())
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
 		url, err := s.router.Get("ballot").URLPath("id", strconv.FormatInt(int64(newBallot.ID), 10))
 		if err != nil {
-			log.Println(fmt.Sprintf("Error retrieving url for created ballot: %s", err.Error()))
+			logging.FromContext(r.Context()).WithError(err).Warn("error retrieving url for created ballot")
 		} else {
 			w.Header().Set("Location", fmt.Sprintf("%s%s", s.host, url))
 		}
@@ -433,25 +661,19 @@ func (s *Server) handleAddBallot() http.HandlerFunc {
 
 func (s *Server) handleGetBallot() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 		vars := mux.Vars(r)
 		id := vars["id"]
 		intId, err := strconv.ParseInt(id, 10, 64)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid ballot id"))
 			return
 		}
 
-		ballot, err := s.App.GetBallotById(token, intId)
+		ballot, err := s.app(r).GetBallotById(token, intId)
 
 		if err != nil {
-			log.Println(err)
-			if errors.Kind(err) == errors.KindNotFound {
-				s.respond(w, r, nil, http.StatusNotFound)
-				return
-			}
-
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
@@ -474,33 +696,87 @@ func (s *Server) handleEditBallot() http.HandlerFunc {
 
 func (s *Server) handleDeleteBallot() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := s.AuthClient.UserTokenFromCtx(r.Context())
+		token := s.userToken(r)
 		vars := mux.Vars(r)
 		id := vars["id"]
 		intId, err := strconv.ParseInt(id, 10, 64)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid ballot id"))
+			return
 		}
 
-		err = s.App.DeleteBallot(token, intId)
+		err = s.app(r).DeleteBallot(token, intId)
 		if err != nil {
-			switch errors.Kind(err) {
-			case errors.KindUnauthenticated:
-				s.respond(w, r, nil, http.StatusUnauthorized)
-				return
-			case errors.KindUnauthorized:
-				s.respond(w, r, nil, http.StatusForbidden)
-				return
-			case errors.KindNotFound:
-				s.respond(w, r, nil, http.StatusNotFound)
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, nil, http.StatusOK)
+		return
+	}
+}
+
+// sessionPayload is the response to starting or refreshing a session.
+type sessionPayload struct {
+	Nickname     string `json:"nickname"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// Why the user can't submit ballots, if they can't
+	IneligibleReason string `json:"ineligible_reason,omitempty"`
+}
+
+type refreshTokenBody struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// handleListAudit responds with the audit log, filtered by any of the actor,
+// action, target_type, target_id and request_id query parameters, and by since
+// and until, which are RFC 3339 times.
+func (s *Server) handleListAudit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.userToken(r)
+		query := r.URL.Query()
+		opts := app.NewOptions()
+
+		if actor := query.Get("actor"); actor != "" {
+			opts = opts.Actor(actor)
+		}
+		if action := query.Get("action"); action != "" {
+			opts = opts.Action(models.AuditAction(action))
+		}
+		if targetType := query.Get("target_type"); targetType != "" {
+			opts = opts.TargetType(targetType)
+		}
+		if targetID := query.Get("target_id"); targetID != "" {
+			opts = opts.TargetID(targetID)
+		}
+		if requestID := query.Get("request_id"); requestID != "" {
+			opts = opts.RequestID(requestID)
+		}
+		if since := query.Get("since"); since != "" {
+			t, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid since time"))
 				return
-			default:
-				s.respond(w, r, nil, http.StatusInternalServerError)
+			}
+			opts = opts.Since(t)
+		}
+		if until := query.Get("until"); until != "" {
+			t, err := time.Parse(time.RFC3339, until)
+			if err != nil {
+				s.respondError(w, r, errors.E(err, errors.KindBadRequest, "invalid until time"))
 				return
 			}
+			opts = opts.Until(t)
 		}
 
-		s.respond(w, r, nil, http.StatusOK)
+		entries, err := s.app(r).GetAuditLog(token, opts)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, entries, http.StatusOK)
 		return
 	}
 }
@@ -511,65 +787,266 @@ func (s *Server) handleNewSession() http.HandlerFunc {
 
 		splitHeader := strings.Split(authHeader, "Bearer")
 		if len(splitHeader) != 2 { // Bearer token not in proper format
-			s.respond(w, r, nil, http.StatusBadRequest)
+			s.respondError(w, r, errors.E(errors.KindBadRequest, "expected reddit access token as a Bearer token"))
 			return
 		}
 
 		accessToken := strings.TrimSpace(splitHeader[1])
 
-		name, err := s.RedditClient.UsernameFromToken(accessToken)
+		// KindAuthError means reddit rejected the token, KindServiceUnavailable
+		// that reddit's api is likely down
+		account, err := s.redditAccount(r, accessToken)
 		if err != nil {
-			if errors.Kind(err) == errors.KindAuthError {
-				s.respond(w, r, nil, http.StatusUnauthorized) // received a 401 from reddit
-				return
-			} else if errors.Kind(err) == errors.KindServiceUnavailable {
-				s.respond(w, r, nil, http.StatusServiceUnavailable) // Possible reddit api is down
-				return
-			} else {
-				s.respond(w, r, nil, http.StatusInternalServerError)
-				return
-			}
+			s.respondError(w, r, err)
+			return
 		}
 
-		// Get user
-		var newUser bool
-		user, err := s.App.GetUser(name)
-		if errors.Kind(err) == errors.KindNotFound {
-			user, err = s.App.NewUser(name)
-			if err != nil {
-				s.respond(w, r, nil, http.StatusInternalServerError)
-				return
-			}
-			newUser = true
+		s.startSession(w, r, account)
+		return
+	}
+}
+
+// redditAccount looks up the account accessToken belongs to, along with
+// anything else the eligibility rules need to know about it.
+func (s *Server) redditAccount(r *http.Request, accessToken string) (models.RedditAccount, error) {
+	account, err := s.RedditClient.AccountFromToken(r.Context(), accessToken)
+	if err != nil {
+		return models.RedditAccount{}, err
+	}
+
+	if rules := s.App.Eligibility; rules.RequiresFlair() {
+		account.Flair, err = s.RedditClient.FlairFromToken(r.Context(), accessToken, rules.FlairSubreddit)
+		if err != nil {
+			return models.RedditAccount{}, err
 		}
+	}
 
-		token, err := s.AuthClient.CreateJWT(user)
+	return account, nil
+}
+
+// newSession logs in the owner of a reddit account, creating them on first
+// login, and reports whether they're new.
+func (s *Server) newSession(r *http.Request, account models.RedditAccount) (sessionPayload, bool, error) {
+	// Get the user as they are now
+	user, newUser, err := s.app(r).LoginUser(account)
+	if err != nil {
+		return sessionPayload{}, false, err
+	}
+
+	token, err := s.AuthClient.CreateJWT(user)
+	if err != nil {
+		return sessionPayload{}, false, err
+	}
+
+	refreshToken, err := s.app(r).NewRefreshToken(user.Nickname)
+	if err != nil {
+		return sessionPayload{}, false, err
+	}
+
+	payload := sessionPayload{
+		Nickname:         user.Nickname,
+		Token:            token,
+		RefreshToken:     refreshToken,
+		IneligibleReason: user.IneligibleReason,
+	}
+	return payload, newUser, nil
+}
+
+// startSession logs in the owner of a reddit account, responding with a new
+// session for them.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, account models.RedditAccount) {
+	payload, newUser, err := s.newSession(r, account)
+	if err != nil {
+		s.respondError(w, r, err)
+		return
+	}
+
+	var status = http.StatusOK
+	if newUser {
+		status = http.StatusCreated
+
+		url, err := s.router.Get("user").URLPath("name", payload.Nickname)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("%s%s", s.host, url))
+	}
+	s.respond(w, r, payload, status)
+}
+
+func (s *Server) logins() loginCookies {
+	return loginCookies{key: s.LoginKey}
+}
+
+// handleRedditLogin sends the user to reddit to approve logging in.
+func (s *Server) handleRedditLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, challenge, login, err := s.logins().start(time.Now())
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		// Only the browser that started the login can finish it, so nobody can
+		// send someone else a callback link that logs them in as its sender
+		callback, err := s.router.Get("redditCallback").URLPath()
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     loginCookie,
+			Value:    login,
+			Path:     callback.Path,
+			MaxAge:   int(loginTimeout.Seconds()),
+			Secure:   true,
+			HttpOnly: true,
+			// Sent along when reddit redirects the user back
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		http.Redirect(w, r, s.RedditOAuth.AuthCodeURL(state, challenge), http.StatusFound)
+		return
+	}
+}
+
+/*
+handleRedditCallback finishes a login started by handleRedditLogin, taking the
+code and state reddit sent the user back with and redirecting them on to the
+frontend with a new session.  The session's fields go in the URL fragment, which
+browsers keep to themselves rather than sending on to servers or in Referer
+headers, with new_user set on the user's first login.
+*/
+func (s *Server) handleRedditCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		// e.g. access_denied if the user declined
+		if reason := query.Get("error"); reason != "" {
+			s.respondError(w, r, errors.E(errors.KindAuthError, fmt.Sprintf("reddit login failed: %s", reason)))
+			return
+		}
+
+		code, state := query.Get("code"), query.Get("state")
+		if code == "" || state == "" {
+			s.respondError(w, r, errors.E(errors.KindBadRequest, "expected code and state from reddit"))
+			return
+		}
+
+		cookie, err := r.Cookie(loginCookie)
+		if err != nil {
+			s.respondError(w, r, errors.E(errors.KindBadRequest, "login wasn't started by this browser"))
+			return
+		}
+		verifier, ok := s.logins().finish(cookie.Value, state, time.Now())
+		if !ok {
+			s.respondError(w, r, errors.E(errors.KindBadRequest, "login expired or wasn't started by this browser"))
+			return
+		}
+		// Reddit won't exchange the code twice, so the login can't be finished again anyway
+		http.SetCookie(w, &http.Cookie{Name: loginCookie, Path: cookie.Path, MaxAge: -1, Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+
+		accessToken, err := s.RedditOAuth.Exchange(r.Context(), code, verifier)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		account, err := s.redditAccount(r, accessToken)
 		if err != nil {
-			s.respond(w, r, nil, http.StatusInternalServerError)
+			s.respondError(w, r, err)
 			return
 		}
 
-		payload := struct {
-			Nickname string `json:"nickname"`
-			Token    string `json:"token"`
-		}{
-			Nickname: name,
-			Token:    token,
+		session, newUser, err := s.newSession(r, account)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
 		}
 
-		var status = http.StatusOK
+		fragment := url.Values{}
+		fragment.Set("nickname", session.Nickname)
+		fragment.Set("token", session.Token)
+		fragment.Set("refresh_token", session.RefreshToken)
+		if session.IneligibleReason != "" {
+			fragment.Set("ineligible_reason", session.IneligibleReason)
+		}
 		if newUser {
-			status = http.StatusCreated
+			fragment.Set("new_user", "true")
+		}
 
-			url, err := s.router.Get("user").URLPath("name", user.Nickname)
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, s.FrontendURL+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+}
+
+func (s *Server) handleRefreshSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body refreshTokenBody
+		err := s.decode(w, r, &body)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		user, refreshToken, err := s.app(r).RefreshSession(body.RefreshToken)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		token, err := s.AuthClient.CreateJWT(user)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		payload := sessionPayload{
+			Nickname:         user.Nickname,
+			Token:            token,
+			RefreshToken:     refreshToken,
+			IneligibleReason: user.IneligibleReason,
+		}
+		s.respond(w, r, payload, http.StatusOK)
+		return
+	}
+}
+
+func (s *Server) handleEndSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.userToken(r)
+
+		// The refresh token is optional; without it only the access token is revoked
+		var body refreshTokenBody
+		if r.ContentLength != 0 {
+			err := s.decode(w, r, &body)
 			if err != nil {
-				s.respond(w, r, nil, http.StatusInternalServerError)
+				s.respondError(w, r, err)
 				return
 			}
+		}
 
-			w.Header().Set("Location", fmt.Sprintf("%s%s", s.host, url))
+		err := s.app(r).EndSession(token, body.RefreshToken)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
 		}
-		s.respond(w, r, payload, status)
+
+		s.respond(w, r, nil, http.StatusNoContent)
+		return
+	}
+}
+
+// handleJWKS publishes the keys tokens are signed with, so other services can
+// verify them.
+func (s *Server) handleJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Keys only change on restart, but clients should notice a rotation soon after
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		s.respond(w, r, s.AuthClient.JWKS(), http.StatusOK)
 		return
 	}
 }
@@ -2,21 +2,30 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/r-cbb/cbbpoll/internal/app"
+	"github.com/r-cbb/cbbpoll/internal/auth"
 	authMocks "github.com/r-cbb/cbbpoll/internal/auth/mocks"
+	"github.com/r-cbb/cbbpoll/internal/db/memory"
 	"github.com/r-cbb/cbbpoll/internal/db/mocks"
 	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/metrics"
 	"github.com/r-cbb/cbbpoll/internal/models"
+	"github.com/r-cbb/cbbpoll/internal/version"
 )
 
 var inputTeam = models.Team{
@@ -54,18 +63,24 @@ var testUser = models.User{
 
 func addTeamMockDb() *mocks.DBClient {
 	myMock := mocks.DBClient{}
+	myMock.On("GetUser", testAdmin.Nickname).Return(testAdmin, nil)
+	myMock.On("WithAudit", mock.AnythingOfType("models.AuditEntry")).Return(&myMock)
 	myMock.On("AddTeam", inputTeam).Return(testArizona, nil).Once()
 	return &myMock
 }
 
 func addTeamDbError() *mocks.DBClient {
 	myMock := mocks.DBClient{}
+	myMock.On("GetUser", testAdmin.Nickname).Return(testAdmin, nil)
+	myMock.On("WithAudit", mock.AnythingOfType("models.AuditEntry")).Return(&myMock)
 	myMock.On("AddTeam", inputTeam).Return(models.Team{}, fmt.Errorf("some error")).Once()
 	return &myMock
 }
 
 func addTeamConcurrencyError() *mocks.DBClient {
 	myMock := mocks.DBClient{}
+	myMock.On("GetUser", testAdmin.Nickname).Return(testAdmin, nil)
+	myMock.On("WithAudit", mock.AnythingOfType("models.AuditEntry")).Return(&myMock)
 	myMock.On("AddTeam", inputTeam).Return(models.Team{}, errors.E(errors.KindConcurrencyProblem, fmt.Errorf("some error"))).Once()
 	myMock.On("AddTeam", inputTeam).Return(testArizona, nil).Once()
 	return &myMock
@@ -159,7 +174,7 @@ func TestPing(t *testing.T) {
 	bodyBytes, _ := ioutil.ReadAll(w.Body)
 	bs := string(bodyBytes)
 
-	expected, _ := json.Marshal(models.VersionInfo{Version: srv.version()})
+	expected, _ := json.Marshal(version.Get())
 	expStr := string(expected) + "\n"
 
 	if bs != expStr {
@@ -167,6 +182,32 @@ func TestPing(t *testing.T) {
 	}
 }
 
+func TestMetrics(t *testing.T) {
+	srv := NewServer()
+
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/ping", nil))
+
+	// Metrics are served on a listener of their own, not by the API
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected StatusNotFound from the API, got: %v %v", w.Result().StatusCode, w.Result().Status)
+	}
+
+	w = httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Errorf("Expected StatusOK, got: %v %v", w.Result().StatusCode, w.Result().Status)
+	}
+
+	bodyBytes, _ := ioutil.ReadAll(w.Body)
+	expected := `cbbpoll_http_requests_total{method="GET",route="ping",status="200"}`
+	if !strings.Contains(string(bodyBytes), expected) {
+		t.Errorf("Expected metrics to include %s, got: %s", expected, string(bodyBytes))
+	}
+}
+
 func TestGetMe(t *testing.T) {
 	getDb := func(nick string, user models.User, err error) *mocks.DBClient {
 		myMock := mocks.DBClient{}
@@ -363,6 +404,12 @@ func TestGetUser(t *testing.T) {
 			mockDb:         getDb(testUser.Nickname, testUser, nil),
 			expectedUser:   testUser,
 		},
+		{
+			name:           "Eligibility hidden",
+			expectedStatus: http.StatusOK,
+			mockDb:         getDb(testUser.Nickname, models.User{Nickname: testUser.Nickname, IneligibleReason: "reddit account is suspended"}, nil),
+			expectedUser:   testUser,
+		},
 		{
 			name:           "Not found",
 			expectedStatus: http.StatusNotFound,
@@ -380,6 +427,9 @@ func TestGetUser(t *testing.T) {
 			srv := NewServer()
 			db := test.mockDb
 			srv.App = app.NewPollService(db)
+			authClient := authMocks.AuthClient{}
+			authClient.On("UserTokenFromCtx", mock.Anything).Return(func(context.Context) models.UserToken { return models.UserToken{} })
+			srv.AuthClient = &authClient
 
 			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/users/%s", testUser.Nickname), nil)
 			w := httptest.NewRecorder()
@@ -405,24 +455,133 @@ func TestGetUser(t *testing.T) {
 	}
 }
 
+func TestSetRoles(t *testing.T) {
+	db := memory.NewClient()
+	srv := NewServer()
+	srv.App = app.NewPollService(db)
+
+	var current models.UserToken
+	authClient := authMocks.AuthClient{}
+	authClient.On("UserTokenFromCtx", mock.Anything).Return(func(context.Context) models.UserToken {
+		return current
+	})
+	srv.AuthClient = &authClient
+
+	for _, u := range []models.User{testAdmin, testUser} {
+		if _, err := db.AddUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		token          models.UserToken
+		body           string
+		expectedStatus int
+		expectedRoles  []models.Role
+	}{
+		{
+			name:           "Not logged in",
+			body:           `{"roles":["auditor"]}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Voter moderator",
+			token:          models.UserToken{Nickname: "mod", Roles: []models.Role{models.RoleVoterModerator}},
+			body:           `{"roles":["auditor"]}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Unknown role",
+			token:          models.UserToken{Nickname: testAdmin.Nickname, IsAdmin: true},
+			body:           `{"roles":["superuser"]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "OK",
+			token:          models.UserToken{Nickname: testAdmin.Nickname, IsAdmin: true},
+			body:           `{"roles":["voter_moderator","auditor"]}`,
+			expectedStatus: http.StatusOK,
+			expectedRoles:  []models.Role{models.RoleAuditor, models.RoleVoterModerator},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current = test.token
+			r := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/v1/users/%s/roles", testUser.Nickname), strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			if w.Code != test.expectedStatus {
+				t.Fatalf("PUT /v1/users/%s/roles returned %v, expected %v", testUser.Nickname, w.Code, test.expectedStatus)
+			}
+
+			if !testSuccess(w.Code) {
+				return
+			}
+
+			var res models.User
+			err := json.NewDecoder(w.Body).Decode(&res)
+			if err != nil {
+				t.Fatalf("Error decoding json response: %v", err.Error())
+			}
+			if !reflect.DeepEqual(res.Roles, test.expectedRoles) {
+				t.Errorf("Expected roles %v, got %v", test.expectedRoles, res.Roles)
+			}
+		})
+	}
+}
+
+func TestListRoles(t *testing.T) {
+	srv := NewServer()
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/roles", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("/v1/roles returned %v, expected %v", w.Code, http.StatusOK)
+	}
+
+	var res []models.RoleInfo
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if err != nil {
+		t.Fatalf("Error decoding json response: %v", err.Error())
+	}
+	if len(res) != len(models.AllRoles()) {
+		t.Fatalf("Expected %d roles, got %d", len(models.AllRoles()), len(res))
+	}
+	for _, info := range res {
+		if info.Name == models.RoleAuditor && !reflect.DeepEqual(info.Permissions, models.RoleAuditor.Permissions()) {
+			t.Errorf("Unexpected permissions for auditor: %v", info.Permissions)
+		}
+	}
+}
+
 type mockRedditClient struct {
-	token string
-	name  string
-	err   error
+	token   string
+	account models.RedditAccount
+	err     error
+}
+
+func (c mockRedditClient) AccountFromToken(ctx context.Context, token string) (models.RedditAccount, error) {
+	if token != c.token {
+		panic("tokens don't match")
+	}
+	return c.account, c.err
 }
 
-func (c mockRedditClient) UsernameFromToken(token string) (name string, err error) {
+func (c mockRedditClient) FlairFromToken(ctx context.Context, token string, subreddit string) (string, error) {
 	if token != c.token {
 		panic("tokens don't match")
 	}
-	return c.name, c.err
+	return c.account.Flair, c.err
 }
 
 func newMockRedditClient(expToken string, name string, err error) mockRedditClient {
 	return mockRedditClient{
-		token: expToken,
-		name:  name,
-		err:   err,
+		token:   expToken,
+		account: models.RedditAccount{Name: name},
+		err:     err,
 	}
 }
 
@@ -434,6 +593,7 @@ func TestNewSession(t *testing.T) {
 		myMock := mocks.DBClient{}
 		myMock.On("GetUser", nick).Return(user, err)
 		myMock.On("AddUser", mock.AnythingOfType("models.User")).Return(user, err2)
+		myMock.On("AddRefreshToken", mock.AnythingOfType("models.RefreshToken")).Return(nil)
 		return &myMock
 	}
 
@@ -516,10 +676,245 @@ func TestNewSession(t *testing.T) {
 			if !testSuccess(w.Result().StatusCode) {
 				return
 			}
+
+			var res sessionPayload
+			err := json.NewDecoder(w.Body).Decode(&res)
+			if err != nil {
+				t.Fatalf("Error decoding json response: %v", err.Error())
+			}
+			if res.Token != expectedToken || res.RefreshToken == "" {
+				t.Errorf("Unexpected session %v", res)
+			}
 		})
 	}
 }
 
+func TestSessionLifecycle(t *testing.T) {
+	db := memory.NewClient()
+	srv := NewServer()
+	srv.App = app.NewPollService(db)
+	srv.RedditClient = newMockRedditClient("reddit.token", testUser.Nickname, nil)
+
+	var current models.UserToken
+	authClient := authMocks.AuthClient{}
+	authClient.On("UserTokenFromCtx", mock.Anything).Return(func(context.Context) models.UserToken {
+		return current
+	})
+	authClient.On("CreateJWT", mock.AnythingOfType("models.User")).Return("some.token.value", nil)
+	authClient.On("Verifier").Return(func(next http.Handler) http.Handler { return next })
+	authClient.On("Authenticator", mock.Anything, mock.Anything).Return(func(next http.HandlerFunc, _ auth.ErrorResponder) http.HandlerFunc { return next })
+	srv.AuthClient = &authClient
+	srv.AuthRoutes()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/sessions", nil)
+	r.Header.Set("Authorization", "Bearer reddit.token")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /v1/sessions returned %v, expected %v", w.Code, http.StatusCreated)
+	}
+	var session sessionPayload
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"refresh_token":%q}`, token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/sessions/refresh", strings.NewReader(body)))
+		return w
+	}
+
+	w = refresh(session.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /v1/sessions/refresh returned %v, expected %v", w.Code, http.StatusOK)
+	}
+	var refreshed sessionPayload
+	if err := json.NewDecoder(w.Body).Decode(&refreshed); err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.Nickname != testUser.Nickname || refreshed.RefreshToken == session.RefreshToken {
+		t.Errorf("Unexpected refreshed session %v", refreshed)
+	}
+
+	if w := refresh("garbage"); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST /v1/sessions/refresh with unknown token returned %v, expected %v", w.Code, http.StatusUnauthorized)
+	}
+
+	// Logging out revokes both tokens
+	current = models.UserToken{Nickname: testUser.Nickname, ID: "abc", ExpiresAt: time.Now().Add(time.Hour)}
+	body := fmt.Sprintf(`{"refresh_token":%q}`, refreshed.RefreshToken)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/sessions", strings.NewReader(body)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /v1/sessions returned %v, expected %v", w.Code, http.StatusNoContent)
+	}
+
+	if w := refresh(refreshed.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST /v1/sessions/refresh after logout returned %v, expected %v", w.Code, http.StatusUnauthorized)
+	}
+	revoked, err := srv.App.IsTokenRevoked("abc")
+	if err != nil || !revoked {
+		t.Errorf("Access token not revoked after logout: %v, %v", revoked, err)
+	}
+}
+
+func TestNewSession_Eligibility(t *testing.T) {
+	srv := NewServer()
+	srv.App = app.NewPollService(memory.NewClient())
+	srv.App.Eligibility = app.EligibilityRules{FlairSubreddit: "CollegeBasketball"}
+
+	authClient := authMocks.AuthClient{}
+	authClient.On("CreateJWT", mock.AnythingOfType("models.User")).Return("some.token.value", nil)
+	authClient.On("Verifier").Return(func(next http.Handler) http.Handler { return next })
+	srv.AuthClient = &authClient
+
+	reddit := newMockRedditClient("reddit.token", testUser.Nickname, nil)
+	srv.RedditClient = reddit
+	srv.AuthRoutes()
+
+	login := func() sessionPayload {
+		r := httptest.NewRequest(http.MethodPost, "/v1/sessions", nil)
+		r.Header.Set("Authorization", "Bearer reddit.token")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if !testSuccess(w.Code) {
+			t.Fatalf("POST /v1/sessions returned %v", w.Code)
+		}
+		var session sessionPayload
+		if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	// Users without flair can log in, but are told why they can't vote
+	if session := login(); !strings.Contains(session.IneligibleReason, "r/CollegeBasketball") {
+		t.Errorf("Expected session to say flair is needed, got %q", session.IneligibleReason)
+	}
+
+	reddit.account.Flair = "Arizona"
+	srv.RedditClient = reddit
+	if session := login(); session.IneligibleReason != "" {
+		t.Errorf("Expected user with flair to be eligible, got %q", session.IneligibleReason)
+	}
+}
+
+func TestBallotLifecycle(t *testing.T) {
+	db := memory.NewClient()
+	srv := NewServer()
+	srv.App = app.NewPollService(db)
+
+	// The token for each request is swapped out by setting current
+	var current models.UserToken
+	authClient := authMocks.AuthClient{}
+	authClient.On("UserTokenFromCtx", mock.Anything).Return(func(context.Context) models.UserToken {
+		return current
+	})
+	srv.AuthClient = &authClient
+
+	do := func(token models.UserToken, method string, path string, body interface{}) *httptest.ResponseRecorder {
+		current = token
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(method, path, &buf))
+		return w
+	}
+
+	admin := models.UserToken{Nickname: testAdmin.Nickname, IsAdmin: true}
+	voter := models.UserToken{Nickname: testUser.Nickname}
+	other := models.UserToken{Nickname: "SomeoneElse"}
+
+	// Privileged requests are checked against the database, so the admin has to exist first
+	if _, err := db.AddUser(testAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	votes := make([]models.Vote, 25)
+	for i := range votes {
+		w := do(admin, http.MethodPost, "/v1/teams", models.Team{ShortName: fmt.Sprintf("Team %d", i)})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST /v1/teams returned %v", w.Code)
+		}
+		var team models.Team
+		if err := json.NewDecoder(w.Body).Decode(&team); err != nil {
+			t.Fatal(err)
+		}
+		votes[i] = models.Vote{TeamID: team.ID, Rank: i + 1}
+	}
+
+	for _, u := range []models.User{testUser, {Nickname: other.Nickname}} {
+		if w := do(admin, http.MethodPost, "/v1/users", u); w.Code != http.StatusCreated {
+			t.Fatalf("POST /v1/users returned %v", w.Code)
+		}
+	}
+
+	_, err := db.AddPoll(models.Poll{Season: 2020, Week: 1, CloseTime: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every problem with an invalid ballot is reported, tied to the vote at fault
+	bad := append([]models.Vote(nil), votes...)
+	bad[3].Rank = 1
+	bad[5].TeamID = 9999
+	w := do(voter, http.MethodPost, "/v1/ballots", models.Ballot{PollSeason: 2020, PollWeek: 1, User: voter.Nickname, Votes: bad})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST /v1/ballots with invalid ballot returned %v, expected %v", w.Code, http.StatusUnprocessableEntity)
+	}
+	var verr app.BallotValidationError
+	if err := json.NewDecoder(w.Body).Decode(&verr); err != nil {
+		t.Fatal(err)
+	}
+	expectedProblems := []app.VoteProblem{
+		{Index: 3, Field: "rank", Message: "rank 1 used more than once"},
+		{Index: 5, Field: "team_id", Message: "team 9999 unknown"},
+		{Index: -1, Field: "rank", Message: "missing rank 4"},
+	}
+	if !reflect.DeepEqual(verr.Problems, expectedProblems) {
+		t.Errorf("Unexpected validation problems:\n%v\nexpected:\n%v", verr.Problems, expectedProblems)
+	}
+
+	ballot := models.Ballot{PollSeason: 2020, PollWeek: 1, User: voter.Nickname, Votes: votes}
+	w = do(voter, http.MethodPost, "/v1/ballots", ballot)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /v1/ballots returned %v, expected %v", w.Code, http.StatusCreated)
+	}
+	if err := json.NewDecoder(w.Body).Decode(&ballot); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := do(voter, http.MethodPost, "/v1/ballots", ballot); w.Code != http.StatusConflict {
+		t.Errorf("Second POST /v1/ballots returned %v, expected %v", w.Code, http.StatusConflict)
+	}
+	path := fmt.Sprintf("/v1/ballots/%d", ballot.ID)
+
+	steps := []struct {
+		name           string
+		token          models.UserToken
+		method         string
+		expectedStatus int
+	}{
+		{"Owner can view before close", voter, http.MethodGet, http.StatusOK},
+		{"Others can't view before close", other, http.MethodGet, http.StatusForbidden},
+		{"Others can't delete", other, http.MethodDelete, http.StatusForbidden},
+		{"Owner can delete", voter, http.MethodDelete, http.StatusOK},
+		{"Gone after delete", voter, http.MethodGet, http.StatusNotFound},
+		{"Can't delete twice", voter, http.MethodDelete, http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		if w := do(step.token, step.method, path, nil); w.Code != step.expectedStatus {
+			t.Errorf("%s: %s %s returned %v, expected %v", step.name, step.method, path, w.Code, step.expectedStatus)
+		}
+	}
+}
+
 // Helpers
 
 func getAuth(token models.UserToken) *authMocks.AuthClient {
@@ -531,3 +926,294 @@ func getAuth(token models.UserToken) *authMocks.AuthClient {
 func testSuccess(status int) bool {
 	return status >= 200 && status < 300
 }
+
+func TestJWKS(t *testing.T) {
+	keys := auth.JSONWebKeySet{Keys: []auth.JSONWebKey{
+		{KeyType: "RSA", Use: "sig", Algorithm: "RS256", KeyID: "current", Modulus: "AQAB", Exponent: "AQAB"},
+		{KeyType: "RSA", Use: "sig", Algorithm: "RS256", KeyID: "previous", Modulus: "AQAB", Exponent: "AQAB"},
+	}}
+
+	srv := NewServer()
+	authClient := authMocks.AuthClient{}
+	authClient.On("JWKS").Return(keys)
+	authClient.On("Verifier").Return(func(next http.Handler) http.Handler { return next })
+	// Rejects any token, to show the keys don't depend on one
+	authClient.On("Authenticator", mock.Anything, mock.Anything).Return(func(http.HandlerFunc, auth.ErrorResponder) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusUnauthorized) }
+	})
+	srv.AuthClient = &authClient
+	srv.AuthRoutes()
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /.well-known/jwks.json returned %v, expected %v", w.Code, http.StatusOK)
+	}
+	if w.Header().Get("Cache-Control") == "" {
+		t.Errorf("Expected a Cache-Control header")
+	}
+
+	var res auth.JSONWebKeySet
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("Error decoding json response: %v", err.Error())
+	}
+	if !reflect.DeepEqual(res, keys) {
+		t.Errorf("Expected keys %v, got %v", keys, res)
+	}
+}
+
+type unavailableRevocations struct{}
+
+func (unavailableRevocations) IsTokenRevoked(string) (bool, error) {
+	return false, fmt.Errorf("revocation list unavailable")
+}
+
+func TestAuthenticationProblems(t *testing.T) {
+	valid := &jwt.Token{Valid: true, Claims: &auth.Claims{Name: "Concision", StandardClaims: jwt.StandardClaims{
+		Issuer: auth.DefaultIssuer, Id: "abc", ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}}}
+	tests := []struct {
+		name           string
+		token          *jwt.Token
+		tokenErr       error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"Bad signature", nil, fmt.Errorf("signature is invalid"), http.StatusUnauthorized, "unauthenticated"},
+		{"Unknown issuer", &jwt.Token{Valid: true, Claims: &auth.Claims{Name: "Concision"}}, nil, http.StatusUnauthorized, "unauthenticated"},
+		{"Revocation list down", valid, nil, http.StatusServiceUnavailable, "service_unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwtClient := auth.JwtClient{Issuer: auth.DefaultIssuer, Revocations: unavailableRevocations{}}
+			authClient := authMocks.AuthClient{}
+			authClient.On("Verifier").Return(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					ctx := context.WithValue(r.Context(), jwtauth.TokenCtxKey, tt.token)
+					ctx = context.WithValue(ctx, jwtauth.ErrorCtxKey, tt.tokenErr)
+					next.ServeHTTP(w, r.WithContext(ctx))
+				})
+			})
+			authClient.On("Authenticator", mock.Anything, mock.Anything).Return(jwtClient.Authenticator)
+
+			srv := NewServer()
+			srv.AuthClient = &authClient
+			srv.AuthRoutes()
+
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/sessions", nil))
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("DELETE /v1/sessions returned %v, expected %v", w.Code, tt.expectedStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("Expected Content-Type %s, got %s", problemContentType, ct)
+			}
+
+			var p problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatalf("Error decoding problem: %s", err.Error())
+			}
+			if p.Status != tt.expectedStatus || p.Code != tt.expectedCode || p.Message == "" {
+				t.Errorf("Unexpected problem %+v", p)
+			}
+		})
+	}
+}
+
+func TestGetResults_Formats(t *testing.T) {
+	db := memory.NewClient()
+	srv := NewServer()
+	srv.App = app.NewPollService(db)
+	srv.AuthClient = getAuth(models.UserToken{})
+
+	admin := models.UserToken{Nickname: testAdmin.Nickname, IsAdmin: true}
+	if _, err := db.AddUser(testAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.App.AddUser(admin, models.User{Nickname: "voter", IsVoter: true}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.AddPoll(models.Poll{Season: 2020, Week: 1, CloseTime: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	votes := make([]models.Vote, 25)
+	for i := range votes {
+		team, err := srv.App.AddTeam(admin, models.Team{ShortName: fmt.Sprintf("Team %02d", i+1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		votes[i] = models.Vote{TeamID: team.ID, Rank: i + 1}
+	}
+	if _, err = srv.App.AddBallot(admin, models.Ballot{PollSeason: 2020, PollWeek: 1, User: "voter", Votes: votes}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description         string
+		query               string
+		accept              string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{"Default", "", "", http.StatusOK, "application/json", `"team_name":"Team 01"`},
+		{"Browser", "", "text/html,application/xhtml+xml,*/*;q=0.8", http.StatusOK, "application/json", `"team_name":"Team 01"`},
+		{"Markdown by Accept", "", "text/markdown", http.StatusOK, "text/markdown; charset=utf-8", "| 1 | Team 01 | 1 | 25 |"},
+		{"CSV by Accept", "", "text/csv; q=0.9, application/json", http.StatusOK, "text/csv; charset=utf-8", "1,1,Team 01,,1,25,"},
+		{"Text by query", "?format=text", "application/json", http.StatusOK, "text/plain; charset=utf-8", "2020 Week 1\n"},
+		{"Unknown format", "?format=xml", "", http.StatusBadRequest, "application/problem+json", "unknown format"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/polls/2020/1/results"+test.query, nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+
+			if w.Code != test.expectedStatus {
+				t.Errorf("Expected status %v, got %v", test.expectedStatus, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != test.expectedContentType {
+				t.Errorf("Expected Content-Type %q, got %q", test.expectedContentType, ct)
+			}
+			if !strings.Contains(w.Body.String(), test.expectedBody) {
+				t.Errorf("Expected %q in body:\n%s", test.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	db := memory.NewClient()
+	srv := NewServer()
+	srv.App = app.NewPollService(db)
+
+	var current models.UserToken
+	authClient := authMocks.AuthClient{}
+	authClient.On("UserTokenFromCtx", mock.Anything).Return(func(context.Context) models.UserToken {
+		return current
+	})
+	srv.AuthClient = &authClient
+
+	for _, u := range []models.User{testAdmin, testUser, {Nickname: "einsteins_haircut"}} {
+		if _, err := db.AddUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	user := models.UserToken{Nickname: testUser.Nickname}
+	admin := models.UserToken{Nickname: testAdmin.Nickname, IsAdmin: true}
+	steps := []struct {
+		name           string
+		token          models.UserToken
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{"Export own data", user, http.MethodGet, "/v1/users/JohnDoe/export", http.StatusOK},
+		{"Can't export someone else's", user, http.MethodGet, "/v1/users/einsteins_haircut/export", http.StatusForbidden},
+		{"Can't delete someone else", user, http.MethodDelete, "/v1/users/einsteins_haircut", http.StatusForbidden},
+		{"Delete self", user, http.MethodDelete, "/v1/users/JohnDoe", http.StatusNoContent},
+		{"Gone after deletion", user, http.MethodGet, "/v1/users/JohnDoe/export", http.StatusNotFound},
+		{"Admin exports anyone", admin, http.MethodGet, "/v1/users/einsteins_haircut/export", http.StatusOK},
+		{"Admin deletes anyone", admin, http.MethodDelete, "/v1/users/einsteins_haircut", http.StatusNoContent},
+		{"Unknown user", admin, http.MethodDelete, "/v1/users/einsteins_haircut", http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		current = step.token
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(step.method, step.path, nil))
+		if w.Code != step.expectedStatus {
+			t.Errorf("%s: %s %s returned %v, expected %v", step.name, step.method, step.path, w.Code, step.expectedStatus)
+		}
+
+		if step.method == http.MethodGet && w.Code == http.StatusOK {
+			name := strings.TrimSuffix(strings.TrimPrefix(step.path, "/v1/users/"), "/export")
+			expected := fmt.Sprintf("attachment; filename=\"cbbpoll-%s.json\"", name)
+			if cd := w.Header().Get("Content-Disposition"); cd != expected {
+				t.Errorf("%s: expected Content-Disposition %q, got %q", step.name, expected, cd)
+			}
+			var export models.UserExport
+			if err := json.NewDecoder(w.Body).Decode(&export); err != nil || export.User.Nickname != name {
+				t.Errorf("%s: unexpected export %+v (%v)", step.name, export, err)
+			}
+		}
+	}
+}
+
+func TestListAudit(t *testing.T) {
+	db := memory.NewClient()
+	srv := NewServer()
+	srv.App = app.NewPollService(db)
+
+	var current models.UserToken
+	authClient := authMocks.AuthClient{}
+	authClient.On("UserTokenFromCtx", mock.Anything).Return(func(context.Context) models.UserToken {
+		return current
+	})
+	srv.AuthClient = &authClient
+
+	for _, u := range []models.User{testAdmin, testUser} {
+		if _, err := db.AddUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The entry notes the request the poll was added in
+	current = models.UserToken{Nickname: testAdmin.Nickname, IsAdmin: true}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/v1/polls", strings.NewReader(`{"season": 2020, "week": 1}`))
+	r.Header.Set("X-Request-ID", "add-poll-1")
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /v1/polls returned %v, expected %v", w.Code, http.StatusCreated)
+	}
+
+	user := models.UserToken{Nickname: testUser.Nickname}
+	admin := current
+	steps := []struct {
+		name            string
+		token           models.UserToken
+		path            string
+		expectedStatus  int
+		expectedEntries int
+	}{
+		{"Not logged in", models.UserToken{}, "/v1/audit", http.StatusUnauthorized, 0},
+		{"Voters can't view", user, "/v1/audit", http.StatusForbidden, 0},
+		{"Admin views all", admin, "/v1/audit", http.StatusOK, 1},
+		{"By request", admin, "/v1/audit?request_id=add-poll-1", http.StatusOK, 1},
+		{"By target", admin, "/v1/audit?target_type=poll&target_id=2020/1&action=poll.add&actor=Concision", http.StatusOK, 1},
+		{"Since later", admin, "/v1/audit?since=" + time.Now().Add(time.Hour).Format(time.RFC3339), http.StatusOK, 0},
+		{"Bad time", admin, "/v1/audit?until=yesterday", http.StatusBadRequest, 0},
+	}
+
+	for _, step := range steps {
+		current = step.token
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, step.path, nil))
+		if w.Code != step.expectedStatus {
+			t.Errorf("%s: GET %s returned %v, expected %v", step.name, step.path, w.Code, step.expectedStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var entries []models.AuditEntry
+		if err := json.NewDecoder(w.Body).Decode(&entries); err != nil || len(entries) != step.expectedEntries {
+			t.Errorf("%s: expected %d entries, got %+v (%v)", step.name, step.expectedEntries, entries, err)
+		}
+		for _, e := range entries {
+			if e.Action != models.AuditAddPoll || e.TargetID != "2020/1" || e.RequestID != "add-poll-1" {
+				t.Errorf("%s: unexpected entry %+v", step.name, e)
+			}
+		}
+	}
+}
@@ -2,15 +2,18 @@ package server
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 
 	"github.com/r-cbb/cbbpoll/internal/app"
 	"github.com/r-cbb/cbbpoll/internal/auth"
 	"github.com/r-cbb/cbbpoll/internal/errors"
+	"github.com/r-cbb/cbbpoll/internal/logging"
+	"github.com/r-cbb/cbbpoll/internal/models"
 )
 
 /*
@@ -20,12 +23,19 @@ type Server struct {
 	App          *app.PollService
 	AuthClient   auth.Client
 	RedditClient RedditClient
-	router       *mux.Router
-	host         string
+	RedditOAuth  RedditOAuth
+	FrontendURL  string
+	// Signs the cookies browsers hold while logging in through reddit
+	LoginKey    []byte
+	Logger      *logrus.Logger
+	router      *mux.Router
+	host        string
+	checks      []namedCheck
+	authEnabled bool
 }
 
 func NewServer() *Server {
-	srv := Server{}
+	srv := Server{Logger: logging.New(os.Stdout)}
 	srv.Routes()
 
 	return &srv
@@ -36,11 +46,30 @@ func (s *Server) SetHost(host string) {
 }
 
 func (s *Server) Handler() http.Handler {
-	return s.router
+	return s.logRequests(s.router)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
+	s.Handler().ServeHTTP(w, r)
+}
+
+// app returns the PollService, logging with the request's logger and noting
+// its ID in the audit log.
+func (s *Server) app(r *http.Request) *app.PollService {
+	ps := s.App.WithLogger(logging.FromContext(r.Context()))
+	if info, ok := requestInfoFromCtx(r.Context()); ok {
+		ps = ps.WithRequestID(info.id)
+	}
+	return ps
+}
+
+// userToken returns the caller's token, noting who they are for the access log.
+func (s *Server) userToken(r *http.Request) models.UserToken {
+	token := s.AuthClient.UserTokenFromCtx(r.Context())
+	if info, ok := requestInfoFromCtx(r.Context()); ok {
+		info.user = token.Nickname
+	}
+	return token
 }
 
 func (s *Server) respond(w http.ResponseWriter, r *http.Request, data interface{}, status int) {
@@ -50,7 +79,7 @@ func (s *Server) respond(w http.ResponseWriter, r *http.Request, data interface{
 	if data != nil {
 		err := json.NewEncoder(w).Encode(data)
 		if err != nil {
-			fmt.Printf("json encode: %s", err)
+			logging.FromContext(r.Context()).WithError(err).Error("error encoding response")
 		}
 	}
 }
@@ -59,9 +88,10 @@ const maxRequestSize = 1 << 20 // 1 MB
 
 func (s *Server) decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
 	const op errors.Op = "server.decode"
-	return json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&v)
-}
+	err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&v)
+	if err != nil {
+		return errors.E(op, err, "request body is not valid json", errors.KindBadRequest)
+	}
 
-func (s Server) version() string {
-	return "v0.1.0"
+	return nil
 }
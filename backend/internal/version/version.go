@@ -0,0 +1,41 @@
+/*
+Package version describes the running build.  Version, Commit and BuildTime are
+stamped in at link time, e.g.
+
+	go build -ldflags "-X github.com/r-cbb/cbbpoll/internal/version.Commit=$(git rev-parse HEAD)"
+
+When they aren't, Version falls back to the module version recorded by the go
+tool, or "dev" for a local build.
+*/
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/r-cbb/cbbpoll/internal/models"
+)
+
+var (
+	Version   string
+	Commit    string
+	BuildTime string
+)
+
+// Get returns the version information for this build.
+func Get() models.VersionInfo {
+	v := Version
+	if v == "" {
+		v = "dev"
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+	}
+
+	return models.VersionInfo{
+		Version:   v,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
@@ -0,0 +1,21 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	defer func(v, c, b string) { Version, Commit, BuildTime = v, c, b }(Version, Commit, BuildTime)
+
+	Version, Commit, BuildTime = "", "", ""
+	if v := Get(); v.Version == "" || v.GoVersion != runtime.Version() {
+		t.Errorf("Unexpected version info for unstamped build: %+v", v)
+	}
+
+	Version, Commit, BuildTime = "v1.2.0", "abc123", "2020-01-02T15:04:05Z"
+	v := Get()
+	if v.Version != "v1.2.0" || v.Commit != "abc123" || v.BuildTime != "2020-01-02T15:04:05Z" {
+		t.Errorf("Expected stamped version info, got %+v", v)
+	}
+}